@@ -0,0 +1,364 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+var (
+	errNoAuth    = errors.New("NOAUTH Authentication required.")
+	errWrongPass = errors.New("WRONGPASS invalid username-password pair or user is disabled.")
+	errNoPermKey = errors.New("NOPERM this user has no permissions to access one of the keys used as arguments")
+)
+
+// aclCategories maps the command categories ACL rules accept, besides @all,
+// to the command flags that make them up.
+var aclCategories = map[string]int{
+	"admin":  cmdAdmin,
+	"pubsub": cmdPubSub,
+	"read":   cmdRead,
+	"write":  cmdWrite,
+}
+
+// aclUser is a user connections authenticate as. commands holds the
+// +command, -command, +@category and -@category rules in the order they were
+// given, the last one matching a command deciding whether it may run, and
+// keys the glob-style patterns of the keys the user may access.
+type aclUser struct {
+	name      string
+	enabled   bool
+	nopass    bool
+	passwords map[string]struct{}
+	commands  []string
+	keys      []string
+}
+
+// acl holds the users, starting with the default user every connection is
+// authenticated as until it runs AUTH.
+type acl struct {
+	mu    sync.RWMutex
+	users map[string]*aclUser
+}
+
+func newACL() *acl {
+	return &acl{users: map[string]*aclUser{
+		"default": {
+			name:     "default",
+			enabled:  true,
+			nopass:   true,
+			commands: []string{"+@all"},
+			keys:     []string{"*"},
+		},
+	}}
+}
+
+// login returns the user new connections are authenticated as: the default
+// user when it is enabled and requires no password, nil otherwise.
+func (a *acl) login() *aclUser {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if user := a.users["default"]; user.enabled && user.nopass {
+		return user
+	}
+	return nil
+}
+
+// authenticate returns the user name if password is one of its passwords.
+func (a *acl) authenticate(name, password string) (*aclUser, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	user := a.users[name]
+	if user == nil || !user.enabled {
+		return nil, errWrongPass
+	}
+	if _, ok := user.passwords[hashPassword(password)]; !ok && !user.nopass {
+		return nil, errWrongPass
+	}
+	return user, nil
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// check reports whether c may run commands, a command with the given flags.
+// Clients without a connection or a user, such as the one applying the
+// replication stream, may run anything, and clients that did not
+// authenticate yet AUTH, QUIT and RESET.
+func (a *acl) check(c *clientConn, commands []string, flags int) error {
+	if c.user == nil {
+		if c.conn == nil || commands[0] == "auth" || commands[0] == "quit" || commands[0] == "reset" {
+			return nil
+		}
+		return errNoAuth
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !c.user.allows(commands[0]) {
+		return fmt.Errorf("NOPERM this user has no permissions to run the '%s' command", commands[0])
+	}
+	if flags&(cmdRead|cmdWrite) == 0 {
+		return nil
+	}
+	for _, key := range commandKeys(commands) {
+		if !c.user.allowsKey(key) {
+			return errNoPermKey
+		}
+	}
+	return nil
+}
+
+// allows reports whether the user may run the lowercased command name.
+func (u *aclUser) allows(name string) bool {
+	allowed := false
+	for _, rule := range u.commands {
+		target := rule[1:]
+		if category, ok := strings.CutPrefix(target, "@"); ok {
+			if category != "all" && !commandHas(name, aclCategories[category]) {
+				continue
+			}
+		} else if target != name {
+			continue
+		}
+		allowed = rule[0] == '+'
+	}
+	return allowed
+}
+
+func (u *aclUser) allowsKey(key string) bool {
+	for _, pattern := range u.keys {
+		if globMatch(pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply changes the user according to one ACL SETUSER rule.
+func (u *aclUser) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.nopass, u.passwords = true, nil
+		return nil
+	case "resetpass":
+		u.nopass, u.passwords = false, nil
+		return nil
+	case "allkeys":
+		u.keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.keys = nil
+		return nil
+	case "allcommands":
+		u.commands = []string{"+@all"}
+		return nil
+	case "nocommands":
+		u.commands = nil
+		return nil
+	case "reset":
+		*u = aclUser{name: u.name}
+		return nil
+	}
+	if rule == "" {
+		return errSyntax
+	}
+	switch arg := rule[1:]; rule[0] {
+	case '>':
+		u.addPassword(hashPassword(arg))
+	case '<':
+		delete(u.passwords, hashPassword(arg))
+	case '#':
+		if _, err := hex.DecodeString(arg); err != nil || len(arg) != 2*sha256.Size {
+			return errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.addPassword(strings.ToLower(arg))
+	case '!':
+		delete(u.passwords, strings.ToLower(arg))
+	case '~':
+		u.keys = append(u.keys, arg)
+	case '+', '-':
+		arg = strings.ToLower(arg)
+		if arg == "@all" {
+			u.commands = nil
+		} else if category, ok := strings.CutPrefix(arg, "@"); ok {
+			if _, ok := aclCategories[category]; !ok {
+				return errors.New("Unknown command or category name in ACL")
+			}
+		} else if _, ok := commandTable[arg]; !ok {
+			return errors.New("Unknown command or category name in ACL")
+		}
+		u.commands = append(u.commands, rule[:1]+arg)
+	default:
+		return errSyntax
+	}
+	return nil
+}
+
+func (u *aclUser) addPassword(hash string) {
+	if u.passwords == nil {
+		u.passwords = map[string]struct{}{}
+	}
+	u.passwords[hash] = struct{}{}
+	u.nopass = false
+}
+
+// flags returns the status flags ACL GETUSER reports.
+func (u *aclUser) flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.nopass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+func (u *aclUser) commandRules() string {
+	if len(u.commands) == 0 {
+		return "-@all"
+	}
+	return strings.Join(u.commands, " ")
+}
+
+func (u *aclUser) keyRules() string {
+	rules := make([]string, len(u.keys))
+	for i, pattern := range u.keys {
+		rules[i] = "~" + pattern
+	}
+	return strings.Join(rules, " ")
+}
+
+// describe returns the user as a line of ACL LIST, rules that recreate it
+// with ACL SETUSER.
+func (u *aclUser) describe() string {
+	parts := append([]string{"user", u.name}, u.flags()...)
+	for _, hash := range sortedKeys(u.passwords) {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.keyRules(); keys != "" {
+		parts = append(parts, keys)
+	}
+	return strings.Join(append(parts, u.commandRules()), " ")
+}
+
+// authCommand implements AUTH [username] password. With the password alone
+// it authenticates as the default user.
+func (s *server) authCommand(c *clientConn, commands []string) {
+	if len(commands) > 3 {
+		c.replyError(errSyntax)
+		return
+	}
+	name, password := "default", commands[1]
+	if len(commands) == 3 {
+		name, password = commands[1], commands[2]
+	} else if s.acl.login() != nil {
+		c.replyErrorMsg("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+		return
+	}
+	user, err := s.acl.authenticate(name, password)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.user = user
+	c.replyOK()
+}
+
+// aclCommand implements ACL SETUSER, GETUSER, WHOAMI and LIST.
+func (s *server) aclCommand(c *clientConn, commands []string) {
+	a := s.acl
+	switch strings.ToLower(commands[1]) {
+	case "setuser":
+		if len(commands) < 3 {
+			c.replyWrongArgs("acl|setuser")
+			return
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		// The rules are applied to a copy, so that an invalid one leaves
+		// the user as it was.
+		user := &aclUser{name: commands[2]}
+		if existing := a.users[commands[2]]; existing != nil {
+			*user = *existing
+			user.passwords = make(map[string]struct{}, len(existing.passwords))
+			for hash := range existing.passwords {
+				user.passwords[hash] = struct{}{}
+			}
+			user.commands = append([]string(nil), existing.commands...)
+			user.keys = append([]string(nil), existing.keys...)
+		}
+		for _, rule := range commands[3:] {
+			if err := user.apply(rule); err != nil {
+				c.replyErrorMsg(fmt.Sprintf("Error in ACL SETUSER modifier '%s': %s", rule, err))
+				return
+			}
+		}
+		// Connections authenticated as the user hold a pointer to it, so
+		// an existing user is updated in place.
+		if existing := a.users[commands[2]]; existing != nil {
+			*existing = *user
+		} else {
+			a.users[user.name] = user
+		}
+		c.replyOK()
+	case "getuser":
+		if len(commands) != 3 {
+			c.replyWrongArgs("acl|getuser")
+			return
+		}
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		user := a.users[commands[2]]
+		if user == nil {
+			c.replyNull()
+			return
+		}
+		c.replyWith(func(w *respWriter) {
+			w.WriteMap(4)
+			for _, field := range []struct {
+				name   string
+				values []string
+			}{{"flags", user.flags()}, {"passwords", sortedKeys(user.passwords)}} {
+				w.WriteBulkString(field.name)
+				w.WriteArray(len(field.values))
+				for _, value := range field.values {
+					w.WriteBulkString(value)
+				}
+			}
+			w.WriteBulkString("commands")
+			w.WriteBulkString(user.commandRules())
+			w.WriteBulkString("keys")
+			w.WriteBulkString(user.keyRules())
+		})
+	case "whoami":
+		name := "default"
+		if c.user != nil {
+			a.mu.RLock()
+			name = c.user.name
+			a.mu.RUnlock()
+		}
+		c.replyBulk(name)
+	case "list":
+		a.mu.RLock()
+		defer a.mu.RUnlock()
+		var lines []string
+		for _, name := range sortedKeys(a.users) {
+			lines = append(lines, a.users[name].describe())
+		}
+		c.replyStrings(lines)
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try ACL HELP.", commands[1]))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestACLCommandPermissions(t *testing.T) {
+	_, addr := startServer(t)
+	admin := dial(t, addr)
+	admin.expect(respStatus("OK"), "SET", "key", "value")
+	admin.expect(respStatus("OK"), "ACL", "SETUSER", "reader", "on", ">secret", "~*", "+get")
+
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "AUTH", "reader", "secret")
+	c.expect("value", "GET", "key")
+	c.expect(respError("NOPERM this user has no permissions to run the 'set' command"), "SET", "key", "other")
+	admin.expect("value", "GET", "key")
+}
+
+func TestACLKeyPatterns(t *testing.T) {
+	_, addr := startServer(t)
+	admin := dial(t, addr)
+	admin.expect(respStatus("OK"), "ACL", "SETUSER", "app", "on", "nopass", "~app:*", "+@all")
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "AUTH", "app", "anything")
+	c.expect(respStatus("OK"), "SET", "app:1", "value")
+	if reply, _ := c.do("GET", "other").(respError); !strings.HasPrefix(string(reply), "NOPERM") {
+		t.Fatalf("GET of a key outside the patterns: got %#v, want NOPERM", reply)
+	}
+}
+
+func TestACLList(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect("default", "ACL", "WHOAMI")
+	c.expect(respStatus("OK"), "ACL", "SETUSER", "off-user", "off")
+	list, _ := c.do("ACL", "LIST").([]any)
+	if len(list) != 2 || !strings.HasPrefix(list[0].(string), "user default on") || !strings.HasPrefix(list[1].(string), "user off-user off") {
+		t.Fatalf("ACL LIST: got %#v", list)
+	}
+	if _, ok := c.do("AUTH", "off-user", "x").(respError); !ok {
+		t.Fatal("AUTH as a disabled user did not fail")
+	}
+	c.expect(nil, "ACL", "GETUSER", "missing")
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// aof is the append only file. Every propagated write is appended to it and
+// flushed to disk according to the appendfsync policy.
+type aof struct {
+	mu     sync.Mutex
+	file   *os.File
+	fsync  string
+	offset int64 // bytes written to the file
+	synced int64 // bytes known to be on disk
+	// fsynced is closed, and replaced, every time synced moves forward.
+	fsynced chan struct{}
+	// db is the database the file last selected, -1 until a write selects
+	// one.
+	db int
+}
+
+func openAOF(path, fsync string) (*aof, error) {
+	switch fsync {
+	case "always", "everysec", "no":
+	default:
+		return nil, fmt.Errorf("invalid appendfsync policy %q", fsync)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	a := &aof{
+		file:    file,
+		fsync:   fsync,
+		offset:  info.Size(),
+		synced:  info.Size(),
+		fsynced: make(chan struct{}),
+		db:      -1,
+	}
+	if fsync == "everysec" {
+		go a.syncEverySecond()
+	}
+	return a, nil
+}
+
+// write appends msg, a command run in db.
+func (a *aof) write(db int, msg string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if db != a.db {
+		msg = createSelectMsg(db) + msg
+		a.db = db
+	}
+	n, err := a.file.WriteString(msg)
+	a.offset += int64(n)
+	if err != nil {
+		fmt.Println("Failed to write to the append only file: ", err)
+		return
+	}
+	if a.fsync == "always" {
+		a.syncLocked()
+	}
+}
+
+func (a *aof) syncLocked() {
+	if a.synced == a.offset {
+		return
+	}
+	if err := a.file.Sync(); err != nil {
+		fmt.Println("Failed to fsync the append only file: ", err)
+		return
+	}
+	a.synced = a.offset
+	close(a.fsynced)
+	a.fsynced = make(chan struct{})
+}
+
+func (a *aof) syncEverySecond() {
+	for range time.Tick(time.Second) {
+		a.mu.Lock()
+		a.syncLocked()
+		a.mu.Unlock()
+	}
+}
+
+func (a *aof) currentOffset() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.offset
+}
+
+func (a *aof) syncedOffset() int64 {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.synced
+}
+
+// syncedUpTo reports whether everything up to offset is on disk. When it is
+// not, the returned channel is closed on the next fsync.
+func (a *aof) syncedUpTo(offset int64) (bool, <-chan struct{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.synced >= offset, a.fsynced
+}
+
+// loadAOF replays the commands in the append only file at path. A missing
+// file is not an error: it just means nothing was written yet.
+func (s *server) loadAOF(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	loader := &clientConn{reader: bufio.NewReader(file)}
+	for {
+		commands, err := loader.next(0)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(commands) > 0 {
+			s.execute(loader, commands)
+		}
+	}
+}
+
+// waitAOF implements WAITAOF numlocal numreplicas timeout. It blocks until the
+// writes issued so far are fsynced locally (numlocal) and on numreplicas
+// replicas, which report the offset of the replication stream they fsynced
+// with REPLCONF ACK, or until timeout milliseconds pass, and replies with the
+// counts reached. With appendfsync no the server never fsyncs, so numlocal
+// can only be asked for with another policy.
+func (s *server) waitAOF(c *clientConn, commands []string) {
+	if len(commands) != 4 {
+		c.replyErrorMsg("wrong number of arguments for 'waitaof' command")
+		return
+	}
+	numLocal, err := strconv.Atoi(commands[1])
+	if err != nil || numLocal < 0 {
+		c.replyErrorMsg("value is out of range, must be positive")
+		return
+	}
+	numReplicas, err := strconv.Atoi(commands[2])
+	if err != nil || numReplicas < 0 {
+		c.replyErrorMsg("value is out of range, must be positive")
+		return
+	}
+	timeout, err := strconv.Atoi(commands[3])
+	if err != nil {
+		c.replyErrorMsg("timeout is not an integer or out of range")
+		return
+	}
+	if timeout < 0 {
+		c.replyErrorMsg("timeout is negative")
+		return
+	}
+	if s.isReplica() {
+		c.replyErrorMsg("WAITAOF cannot be used with replica instances. Please also note that writes to replicas are just local and are not propagated.")
+		return
+	}
+	if numLocal > 0 && s.aof == nil {
+		c.replyErrorMsg("WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+		return
+	}
+	if numLocal > 0 && s.aof.fsync == "no" {
+		c.replyErrorMsg("WAITAOF cannot be used when numlocal is set but appendfsync is no.")
+		return
+	}
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = s.clock.After(time.Duration(timeout) * time.Millisecond)
+	}
+	var target int64
+	if s.aof != nil {
+		target = s.aof.currentOffset()
+	}
+	s.slavesMu.Lock()
+	replTarget := s.replOffset
+	if numReplicas > 0 {
+		// Ask the replicas where they are, as WAIT does.
+		s.feedReplicas(createArrayMsg([]string{"REPLCONF", "GETACK", "*"}))
+	}
+	s.slavesMu.Unlock()
+	s.blockedClients.Add(1)
+	defer s.blockedClients.Add(-1)
+	for {
+		local := 0
+		var fsynced <-chan struct{}
+		if s.aof != nil {
+			var synced bool
+			synced, fsynced = s.aof.syncedUpTo(target)
+			if synced {
+				local = 1
+			}
+		}
+		replicas, ackCh := s.aofAcked(replTarget)
+		// Nothing else runs until EXEC is done, so WAITAOF does not block
+		// inside it.
+		if local >= numLocal && replicas >= numReplicas || c.execLocked {
+			c.replyValue([]any{local, replicas})
+			return
+		}
+		select {
+		case <-fsynced:
+		case <-ackCh:
+		case <-deadline:
+			c.replyValue([]any{local, replicas})
+			return
+		case <-s.shuttingDown:
+			c.replyValue([]any{local, replicas})
+			return
+		}
+	}
+}
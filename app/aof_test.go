@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitAOF(t *testing.T) {
+	_, addr := startServer(t, "appendonly", "yes", "appendfsync", "everysec")
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	c.expect([]any{int64(1), int64(0)}, "WAITAOF", "1", "0", "3000")
+}
+
+func TestWaitAOFWithoutAppendOnly(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	if _, ok := c.do("WAITAOF", "1", "0", "0").(respError); !ok {
+		t.Fatal("WAITAOF numlocal 1 did not fail with appendonly disabled")
+	}
+	c.expect([]any{int64(0), int64(0)}, "WAITAOF", "0", "0", "0")
+}
+
+func TestWaitAOFAppendFsyncNo(t *testing.T) {
+	_, addr := startServer(t, "appendonly", "yes", "appendfsync", "no")
+	c := dial(t, addr)
+	if _, ok := c.do("WAITAOF", "1", "0", "0").(respError); !ok {
+		t.Fatal("WAITAOF numlocal 1 did not fail with appendfsync no")
+	}
+}
+
+func TestWaitAOFReplicas(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	c.send("WAITAOF", "0", "1", "0")
+	r.expectNext("REPLCONF", "GETACK", "*")
+	// Acknowledging the offset is not enough: it has to be fsynced.
+	r.ack()
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.reader.Peek(1); err == nil {
+		t.Fatal("WAITAOF replied before the replica fsynced the write")
+	}
+	offset := strconv.FormatInt(r.offset, 10)
+	r.send("REPLCONF", "ACK", offset, "FACK", offset)
+	if reply := c.read(); !reflect.DeepEqual(reply, []any{int64(0), int64(1)}) {
+		t.Fatalf("WAITAOF 0 1 0: got %#v", reply)
+	}
+	// The counts reached are returned on timeout.
+	c.expect([]any{int64(0), int64(1)}, "WAITAOF", "0", "2", "50")
+}
+
+func TestWaitAOFReplicaFsyncs(t *testing.T) {
+	masterServer, masterAddr := startServer(t)
+	replicaServer, replicaAddr := startServer(t, "appendonly", "yes", "appendfsync", "always")
+	master, replica := dial(t, masterAddr), dial(t, replicaAddr)
+	host, port, _ := net.SplitHostPort(masterAddr)
+	replica.expect(respStatus("OK"), "REPLICAOF", host, port)
+	t.Cleanup(replicaServer.promote)
+	waitFor(t, "the replica", func() bool {
+		masterServer.slavesMu.Lock()
+		defer masterServer.slavesMu.Unlock()
+		return len(masterServer.slaves) == 1
+	})
+	master.expect(respStatus("OK"), "SET", "key", "value")
+	master.expect([]any{int64(0), int64(1)}, "WAITAOF", "0", "1", "5000")
+}
+
+func TestAOFRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	params := []string{"dir", dir, "appendonly", "yes", "appendfsync", "always"}
+	_, addr := startServer(t, params...)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "string", "value")
+	c.expect(int64(1), "EXPIRE", "string", "1000")
+	c.expect(int64(5), "INCRBY", "counter", "5")
+	c.expect(int64(3), "RPUSH", "list", "a", "b", "c")
+	c.expect("a", "LPOP", "list")
+	c.expect(respStatus("OK"), "SET", "gone", "value")
+	c.expect(int64(1), "DEL", "gone")
+	c.expect(respStatus("OK"), "SELECT", "1")
+	c.expect(int64(1), "HSET", "hash", "field", "value")
+
+	_, restartedAddr := startServer(t, params...)
+	r := dial(t, restartedAddr)
+	r.expect("value", "GET", "string")
+	if ttl, _ := r.do("TTL", "string").(int64); ttl < 999 || ttl > 1000 {
+		t.Fatalf("TTL after reloading the append only file: got %d, want 1000", ttl)
+	}
+	r.expect("5", "GET", "counter")
+	r.expect([]any{"b", "c"}, "LRANGE", "list", "0", "-1")
+	r.expect(int64(0), "EXISTS", "gone")
+	r.expect(respStatus("OK"), "SELECT", "1")
+	r.expect("value", "HGET", "hash", "field")
+}
+
+// aofCommands returns the commands logged to the append only file in dir.
+func aofCommands(t *testing.T, dir string) [][]string {
+	t.Helper()
+	f, err := os.Open(filepath.Join(dir, "appendonly.aof"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var commands [][]string
+	reader := bufio.NewReader(f)
+	for {
+		command, err := readCommand(reader, 0)
+		if err == io.EOF {
+			return commands
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		commands = append(commands, command)
+	}
+}
+
+func TestAOFLogsOnlyChanges(t *testing.T) {
+	dir := t.TempDir()
+	_, addr := startServer(t, "dir", dir, "appendonly", "yes", "appendfsync", "always")
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	c.expect("value", "GET", "key")
+	c.expect("value", "GETEX", "key")
+	c.expect(int64(1), "COPY", "key", "copy")
+	c.expect(int64(0), "COPY", "key", "copy")
+	c.expect("value", "GETEX", "copy", "EX", "100")
+	c.expect(nil, "GETDEL", "missing")
+	c.expect("value", "GETDEL", "key")
+
+	var names []string
+	for _, command := range aofCommands(t, dir) {
+		names = append(names, strings.ToUpper(command[0]))
+		// The expiry is logged as the time it falls at.
+		if strings.EqualFold(command[0], "getex") && !strings.EqualFold(command[2], "pxat") {
+			t.Errorf("GETEX was logged as %q", command)
+		}
+	}
+	if want := []string{"SELECT", "SET", "COPY", "GETEX", "DEL"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("the append only file logged %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBgsaveInProgress = errors.New("ERR Background save already in progress")
+
+// saveRetryDelay is how long automatic saves wait after a failed background
+// save before trying again.
+const saveRetryDelay = 5 * time.Second
+
+// saveRule is a rule of the save parameter: a background save starts once
+// changes writes happened and period passed since the last save.
+type saveRule struct {
+	period  time.Duration
+	changes int64
+}
+
+// parseSaveRules parses the save parameter, pairs of seconds and changes
+// separated by spaces. An empty value disables automatic saves.
+func parseSaveRules(value string) ([]saveRule, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, errors.New("Invalid save parameters")
+	}
+	var rules []saveRule
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.ParseInt(fields[i], 10, 64)
+		if err != nil || seconds < 1 {
+			return nil, errors.New("Invalid save parameters")
+		}
+		changes, err := strconv.ParseInt(fields[i+1], 10, 64)
+		if err != nil || changes < 0 {
+			return nil, errors.New("Invalid save parameters")
+		}
+		rules = append(rules, saveRule{time.Duration(seconds) * time.Second, changes})
+	}
+	return rules, nil
+}
+
+// startSnapshot takes a snapshot of the databases and returns write, which
+// returns it as an RDB file like snapshot, to be called on another
+// goroutine. Only copying the references of the values holds the locks,
+// and then the serialization of each key in turn. Commands lookup a value
+// through detach meanwhile, which hands them a copy of the values the
+// snapshot has yet to write, so that the ones they change in place are
+// written as they were.
+func (s *server) startSnapshot() (write func() []byte) {
+	for _, db := range s.dbs {
+		db.Mutex.Lock()
+	}
+	now := s.clock.Now()
+	writes := make([]func(buf *bytes.Buffer, index int), len(s.dbs))
+	for i, db := range s.dbs {
+		writes[i] = db.startSnapshot(now)
+	}
+	for _, db := range s.dbs {
+		db.Mutex.Unlock()
+	}
+	return func() []byte {
+		var buf bytes.Buffer
+		writeRDBHeader(&buf, now)
+		for index, write := range writes {
+			write(&buf, index)
+		}
+		writeRDBFooter(&buf)
+		return buf.Bytes()
+	}
+}
+
+// startSnapshot takes a snapshot of the database at now, and returns write,
+// which writes it like writeRDB. The caller must hold the write lock.
+func (s *Store) startSnapshot(now time.Time) (write func(buf *bytes.Buffer, index int)) {
+	saving, expiries := maps.Clone(s.Data), maps.Clone(s.Expiries)
+	s.saving, s.detached = saving, make(map[string]struct{})
+	return func(buf *bytes.Buffer, index int) { s.serialize(buf, index, now, saving, expiries) }
+}
+
+// serialize writes the snapshot startSnapshot took at now, emptying saving
+// as it goes.
+func (s *Store) serialize(buf *bytes.Buffer, index int, now time.Time, saving map[string]any, expiries map[string]time.Time) {
+	// Only serialize changes saving, so it can be read without the lock.
+	keys := make([]string, 0, len(saving))
+	for key := range saving {
+		if expiry, ok := expiries[key]; !ok || !now.After(expiry) {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) > 0 {
+		sort.Strings(keys)
+		writeRDBDatabase(buf, index, len(keys), len(expiries))
+	}
+	for _, key := range keys {
+		s.Mutex.Lock()
+		value := saving[key]
+		delete(saving, key)
+		_, detached := s.detached[key]
+		// A value still shared with the keyspace may change as soon as
+		// the lock is released.
+		if !detached {
+			writeRDBKey(buf, key, value, expiries[key])
+		}
+		s.Mutex.Unlock()
+		if detached {
+			writeRDBKey(buf, key, value, expiries[key])
+		}
+	}
+	s.Mutex.Lock()
+	s.saving, s.detached = nil, nil
+	s.Mutex.Unlock()
+}
+
+// detach returns the value at key, replaced first with a copy if a
+// background snapshot has yet to write it. The caller must hold the write
+// lock.
+func (s *Store) detach(key string, value any) any {
+	if _, pending := s.saving[key]; !pending {
+		return value
+	}
+	if _, detached := s.detached[key]; detached {
+		return value
+	}
+	s.detached[key] = struct{}{}
+	value = cloneValue(value)
+	s.Data[key] = value
+	return value
+}
+
+// cloneValue returns a copy of value that can be changed without changing
+// value. Strings and intsets are never changed in place and are returned as
+// they are.
+func cloneValue(value any) any {
+	switch v := value.(type) {
+	case []string:
+		return append([]string(nil), v...)
+	case map[string]string:
+		return maps.Clone(v)
+	case map[string]struct{}:
+		return maps.Clone(v)
+	case *sortedSet:
+		return sortedSetFrom(maps.Clone(v.scores))
+	case *stream:
+		clone := *v
+		clone.entries = append([]streamEntry(nil), v.entries...)
+		if v.groups != nil {
+			clone.groups = make(map[string]*consumerGroup, len(v.groups))
+			for name, group := range v.groups {
+				pending := make(map[streamID]*pendingEntry, len(group.pending))
+				for id, p := range group.pending {
+					entry := *p
+					pending[id] = &entry
+				}
+				clone.groups[name] = &consumerGroup{lastID: group.lastID, pending: pending, consumers: maps.Clone(group.consumers)}
+			}
+		}
+		return &clone
+	}
+	return value
+}
+
+func (s *server) bgsaveInProgress() bool {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return s.bgsaving
+}
+
+// saved records a successful save of the dataset as it was after dirty
+// writes.
+func (s *server) saved(dirty int64) {
+	s.dirty.Add(-dirty)
+	s.saveMu.Lock()
+	s.lastSave = s.clock.Now()
+	s.saveMu.Unlock()
+}
+
+// bgsave starts writing a snapshot in the background, unless one is being
+// written already.
+func (s *server) bgsave() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	if s.bgsaving {
+		return errBgsaveInProgress
+	}
+	s.bgsaving, s.bgsaveStarted = true, s.clock.Now()
+	dirty := s.dirty.Load()
+	path := s.config.rdbPath()
+	write := s.startSnapshot()
+	go func() {
+		err := writeSnapshot(path, write())
+		if err != nil {
+			fmt.Println("Background saving error: ", err)
+		} else {
+			s.saved(dirty)
+		}
+		s.saveMu.Lock()
+		s.bgsaving, s.bgsaveErr = false, err
+		s.saveMu.Unlock()
+	}()
+	return nil
+}
+
+// autoSave starts a background save whenever one of the save rules is met.
+func (s *server) autoSave() {
+	for {
+		<-s.clock.After(time.Second)
+		s.saveMu.Lock()
+		now := s.clock.Now()
+		sinceSave := now.Sub(s.lastSave)
+		retry := s.bgsaveErr == nil || now.Sub(s.bgsaveStarted) >= saveRetryDelay
+		busy := s.bgsaving
+		s.saveMu.Unlock()
+		if busy || !retry {
+			continue
+		}
+		dirty := s.dirty.Load()
+		for _, rule := range s.config.saveRules() {
+			if dirty >= rule.changes && sinceSave >= rule.period {
+				fmt.Printf("%d changes in %d seconds. Saving...\n", rule.changes, int(rule.period.Seconds()))
+				s.execMu.RLock()
+				s.bgsave()
+				s.execMu.RUnlock()
+				break
+			}
+		}
+	}
+}
+
+// bgsaveCommand implements BGSAVE, which replies as soon as the snapshot
+// starts being written.
+func (s *server) bgsaveCommand(c *clientConn, commands []string) {
+	if err := s.bgsave(); err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyStatus("Background saving started")
+}
+
+// lastsaveCommand implements LASTSAVE, the unix time of the last successful
+// save.
+func (s *server) lastsaveCommand(c *clientConn, commands []string) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	c.replyInteger(int(s.lastSave.Unix()))
+}
+
+// persistenceInfo is the persistence section of INFO.
+func (s *server) persistenceInfo() string {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	status, inProgress, aofEnabled := "ok", 0, 0
+	if s.bgsaveErr != nil {
+		status = "err"
+	}
+	if s.bgsaving {
+		inProgress = 1
+	}
+	if s.aof != nil {
+		aofEnabled = 1
+	}
+	var info strings.Builder
+	fmt.Fprintf(&info, "rdb_changes_since_last_save:%d\r\n", s.dirty.Load())
+	fmt.Fprintf(&info, "rdb_bgsave_in_progress:%d\r\n", inProgress)
+	fmt.Fprintf(&info, "rdb_last_save_time:%d\r\n", s.lastSave.Unix())
+	fmt.Fprintf(&info, "rdb_last_bgsave_status:%s\r\n", status)
+	fmt.Fprintf(&info, "aof_enabled:%d\r\n", aofEnabled)
+	return info.String()
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSnapshotOfStartTime(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "string", "before")
+	c.expect(int64(2), "RPUSH", "list", "a", "b")
+	c.expect(int64(1), "HSET", "hash", "field", "before")
+	c.expect(respStatus("OK"), "SET", "deleted", "value")
+
+	// The writes between taking the snapshot and serializing it change
+	// the values in place where they can, which the snapshot must not see.
+	write := s.startSnapshot()
+	c.expect(respStatus("OK"), "SET", "string", "after")
+	c.expect(int64(3), "RPUSH", "list", "c")
+	c.expect(int64(0), "HSET", "hash", "field", "after")
+	c.expect(int64(1), "DEL", "deleted")
+	c.expect(respStatus("OK"), "SET", "added", "value")
+	snapshot := write()
+
+	loaded, loadedAddr := startServer(t)
+	if err := loaded.loadSnapshot(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	l := dial(t, loadedAddr)
+	l.expect("before", "GET", "string")
+	l.expect([]any{"a", "b"}, "LRANGE", "list", "0", "-1")
+	l.expect("before", "HGET", "hash", "field")
+	l.expect("value", "GET", "deleted")
+	l.expect(int64(0), "EXISTS", "added")
+	// The keyspace kept the writes.
+	c.expect([]any{"a", "b", "c"}, "LRANGE", "list", "0", "-1")
+	c.expect("after", "HGET", "hash", "field")
+}
+
+// BenchmarkSetDuringBgsave runs SETs while BGSAVE writes a large dataset
+// over and over, reporting the slowest of them, and fails if one stalled for
+// half as long as serializing the whole dataset takes.
+func BenchmarkSetDuringBgsave(b *testing.B) {
+	s, addr := startServer(b)
+	s.dbs[0].Populate(200000, "key", 100)
+	start := time.Now()
+	s.snapshot()
+	serialization := time.Since(start)
+	c := dial(b, addr)
+	b.ResetTimer()
+	var slowest time.Duration
+	for i := 0; i < b.N; i++ {
+		if !s.bgsaveInProgress() {
+			s.bgsave()
+		}
+		start := time.Now()
+		if reply := c.do("SET", "key:"+strconv.Itoa(i%1000), "value"); reply != respStatus("OK") {
+			b.Fatalf("SET: got %#v", reply)
+		}
+		slowest = max(slowest, time.Since(start))
+	}
+	b.StopTimer()
+	for s.bgsaveInProgress() {
+		time.Sleep(time.Millisecond)
+	}
+	b.ReportMetric(float64(slowest.Microseconds()), "max-µs/op")
+	if slowest > serialization/2 {
+		b.Fatalf("a SET stalled for %v during BGSAVE, which serializes in %v", slowest, serialization)
+	}
+}
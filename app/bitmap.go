@@ -0,0 +1,381 @@
+package main
+
+import (
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+var (
+	errBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+	errBitValue  = errors.New("ERR bit is not an integer or out of range")
+)
+
+// str returns the string stored at key. The caller must hold the write lock.
+func (s *Store) str(key string) (string, bool, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		return "", false, nil
+	}
+	str, ok := val.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return str, true, nil
+}
+
+// SetBit sets the bit at offset, counting from the most significant bit of
+// the first byte, growing the string with zero bytes as needed. It returns
+// the previous value of the bit. The key keeps its TTL.
+func (s *Store) SetBit(key string, offset int, bit int) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	str, _, err := s.str(key)
+	if err != nil {
+		return 0, err
+	}
+	value := []byte(str)
+	byteIndex := offset / 8
+	if byteIndex >= len(value) {
+		value = append(value, make([]byte, byteIndex-len(value)+1)...)
+	}
+	mask := byte(1 << (7 - offset%8))
+	old := 0
+	if value[byteIndex]&mask != 0 {
+		old = 1
+	}
+	if bit == 1 {
+		value[byteIndex] |= mask
+	} else {
+		value[byteIndex] &^= mask
+	}
+	s.setRaw(key, string(value))
+	return old, nil
+}
+
+// GetBit returns the bit at offset, which is 0 past the end of the string.
+func (s *Store) GetBit(key string, offset int) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	str, ok, err := s.str(key)
+	if err != nil {
+		return 0, err
+	}
+	s.countLookup(ok)
+	if offset/8 >= len(str) {
+		return 0, nil
+	}
+	if str[offset/8]&byte(1<<(7-offset%8)) != 0 {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// BitCount counts the set bits between start and end inclusive. The range is
+// in bytes, or in bits when bitMode is set, and negative indexes count from
+// the end of the string.
+func (s *Store) BitCount(key string, start, end int, bitMode bool) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	str, _, err := s.str(key)
+	if err != nil {
+		return 0, err
+	}
+	n := len(str)
+	if bitMode {
+		n *= 8
+	}
+	start, end, ok := normalizeRange(start, end, n)
+	if !ok {
+		return 0, nil
+	}
+	count := 0
+	if !bitMode {
+		for i := start; i <= end; i++ {
+			count += bits.OnesCount8(str[i])
+		}
+		return count, nil
+	}
+	for i := start; i <= end; i++ {
+		if str[i/8]&byte(1<<(7-i%8)) != 0 {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// BitOp stores the bitwise op (and, or, xor or not) of the strings at keys in
+// dest and returns the length of the result. Shorter strings are treated as if
+// padded with zero bytes. An empty result deletes dest.
+func (s *Store) BitOp(op, dest string, keys []string) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	sources := make([]string, len(keys))
+	size := 0
+	for i, key := range keys {
+		str, _, err := s.str(key)
+		if err != nil {
+			return 0, err
+		}
+		sources[i] = str
+		size = max(size, len(str))
+	}
+
+	result := make([]byte, size)
+	for i := range result {
+		var b byte
+		for j, src := range sources {
+			var v byte
+			if i < len(src) {
+				v = src[i]
+			}
+			switch {
+			case op == "not":
+				b = ^v
+			case j == 0:
+				b = v
+			case op == "and":
+				b &= v
+			case op == "or":
+				b |= v
+			case op == "xor":
+				b ^= v
+			}
+		}
+		result[i] = b
+	}
+
+	if size == 0 {
+		s.remove(dest)
+		return 0, nil
+	}
+	delete(s.Expiries, dest)
+	s.setRaw(dest, string(result))
+	return size, nil
+}
+
+// BitPos returns the position of the first bit set to bit between start and
+// end inclusive, in bytes or in bits when bitMode is set, or -1 if there is
+// none. When looking for a clear bit without an explicit end, the string is
+// considered padded with zeros on the right, so the bit just past the range
+// is returned.
+func (s *Store) BitPos(key string, bit, start, end int, endGiven, bitMode bool) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	str, ok, err := s.str(key)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		if bit == 0 {
+			return 0, nil
+		}
+		return -1, nil
+	}
+	n := len(str)
+	if bitMode {
+		n *= 8
+	}
+	start, end, ok = normalizeRange(start, end, n)
+	if !ok {
+		return -1, nil
+	}
+	first, last := start, end
+	if !bitMode {
+		first, last = start*8, end*8+7
+	}
+	for i := first; i <= last; i++ {
+		set := str[i/8]&byte(1<<(7-i%8)) != 0
+		if set == (bit == 1) {
+			return i, nil
+		}
+	}
+	if bit == 0 && !endGiven {
+		return last + 1, nil
+	}
+	return -1, nil
+}
+
+// normalizeRange resolves an inclusive range whose negative indexes count
+// from the end of a sequence of length n, clamping it to the sequence. ok is
+// false when the resulting range is empty.
+func normalizeRange(start, end, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end >= n {
+		end = n - 1
+	}
+	if n == 0 || end < 0 || start > end {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// parseBitOffset parses the offset of a bit within a string of at most
+// maxLen bytes.
+func parseBitOffset(arg string, maxLen int) (int, error) {
+	offset, err := strconv.Atoi(arg)
+	if err != nil || offset < 0 || offset/8 >= maxLen {
+		return 0, errBitOffset
+	}
+	return offset, nil
+}
+
+func (s *server) setbitCommand(c *clientConn, commands []string) {
+	if len(commands) != 4 {
+		c.replyWrongArgs("setbit")
+		return
+	}
+	offset, err := parseBitOffset(commands[2], s.config.bulkLimit())
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if commands[3] != "0" && commands[3] != "1" {
+		c.replyError(errBitValue)
+		return
+	}
+	old, err := s.db(c).SetBit(commands[1], offset, int(commands[3][0]-'0'))
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(old)
+}
+
+func (s *server) getbitCommand(c *clientConn, commands []string) {
+	if len(commands) != 3 {
+		c.replyWrongArgs("getbit")
+		return
+	}
+	offset, err := parseBitOffset(commands[2], s.config.bulkLimit())
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	bit, err := s.db(c).GetBit(commands[1], offset)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(bit)
+}
+
+// bitcountCommand implements BITCOUNT key [start end [BYTE|BIT]].
+func (s *server) bitcountCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("bitcount")
+		return
+	}
+	start, end, bitMode := 0, -1, false
+	switch len(commands) {
+	case 2:
+	case 4, 5:
+		var err1, err2 error
+		start, err1 = strconv.Atoi(commands[2])
+		end, err2 = strconv.Atoi(commands[3])
+		if err1 != nil || err2 != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+		if len(commands) == 5 {
+			switch strings.ToLower(commands[4]) {
+			case "byte":
+			case "bit":
+				bitMode = true
+			default:
+				c.replyError(errSyntax)
+				return
+			}
+		}
+	default:
+		c.replyError(errSyntax)
+		return
+	}
+	count, err := s.db(c).BitCount(commands[1], start, end, bitMode)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(count)
+}
+
+// bitopCommand implements BITOP AND|OR|XOR|NOT destkey key [key ...].
+func (s *server) bitopCommand(c *clientConn, commands []string) {
+	if len(commands) < 4 {
+		c.replyWrongArgs("bitop")
+		return
+	}
+	op := strings.ToLower(commands[1])
+	switch op {
+	case "and", "or", "xor":
+	case "not":
+		if len(commands) != 4 {
+			c.replyErrorMsg("BITOP NOT must be called with a single source key.")
+			return
+		}
+	default:
+		c.replyError(errSyntax)
+		return
+	}
+	size, err := s.db(c).BitOp(op, commands[2], commands[3:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(size)
+}
+
+// bitposCommand implements BITPOS key bit [start [end [BYTE|BIT]]].
+func (s *server) bitposCommand(c *clientConn, commands []string) {
+	if len(commands) < 3 || len(commands) > 6 {
+		c.replyWrongArgs("bitpos")
+		return
+	}
+	if commands[2] != "0" && commands[2] != "1" {
+		c.replyErrorMsg("The bit argument must be 1 or 0.")
+		return
+	}
+	bit := int(commands[2][0] - '0')
+	start, end, endGiven, bitMode := 0, -1, false, false
+	var err error
+	if len(commands) > 3 {
+		if start, err = strconv.Atoi(commands[3]); err != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+	}
+	if len(commands) > 4 {
+		if end, err = strconv.Atoi(commands[4]); err != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+		endGiven = true
+	}
+	if len(commands) > 5 {
+		switch strings.ToLower(commands[5]) {
+		case "byte":
+		case "bit":
+			bitMode = true
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	pos, err := s.db(c).BitPos(commands[1], bit, start, end, endGiven, bitMode)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(pos)
+}
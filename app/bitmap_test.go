@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestStoreBits(t *testing.T) {
+	s := NewStore(realClock{})
+	if old, err := s.SetBit("key", 100, 1); err != nil || old != 0 {
+		t.Fatalf("SetBit 100: got %d, %v", old, err)
+	}
+	if value, _, _ := s.Get("key"); value != string(make([]byte, 12))+"\x08" {
+		t.Fatalf("SetBit 100 left %q, want 12 zero bytes and then 0x08", value)
+	}
+	if bit, _ := s.GetBit("key", 100); bit != 1 {
+		t.Fatalf("GetBit 100: got %d, want 1", bit)
+	}
+	if bit, _ := s.GetBit("key", 1000); bit != 0 {
+		t.Fatalf("GetBit past the end: got %d, want 0", bit)
+	}
+	if old, _ := s.SetBit("key", 100, 0); old != 1 {
+		t.Fatalf("clearing SetBit 100: got %d, want 1", old)
+	}
+	if count, _ := s.BitCount("key", 0, -1, false); count != 0 {
+		t.Fatalf("BitCount after clearing: got %d, want 0", count)
+	}
+}
+
+func TestBitCommands(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(0), "SETBIT", "key", "23", "1")
+	c.expect("\x00\x00\x01", "GET", "key")
+	c.expect(int64(0), "GETBIT", "key", "8000")
+	c.expect(int64(0), "SETBIT", "key", "0", "1")
+	c.expect(int64(1), "SETBIT", "key", "0", "1")
+	c.expect(int64(2), "BITCOUNT", "key")
+	c.expect(int64(1), "BITCOUNT", "key", "1", "-1")
+	c.expect(int64(1), "BITCOUNT", "key", "1", "23", "BIT")
+	c.expect(int64(0), "BITCOUNT", "key", "5", "10")
+	c.expect(respError("ERR bit is not an integer or out of range"), "SETBIT", "key", "0", "2")
+}
+
+func TestBitOp(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "short", "\x01")
+	c.expect(respStatus("OK"), "SET", "long", "\x10\x20\x30")
+	c.expect(int64(3), "BITOP", "OR", "dest", "short", "long")
+	c.expect("\x11\x20\x30", "GET", "dest")
+	c.expect(int64(3), "BITOP", "AND", "dest", "short", "long", "missing")
+	c.expect("\x00\x00\x00", "GET", "dest")
+	c.expect(int64(1), "BITOP", "NOT", "dest", "short")
+	c.expect("\xfe", "GET", "dest")
+	if _, ok := c.do("BITOP", "NOT", "dest", "short", "long").(respError); !ok {
+		t.Fatal("BITOP NOT with two sources did not fail")
+	}
+	c.expect(int64(0), "BITOP", "OR", "dest", "missing")
+	c.expect(int64(0), "EXISTS", "dest")
+}
+
+func TestBitPos(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "\x00\x00\x10\xff")
+	c.expect(int64(19), "BITPOS", "key", "1")
+	c.expect(int64(0), "BITPOS", "key", "0")
+	c.expect(int64(24), "BITPOS", "key", "1", "3")
+	c.expect(int64(20), "BITPOS", "key", "0", "19", "-1", "BIT")
+	c.expect(int64(-1), "BITPOS", "key", "1", "0", "1")
+	c.expect(int64(-1), "BITPOS", "missing", "1")
+	c.expect(int64(0), "BITPOS", "missing", "0")
+	// The first clear bit of all set bits is past the end, unless an end is
+	// given.
+	c.expect(respStatus("OK"), "SET", "ones", "\xff")
+	c.expect(int64(8), "BITPOS", "ones", "0")
+	c.expect(int64(-1), "BITPOS", "ones", "0", "0", "0")
+}
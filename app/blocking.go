@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	errTimeout         = errors.New("ERR timeout is not a float or out of range")
+	errTimeoutNegative = errors.New("ERR timeout is negative")
+)
+
+// parseTimeout parses the timeout in seconds of a blocking command, where 0
+// stands for waiting forever.
+func parseTimeout(arg string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(arg, 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0, errTimeout
+	}
+	if seconds < 0 {
+		return 0, errTimeoutNegative
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// block serves a blocking pop. pop looks at the keys in db and, if it could
+// pop something, returns the reply, encoded with WriteReply, and the command
+// the pop is propagated as, nil for a read that writes nothing, and a nil
+// reply otherwise. It is run again whenever a key of the database is written, until it pops something, timeout passes unless it is
+// 0, or the server shuts down, which get a nil reply. execMu is only held
+// while pop runs, so that the writes the client waits for can run meanwhile.
+// A negative timeout does not wait: pop runs once, as it does inside EXEC and
+// scripts, which hold execMu.
+func (s *server) block(c *clientConn, timeout time.Duration, pop func(db *Store) (any, []string, error)) {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = s.clock.After(timeout)
+	}
+	for blocked := false; ; blocked = true {
+		if !c.execLocked {
+			s.execMu.RLock()
+		}
+		db := s.db(c)
+		ready := db.readyChan()
+		reply, propagated, err := pop(db)
+		if reply != nil && propagated != nil {
+			s.propagate(c.db, propagated)
+		}
+		if !c.execLocked {
+			s.execMu.RUnlock()
+		}
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		if reply != nil {
+			c.replyValue(reply)
+			return
+		}
+		if timeout < 0 || c.execLocked {
+			c.replyNullArray()
+			return
+		}
+		if !blocked {
+			s.blockedClients.Add(1)
+			defer s.blockedClients.Add(-1)
+		}
+		select {
+		case <-ready:
+		case <-deadline:
+			c.replyNullArray()
+			return
+		case <-s.shuttingDown:
+			c.replyNullArray()
+			return
+		}
+	}
+}
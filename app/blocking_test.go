@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// blockedOn waits until n clients of s are blocked.
+func blockedOn(t *testing.T, s *server, n int64) {
+	t.Helper()
+	waitFor(t, "the clients to block", func() bool { return s.blockedClients.Load() == n })
+}
+
+func TestBlockingPopWokenByPush(t *testing.T) {
+	s, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	c.send("BLPOP", "empty", "list", "0")
+	blockedOn(t, s, 1)
+	other.expect(int64(2), "RPUSH", "list", "a", "b")
+	if reply := c.read(); !reflect.DeepEqual(reply, []any{"list", "a"}) {
+		t.Fatalf("BLPOP: got %#v", reply)
+	}
+	other.expect([]any{"b"}, "LRANGE", "list", "0", "-1")
+
+	// A key that has elements already is popped right away, the first of
+	// the keys given that has some.
+	other.expect(int64(1), "RPUSH", "second", "x")
+	c.expect([]any{"list", "b"}, "BRPOP", "empty", "list", "second", "0")
+}
+
+func TestBlockingPopEachElementOnce(t *testing.T) {
+	s, addr := startServer(t)
+	first, second, other := dial(t, addr), dial(t, addr), dial(t, addr)
+	first.send("BLPOP", "list", "0")
+	second.send("BLPOP", "list", "0")
+	blockedOn(t, s, 2)
+	other.expect(int64(2), "RPUSH", "list", "a", "b")
+	got := map[any]bool{}
+	for _, c := range []*testClient{first, second} {
+		reply, _ := c.read().([]any)
+		if len(reply) != 2 {
+			t.Fatalf("BLPOP: got %#v", reply)
+		}
+		got[reply[1]] = true
+	}
+	if !got["a"] || !got["b"] {
+		t.Fatalf("the blocked clients popped %v, want a and b", got)
+	}
+	other.expect(int64(0), "EXISTS", "list")
+}
+
+func TestBlockingPopTimeout(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	start := time.Now()
+	c.expect(nil, "BLPOP", "list", "0.1")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("BLPOP replied after %v, before its timeout", elapsed)
+	}
+	c.expect(respError("ERR timeout is negative"), "BLPOP", "list", "-1")
+	// A blocking pop does not wait inside a transaction.
+	c.expect(respStatus("OK"), "MULTI")
+	c.expect(respStatus("QUEUED"), "BLPOP", "list", "0")
+	c.expect(respStatus("QUEUED"), "RPUSH", "list", "a")
+	c.expect(respStatus("QUEUED"), "BLPOP", "list", "0")
+	c.expect([]any{nil, int64(1), []any{"list", "a"}}, "EXEC")
+}
+
+func TestShutdownWakesBlockedClients(t *testing.T) {
+	s, addr := startServer(t)
+	blpop, bzpop, wait := dial(t, addr), dial(t, addr), dial(t, addr)
+	blpop.send("BLPOP", "list", "0")
+	bzpop.send("BZPOPMIN", "zset", "0")
+	wait.send("WAIT", "1", "0")
+	blockedOn(t, s, 3)
+	start := time.Now()
+	// shutdown then closes the connections and exits, which the test
+	// cannot; the blocked clients react to the signal alone.
+	s.shutdownOnce.Do(func() { close(s.shuttingDown) })
+	if reply := blpop.read(); reply != nil {
+		t.Fatalf("BLPOP: got %#v", reply)
+	}
+	if reply := bzpop.read(); reply != nil {
+		t.Fatalf("BZPOPMIN: got %#v", reply)
+	}
+	if reply := wait.read(); reply != int64(0) {
+		t.Fatalf("WAIT: got %#v", reply)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("the blocked clients replied after %v", elapsed)
+	}
+	blockedOn(t, s, 0)
+}
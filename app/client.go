@@ -0,0 +1,589 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// clientConn is a connection accepted by the server, tracked in the client
+// registry so that it can be listed and killed by other clients, and the
+// state of the connection commands read and change. Internal clients, such
+// as the ones applying the append only file and the replication stream, are
+// clientConns without a conn.
+type clientConn struct {
+	id   int64
+	conn net.Conn
+	// reader buffers what the client sends, the commands of the append
+	// only file for the client loading it.
+	reader  *bufio.Reader
+	addr    string
+	created time.Time
+	// name is set with CLIENT SETNAME, and cmd is the last command the
+	// client ran, as CLIENT LIST shows it.
+	name string
+	cmd  atomic.Pointer[string]
+	// replyOff and skipReply are set with CLIENT REPLY OFF and SKIP, and
+	// silenced drops the replies to the command running.
+	replyOff  bool
+	skipReply bool
+	silenced  atomic.Bool
+	// noEvict is set with CLIENT NO-EVICT ON. Like replica and db, it is
+	// changed under the server's clientsMu, under which CLIENT LIST reads
+	// it.
+	noEvict bool
+	// out queues what is sent to the client until its writer goroutine
+	// writes it to conn, so that the clients pushing messages to it, such
+	// as PUBLISH, never wait for a client that does not read. outLimit is
+	// the size out may reach before the client is disconnected, zero for
+	// no limit. closing is set once the client is closed, after which the
+	// writer writes what is left and closes flushed.
+	outMu    sync.Mutex
+	outCond  *sync.Cond
+	out      []byte
+	outLimit atomic.Int64
+	closing  bool
+	flushed  chan struct{}
+	// broken is set once a write to conn failed or out overflowed. The
+	// connection is closed then, so that its read loop ends and the client
+	// is unregistered.
+	broken atomic.Bool
+	// closeAfterReply is set by QUIT.
+	closeAfterReply bool
+	// multi is set by MULTI, after which the commands are queued until
+	// EXEC, and multiFailed once one of them was rejected, which aborts
+	// EXEC. They are changed under the server's clientsMu, under which
+	// CLIENT LIST reads them.
+	multi       bool
+	queued      [][]string
+	multiFailed bool
+	// execLocked is set while the commands of the client run with execMu
+	// held for writing already: inside EXEC, and on the client scripts run
+	// their commands as. The commands that take execMu themselves then do
+	// not take it again, and the blocking ones do not block.
+	execLocked bool
+	// errorStats counts the error replies sent to the client, nil for
+	// internal clients.
+	errorStats *errorStats
+	// captured collects the replies instead of a connection for the
+	// commands scripts run, and for a write whose reply wait-on-write holds
+	// back. Only the goroutine running the commands of the client uses it.
+	captured *strings.Builder
+	// master is set on the client applying the replication stream, and
+	// listeningPort is the port a replica announced with REPLCONF.
+	master        bool
+	listeningPort string
+	// replica is set once the client turned into a replica with PSYNC.
+	replica *replica
+	// proto is the protocol version set with HELLO, which the clients
+	// pushing messages to this one read, and db the database selected with
+	// SELECT.
+	proto atomic.Int32
+	db    int
+	// user is the ACL user the client is authenticated as, nil until it
+	// runs AUTH if the default user requires a password.
+	user *aclUser
+	// channels, patterns and shardChannels are the pub/sub subscriptions
+	// of the client, guarded by the server's pubsubMu.
+	channels      map[string]struct{}
+	patterns      map[string]struct{}
+	shardChannels map[string]struct{}
+	// tracking is set by CLIENT TRACKING ON. The client is then told about
+	// changes to the trackedKeys it read or, in BCAST mode, to any key
+	// starting with one of its prefixes. They are guarded by the server's
+	// trackingMu.
+	tracking    bool
+	prefixes    []string
+	trackedKeys map[string]struct{}
+}
+
+// reply writes a raw RESP reply to the client. Clients without a connection,
+// such as the one replaying the append only file, discard their replies.
+func (c *clientConn) reply(msg string) {
+	if c.captured != nil {
+		c.captured.WriteString(msg)
+		return
+	}
+	if c.errorStats != nil && strings.HasPrefix(msg, "-") {
+		c.errorStats.count(msg)
+	}
+	c.send(msg)
+}
+
+// send queues msg on the connection of c. Unlike reply, which only the
+// goroutine running the commands of c calls, send is safe to call from any
+// goroutine, for what other clients send c: messages, invalidations, the
+// lines of MONITOR and the replication stream.
+func (c *clientConn) send(msg string) {
+	if c.conn == nil || c.silenced.Load() || c.broken.Load() {
+		return
+	}
+	c.outMu.Lock()
+	c.out = append(c.out, msg...)
+	overflow := c.outLimit.Load() > 0 && int64(len(c.out)) > c.outLimit.Load()
+	c.outMu.Unlock()
+	c.outCond.Signal()
+	if overflow {
+		fmt.Printf("Client %d closed for overcoming of output buffer limits.\n", c.id)
+		c.fail()
+	}
+}
+
+// The output buffer limits of subscribers and replicas, past which they are
+// disconnected. The replies of other clients are not limited.
+const (
+	pubsubOutputLimit  = 32 << 20
+	replicaOutputLimit = 256 << 20
+)
+
+// flushTimeout is how long closing a client waits for what is left of its
+// output to be written.
+const flushTimeout = time.Second
+
+// writeLoop writes the output of c to its connection as it is queued, until
+// c is closed and its output written or a write fails.
+func (c *clientConn) writeLoop() {
+	defer close(c.flushed)
+	for {
+		c.outMu.Lock()
+		for len(c.out) == 0 && !c.closing {
+			c.outCond.Wait()
+		}
+		out := c.out
+		c.out = nil
+		c.outMu.Unlock()
+		if len(out) == 0 {
+			return
+		}
+		if _, err := c.conn.Write(out); err != nil {
+			c.fail()
+			return
+		}
+	}
+}
+
+// fail drops the output of c and closes its connection.
+func (c *clientConn) fail() {
+	if !c.broken.Swap(true) {
+		c.conn.Close()
+	}
+	c.outMu.Lock()
+	c.out, c.closing = nil, true
+	c.outMu.Unlock()
+	c.outCond.Signal()
+}
+
+// close closes the connection of c once its output is written, or once
+// deadline passes.
+func (c *clientConn) close(deadline time.Time) {
+	c.outMu.Lock()
+	c.closing = true
+	c.outMu.Unlock()
+	c.outCond.Signal()
+	select {
+	case <-c.flushed:
+	case <-time.After(time.Until(deadline)):
+	}
+	c.conn.Close()
+}
+
+// startCommand decides whether the replies to the command the client is about
+// to run are sent, following its CLIENT REPLY mode.
+func (c *clientConn) startCommand() {
+	c.silenced.Store(c.replyOff || c.skipReply)
+	c.skipReply = false
+}
+
+var errMaxClients = errors.New("ERR max number of clients reached")
+
+// registerClient adds conn to the client registry, unless it already holds
+// maxclients clients.
+func (s *server) registerClient(conn net.Conn) (*clientConn, error) {
+	limit := s.config.clientLimit()
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if len(s.clients) >= limit {
+		return nil, errMaxClients
+	}
+	c := &clientConn{
+		id:      s.nextClientID.Add(1),
+		conn:    conn,
+		reader:  bufio.NewReaderSize(conn, s.config.readBufferSize()),
+		addr:    conn.RemoteAddr().String(),
+		created: time.Now(),
+		user:    s.acl.login(),
+
+		errorStats: &s.errorStats,
+		flushed:    make(chan struct{}),
+	}
+	c.outCond = sync.NewCond(&c.outMu)
+	go c.writeLoop()
+	s.clients[c.id] = c
+	s.totalConnections.Add(1)
+	return c, nil
+}
+
+// unregisterClient forgets a client that disconnected everywhere it is
+// referred to: the client registry, the tracking tables, the pub/sub
+// subscriptions, the monitors and, for a replica, the replicas fed the
+// replication stream.
+func (s *server) unregisterClient(c *clientConn) {
+	s.clientsMu.Lock()
+	delete(s.clients, c.id)
+	s.clientsMu.Unlock()
+	s.trackingMu.Lock()
+	s.untrack(c)
+	s.trackingMu.Unlock()
+	s.unsubscribeAll(c)
+	s.monitorsMu.Lock()
+	delete(s.monitors, c)
+	s.monitorsMu.Unlock()
+	if c.replica != nil {
+		s.removeReplica(c.replica)
+	}
+}
+
+// next reads the next command the client sends, refusing bulk strings of
+// more than maxBulkLen bytes unless it is zero.
+func (c *clientConn) next(maxBulkLen int) ([]string, error) {
+	return readCommand(c.reader, maxBulkLen)
+}
+
+// clientInfo describes c on one line of CLIENT LIST, where multi is the
+// number of commands queued since MULTI, or -1 outside of a transaction. The
+// caller must hold clientsMu, unless c is its own client.
+func (s *server) clientInfo(c *clientConn) string {
+	s.pubsubMu.Lock()
+	sub, psub, ssub := len(c.channels), len(c.patterns), len(c.shardChannels)
+	s.pubsubMu.Unlock()
+	cmd := "NULL"
+	if last := c.cmd.Load(); last != nil {
+		cmd = *last
+	}
+	proto := 2
+	if c.resp3() {
+		proto = 3
+	}
+	flags := ""
+	if c.replica != nil {
+		flags += "S"
+	}
+	if sub+psub+ssub > 0 {
+		flags += "P"
+	}
+	if c.noEvict {
+		flags += "e"
+	}
+	multi := -1
+	if c.multi {
+		flags += "x"
+		multi = len(c.queued)
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d flags=%s db=%d sub=%d psub=%d ssub=%d multi=%d cmd=%s resp=%d",
+		c.id, c.addr, c.conn.LocalAddr(), c.name, int(time.Since(c.created).Seconds()), flags, c.db, sub, psub, ssub, multi, cmd, proto)
+}
+
+// setCommandName records the command c is running for CLIENT LIST, with its
+// subcommand for the commands that have some.
+func (c *clientConn) setCommandName(commands []string) {
+	name := commands[0]
+	if _, ok := commandHelp[name]; ok && len(commands) > 1 {
+		name += "|" + strings.ToLower(commands[1])
+	}
+	c.cmd.Store(&name)
+}
+
+// validClientName reports whether name may be set with CLIENT SETNAME, which
+// rejects spaces and other characters CLIENT LIST could not show.
+func validClientName(name string) bool {
+	for _, r := range name {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *server) clientCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyErrorMsg("wrong number of arguments for 'client' command")
+		return
+	}
+	switch strings.ToLower(commands[1]) {
+	case "list":
+		s.clientsMu.Lock()
+		var list strings.Builder
+		for _, other := range s.clients {
+			list.WriteString(s.clientInfo(other) + "\n")
+		}
+		s.clientsMu.Unlock()
+		c.replyBulk(list.String())
+	case "id":
+		c.replyInteger(int(c.id))
+	case "info":
+		c.replyVerbatim("txt", s.clientInfo(c)+"\n")
+	case "reply":
+		if len(commands) != 3 {
+			c.replyWrongArgs("client|reply")
+			return
+		}
+		switch strings.ToLower(commands[2]) {
+		case "on":
+			c.replyOff = false
+			c.silenced.Store(false)
+			c.replyOK()
+		case "off":
+			c.replyOff = true
+			c.silenced.Store(true)
+		case "skip":
+			if !c.replyOff {
+				c.skipReply = true
+				c.silenced.Store(true)
+			}
+		default:
+			c.replyError(errSyntax)
+		}
+	case "no-evict":
+		if len(commands) != 3 {
+			c.replyWrongArgs("client|no-evict")
+			return
+		}
+		var noEvict bool
+		switch strings.ToLower(commands[2]) {
+		case "on":
+			noEvict = true
+		case "off":
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+		s.clientsMu.Lock()
+		c.noEvict = noEvict
+		s.clientsMu.Unlock()
+		c.replyOK()
+	case "setname":
+		if len(commands) != 3 {
+			c.replyWrongArgs("client|setname")
+			return
+		}
+		if !validClientName(commands[2]) {
+			c.replyErrorMsg("Client names cannot contain spaces, newlines or special characters.")
+			return
+		}
+		s.clientsMu.Lock()
+		c.name = commands[2]
+		s.clientsMu.Unlock()
+		c.replyOK()
+	case "getname":
+		if c.name == "" {
+			c.replyNull()
+			return
+		}
+		c.replyBulk(c.name)
+	case "kill":
+		s.clientKill(c, commands[2:])
+	case "pause":
+		s.clientPause(c, commands[2:])
+	case "tracking":
+		s.trackingCommand(c, commands[2:])
+	case "unpause":
+		s.unpause()
+		c.replyOK()
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try CLIENT HELP.", commands[1]))
+	}
+}
+
+// resetCommand implements RESET, which puts the connection back the way it
+// was when the client connected: it leaves the transaction, tracking,
+// pub/sub and MONITOR, turns its replies back on, switches back to RESP2 and
+// the database 0, and authenticates it as the default user again if that
+// needs no password.
+func (s *server) resetCommand(c *clientConn, commands []string) {
+	s.endMulti(c)
+	s.trackingMu.Lock()
+	s.untrack(c)
+	s.trackingMu.Unlock()
+	s.unsubscribeAll(c)
+	s.monitorsMu.Lock()
+	delete(s.monitors, c)
+	s.monitorsMu.Unlock()
+	c.replyOff, c.skipReply = false, false
+	c.silenced.Store(false)
+	c.proto.Store(2)
+	s.clientsMu.Lock()
+	c.db = 0
+	s.clientsMu.Unlock()
+	c.user = s.acl.login()
+	c.replyStatus("RESET")
+}
+
+// clientKill implements both the old CLIENT KILL ip:port form, which replies
+// +OK, and the filter form (ID, ADDR, SKIPME), which replies with the number of
+// clients killed. Closing the target's conn makes its blocked Read return, so
+// its own handleConnection goroutine runs the usual teardown. A client that
+// kills itself is closed once its reply is sent, as after QUIT.
+func (s *server) clientKill(c *clientConn, args []string) {
+	if len(args) == 0 {
+		c.replyErrorMsg("syntax error")
+		return
+	}
+	legacy := len(args) == 1
+	var id int64 = -1
+	addr := ""
+	skipMe := !legacy
+	if legacy {
+		addr = args[0]
+	} else {
+		if len(args)%2 != 0 {
+			c.replyErrorMsg("syntax error")
+			return
+		}
+		for i := 0; i < len(args); i += 2 {
+			switch strings.ToLower(args[i]) {
+			case "id":
+				parsed, err := strconv.ParseInt(args[i+1], 10, 64)
+				if err != nil || parsed <= 0 {
+					c.replyErrorMsg("client-id should be greater than 0")
+					return
+				}
+				id = parsed
+			case "addr":
+				addr = args[i+1]
+			case "skipme":
+				switch strings.ToLower(args[i+1]) {
+				case "yes":
+					skipMe = true
+				case "no":
+					skipMe = false
+				default:
+					c.replyErrorMsg("syntax error")
+					return
+				}
+			default:
+				c.replyErrorMsg("syntax error")
+				return
+			}
+		}
+	}
+
+	var victims []*clientConn
+	s.clientsMu.Lock()
+	for _, other := range s.clients {
+		if id != -1 && other.id != id {
+			continue
+		}
+		if addr != "" && other.addr != addr {
+			continue
+		}
+		if skipMe && other == c {
+			continue
+		}
+		victims = append(victims, other)
+	}
+	s.clientsMu.Unlock()
+
+	killSelf := false
+	for _, victim := range victims {
+		if victim == c {
+			killSelf = true
+			continue
+		}
+		victim.conn.Close()
+	}
+
+	if legacy {
+		if len(victims) == 0 {
+			c.replyErrorMsg("No such client")
+		} else {
+			c.replyOK()
+		}
+	} else {
+		c.replyInteger(len(victims))
+	}
+	if killSelf {
+		c.closeAfterReply = true
+	}
+}
+
+// clientPause holds back commands from every client until the given number of
+// milliseconds has passed. With WRITE only commands that modify the keyspace
+// are held back; ALL, the default, holds back everything.
+func (s *server) clientPause(c *clientConn, args []string) {
+	if len(args) < 1 || len(args) > 2 {
+		c.replyErrorMsg("wrong number of arguments for 'client|pause' command")
+		return
+	}
+	ms, err := strconv.Atoi(args[0])
+	if err != nil || ms < 0 {
+		c.replyErrorMsg("timeout is not an integer or out of range")
+		return
+	}
+	all := true
+	if len(args) == 2 {
+		switch strings.ToLower(args[1]) {
+		case "all":
+		case "write":
+			all = false
+		default:
+			c.replyErrorMsg("syntax error")
+			return
+		}
+	}
+
+	now := s.clock.Now()
+	end := now.Add(time.Duration(ms) * time.Millisecond)
+	s.pauseMu.Lock()
+	if now.Before(s.pauseEnd) {
+		// A pause is already in effect: keep the later deadline and the
+		// stricter mode.
+		s.pauseAll = s.pauseAll || all
+	} else {
+		s.pauseAll = all
+	}
+	if end.After(s.pauseEnd) {
+		s.pauseEnd = end
+	}
+	s.pauseMu.Unlock()
+	c.replyOK()
+}
+
+func (s *server) unpause() {
+	s.pauseMu.Lock()
+	s.pauseEnd = time.Time{}
+	close(s.unpaused)
+	s.unpaused = make(chan struct{})
+	s.pauseMu.Unlock()
+}
+
+// waitWhilePaused blocks the calling connection until a CLIENT PAUSE covering
+// command is over. CLIENT itself is never held back so that a pause can always
+// be lifted with CLIENT UNPAUSE.
+func (s *server) waitWhilePaused(command string) {
+	if command == "client" {
+		return
+	}
+	for {
+		s.pauseMu.Lock()
+		remaining := s.pauseEnd.Sub(s.clock.Now())
+		covered := s.pauseAll || commandHas(command, cmdWrite) || commandHas(command, cmdMayReplicate)
+		unpaused := s.unpaused
+		s.pauseMu.Unlock()
+		if remaining <= 0 || !covered {
+			return
+		}
+		select {
+		case <-s.clock.After(remaining):
+		case <-unpaused:
+		}
+	}
+}
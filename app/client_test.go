@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// clientCount returns the number of clients registered with s.
+func clientCount(s *server) int {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	return len(s.clients)
+}
+
+func TestClientKill(t *testing.T) {
+	s, addr := startServer(t)
+	victim, killer := dial(t, addr), dial(t, addr)
+	id, ok := victim.do("CLIENT", "ID").(int64)
+	if !ok {
+		t.Fatal("CLIENT ID did not reply an integer")
+	}
+	killer.expect(int64(1), "CLIENT", "KILL", "ID", strconv.FormatInt(id, 10))
+	if !victim.closed() {
+		t.Fatal("the killed connection was not closed")
+	}
+	waitFor(t, "the killed client to be unregistered", func() bool { return clientCount(s) == 1 })
+	killer.expect(int64(0), "CLIENT", "KILL", "ID", strconv.FormatInt(id, 10))
+}
+
+func TestClientPause(t *testing.T) {
+	_, addr := startServer(t)
+	pauser, writer := dial(t, addr), dial(t, addr)
+	pause := 300 * time.Millisecond
+	start := time.Now()
+	pauser.expect(respStatus("OK"), "CLIENT", "PAUSE", strconv.Itoa(int(pause.Milliseconds())), "WRITE")
+	// Reads go on during a WRITE pause.
+	writer.expect(nil, "GET", "key")
+	writer.expect(respStatus("OK"), "SET", "key", "value")
+	if elapsed := time.Since(start); elapsed < pause {
+		t.Fatalf("SET completed after %v, within the %v pause", elapsed, pause)
+	}
+	writer.expect("value", "GET", "key")
+}
+
+func TestIdleTimeout(t *testing.T) {
+	_, addr := startServer(t, "timeout", "1")
+	idle, busy := dial(t, addr), dial(t, addr)
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		time.Sleep(250 * time.Millisecond)
+		busy.expect(respStatus("PONG"), "PING")
+	}
+	if !idle.closed() {
+		t.Fatal("the idle connection was not closed")
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("the idle connection was closed after %v, within the timeout", elapsed)
+	}
+	busy.expect(respStatus("PONG"), "PING")
+}
+
+func TestMaxClients(t *testing.T) {
+	s, addr := startServer(t, "maxclients", "1")
+	first := dial(t, addr)
+	first.expect(respStatus("PONG"), "PING")
+	second := dial(t, addr)
+	if reply := second.read(); reply != respError("ERR max number of clients reached") {
+		t.Fatalf("connecting past maxclients: got %#v", reply)
+	}
+	if !second.closed() {
+		t.Fatal("the rejected connection was not closed")
+	}
+	first.expect(respStatus("PONG"), "PING")
+
+	// The slot of a client that leaves is free again.
+	first.conn.Close()
+	waitFor(t, "the first client to be unregistered", func() bool { return clientCount(s) == 0 })
+	dial(t, addr).expect(respStatus("PONG"), "PING")
+}
+
+func TestSilentPeerReaped(t *testing.T) {
+	s, addr := startServer(t, "timeout", "1")
+	before := runtime.NumGoroutine()
+	c := dial(t, addr)
+	c.hello3()
+	c.send("CLIENT", "TRACKING", "ON")
+	c.send("GET", "key")
+	c.readRaw()
+	s.trackingMu.Lock()
+	tracked := len(s.trackedKeys)
+	s.trackingMu.Unlock()
+	if tracked != 1 {
+		t.Fatalf("%d keys tracked, want 1", tracked)
+	}
+	// The peer neither reads nor writes from now on, nor closes its end.
+	waitFor(t, "the silent client to be unregistered", func() bool { return clientCount(s) == 0 })
+	s.trackingMu.Lock()
+	tracked = len(s.trackedKeys)
+	s.trackingMu.Unlock()
+	if tracked != 0 {
+		t.Fatalf("%d keys still tracked for the reaped client", tracked)
+	}
+	waitFor(t, "the connection goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestClientInfo(t *testing.T) {
+	_, addr := startServer(t)
+	first, second := dial(t, addr), dial(t, addr)
+	firstID, _ := first.do("CLIENT", "ID").(int64)
+	secondID, _ := second.do("CLIENT", "ID").(int64)
+	if secondID <= firstID {
+		t.Fatalf("client ids %d and then %d are not increasing", firstID, secondID)
+	}
+	second.expect(respStatus("OK"), "SELECT", "3")
+	second.expect(respStatus("OK"), "CLIENT", "SETNAME", "worker")
+	info, _ := second.do("CLIENT", "INFO").(string)
+	for _, field := range []string{
+		"id=" + strconv.FormatInt(secondID, 10) + " ",
+		" addr=" + second.conn.LocalAddr().String() + " ",
+		" name=worker ",
+		" db=3 ",
+		" sub=0 ",
+		" multi=-1 ",
+		" cmd=client|info ",
+		" resp=2",
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("CLIENT INFO %q lacks %q", info, field)
+		}
+	}
+	if !strings.HasSuffix(info, "\n") || strings.Count(info, "\n") != 1 {
+		t.Errorf("CLIENT INFO %q is not a single line", info)
+	}
+}
+
+func TestHandlersMutateClient(t *testing.T) {
+	s, _ := startServer(t)
+	c, reader := pipeClient(t, s)
+	run := func(want any, command ...string) {
+		t.Helper()
+		s.execute(c, command)
+		if reply, err := readReply(reader); err != nil || !reflect.DeepEqual(reply, want) {
+			t.Fatalf("%v: got %#v, %v, want %#v", command, reply, err, want)
+		}
+	}
+	run(respStatus("OK"), "SELECT", "5")
+	run(respStatus("OK"), "CLIENT", "SETNAME", "direct")
+	run(respStatus("OK"), "MULTI")
+	if c.db != 5 || c.name != "direct" || !c.multi {
+		t.Fatalf("client state: db %d, name %q, multi %v", c.db, c.name, c.multi)
+	}
+	run(respStatus("OK"), "DISCARD")
+	if c.multi {
+		t.Fatal("DISCARD did not end the transaction")
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.send("CLIENT", "REPLY", "OFF")
+	c.send("SET", "key", "off")
+	c.send("CLIENT", "REPLY", "ON")
+	if reply := c.read(); reply != respStatus("OK") {
+		t.Fatalf("CLIENT REPLY ON: got %#v, want the first reply since OFF", reply)
+	}
+	c.expect("off", "GET", "key")
+
+	c.send("CLIENT", "REPLY", "SKIP")
+	c.send("SET", "key", "skipped")
+	c.expect("skipped", "GET", "key")
+}
+
+func TestReset(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "ACL", "SETUSER", "reader", "on", ">secret", "~*", "+get", "+reset")
+	c.expect(respStatus("OK"), "AUTH", "reader", "secret")
+	c.expect(respStatus("RESET"), "RESET")
+	c.expect(respStatus("OK"), "SET", "key", "db0")
+
+	c.expect(respStatus("OK"), "SELECT", "1")
+	c.expect(respStatus("OK"), "MULTI")
+	c.expect(respStatus("RESET"), "RESET")
+	c.expect(respError("ERR EXEC without MULTI"), "EXEC")
+	c.expect("db0", "GET", "key")
+
+	c.send("SUBSCRIBE", "news")
+	c.read()
+	c.expect(respStatus("RESET"), "RESET")
+	c.expect("db0", "GET", "key")
+
+	c.hello3()
+	c.send("CLIENT", "REPLY", "OFF")
+	c.expect(respStatus("RESET"), "RESET")
+	if reply := c.readRaw(); reply != "" {
+		t.Fatalf("after RESET: got %q, want nothing more", reply)
+	}
+	c.send("PUBSUB", "NUMSUB")
+	if reply := c.readRaw(); reply != "*0\r\n" {
+		t.Fatalf("PUBSUB NUMSUB after RESET: got %q, want a RESP2 array", reply)
+	}
+}
+
+func TestPanicRecovery(t *testing.T) {
+	// The command is registered before the server starts, and removed once
+	// its connections are gone.
+	commandTable["test-panic"] = commandHandler{
+		fn:    func(*server, *clientConn, []string) { panic("test panic") },
+		arity: 1,
+		flags: cmdWrite,
+	}
+	t.Cleanup(func() { delete(commandTable, "test-panic") })
+	s, addr := startServer(t)
+	victim, other := dial(t, addr), dial(t, addr)
+	other.expect(respStatus("OK"), "SET", "key", "value")
+	victim.expect(respError("ERR internal error"), "TEST-PANIC")
+	if !victim.closed() {
+		t.Fatal("the connection of the panicking command was not closed")
+	}
+	other.expect("value", "GET", "key")
+	other.expect(respStatus("OK"), "SET", "key", "other")
+	waitFor(t, "the panicking client to be unregistered", func() bool { return clientCount(s) == 1 })
+}
+
+// failingConn is a connection whose writes fail, as after a broken pipe.
+type failingConn struct {
+	net.Conn
+}
+
+func (failingConn) Write([]byte) (int, error) {
+	return 0, syscall.EPIPE
+}
+
+func TestWriteErrorEndsConnection(t *testing.T) {
+	s, _ := startServer(t)
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	done := make(chan struct{})
+	go func() {
+		s.handleConnection(failingConn{conn})
+		close(done)
+	}()
+	// The second command is not run: the connection ends on the failed
+	// reply of the first.
+	peer.Write([]byte(createArrayMsg([]string{"SUBSCRIBE", "news"}) + createArrayMsg([]string{"SET", "key", "value"})))
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the connection did not end after its write failed")
+	}
+	if n := clientCount(s); n != 0 {
+		t.Fatalf("%d clients still registered", n)
+	}
+	if n := s.publish("news", "hello"); n != 0 {
+		t.Fatalf("the channel still has %d subscribers", n)
+	}
+	if _, ok, _ := s.dbs[0].Get("key"); ok {
+		t.Fatal("the command after the failed reply was run")
+	}
+}
+
+func TestQuit(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	// The replies to the commands pipelined before QUIT are flushed first,
+	// and those after it are never run.
+	c.send("SET", "key", "value")
+	c.send("QUIT")
+	c.send("SET", "key", "other")
+	if reply := c.read(); reply != respStatus("OK") {
+		t.Fatalf("SET: got %#v", reply)
+	}
+	if reply := c.read(); reply != respStatus("OK") {
+		t.Fatalf("QUIT: got %#v, want OK", reply)
+	}
+	if !c.closed() {
+		t.Fatal("the connection was not closed after QUIT")
+	}
+	waitFor(t, "the client to be unregistered", func() bool { return clientCount(s) == 0 })
+	if value, _, _ := s.dbs[0].Get("key"); value != "value" {
+		t.Fatalf("the command after QUIT was run: key is %q", value)
+	}
+}
+
+func TestReadBufferSize(t *testing.T) {
+	s, _ := startServer(t)
+	c, _ := pipeClient(t, s)
+	if size := c.reader.Size(); size != 16<<10 {
+		t.Errorf("default read buffer: got %d bytes, want 16kb", size)
+	}
+	small, _ := startServer(t, "read-buffer-size", "1kb")
+	c, _ = pipeClient(t, small)
+	if size := c.reader.Size(); size != 1<<10 {
+		t.Errorf("read-buffer-size 1kb: got %d bytes", size)
+	}
+	if err := newConfig().set("read-buffer-size", "512", true); err == nil {
+		t.Error("a read buffer under 1kb was accepted")
+	}
+}
+
+// BenchmarkReadCommandBuffer reads commands with a large value through read
+// buffers of the default size and of the smallest allowed.
+func BenchmarkReadCommandBuffer(b *testing.B) {
+	command := createArrayMsg([]string{"SET", "key", strings.Repeat("x", 256<<10)})
+	for _, size := range []int{1 << 10, 16 << 10} {
+		b.Run(strconv.Itoa(size>>10)+"kb", func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(command)))
+			for i := 0; i < b.N; i++ {
+				reader := bufio.NewReaderSize(strings.NewReader(command), size)
+				if _, err := readCommand(reader, 512<<20); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
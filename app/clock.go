@@ -0,0 +1,18 @@
+package main
+
+import "time"
+
+// clock is the source of time for expiries and blocking timeouts. It is an
+// interface so that tests can move time forward without sleeping.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock reads the system clock. The times it returns carry a monotonic
+// reading, so durations between them are not affected by wall clock jumps.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
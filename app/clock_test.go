@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock that only moves when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeTimer
+}
+
+type fakeTimer struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1700000000, 0)}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- f.now
+		return ch
+	}
+	f.waiters = append(f.waiters, fakeTimer{f.now.Add(d), ch})
+	return ch
+}
+
+// Advance moves the clock forward by d, firing the timers that are due.
+func (f *fakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+		} else {
+			w.ch <- f.now
+		}
+	}
+	f.waiters = pending
+}
+
+// timers returns the number of timers waiting to fire.
+func (f *fakeClock) timers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+func TestFakeClockExpiry(t *testing.T) {
+	clk := newFakeClock()
+	_, addr := startServerWithClock(t, clk)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value", "EX", "10")
+	clk.Advance(9 * time.Second)
+	c.expect("value", "GET", "key")
+	c.expect(int64(1), "TTL", "key")
+	clk.Advance(2 * time.Second)
+	c.expect(nil, "GET", "key")
+	c.expect(int64(-2), "TTL", "key")
+}
+
+func TestFakeClockBlockingTimeout(t *testing.T) {
+	clk := newFakeClock()
+	_, addr := startServerWithClock(t, clk)
+	c := dial(t, addr)
+	c.send("BLPOP", "list", "30")
+	waitFor(t, "BLPOP to wait on the clock", func() bool { return clk.timers() > 0 })
+	clk.Advance(30 * time.Second)
+	if reply := c.read(); reply != nil {
+		t.Fatalf("BLPOP after its timeout: got %#v, want a null array", reply)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// clusterCommand implements CLUSTER INFO, MYID, NODES, SLOTS and SHARDS, which
+// cluster-aware clients send to find out how the keys are spread. They
+// describe a single node with cluster support disabled and no slots, whose
+// id is the run id.
+func (s *server) clusterCommand(c *clientConn, commands []string) {
+	subcommand := strings.ToLower(commands[1])
+	if len(commands) != 2 {
+		c.replyWrongArgs("cluster|" + subcommand)
+		return
+	}
+	switch subcommand {
+	case "info":
+		var info strings.Builder
+		info.WriteString("cluster_enabled:0\r\n")
+		info.WriteString("cluster_state:ok\r\n")
+		info.WriteString("cluster_slots_assigned:0\r\n")
+		info.WriteString("cluster_known_nodes:1\r\n")
+		info.WriteString("cluster_size:0\r\n")
+		info.WriteString("cluster_current_epoch:0\r\n")
+		info.WriteString("cluster_my_epoch:0\r\n")
+		c.replyVerbatim("txt", info.String())
+	case "myid":
+		c.replyBulk(s.runID)
+	case "nodes":
+		addr := fmt.Sprintf("127.0.0.1:%d", s.config.port)
+		if c.conn != nil {
+			addr = c.conn.LocalAddr().String()
+		}
+		c.replyVerbatim("txt", fmt.Sprintf("%s %s@%d myself,master - 0 0 0 connected\n", s.runID, addr, s.config.port+10000))
+	case "slots", "shards":
+		c.replyValue([]any{})
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try CLUSTER HELP.", commands[1]))
+	}
+}
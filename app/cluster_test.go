@@ -0,0 +1,23 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClusterStubs(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	if info := infoField(c.do("CLUSTER", "INFO"), "cluster_enabled"); info != "0" {
+		t.Fatalf("CLUSTER INFO: got cluster_enabled:%s, want 0", info)
+	}
+	c.expect(s.runID, "CLUSTER", "MYID")
+	c.expect(s.runID, "CLUSTER", "MYID")
+	nodes, _ := c.do("CLUSTER", "NODES").(string)
+	if fields := strings.Fields(nodes); len(fields) < 3 || fields[0] != s.runID || !strings.HasPrefix(fields[1], addr+"@") || fields[2] != "myself,master" {
+		t.Fatalf("CLUSTER NODES: got %q", nodes)
+	}
+	c.expect([]any{}, "CLUSTER", "SLOTS")
+	c.expect([]any{}, "CLUSTER", "SHARDS")
+	c.expect(respError("ERR wrong number of arguments for 'cluster|info' command"), "CLUSTER", "INFO", "extra")
+}
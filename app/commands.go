@@ -0,0 +1,456 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Command flags.
+const (
+	// cmdWrite commands modify the keyspace. They are rejected on replicas
+	// and held back by CLIENT PAUSE WRITE.
+	cmdWrite = 1 << iota
+	// cmdRead commands read keys, which CLIENT TRACKING remembers.
+	cmdRead
+	// cmdAdmin commands manage the server rather than the data.
+	cmdAdmin
+	// cmdPubSub commands are about pub/sub.
+	cmdPubSub
+	// cmdNoScript commands may not be called from scripts, because they
+	// block, take over the connection or run scripts themselves.
+	cmdNoScript
+	// cmdUnlocked commands run without execMu held: they either take it
+	// themselves or block for long and would hold up scripts.
+	cmdUnlocked
+	// cmdSkipMonitor commands are not shown to MONITOR clients, because
+	// they carry passwords or are MONITOR itself.
+	cmdSkipMonitor
+	// cmdDenyOOM commands may grow the keyspace, so they are rejected when
+	// it is over maxmemory and nothing can be evicted.
+	cmdDenyOOM
+	// cmdNoTouch commands look at keys without counting as an access for
+	// eviction.
+	cmdNoTouch
+	// cmdBlocking commands wait for replicas, the disk or keys, which is not
+	// latency of the server they would report to LATENCY.
+	cmdBlocking
+	// cmdMayReplicate commands, the scripts, write through the commands they
+	// run, so CLIENT PAUSE WRITE holds them back as well.
+	cmdMayReplicate
+	// cmdNoMulti commands may not be queued inside MULTI, because they take
+	// over the connection or the server.
+	cmdNoMulti
+)
+
+// commandHandler is an entry of the command table. arity is the number of
+// arguments, the command name included, or minus the minimum number for
+// commands that take a variable number.
+type commandHandler struct {
+	fn    func(s *server, c *clientConn, commands []string)
+	arity int
+	flags int
+	keys  keySpec
+}
+
+// keySpec tells which arguments of a command are keys: those from first to
+// last, a negative last counting from the end, every step arguments. The
+// commands whose keys move with their options find them with find instead.
+type keySpec struct {
+	first, last, step int
+	find              func(commands []string) []string
+}
+
+var (
+	noKeys   = keySpec{}
+	firstKey = keySpec{1, 1, 1, nil}
+	everyKey = keySpec{1, -1, 1, nil}
+)
+
+// numKeysAt is the key spec of the commands whose keys follow a numkeys
+// argument at pos.
+func numKeysAt(pos int) keySpec {
+	return keySpec{find: func(commands []string) []string {
+		if len(commands) <= pos {
+			return nil
+		}
+		n, err := strconv.Atoi(commands[pos])
+		if err != nil || n < 0 || n > len(commands)-pos-1 {
+			return nil
+		}
+		return commands[pos+1 : pos+1+n]
+	}}
+}
+
+// sortKeys returns the key SORT sorts and the destination of STORE.
+func sortKeys(commands []string) []string {
+	for i := 2; i < len(commands)-1; i++ {
+		switch strings.ToLower(commands[i]) {
+		case "by", "get":
+			i++
+		case "limit":
+			i += 2
+		case "store":
+			return []string{commands[1], commands[i+1]}
+		}
+	}
+	return commands[1:2]
+}
+
+// zstoreKeys returns the destination and the keys of ZUNIONSTORE, ZINTERSTORE
+// and ZDIFFSTORE.
+func zstoreKeys(commands []string) []string {
+	return append([]string{commands[1]}, numKeysAt(2).find(commands)...)
+}
+
+// xreadKeys and xreadgroupKeys return the streams XREAD and XREADGROUP
+// read, the first half of the arguments after STREAMS. The search starts
+// after GROUP group consumer for XREADGROUP, and skips the values of COUNT
+// and BLOCK, any of which may be "streams".
+func xreadKeys(commands []string) []string {
+	return streamsKeys(commands, 1)
+}
+
+func xreadgroupKeys(commands []string) []string {
+	return streamsKeys(commands, 4)
+}
+
+func streamsKeys(commands []string, start int) []string {
+	for i := start; i < len(commands); i++ {
+		switch strings.ToLower(commands[i]) {
+		case "count", "block":
+			i++
+		case "streams":
+			streams := commands[i+1:]
+			return streams[:len(streams)/2]
+		}
+	}
+	return nil
+}
+
+// migrateKeys returns the key MIGRATE moves or, when it is empty, the keys
+// after KEYS.
+func migrateKeys(commands []string) []string {
+	if len(commands) < 4 {
+		return nil
+	}
+	if commands[3] != "" {
+		return commands[3:4]
+	}
+	for i := 6; i < len(commands); i++ {
+		if strings.EqualFold(commands[i], "keys") {
+			return commands[i+1:]
+		}
+	}
+	return nil
+}
+
+// commandTable maps the lowercased command names to their handlers. It is
+// filled in init since the handlers refer back to it through dispatch.
+var commandTable map[string]commandHandler
+
+func init() {
+	commandTable = map[string]commandHandler{
+		"echo":         {(*server).echoCommand, 2, 0, noKeys},
+		"ping":         {(*server).pingCommand, -1, 0, noKeys},
+		"quit":         {(*server).quitCommand, -1, 0, noKeys},
+		"multi":        {(*server).multiCommand, 1, cmdNoScript, noKeys},
+		"exec":         {(*server).execCommand, 1, cmdNoScript | cmdUnlocked, noKeys},
+		"discard":      {(*server).discardCommand, 1, cmdNoScript, noKeys},
+		"reset":        {(*server).resetCommand, 1, cmdNoScript, noKeys},
+		"mset":         {(*server).msetCommand, -3, cmdWrite | cmdDenyOOM, keySpec{1, -1, 2, nil}},
+		"mget":         {(*server).mgetCommand, -2, cmdRead, everyKey},
+		"set":          {(*server).setCommand, -3, cmdWrite | cmdDenyOOM, firstKey},
+		"get":          {(*server).getCommand, 2, cmdRead, firstKey},
+		"getdel":       {(*server).getdelCommand, 2, cmdWrite, firstKey},
+		"getex":        {(*server).getexCommand, -2, cmdWrite, firstKey},
+		"incr":         {(*server).incrCommand, 2, cmdWrite | cmdDenyOOM, firstKey},
+		"decr":         {(*server).incrCommand, 2, cmdWrite | cmdDenyOOM, firstKey},
+		"incrby":       {(*server).incrCommand, 3, cmdWrite | cmdDenyOOM, firstKey},
+		"decrby":       {(*server).incrCommand, 3, cmdWrite | cmdDenyOOM, firstKey},
+		"setrange":     {(*server).setrangeCommand, 4, cmdWrite | cmdDenyOOM, firstKey},
+		"append":       {(*server).appendCommand, 3, cmdWrite | cmdDenyOOM, firstKey},
+		"del":          {(*server).delCommand, -2, cmdWrite, everyKey},
+		"expire":       {(*server).expireCommand, -3, cmdWrite, firstKey},
+		"pexpire":      {(*server).expireCommand, -3, cmdWrite, firstKey},
+		"expireat":     {(*server).expireCommand, -3, cmdWrite, firstKey},
+		"pexpireat":    {(*server).expireCommand, -3, cmdWrite, firstKey},
+		"persist":      {(*server).persistCommand, 2, cmdWrite, firstKey},
+		"ttl":          {(*server).ttlCommand, 2, cmdRead | cmdNoTouch, firstKey},
+		"pttl":         {(*server).ttlCommand, 2, cmdRead | cmdNoTouch, firstKey},
+		"expiretime":   {(*server).ttlCommand, 2, cmdRead | cmdNoTouch, firstKey},
+		"pexpiretime":  {(*server).ttlCommand, 2, cmdRead | cmdNoTouch, firstKey},
+		"exists":       {(*server).existsCommand, -2, cmdRead | cmdNoTouch, everyKey},
+		"type":         {(*server).typeCommand, 2, cmdRead | cmdNoTouch, firstKey},
+		"object":       {(*server).objectCommand, -2, cmdRead | cmdNoTouch, keySpec{2, 2, 1, nil}},
+		"dbsize":       {(*server).dbsizeCommand, 1, cmdRead, noKeys},
+		"select":       {(*server).selectCommand, 2, 0, noKeys},
+		"swapdb":       {(*server).swapdbCommand, 3, cmdWrite | cmdNoScript | cmdUnlocked, noKeys},
+		"copy":         {(*server).copyCommand, -3, cmdWrite | cmdDenyOOM, keySpec{1, 2, 1, nil}},
+		"move":         {(*server).moveCommand, 3, cmdWrite, firstKey},
+		"keys":         {(*server).keysCommand, 2, cmdRead, noKeys},
+		"randomkey":    {(*server).randomkeyCommand, 1, cmdRead, noKeys},
+		"flushdb":      {(*server).flushdbCommand, -1, cmdWrite, noKeys},
+		"flushall":     {(*server).flushdbCommand, -1, cmdWrite, noKeys},
+		"scan":         {(*server).scanCommand, -2, 0, noKeys},
+		"hscan":        {(*server).collectionScanCommand, -3, cmdRead, firstKey},
+		"sscan":        {(*server).collectionScanCommand, -3, cmdRead, firstKey},
+		"zscan":        {(*server).collectionScanCommand, -3, cmdRead, firstKey},
+		"dump":         {(*server).dumpCommand, 2, cmdRead, firstKey},
+		"restore":      {(*server).restoreCommand, -4, cmdWrite | cmdDenyOOM, firstKey},
+		"migrate":      {(*server).migrateCommand, -6, cmdWrite, keySpec{find: migrateKeys}},
+		"setbit":       {(*server).setbitCommand, 4, cmdWrite | cmdDenyOOM, firstKey},
+		"getbit":       {(*server).getbitCommand, 3, cmdRead, firstKey},
+		"bitcount":     {(*server).bitcountCommand, -2, cmdRead, firstKey},
+		"bitop":        {(*server).bitopCommand, -4, cmdWrite | cmdDenyOOM, keySpec{2, -1, 1, nil}},
+		"bitpos":       {(*server).bitposCommand, -3, cmdRead, firstKey},
+		"lcs":          {(*server).lcsCommand, -3, cmdRead, keySpec{1, 2, 1, nil}},
+		"pfadd":        {(*server).pfaddCommand, -2, cmdWrite | cmdDenyOOM, firstKey},
+		"pfcount":      {(*server).pfcountCommand, -2, cmdRead, everyKey},
+		"pfmerge":      {(*server).pfmergeCommand, -2, cmdWrite | cmdDenyOOM, everyKey},
+		"sadd":         {(*server).saddCommand, -3, cmdWrite | cmdDenyOOM, firstKey},
+		"srem":         {(*server).sremCommand, -3, cmdWrite, firstKey},
+		"smismember":   {(*server).smismemberCommand, -3, cmdRead, firstKey},
+		"sintercard":   {(*server).sintercardCommand, -3, cmdRead, numKeysAt(1)},
+		"lpush":        {(*server).pushCommand, -3, cmdWrite | cmdDenyOOM, firstKey},
+		"rpush":        {(*server).pushCommand, -3, cmdWrite | cmdDenyOOM, firstKey},
+		"lpushx":       {(*server).pushCommand, -3, cmdWrite | cmdDenyOOM, firstKey},
+		"rpushx":       {(*server).pushCommand, -3, cmdWrite | cmdDenyOOM, firstKey},
+		"lpop":         {(*server).popCommand, -2, cmdWrite, firstKey},
+		"rpop":         {(*server).popCommand, -2, cmdWrite, firstKey},
+		"lrange":       {(*server).lrangeCommand, 4, cmdRead, firstKey},
+		"sort":         {(*server).sortCommand, -2, cmdWrite | cmdDenyOOM, keySpec{find: sortKeys}},
+		"sort_ro":      {(*server).sortROCommand, -2, cmdRead, firstKey},
+		"lmpop":        {(*server).lmpopCommand, -4, cmdWrite, numKeysAt(1)},
+		"blpop":        {(*server).bpopCommand, -3, cmdWrite | cmdNoScript | cmdUnlocked | cmdBlocking, keySpec{1, -2, 1, nil}},
+		"brpop":        {(*server).bpopCommand, -3, cmdWrite | cmdNoScript | cmdUnlocked | cmdBlocking, keySpec{1, -2, 1, nil}},
+		"hset":         {(*server).hsetCommand, -4, cmdWrite | cmdDenyOOM, firstKey},
+		"hsetnx":       {(*server).hsetnxCommand, 4, cmdWrite | cmdDenyOOM, firstKey},
+		"hget":         {(*server).hgetCommand, 3, cmdRead, firstKey},
+		"hdel":         {(*server).hdelCommand, -3, cmdWrite, firstKey},
+		"hrandfield":   {(*server).hrandfieldCommand, -2, cmdRead, firstKey},
+		"zadd":         {(*server).zaddCommand, -4, cmdWrite | cmdDenyOOM, firstKey},
+		"zrem":         {(*server).zremCommand, -3, cmdWrite, firstKey},
+		"zpopmin":      {(*server).zpopCommand, -2, cmdWrite, firstKey},
+		"zpopmax":      {(*server).zpopCommand, -2, cmdWrite, firstKey},
+		"bzpopmin":     {(*server).bzpopCommand, -3, cmdWrite | cmdNoScript | cmdUnlocked | cmdBlocking, keySpec{1, -2, 1, nil}},
+		"bzpopmax":     {(*server).bzpopCommand, -3, cmdWrite | cmdNoScript | cmdUnlocked | cmdBlocking, keySpec{1, -2, 1, nil}},
+		"zmpop":        {(*server).zmpopCommand, -4, cmdWrite, numKeysAt(1)},
+		"zunionstore":  {(*server).zstoreCommand, -4, cmdWrite | cmdDenyOOM, keySpec{find: zstoreKeys}},
+		"zinterstore":  {(*server).zstoreCommand, -4, cmdWrite | cmdDenyOOM, keySpec{find: zstoreKeys}},
+		"zdiffstore":   {(*server).zstoreCommand, -4, cmdWrite | cmdDenyOOM, keySpec{find: zstoreKeys}},
+		"zunion":       {(*server).zsetOpCommand, -3, cmdRead, numKeysAt(1)},
+		"zinter":       {(*server).zsetOpCommand, -3, cmdRead, numKeysAt(1)},
+		"zdiff":        {(*server).zsetOpCommand, -3, cmdRead, numKeysAt(1)},
+		"zintercard":   {(*server).zintercardCommand, -3, cmdRead, numKeysAt(1)},
+		"zrangebylex":  {(*server).zrangebylexCommand, -4, cmdRead, firstKey},
+		"zlexcount":    {(*server).zlexcountCommand, 4, cmdRead, firstKey},
+		"zscore":       {(*server).zscoreCommand, 3, cmdRead, firstKey},
+		"zrandmember":  {(*server).zrandmemberCommand, -2, cmdRead, firstKey},
+		"xadd":         {(*server).xaddCommand, -5, cmdWrite | cmdDenyOOM, firstKey},
+		"xrange":       {(*server).xrangeCommand, -4, cmdRead, firstKey},
+		"xlen":         {(*server).xlenCommand, 2, cmdRead, firstKey},
+		"xdel":         {(*server).xdelCommand, -3, cmdWrite, firstKey},
+		"xtrim":        {(*server).xtrimCommand, -4, cmdWrite, firstKey},
+		"xinfo":        {(*server).xinfoCommand, -2, cmdRead, keySpec{2, 2, 1, nil}},
+		"xgroup":       {(*server).xgroupCommand, -2, cmdWrite | cmdDenyOOM, keySpec{2, 2, 1, nil}},
+		"xread":        {(*server).xreadCommand, -4, cmdRead | cmdUnlocked | cmdBlocking, keySpec{find: xreadKeys}},
+		"xreadgroup":   {(*server).xreadgroupCommand, -7, cmdWrite | cmdUnlocked | cmdBlocking, keySpec{find: xreadgroupKeys}},
+		"xack":         {(*server).xackCommand, -4, cmdWrite, firstKey},
+		"xpending":     {(*server).xpendingCommand, -3, cmdRead, firstKey},
+		"xclaim":       {(*server).xclaimCommand, -6, cmdWrite, firstKey},
+		"xautoclaim":   {(*server).xautoclaimCommand, -6, cmdWrite, firstKey},
+		"geoadd":       {(*server).geoaddCommand, -5, cmdWrite | cmdDenyOOM, firstKey},
+		"geopos":       {(*server).geoposCommand, -2, cmdRead, firstKey},
+		"geodist":      {(*server).geodistCommand, -4, cmdRead, firstKey},
+		"geosearch":    {(*server).geosearchCommand, -7, cmdRead, firstKey},
+		"info":         {(*server).infoCommand, -1, 0, noKeys},
+		"client":       {(*server).clientCommand, -2, cmdAdmin, noKeys},
+		"wait":         {(*server).waitCommand, 3, cmdNoScript | cmdUnlocked | cmdBlocking, noKeys},
+		"waitaof":      {(*server).waitAOF, 4, cmdNoScript | cmdUnlocked | cmdBlocking, noKeys},
+		"eval":         {(*server).evalCommand, -3, cmdNoScript | cmdUnlocked | cmdMayReplicate, numKeysAt(2)},
+		"evalsha":      {(*server).evalshaCommand, -3, cmdNoScript | cmdUnlocked | cmdMayReplicate, numKeysAt(2)},
+		"script":       {(*server).scriptCommand, -2, cmdNoScript | cmdUnlocked, noKeys},
+		"replconf":     {(*server).replconfCommand, -1, cmdAdmin | cmdNoScript, noKeys},
+		"psync":        {(*server).psyncCommand, -3, cmdAdmin | cmdNoScript | cmdUnlocked | cmdNoMulti, noKeys},
+		"replicaof":    {(*server).replicaofCommand, 3, cmdAdmin | cmdNoScript, noKeys},
+		"slaveof":      {(*server).replicaofCommand, 3, cmdAdmin | cmdNoScript, noKeys},
+		"failover":     {(*server).failoverCommand, -1, cmdAdmin | cmdNoScript | cmdUnlocked | cmdBlocking | cmdNoMulti, noKeys},
+		"shutdown":     {(*server).shutdownCommand, -1, cmdAdmin | cmdNoScript | cmdUnlocked | cmdNoMulti, noKeys},
+		"save":         {(*server).saveCommand, 1, cmdAdmin | cmdNoScript, noKeys},
+		"bgsave":       {(*server).bgsaveCommand, 1, cmdAdmin | cmdNoScript, noKeys},
+		"lastsave":     {(*server).lastsaveCommand, 1, cmdAdmin, noKeys},
+		"config":       {(*server).configCommand, -2, cmdAdmin, noKeys},
+		"hello":        {(*server).helloCommand, -1, cmdNoScript, noKeys},
+		"lolwut":       {(*server).lolwutCommand, -1, 0, noKeys},
+		"debug":        {(*server).debugCommand, -2, cmdAdmin | cmdNoScript | cmdUnlocked, noKeys},
+		"subscribe":    {(*server).subscribeCommand, -2, cmdPubSub | cmdNoScript, noKeys},
+		"psubscribe":   {(*server).psubscribeCommand, -2, cmdPubSub | cmdNoScript, noKeys},
+		"unsubscribe":  {(*server).unsubscribeCommand, -1, cmdPubSub | cmdNoScript, noKeys},
+		"punsubscribe": {(*server).punsubscribeCommand, -1, cmdPubSub | cmdNoScript, noKeys},
+		"ssubscribe":   {(*server).ssubscribeCommand, -2, cmdPubSub | cmdNoScript, noKeys},
+		"sunsubscribe": {(*server).sunsubscribeCommand, -1, cmdPubSub | cmdNoScript, noKeys},
+		"spublish":     {(*server).spublishCommand, 3, cmdPubSub, noKeys},
+		"publish":      {(*server).publishCommand, 3, cmdPubSub, noKeys},
+		"pubsub":       {(*server).pubsubCommand, -2, cmdPubSub, noKeys},
+		"auth":         {(*server).authCommand, -2, cmdNoScript | cmdSkipMonitor, noKeys},
+		"acl":          {(*server).aclCommand, -2, cmdAdmin | cmdNoScript, noKeys},
+		"command":      {(*server).commandCommand, -1, 0, noKeys},
+		"cluster":      {(*server).clusterCommand, -2, 0, noKeys},
+		"latency":      {(*server).latencyCommand, -2, cmdAdmin | cmdNoScript, noKeys},
+		"monitor":      {(*server).monitorCommand, 1, cmdAdmin | cmdNoScript | cmdSkipMonitor, noKeys},
+	}
+}
+
+// commandHas reports whether the lowercased command name has all of flags.
+// Unknown commands have none.
+func commandHas(name string, flags int) bool {
+	return commandTable[name].flags&flags == flags
+}
+
+// arityOK reports whether commands has a number of arguments that fits arity.
+func arityOK(arity int, commands []string) bool {
+	if arity < 0 {
+		return len(commands) >= -arity
+	}
+	return len(commands) == arity
+}
+
+// commandKeys returns the keys a command refers to.
+func commandKeys(commands []string) []string {
+	spec := commandTable[strings.ToLower(commands[0])].keys
+	if spec.find != nil {
+		return spec.find(commands)
+	}
+	if spec.step == 0 {
+		return nil
+	}
+	last := spec.last
+	if last < 0 {
+		last += len(commands)
+	}
+	last = min(last, len(commands)-1)
+	var keys []string
+	for i := spec.first; i <= last; i += spec.step {
+		keys = append(keys, commands[i])
+	}
+	return keys
+}
+
+// commandFlags names the command flags in COMMAND replies.
+var commandFlags = []struct {
+	flag int
+	name string
+}{
+	{cmdWrite, "write"},
+	{cmdRead, "readonly"},
+	{cmdAdmin, "admin"},
+	{cmdPubSub, "pubsub"},
+	{cmdNoScript, "noscript"},
+	{cmdSkipMonitor, "skip_monitor"},
+	{cmdDenyOOM, "denyoom"},
+	{cmdNoMulti, "no_multi"},
+}
+
+// commandInfo describes a command the way COMMAND does: its name, arity,
+// flags, first key, last key and step, ACL categories, tips, key
+// specifications and subcommands. There are no tips, and the subcommands
+// are not described separately.
+func commandInfo(name string) []any {
+	handler := commandTable[name]
+	flags := []string{}
+	for _, f := range commandFlags {
+		if handler.flags&f.flag != 0 {
+			flags = append(flags, f.name)
+		}
+	}
+	if handler.keys.find != nil {
+		flags = append(flags, "movablekeys")
+	}
+	categories := []string{}
+	for _, category := range sortedKeys(aclCategories) {
+		if handler.flags&aclCategories[category] != 0 {
+			categories = append(categories, "@"+category)
+		}
+	}
+	return []any{name, handler.arity, flags, handler.keys.first, handler.keys.last, handler.keys.step,
+		categories, []string{}, handler.keySpecs(), []any{}}
+}
+
+// keySpecs describes where the keys of the command are, in the form of the
+// key specifications redis 7 reports: a range of arguments, or unknown when
+// they move with the options.
+func (h commandHandler) keySpecs() []any {
+	k := h.keys
+	if k.find == nil && k.first == 0 {
+		return []any{}
+	}
+	access := "RW"
+	if h.flags&cmdWrite == 0 {
+		access = "RO"
+	}
+	if k.find != nil {
+		return []any{replyMap{
+			{"flags", []string{access}},
+			{"begin_search", replyMap{{"type", "unknown"}, {"spec", replyMap{}}}},
+			{"find_keys", replyMap{{"type", "unknown"}, {"spec", replyMap{}}}},
+		}}
+	}
+	last := k.last
+	if last > 0 {
+		last -= k.first
+	}
+	return []any{replyMap{
+		{"flags", []string{access}},
+		{"begin_search", replyMap{{"type", "index"}, {"spec", replyMap{{"index", k.first}}}}},
+		{"find_keys", replyMap{{"type", "range"}, {"spec", replyMap{{"lastkey", last}, {"keystep", k.step}, {"limit", 0}}}}},
+	}}
+}
+
+// commandCommand implements COMMAND COUNT, COMMAND GETKEYS command [arg ...]
+// and COMMAND INFO [name ...], which describes every command when no name is
+// given, like COMMAND alone.
+func (s *server) commandCommand(c *clientConn, commands []string) {
+	if len(commands) == 1 {
+		commands = []string{"command", "info"}
+	}
+	switch strings.ToLower(commands[1]) {
+	case "count":
+		c.replyInteger(len(commandTable))
+	case "info":
+		names := commands[2:]
+		if len(names) == 0 {
+			names = sortedKeys(commandTable)
+		}
+		infos := make([]any, len(names))
+		for i, name := range names {
+			if _, ok := commandTable[strings.ToLower(name)]; ok {
+				infos[i] = commandInfo(strings.ToLower(name))
+			}
+		}
+		c.replyValue(infos)
+	case "getkeys":
+		if len(commands) < 3 {
+			c.replyWrongArgs("command|getkeys")
+			return
+		}
+		args := commands[2:]
+		handler, ok := commandTable[strings.ToLower(args[0])]
+		if !ok {
+			c.replyErrorMsg("Invalid command specified")
+			return
+		}
+		if !arityOK(handler.arity, args) {
+			c.replyErrorMsg("Invalid number of arguments specified for command")
+			return
+		}
+		keys := commandKeys(args)
+		if len(keys) == 0 {
+			c.replyErrorMsg("The command has no key arguments")
+			return
+		}
+		c.replyStrings(keys)
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try COMMAND HELP.", commands[1]))
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestArityOK(t *testing.T) {
+	for _, test := range []struct {
+		arity int
+		args  int
+		want  bool
+	}{
+		{2, 2, true},
+		{2, 3, false},
+		{-3, 3, true},
+		{-3, 5, true},
+		{-3, 2, false},
+	} {
+		if got := arityOK(test.arity, make([]string, test.args)); got != test.want {
+			t.Errorf("arityOK(%d) of %d arguments = %v", test.arity, test.args, got)
+		}
+	}
+}
+
+func TestCommandKeys(t *testing.T) {
+	for _, test := range []struct {
+		command []string
+		keys    []string
+	}{
+		{[]string{"GET", "a"}, []string{"a"}},
+		{[]string{"MSET", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{[]string{"DEL", "a", "b", "c"}, []string{"a", "b", "c"}},
+		{[]string{"BLPOP", "a", "b", "0"}, []string{"a", "b"}},
+		{[]string{"PING"}, nil},
+	} {
+		if got := commandKeys(test.command); !reflect.DeepEqual(got, test.keys) {
+			t.Errorf("commandKeys(%q) = %q, want %q", test.command, got, test.keys)
+		}
+	}
+}
+
+func TestCommandTable(t *testing.T) {
+	for name, handler := range commandTable {
+		if handler.fn == nil || handler.arity == 0 {
+			t.Errorf("%s has no handler or no arity", name)
+		}
+		if handler.flags&cmdWrite != 0 && handler.flags&cmdRead != 0 {
+			t.Errorf("%s is flagged both a read and a write", name)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	s, _ := startServer(t)
+	c, reader := pipeClient(t, s)
+	for _, test := range []struct {
+		command []string
+		want    any
+	}{
+		{[]string{"set", "key", "value"}, respStatus("OK")},
+		{[]string{"get", "key"}, "value"},
+		{[]string{"get"}, respError("ERR wrong number of arguments for 'get' command")},
+		{[]string{"nosuch", "arg"}, respError("ERR unknown command 'nosuch', with args beginning with: 'arg' ")},
+	} {
+		s.dispatch(c, test.command)
+		reply, err := readReply(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(reply, test.want) {
+			t.Errorf("dispatching %q: got %#v, want %#v", test.command, reply, test.want)
+		}
+	}
+}
+
+func TestCommandGetKeys(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect([]any{"k"}, "COMMAND", "GETKEYS", "SET", "k", "v")
+	c.expect([]any{"a", "b"}, "COMMAND", "GETKEYS", "MSET", "a", "1", "b", "2")
+	c.expect([]any{"dest", "a", "b"}, "COMMAND", "GETKEYS", "ZUNIONSTORE", "dest", "2", "a", "b", "WEIGHTS", "1", "2")
+	c.expect(respError("ERR The command has no key arguments"), "COMMAND", "GETKEYS", "PING")
+	c.expect(respError("ERR Invalid number of arguments specified for command"), "COMMAND", "GETKEYS", "GET")
+	if _, ok := c.do("COMMAND", "GETKEYS", "NOSUCH", "k").(respError); !ok {
+		t.Fatal("COMMAND GETKEYS of an unknown command did not fail")
+	}
+}
+
+func TestCommandInfo(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	infos, ok := c.do("COMMAND", "INFO", "get", "nosuch").([]any)
+	if !ok || len(infos) != 2 {
+		t.Fatalf("COMMAND INFO: got %#v", infos)
+	}
+	info, ok := infos[0].([]any)
+	if !ok || len(info) != 10 {
+		t.Fatalf("COMMAND INFO get: got %#v", infos[0])
+	}
+	if info[0] != "get" || info[1] != int64(2) {
+		t.Errorf("COMMAND INFO get: got name %#v and arity %#v", info[0], info[1])
+	}
+	if flags, _ := info[2].([]any); !containsValue(flags, "readonly") {
+		t.Errorf("COMMAND INFO get: flags %#v lack readonly", info[2])
+	}
+	if !reflect.DeepEqual(info[3:6], []any{int64(1), int64(1), int64(1)}) {
+		t.Errorf("COMMAND INFO get: got keys %#v", info[3:6])
+	}
+	if infos[1] != nil {
+		t.Errorf("COMMAND INFO of an unknown command: got %#v, want nil", infos[1])
+	}
+}
+
+// containsValue reports whether values holds value.
+func containsValue(values []any, value any) bool {
+	for _, v := range values {
+		if reflect.DeepEqual(v, value) {
+			return true
+		}
+	}
+	return false
+}
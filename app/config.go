@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errImmutableConfig = errors.New("can't set immutable config")
+
+// config holds the parameters set with command line flags, the config file
+// and CONFIG SET. The parameters that can change at run time are guarded by
+// mu; the others are only set before the server starts.
+type config struct {
+	mu     sync.Mutex
+	params map[string]*configParam
+	// file is the config file the server was started with, rewritten by
+	// CONFIG REWRITE.
+	file string
+
+	port           int64
+	replicaOf      string
+	appendOnly     bool
+	appendFilename string
+	dir            string
+	dbFilename     string
+	databases      int64
+	save           string
+	appendFsync    string
+	maxMemory      int64
+	evict          evictionConfig
+	timeout        int64
+	tcpKeepAlive   int64
+	maxClients     int64
+	replNoDelayOff bool
+	maxBulkLen     int64
+	readBuffer     int64
+	waitReplicas   int64
+	backlogSize    int64
+	waitTimeout    int64
+	latencyMonitor int64
+	busyReply      int64
+	notifyEvents   int
+	limits         encodingLimits
+}
+
+// configParam is a parameter of the config. get formats its value, and set
+// parses a new one, failing without any change when it is invalid.
+type configParam struct {
+	get       func() string
+	set       func(value string) error
+	immutable bool
+	// initial is the default value, which CONFIG REWRITE leaves out.
+	initial string
+}
+
+func newConfig() *config {
+	cfg := &config{}
+	cfg.params = map[string]*configParam{
+		"port":           intParam(&cfg.port, 6379, 0, 65535).fixed(),
+		"replicaof":      stringParam(&cfg.replicaOf, "").fixed(),
+		"appendonly":     boolParam(&cfg.appendOnly, false).fixed(),
+		"appendfilename": stringParam(&cfg.appendFilename, "appendonly.aof").fixed(),
+		"dir":            stringParam(&cfg.dir, ".").fixed(),
+		"dbfilename":     stringParam(&cfg.dbFilename, "dump.rdb").checked(isFileName),
+		"databases":      intParam(&cfg.databases, 16, 1, math.MaxInt32).fixed(),
+		"save":           stringParam(&cfg.save, "3600 1 300 100 60 10000").checked(isSaveRules),
+		"appendfsync":    enumParam(&cfg.appendFsync, "everysec", "always", "everysec", "no").fixed(),
+		"maxmemory":      memoryParam(&cfg.maxMemory, 0),
+
+		"maxmemory-policy":  enumParam(&cfg.evict.policy, "noeviction", evictionPolicies...),
+		"maxmemory-samples": intParam(&cfg.evict.samples, 5, 1, 64),
+		"lfu-log-factor":    intParam(&cfg.evict.lfuLogFactor, 10, 0, math.MaxInt32),
+		"lfu-decay-time":    intParam(&cfg.evict.lfuDecayTime, 1, 0, math.MaxInt32),
+
+		"timeout":       intParam(&cfg.timeout, 0, 0, math.MaxInt32),
+		"tcp-keepalive": intParam(&cfg.tcpKeepAlive, 300, 0, math.MaxInt32),
+		"maxclients":    intParam(&cfg.maxClients, 10000, 1, math.MaxInt32),
+
+		"repl-disable-tcp-nodelay": boolParam(&cfg.replNoDelayOff, false),
+		"repl-backlog-size":        memoryParam(&cfg.backlogSize, 1<<20).checked(isBacklogSize),
+		"wait-on-write":            intParam(&cfg.waitReplicas, 0, 0, math.MaxInt32),
+		"wait-on-write-timeout":    intParam(&cfg.waitTimeout, 1000, 0, math.MaxInt32),
+		"proto-max-bulk-len":       memoryParam(&cfg.maxBulkLen, 512<<20).checked(isBulkLimit),
+		"read-buffer-size":         memoryParam(&cfg.readBuffer, 16<<10).checked(isReadBufferSize),
+
+		"latency-monitor-threshold": intParam(&cfg.latencyMonitor, 0, 0, math.MaxInt32),
+
+		"busy-reply-threshold": intParam(&cfg.busyReply, 5000, 0, math.MaxInt32),
+		"lua-time-limit":       intParam(&cfg.busyReply, 5000, 0, math.MaxInt32),
+
+		"notify-keyspace-events": keyspaceEventsParam(&cfg.notifyEvents),
+
+		"list-max-listpack-size":    intParam(&cfg.limits.listSize, -2, -5, math.MaxInt32),
+		"set-max-intset-entries":    intParam(&cfg.limits.setIntsetEntries, 512, 0, math.MaxInt32),
+		"set-max-listpack-entries":  intParam(&cfg.limits.setListpackEntries, 128, 0, math.MaxInt32),
+		"set-max-listpack-value":    intParam(&cfg.limits.setListpackValue, 64, 0, math.MaxInt32),
+		"hash-max-listpack-entries": intParam(&cfg.limits.hashListpackEntries, 128, 0, math.MaxInt32),
+		"hash-max-listpack-value":   intParam(&cfg.limits.hashListpackValue, 64, 0, math.MaxInt32),
+		"zset-max-listpack-entries": intParam(&cfg.limits.zsetListpackEntries, 128, 0, math.MaxInt32),
+		"zset-max-listpack-value":   intParam(&cfg.limits.zsetListpackValue, 64, 0, math.MaxInt32),
+	}
+	return cfg
+}
+
+func (p *configParam) fixed() *configParam {
+	p.immutable = true
+	return p
+}
+
+// checked makes the parameter accept only the values check does not reject.
+func (p *configParam) checked(check func(value string) error) *configParam {
+	set := p.set
+	p.set = func(value string) error {
+		if err := check(value); err != nil {
+			return err
+		}
+		return set(value)
+	}
+	return p
+}
+
+func isFileName(value string) error {
+	if value == "" || strings.ContainsRune(value, '/') {
+		return errors.New("dbfilename can't be a path, just a filename")
+	}
+	return nil
+}
+
+func isSaveRules(value string) error {
+	_, err := parseSaveRules(value)
+	return err
+}
+
+// isBulkLimit accepts the proto-max-bulk-len values redis does, from 1mb up.
+func isBulkLimit(value string) error {
+	if n, err := parseMemory(value); err == nil && n < 1<<20 {
+		return errors.New("proto-max-bulk-len must be at least 1mb")
+	}
+	return nil
+}
+
+func isBacklogSize(value string) error {
+	if n, err := parseMemory(value); err == nil && n < 16<<10 {
+		return errors.New("repl-backlog-size must be at least 16kb")
+	}
+	return nil
+}
+
+func isReadBufferSize(value string) error {
+	if n, err := parseMemory(value); err == nil && n < 1<<10 {
+		return errors.New("read-buffer-size must be at least 1kb")
+	}
+	return nil
+}
+
+func stringParam(field *string, initial string) *configParam {
+	*field = initial
+	return &configParam{
+		get:     func() string { return *field },
+		set:     func(value string) error { *field = value; return nil },
+		initial: initial,
+	}
+}
+
+func intParam(field *int64, initial, min, max int64) *configParam {
+	*field = initial
+	return &configParam{
+		get: func() string { return strconv.FormatInt(*field, 10) },
+		set: func(value string) error {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("argument couldn't be parsed into an integer")
+			}
+			if n < min || n > max {
+				return fmt.Errorf("argument must be between %d and %d inclusive", min, max)
+			}
+			*field = n
+			return nil
+		},
+		initial: strconv.FormatInt(initial, 10),
+	}
+}
+
+// memoryUnits are the suffixes of memory sizes: k, m and g are powers of
+// 1000 and kb, mb and gb powers of 1024.
+var memoryUnits = map[string]int64{
+	"":   1,
+	"b":  1,
+	"k":  1000,
+	"kb": 1024,
+	"m":  1000 * 1000,
+	"mb": 1024 * 1024,
+	"g":  1000 * 1000 * 1000,
+	"gb": 1024 * 1024 * 1024,
+}
+
+// parseMemory parses a memory size such as 100mb, 1gb or 512kb into bytes.
+// The unit is case insensitive and defaults to bytes.
+func parseMemory(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	digits := strings.TrimRight(lower, "bkmg")
+	unit, ok := memoryUnits[lower[len(digits):]]
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if !ok || err != nil || n < 0 || n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("invalid memory size '%s'", value)
+	}
+	return n * unit, nil
+}
+
+func memoryParam(field *int64, initial int64) *configParam {
+	*field = initial
+	return &configParam{
+		get: func() string { return strconv.FormatInt(*field, 10) },
+		set: func(value string) error {
+			n, err := parseMemory(value)
+			if err != nil {
+				return errors.New("argument must be a memory value")
+			}
+			*field = n
+			return nil
+		},
+		initial: strconv.FormatInt(initial, 10),
+	}
+}
+
+func boolParam(field *bool, initial bool) *configParam {
+	*field = initial
+	format := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	return &configParam{
+		get: func() string { return format(*field) },
+		set: func(value string) error {
+			switch strings.ToLower(value) {
+			case "yes":
+				*field = true
+			case "no":
+				*field = false
+			default:
+				return errors.New("argument must be 'yes' or 'no'")
+			}
+			return nil
+		},
+		initial: format(initial),
+	}
+}
+
+func enumParam(field *string, initial string, values ...string) *configParam {
+	*field = initial
+	return &configParam{
+		get: func() string { return *field },
+		set: func(value string) error {
+			for _, v := range values {
+				if strings.EqualFold(value, v) {
+					*field = v
+					return nil
+				}
+			}
+			return errors.New("argument(s) must be one of the following: " + strings.Join(values, ", "))
+		},
+		initial: initial,
+	}
+}
+
+// keyspaceEventsParam is notify-keyspace-events, which is off by default.
+func keyspaceEventsParam(field *int) *configParam {
+	return &configParam{
+		get: func() string { return formatKeyspaceEvents(*field) },
+		set: func(value string) error {
+			flags, err := parseKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			*field = flags
+			return nil
+		},
+	}
+}
+
+// idleTimeout is how long a client may stay idle before it is disconnected,
+// or zero if it may stay idle forever.
+func (cfg *config) idleTimeout() time.Duration {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return time.Duration(cfg.timeout) * time.Second
+}
+
+// keepAlive is the interval of the TCP keepalive probes sent to clients, or
+// zero if they are not sent.
+func (cfg *config) keepAlive() time.Duration {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return time.Duration(cfg.tcpKeepAlive) * time.Second
+}
+
+// replNoDelay reports whether the replication links send their writes right
+// away rather than batching them into fewer packets.
+func (cfg *config) replNoDelay() bool {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return !cfg.replNoDelayOff
+}
+
+// bulkLimit is the size strings can reach, whether they are sent by clients
+// or grown by commands.
+func (cfg *config) bulkLimit() int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return int(cfg.maxBulkLen)
+}
+
+// readBufferSize is the size of the buffer the commands of a new client are
+// read into. It is never larger than the biggest string a client can send.
+func (cfg *config) readBufferSize() int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return int(min(cfg.readBuffer, cfg.maxBulkLen))
+}
+
+// replBacklogSize is how much of the end of the replication stream is kept
+// for the replicas that reconnect.
+func (cfg *config) replBacklogSize() int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return int(cfg.backlogSize)
+}
+
+// waitOnWrite is the number of replicas that must acknowledge a write before
+// it is replied to, zero if it is replied to right away, and how long it
+// waits for them at most, zero if forever.
+func (cfg *config) waitOnWrite() (int, time.Duration) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return int(cfg.waitReplicas), time.Duration(cfg.waitTimeout) * time.Millisecond
+}
+
+// latencyThreshold is the latency from which events are recorded, or zero if
+// the latency monitor is disabled.
+func (cfg *config) latencyThreshold() time.Duration {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return time.Duration(cfg.latencyMonitor) * time.Millisecond
+}
+
+// busyThreshold is how long a script runs before the other clients are
+// replied BUSY.
+func (cfg *config) busyThreshold() time.Duration {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return time.Duration(cfg.busyReply) * time.Millisecond
+}
+
+// keyspaceEvents are the flags of the keyspace events published.
+func (cfg *config) keyspaceEvents() int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.notifyEvents
+}
+
+func (cfg *config) clientLimit() int {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return int(cfg.maxClients)
+}
+
+// rdbPath is the file SAVE writes the snapshot to, and that is loaded at
+// startup.
+func (cfg *config) rdbPath() string {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return filepath.Join(cfg.dir, cfg.dbFilename)
+}
+
+// saveRules are the rules of the save parameter, after which a background
+// save starts.
+func (cfg *config) saveRules() []saveRule {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	rules, _ := parseSaveRules(cfg.save)
+	return rules
+}
+
+func (cfg *config) eviction() evictionConfig {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	e := cfg.evict
+	e.maxMemory = cfg.maxMemory
+	return e
+}
+
+func (cfg *config) encodingLimits() encodingLimits {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	return cfg.limits
+}
+
+// get returns the value of the parameter name.
+func (cfg *config) get(name string) (string, bool) {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	p, ok := cfg.params[name]
+	if !ok {
+		return "", false
+	}
+	return p.get(), true
+}
+
+// set changes the parameter name. Immutable parameters can only be set while
+// starting, when atStartup is set.
+func (cfg *config) set(name, value string, atStartup bool) error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	p, ok := cfg.params[name]
+	if !ok {
+		return fmt.Errorf("unknown parameter '%s'", name)
+	}
+	if p.immutable && !atStartup {
+		return errImmutableConfig
+	}
+	return p.set(value)
+}
+
+// splitConfigLine splits a line of a config file into its directive and
+// arguments. Arguments may be double quoted to hold spaces.
+func splitConfigLine(line string) ([]string, error) {
+	var args []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] != '"' {
+			end := strings.IndexAny(line, " \t")
+			if end < 0 {
+				end = len(line)
+			}
+			args = append(args, line[:end])
+			line = line[end:]
+			continue
+		}
+		end := strings.IndexByte(line[1:], '"')
+		if end < 0 {
+			return nil, errors.New("unbalanced quotes in configuration line")
+		}
+		args = append(args, line[1:end+1])
+		line = line[end+2:]
+	}
+	return args, nil
+}
+
+// load reads the config file at path: one directive and its arguments per
+// line, with blank lines and those starting with # ignored. Unknown
+// directives are reported and skipped, invalid values are an error.
+func (cfg *config) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		args, err := splitConfigLine(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		name := strings.ToLower(args[0])
+		if _, ok := cfg.params[name]; !ok {
+			fmt.Printf("Warning: unknown directive '%s' at line %d of %s\n", args[0], n, path)
+			continue
+		}
+		if err := cfg.set(name, strings.Join(args[1:], " "), true); err != nil {
+			return fmt.Errorf("line %d: '%s': %v", n, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	cfg.file = path
+	return nil
+}
+
+// formatConfigLine formats a directive for the config file, quoting values
+// that are empty or contain spaces. replicaof is the only parameter written
+// as several arguments: save is quoted like any value with spaces.
+func formatConfigLine(name, value string) string {
+	if value == "" || name != "replicaof" && strings.ContainsAny(value, " \t") {
+		value = `"` + value + `"`
+	}
+	return name + " " + value
+}
+
+// rewrite writes the current parameters back to the config file. The lines
+// of the parameters are updated in place, with duplicates dropped, and the
+// parameters missing from the file that are not at their default appended.
+// Comments and unknown directives are kept as they are.
+func (cfg *config) rewrite() error {
+	cfg.mu.Lock()
+	defer cfg.mu.Unlock()
+	if cfg.file == "" {
+		return errors.New("ERR The server is running without a config file")
+	}
+	data, err := os.ReadFile(cfg.file)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+
+	var lines []string
+	written := map[string]bool{}
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		args, err := splitConfigLine(line)
+		if err != nil || len(args) == 0 || strings.HasPrefix(args[0], "#") {
+			lines = append(lines, line)
+			continue
+		}
+		name := strings.ToLower(args[0])
+		p, ok := cfg.params[name]
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		if !written[name] {
+			lines = append(lines, formatConfigLine(name, p.get()))
+			written[name] = true
+		}
+	}
+	names := sortedKeys(cfg.params)
+	appended := false
+	for _, name := range names {
+		p := cfg.params[name]
+		if written[name] || p.get() == p.initial {
+			continue
+		}
+		if !appended {
+			lines = append(lines, "# Generated by CONFIG REWRITE")
+			appended = true
+		}
+		lines = append(lines, formatConfigLine(name, p.get()))
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.file), "redis-conf-*")
+	if err != nil {
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	_, err = tmp.WriteString(strings.Join(lines, "\n") + "\n")
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), cfg.file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ERR Rewriting config file: %v", err)
+	}
+	return nil
+}
+
+// configCommand implements CONFIG GET, CONFIG SET, CONFIG REWRITE and CONFIG
+// RESETSTAT.
+func (s *server) configCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("config")
+		return
+	}
+	cfg := s.config
+	switch strings.ToLower(commands[1]) {
+	case "get":
+		if len(commands) < 3 {
+			c.replyWrongArgs("config|get")
+			return
+		}
+		var matched []string
+		for _, name := range sortedKeys(cfg.params) {
+			for _, pattern := range commands[2:] {
+				if globMatch(strings.ToLower(pattern), name) {
+					matched = append(matched, name)
+					break
+				}
+			}
+		}
+		c.replyWith(func(w *respWriter) {
+			w.WriteMap(len(matched))
+			for _, name := range matched {
+				value, _ := cfg.get(name)
+				w.WriteBulkString(name)
+				w.WriteBulkString(value)
+			}
+		})
+	case "set":
+		if len(commands) < 4 || len(commands)%2 != 0 {
+			c.replyWrongArgs("config|set")
+			return
+		}
+		// Every parameter is checked before any is changed, so that the
+		// command is all or nothing.
+		seen := map[string]bool{}
+		for i := 2; i < len(commands); i += 2 {
+			name := strings.ToLower(commands[i])
+			p, ok := cfg.params[name]
+			if !ok {
+				c.replyErrorMsg(fmt.Sprintf("Unknown option or number of arguments for CONFIG SET - '%s'", commands[i]))
+				return
+			}
+			if seen[name] {
+				c.replyErrorMsg(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - duplicate parameter", commands[i]))
+				return
+			}
+			seen[name] = true
+			if p.immutable {
+				c.replyErrorMsg(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %v", commands[i], errImmutableConfig))
+				return
+			}
+		}
+		cfg.mu.Lock()
+		previous := map[string]string{}
+		for i := 2; i < len(commands); i += 2 {
+			name := strings.ToLower(commands[i])
+			p := cfg.params[name]
+			previous[name] = p.get()
+			if err := p.set(commands[i+1]); err != nil {
+				for name, value := range previous {
+					cfg.params[name].set(value)
+				}
+				cfg.mu.Unlock()
+				c.replyErrorMsg(fmt.Sprintf("CONFIG SET failed (possibly related to argument '%s') - %v", commands[i], err))
+				return
+			}
+		}
+		cfg.mu.Unlock()
+		c.replyOK()
+	case "rewrite":
+		if len(commands) != 2 {
+			c.replyWrongArgs("config|rewrite")
+			return
+		}
+		if err := cfg.rewrite(); err != nil {
+			c.replyError(err)
+			return
+		}
+		c.replyOK()
+	case "resetstat":
+		if len(commands) != 2 {
+			c.replyWrongArgs("config|resetstat")
+			return
+		}
+		s.resetStats()
+		c.replyOK()
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try CONFIG HELP.", commands[1]))
+	}
+}
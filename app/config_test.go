@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfigFile writes a config file of lines in a temporary directory
+// and returns its path.
+func writeConfigFile(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	path := writeConfigFile(t,
+		"# memory",
+		"maxmemory 100mb",
+		"no-such-directive yes",
+		`save "900 1 300 10"`,
+	)
+	s, addr := startServer(t)
+	if err := s.config.load(path); err != nil {
+		t.Fatal(err)
+	}
+	c := dial(t, addr)
+	c.expect([]any{"maxmemory", "104857600"}, "CONFIG", "GET", "maxmemory")
+	c.expect([]any{"save", "900 1 300 10"}, "CONFIG", "GET", "save")
+
+	c.expect(respStatus("OK"), "CONFIG", "SET", "maxmemory", "200mb")
+	c.expect(respStatus("OK"), "CONFIG", "SET", "maxmemory-policy", "allkeys-lru")
+	c.expect(respStatus("OK"), "CONFIG", "REWRITE")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"# memory", "maxmemory 209715200", "no-such-directive yes", "maxmemory-policy allkeys-lru"} {
+		if !strings.Contains(string(data), line+"\n") {
+			t.Errorf("the rewritten file lacks %q:\n%s", line, data)
+		}
+	}
+	reloaded := newConfig()
+	if err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := reloaded.get("maxmemory"); value != "209715200" {
+		t.Errorf("maxmemory after reloading the rewritten file: %s", value)
+	}
+}
+
+func TestConfigFileInvalidValue(t *testing.T) {
+	path := writeConfigFile(t, "maxmemory lots")
+	if err := newConfig().load(path); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Fatalf("loading an invalid maxmemory: got %v, want an error for line 1", err)
+	}
+}
+
+func TestConfigRewriteWithoutFile(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respError("ERR The server is running without a config file"), "CONFIG", "REWRITE")
+}
+
+func TestParseMemory(t *testing.T) {
+	for value, want := range map[string]int64{
+		"0":     0,
+		"512":   512,
+		"512b":  512,
+		"1k":    1000,
+		"1kb":   1024,
+		"2m":    2000000,
+		"100mb": 100 << 20,
+		"3g":    3000000000,
+		"1gb":   1 << 30,
+		"1GB":   1 << 30,
+	} {
+		if got, err := parseMemory(value); err != nil || got != want {
+			t.Errorf("parseMemory(%q) = %d, %v, want %d", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "mb", "-1", "1tb", "1.5gb", "1 mb", "10000000000gb"} {
+		if _, err := parseMemory(value); err == nil {
+			t.Errorf("parseMemory(%q) did not fail", value)
+		}
+	}
+}
+
+func TestConfigSetMemory(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "CONFIG", "SET", "maxmemory", "1kb")
+	c.expect([]any{"maxmemory", "1024"}, "CONFIG", "GET", "maxmemory")
+	if _, ok := c.do("CONFIG", "SET", "maxmemory", "1xb").(respError); !ok {
+		t.Fatal("CONFIG SET maxmemory 1xb did not fail")
+	}
+	c.expect([]any{"maxmemory", "1024"}, "CONFIG", "GET", "maxmemory")
+}
+
+func TestConfigSetAtomic(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "CONFIG", "SET", "maxmemory", "10mb", "maxmemory-policy", "allkeys-lru")
+	c.expect([]any{"maxmemory", "10485760", "maxmemory-policy", "allkeys-lru"}, "CONFIG", "GET", "maxmemory", "maxmemory-policy")
+	// A value that is refused leaves the others of the command unset.
+	if _, ok := c.do("CONFIG", "SET", "maxmemory", "20mb", "maxmemory-policy", "bogus").(respError); !ok {
+		t.Fatal("CONFIG SET of an invalid policy did not fail")
+	}
+	c.expect([]any{"maxmemory", "10485760"}, "CONFIG", "GET", "maxmemory")
+	c.expect(respError("ERR Unknown option or number of arguments for CONFIG SET - 'no-such-param'"), "CONFIG", "SET", "no-such-param", "1")
+}
+
+func TestConfigGetPattern(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	reply, _ := c.do("CONFIG", "GET", "maxmemory*").([]any)
+	got := map[any]any{}
+	for i := 0; i+1 < len(reply); i += 2 {
+		got[reply[i]] = reply[i+1]
+	}
+	for _, name := range []string{"maxmemory", "maxmemory-policy", "maxmemory-samples"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("CONFIG GET maxmemory* lacks %s: %v", name, reply)
+		}
+	}
+	if _, ok := got["port"]; ok {
+		t.Errorf("CONFIG GET maxmemory* returned port")
+	}
+	c.expect([]any{}, "CONFIG", "GET", "no-such-param")
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBusyGroup = errors.New("BUSYGROUP Consumer Group name already exists")
+
+// pendingEntry is an entry of a group's pending entries list: delivered to a
+// consumer and not acknowledged yet. delivered is when it was last delivered
+// and deliveries how many times it was.
+type pendingEntry struct {
+	consumer   string
+	delivered  time.Time
+	deliveries int
+}
+
+// consumerGroup tracks which entries of a stream were delivered to which
+// consumer. lastID is the last id delivered to any consumer.
+type consumerGroup struct {
+	lastID    streamID
+	pending   map[streamID]*pendingEntry
+	consumers map[string]struct{}
+}
+
+func newConsumerGroup(lastID streamID) *consumerGroup {
+	return &consumerGroup{
+		lastID:    lastID,
+		pending:   map[streamID]*pendingEntry{},
+		consumers: map[string]struct{}{},
+	}
+}
+
+// pendingIDs returns the sorted ids pending for consumer, or for every
+// consumer when consumer is empty.
+func (g *consumerGroup) pendingIDs(consumer string) []streamID {
+	var ids []streamID
+	for id, p := range g.pending {
+		if consumer == "" || p.consumer == consumer {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].less(ids[j]) })
+	return ids
+}
+
+func errNoGroup(key, group, context string) error {
+	return fmt.Errorf("NOGROUP No such key '%s' or consumer group '%s'%s", key, group, context)
+}
+
+// group returns the consumer group of the stream at key, or nil if either does
+// not exist. The caller must hold the write lock.
+func (s *Store) group(key, name string) (*stream, *consumerGroup, error) {
+	st, err := s.stream(key, false)
+	if err != nil || st == nil {
+		return nil, nil, err
+	}
+	return st, st.groups[name], nil
+}
+
+// XGroupCreate creates the consumer group name on the stream at key, starting
+// after idArg ($ for the last entry). With mkStream a missing stream is
+// created empty.
+func (s *Store) XGroupCreate(key, name, idArg string, mkStream bool) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil {
+		return err
+	}
+	if st == nil && !mkStream {
+		return errors.New("ERR The XGROUP subcommand requires the key to exist. Note that for CREATE you may want to use the MKSTREAM option to create an empty stream automatically.")
+	}
+	var lastID streamID
+	if idArg != "$" {
+		if lastID, err = parseStreamID(idArg, 0); err != nil {
+			return err
+		}
+	}
+	if st == nil {
+		st, _ = s.stream(key, true)
+	}
+	if idArg == "$" {
+		lastID = st.lastID
+	}
+	if _, ok := st.groups[name]; ok {
+		return errBusyGroup
+	}
+	if st.groups == nil {
+		st.groups = map[string]*consumerGroup{}
+	}
+	st.groups[name] = newConsumerGroup(lastID)
+	return nil
+}
+
+// XGroupDestroy deletes a consumer group and reports whether it existed.
+func (s *Store) XGroupDestroy(key, name string) (bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil {
+		return false, err
+	}
+	if st == nil {
+		return false, errors.New("ERR The XGROUP subcommand requires the key to exist")
+	}
+	if _, ok := st.groups[name]; !ok {
+		return false, nil
+	}
+	delete(st.groups, name)
+	return true, nil
+}
+
+// streamRead is the part of an XREAD or XREADGROUP reply for one stream.
+type streamRead struct {
+	key     string
+	entries []streamEntry
+}
+
+// XReadGroup reads keys on behalf of consumer in group. An id of > delivers
+// entries never delivered to the group and adds them to the pending entries
+// list unless noAck is set; any other id returns the consumer's own pending
+// entries after it. count limits the entries per stream when positive.
+func (s *Store) XReadGroup(group, consumer string, keys, ids []string, count int, noAck bool) ([]streamRead, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	now := s.Clock.Now()
+	groups := make([]*consumerGroup, len(keys))
+	streams := make([]*stream, len(keys))
+	for i, key := range keys {
+		st, g, err := s.group(key, group)
+		if err != nil {
+			return nil, err
+		}
+		if g == nil {
+			return nil, errNoGroup(key, group, " in XREADGROUP with GROUP option")
+		}
+		streams[i], groups[i] = st, g
+	}
+
+	var reads []streamRead
+	for i, key := range keys {
+		st, g := streams[i], groups[i]
+		g.consumers[consumer] = struct{}{}
+		if ids[i] == ">" {
+			entries := st.rangeEntries(g.lastID.next(), st.lastID, count)
+			if len(entries) == 0 {
+				continue
+			}
+			g.lastID = entries[len(entries)-1].id
+			if !noAck {
+				for _, entry := range entries {
+					g.pending[entry.id] = &pendingEntry{consumer: consumer, delivered: now, deliveries: 1}
+				}
+			}
+			reads = append(reads, streamRead{key, entries})
+			continue
+		}
+		after, _ := parseStreamID(ids[i], 0)
+		entries := []streamEntry{}
+		for _, id := range g.pendingIDs(consumer) {
+			if !after.less(id) {
+				continue
+			}
+			if count > 0 && len(entries) == count {
+				break
+			}
+			p := g.pending[id]
+			p.delivered = now
+			p.deliveries++
+			entry := streamEntry{id: id}
+			if j := st.search(id); j < len(st.entries) && st.entries[j].id == id {
+				entry = st.entries[j]
+			}
+			entries = append(entries, entry)
+		}
+		reads = append(reads, streamRead{key, entries})
+	}
+	return reads, nil
+}
+
+// XAck removes ids from the pending entries list of group and returns how
+// many were pending.
+func (s *Store) XAck(key, group string, ids []streamID) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	_, g, err := s.group(key, group)
+	if err != nil || g == nil {
+		return 0, err
+	}
+	acked := 0
+	for _, id := range ids {
+		if _, ok := g.pending[id]; ok {
+			delete(g.pending, id)
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// pendingSummary is the reply of the summary form of XPENDING.
+type pendingSummary struct {
+	count     int
+	first     streamID
+	last      streamID
+	consumers map[string]int
+}
+
+func (s *Store) XPending(key, group string) (*pendingSummary, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	_, g, err := s.group(key, group)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil {
+		return nil, errNoGroup(key, group, "")
+	}
+	ids := g.pendingIDs("")
+	summary := &pendingSummary{count: len(ids), consumers: map[string]int{}}
+	if len(ids) > 0 {
+		summary.first, summary.last = ids[0], ids[len(ids)-1]
+	}
+	for _, p := range g.pending {
+		summary.consumers[p.consumer]++
+	}
+	return summary, nil
+}
+
+// xgroupCommand implements XGROUP CREATE key group id|$ [MKSTREAM] and
+// XGROUP DESTROY key group.
+func (s *server) xgroupCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("xgroup")
+		return
+	}
+	switch strings.ToLower(commands[1]) {
+	case "create":
+		if len(commands) != 5 && len(commands) != 6 {
+			c.replyWrongArgs("xgroup|create")
+			return
+		}
+		mkStream := false
+		if len(commands) == 6 {
+			if !strings.EqualFold(commands[5], "mkstream") {
+				c.replyError(errSyntax)
+				return
+			}
+			mkStream = true
+		}
+		if err := s.db(c).XGroupCreate(commands[2], commands[3], commands[4], mkStream); err != nil {
+			c.replyError(err)
+			return
+		}
+		s.propagate(c.db, commands)
+		c.replyOK()
+	case "destroy":
+		if len(commands) != 4 {
+			c.replyWrongArgs("xgroup|destroy")
+			return
+		}
+		destroyed, err := s.db(c).XGroupDestroy(commands[2], commands[3])
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		if !destroyed {
+			c.replyInteger(0)
+			return
+		}
+		s.propagate(c.db, commands)
+		c.replyInteger(1)
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try XGROUP HELP.", commands[1]))
+	}
+}
+
+// xreadgroupCommand implements
+// XREADGROUP GROUP group consumer [COUNT count] [BLOCK milliseconds] [NOACK] STREAMS key [key ...] id [id ...].
+// With BLOCK, a read of new entries only, with > for every stream, that
+// finds none waits for some to be added, like XREAD.
+func (s *server) xreadgroupCommand(c *clientConn, commands []string) {
+	if len(commands) < 7 || !strings.EqualFold(commands[1], "group") {
+		c.replyWrongArgs("xreadgroup")
+		return
+	}
+	group, consumer := commands[2], commands[3]
+	opts, err := parseXRead(commands, 4, true)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	for _, id := range opts.ids {
+		if id == "$" {
+			c.replyErrorMsg("The $ ID is meaningless in the context of XREADGROUP: you want to read the history of this consumer by specifying a proper ID, or use the > ID to get new messages. The $ ID would just return an empty result set.")
+			return
+		}
+		if id == ">" {
+			continue
+		}
+		// The history of the consumer is read right away, BLOCK or not.
+		opts.timeout = -1
+		if _, err := parseStreamID(id, 0); err != nil {
+			c.replyError(err)
+			return
+		}
+	}
+	// The read is propagated without BLOCK, which the replicas and the
+	// append only file have no use for.
+	propagated := commands
+	if opts.blockAt > 0 {
+		propagated = append(slices.Clip(commands[:opts.blockAt]), commands[opts.blockAt+2:]...)
+	}
+	s.block(c, opts.timeout, func(db *Store) (any, []string, error) {
+		reads, err := db.XReadGroup(group, consumer, opts.keys, opts.ids, opts.count, opts.noAck)
+		if err != nil || len(reads) == 0 {
+			return nil, nil, err
+		}
+		return readsReply(reads), propagated, nil
+	})
+}
+
+// xackCommand implements XACK key group id [id ...].
+func (s *server) xackCommand(c *clientConn, commands []string) {
+	if len(commands) < 4 {
+		c.replyWrongArgs("xack")
+		return
+	}
+	ids := make([]streamID, len(commands)-3)
+	for i, arg := range commands[3:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		ids[i] = id
+	}
+	acked, err := s.db(c).XAck(commands[1], commands[2], ids)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if acked > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(acked)
+}
+
+// xpendingCommand implements the summary form of XPENDING: the number of
+// pending entries, the smallest and greatest pending ids and how many entries
+// each consumer has pending.
+func (s *server) xpendingCommand(c *clientConn, commands []string) {
+	if len(commands) != 3 {
+		c.replyWrongArgs("xpending")
+		return
+	}
+	summary, err := s.db(c).XPending(commands[1], commands[2])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if summary.count == 0 {
+		c.replyValue([]any{0, nil, nil, nullArray})
+		return
+	}
+	consumers := make([]string, 0, len(summary.consumers))
+	for consumer := range summary.consumers {
+		consumers = append(consumers, consumer)
+	}
+	sort.Strings(consumers)
+	c.replyWith(func(w *respWriter) {
+		w.WriteArray(4)
+		w.WriteInteger(int64(summary.count))
+		w.WriteBulkString(summary.first.String())
+		w.WriteBulkString(summary.last.String())
+		w.WriteArray(len(consumers))
+		for _, consumer := range consumers {
+			w.WriteArray(2)
+			w.WriteBulkString(consumer)
+			w.WriteBulkString(strconv.Itoa(summary.consumers[consumer]))
+		}
+	})
+}
+
+// claimOptions are the XCLAIM options. A zero deliveryTime means now and a
+// negative retryCount that the delivery count is incremented.
+type claimOptions struct {
+	minIdle      time.Duration
+	deliveryTime time.Time
+	retryCount   int
+	force        bool
+	justID       bool
+	lastID       streamID
+}
+
+// claimedEntry is an entry assigned to a new consumer, with its updated state
+// in the pending entries list.
+type claimedEntry struct {
+	entry   streamEntry
+	pending pendingEntry
+}
+
+// claim assigns the pending entry id to consumer if it has been idle for at
+// least opts.minIdle. Pending entries deleted from the stream are removed
+// from the list instead, and deleted is set.
+func (g *consumerGroup) claim(st *stream, id streamID, consumer string, now time.Time, opts claimOptions) (claimed *claimedEntry, deleted bool) {
+	entry := streamEntry{id: id}
+	if i := st.search(id); i < len(st.entries) && st.entries[i].id == id {
+		entry = st.entries[i]
+	}
+	p, ok := g.pending[id]
+	if !ok {
+		if !opts.force || entry.fields == nil {
+			return nil, false
+		}
+		p = &pendingEntry{}
+		g.pending[id] = p
+	} else if opts.minIdle > 0 && now.Sub(p.delivered) < opts.minIdle {
+		return nil, false
+	}
+	if entry.fields == nil {
+		delete(g.pending, id)
+		return nil, true
+	}
+	g.consumers[consumer] = struct{}{}
+	p.consumer = consumer
+	p.delivered = now
+	if !opts.deliveryTime.IsZero() {
+		p.delivered = opts.deliveryTime
+	}
+	if opts.retryCount >= 0 {
+		p.deliveries = opts.retryCount
+	} else if !opts.justID {
+		p.deliveries++
+	}
+	return &claimedEntry{entry, *p}, false
+}
+
+// XClaim assigns the pending entries ids of group to consumer, skipping those
+// not idle for opts.minIdle. It also returns the ids of pending entries that
+// no longer exist in the stream, which are dropped from the list.
+func (s *Store) XClaim(key, group, consumer string, ids []streamID, opts claimOptions) (claimed []claimedEntry, deleted []streamID, err error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, g, err := s.group(key, group)
+	if err != nil {
+		return nil, nil, err
+	}
+	if g == nil {
+		return nil, nil, errNoGroup(key, group, "")
+	}
+	if g.lastID.less(opts.lastID) {
+		g.lastID = opts.lastID
+	}
+	now := s.Clock.Now()
+	for _, id := range ids {
+		c, gone := g.claim(st, id, consumer, now, opts)
+		if c != nil {
+			claimed = append(claimed, *c)
+		}
+		if gone {
+			deleted = append(deleted, id)
+		}
+	}
+	return claimed, deleted, nil
+}
+
+// XAutoClaim scans the pending entries list of group from start and claims
+// for consumer up to count entries idle for at least minIdle, looking at no
+// more than ten times count entries. It returns the id to continue the scan
+// from, or 0-0 when the list was exhausted, the claimed entries and the ids
+// of pending entries that no longer exist in the stream.
+func (s *Store) XAutoClaim(key, group, consumer string, minIdle time.Duration, start streamID, count int, justID bool) (next streamID, claimed []claimedEntry, deleted []streamID, err error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, g, err := s.group(key, group)
+	if err != nil {
+		return next, nil, nil, err
+	}
+	if g == nil {
+		return next, nil, nil, errNoGroup(key, group, "")
+	}
+	now := s.Clock.Now()
+	opts := claimOptions{minIdle: minIdle, retryCount: -1, justID: justID}
+	ids := g.pendingIDs("")
+	i := sort.Search(len(ids), func(i int) bool { return !ids[i].less(start) })
+	for attempts := count * 10; i < len(ids) && attempts > 0 && len(claimed) < count; i, attempts = i+1, attempts-1 {
+		c, gone := g.claim(st, ids[i], consumer, now, opts)
+		if c != nil {
+			claimed = append(claimed, *c)
+		}
+		if gone {
+			deleted = append(deleted, ids[i])
+		}
+	}
+	if i < len(ids) {
+		next = ids[i]
+	}
+	return next, claimed, deleted, nil
+}
+
+// propagateClaims propagates claims as XCLAIM commands carrying the resulting
+// delivery time and count, so that replicas and the AOF do not depend on when
+// they apply them. Dropped pending entries are propagated as an XACK.
+func (s *server) propagateClaims(db int, key, group string, claimed []claimedEntry, deleted []streamID, lastID streamID) {
+	for _, c := range claimed {
+		s.propagate(db, []string{"XCLAIM", key, group, c.pending.consumer, "0", c.entry.id.String(),
+			"TIME", strconv.FormatInt(c.pending.delivered.UnixMilli(), 10),
+			"RETRYCOUNT", strconv.Itoa(c.pending.deliveries),
+			"FORCE", "JUSTID", "LASTID", lastID.String()})
+	}
+	if len(deleted) > 0 {
+		ack := []string{"XACK", key, group}
+		for _, id := range deleted {
+			ack = append(ack, id.String())
+		}
+		s.propagate(db, ack)
+	}
+}
+
+func claimedReply(claimed []claimedEntry, justID bool) any {
+	if justID {
+		ids := make([]string, len(claimed))
+		for i, c := range claimed {
+			ids[i] = c.entry.id.String()
+		}
+		return ids
+	}
+	entries := make([]streamEntry, len(claimed))
+	for i, c := range claimed {
+		entries[i] = c.entry
+	}
+	return entriesReply(entries)
+}
+
+func parseMinIdle(arg string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errors.New("ERR Invalid min-idle-time argument for XCLAIM")
+	}
+	return time.Duration(max(ms, 0)) * time.Millisecond, nil
+}
+
+// xclaimCommand implements XCLAIM key group consumer min-idle-time id [id ...]
+// [IDLE ms] [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID]
+// [LASTID id].
+func (s *server) xclaimCommand(c *clientConn, commands []string) {
+	if len(commands) < 6 {
+		c.replyWrongArgs("xclaim")
+		return
+	}
+	minIdle, err := parseMinIdle(commands[4])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	i := 5
+	var ids []streamID
+	for ; i < len(commands); i++ {
+		id, err := parseStreamID(commands[i], 0)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	opts := claimOptions{minIdle: minIdle, retryCount: -1}
+	for ; i < len(commands); i++ {
+		option := strings.ToLower(commands[i])
+		switch option {
+		case "force":
+			opts.force = true
+			continue
+		case "justid":
+			opts.justID = true
+			continue
+		case "idle", "time", "retrycount", "lastid":
+		default:
+			c.replyErrorMsg(fmt.Sprintf("Unrecognized XCLAIM option '%s'", commands[i]))
+			return
+		}
+		if i+1 >= len(commands) {
+			c.replyError(errSyntax)
+			return
+		}
+		i++
+		if option == "lastid" {
+			if opts.lastID, err = parseStreamID(commands[i], 0); err != nil {
+				c.replyError(err)
+				return
+			}
+			continue
+		}
+		n, err := strconv.ParseInt(commands[i], 10, 64)
+		if err != nil {
+			c.replyErrorMsg(fmt.Sprintf("Invalid %s option argument for XCLAIM", strings.ToUpper(option)))
+			return
+		}
+		switch option {
+		case "idle":
+			opts.deliveryTime = s.clock.Now().Add(-time.Duration(n) * time.Millisecond)
+		case "time":
+			opts.deliveryTime = time.UnixMilli(n)
+		case "retrycount":
+			opts.retryCount = int(max(n, 0))
+		}
+	}
+	claimed, deleted, err := s.db(c).XClaim(commands[1], commands[2], commands[3], ids, opts)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagateClaims(c.db, commands[1], commands[2], claimed, deleted, opts.lastID)
+	c.replyValue(claimedReply(claimed, opts.justID))
+}
+
+// xautoclaimCommand implements
+// XAUTOCLAIM key group consumer min-idle-time start [COUNT count] [JUSTID].
+func (s *server) xautoclaimCommand(c *clientConn, commands []string) {
+	if len(commands) < 6 {
+		c.replyWrongArgs("xautoclaim")
+		return
+	}
+	minIdle, err := parseMinIdle(commands[4])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	start, err := parseRangeID(commands[5], true)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	count := 100
+	justID := false
+	for i := 6; i < len(commands); i++ {
+		switch {
+		case strings.EqualFold(commands[i], "count") && i+1 < len(commands):
+			n, err := strconv.Atoi(commands[i+1])
+			if err != nil || n < 1 {
+				c.replyErrorMsg("COUNT must be > 0")
+				return
+			}
+			count = n
+			i++
+		case strings.EqualFold(commands[i], "justid"):
+			justID = true
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	next, claimed, deleted, err := s.db(c).XAutoClaim(commands[1], commands[2], commands[3], minIdle, start, count, justID)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagateClaims(c.db, commands[1], commands[2], claimed, deleted, streamID{})
+	deletedIDs := make([]string, len(deleted))
+	for i, id := range deleted {
+		deletedIDs[i] = id.String()
+	}
+	c.replyValue([]any{next.String(), claimedReply(claimed, justID), deletedIDs})
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DebugObject describes the value at key the way DEBUG OBJECT does. The
+// serialized length is the size of the value in a snapshot, and the
+// quicklist fields of lists tell how they would be split into listpacks.
+func (s *Store) DebugObject(key string, limits encodingLimits) (string, bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	value, ok := s.lookup(key)
+	if !ok {
+		return "", false
+	}
+	var serialized bytes.Buffer
+	writeRDBValue(&serialized, value)
+	idle := 0
+	if last := s.lastAccess(key); !last.IsZero() {
+		idle = int(s.Clock.Now().Sub(last).Seconds())
+	}
+	info := fmt.Sprintf("Value at:0x0 refcount:1 encoding:%s serializedlength:%d lru:0 lru_seconds_idle:%d",
+		s.encoding(key, value, limits), serialized.Len(), idle)
+	if list, ok := value.([]string); ok {
+		nodes, size := max(limits.listNodes(list), 1), 0
+		for _, element := range list {
+			size += len(element)
+		}
+		info += fmt.Sprintf(" ql_nodes:%d ql_avg_node:%.2f ql_listpack_max:%d ql_compressed:0 ql_uncompressed_size:%d",
+			nodes, float64(len(list))/float64(nodes), limits.listSize, size)
+	}
+	return info, true
+}
+
+// Populate creates the keys prefix:0 to prefix:count-1 that do not exist,
+// holding value:0 and so on. With a size other than -1 the values are cut or
+// padded with zero bytes to size bytes.
+func (s *Store) Populate(count int, prefix string, size int) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for i := 0; i < count; i++ {
+		key := prefix + ":" + strconv.Itoa(i)
+		if _, ok := s.lookup(key); ok {
+			continue
+		}
+		value := "value:" + strconv.Itoa(i)
+		if size >= 0 {
+			if size < len(value) {
+				value = value[:size]
+			} else {
+				value += strings.Repeat("\x00", size-len(value))
+			}
+		}
+		s.Data[key] = value
+		s.Sizes[key] = valueSize(key, value)
+		s.Used += s.Sizes[key]
+	}
+}
+
+// debugCommand implements DEBUG PROTOCOL verbatim|bignum, which reply with a
+// sample of the given RESP3 type, DEBUG SET-ACTIVE-EXPIRE 0|1, which stops
+// and restarts the background deletion of expired keys, DEBUG RELOAD, which
+// saves and loads back the snapshot, DEBUG CHANGE-REPL-ID, which starts a new
+// replication history as a failover would, DEBUG OBJECT, which describes how
+// a value is stored, DEBUG POPULATE, which creates keys to test with, DEBUG
+// SLEEP, which stalls the server, and DEBUG STRINGMATCH-LEN, which tells
+// whether a string matches a glob-style pattern as KEYS and PSUBSCRIBE
+// match them. Test suites call other subcommands, such as JMAP, that have
+// nothing to do here and reply +OK.
+func (s *server) debugCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("debug")
+		return
+	}
+	switch strings.ToLower(commands[1]) {
+	case "protocol":
+		if len(commands) != 3 {
+			c.replyWrongArgs("debug")
+			return
+		}
+		switch strings.ToLower(commands[2]) {
+		case "verbatim":
+			c.replyVerbatim("txt", "This is a verbatim\nstring")
+		case "bignum":
+			c.replyBigNumber("1234567999999999999999999999999999999")
+		default:
+			c.replyErrorMsg("Wrong protocol type name. Please use one of the following: verbatim|bignum")
+		}
+	case "set-active-expire":
+		if len(commands) != 3 {
+			c.replyWrongArgs("debug")
+			return
+		}
+		enabled, err := strconv.Atoi(commands[2])
+		if err != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+		s.activeExpireOff.Store(enabled == 0)
+		c.replyOK()
+	case "reload":
+		// No other command runs between the save and the load.
+		unlock, err := s.lockExclusive(c)
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		defer unlock()
+		path := s.config.rdbPath()
+		if err := s.save(path); err != nil {
+			c.replyErrorMsg("Error trying to save the saving DB on disk: " + err.Error())
+			return
+		}
+		if err := s.loadRDB(path); err != nil {
+			c.replyErrorMsg("Error trying to load the RDB dump: " + err.Error())
+			return
+		}
+		c.replyOK()
+	case "object":
+		if len(commands) != 3 {
+			c.replyWrongArgs("debug")
+			return
+		}
+		info, ok := s.db(c).DebugObject(commands[2], s.config.encodingLimits())
+		if !ok {
+			c.replyErrorMsg("no such key")
+			return
+		}
+		c.replyStatus(info)
+	case "populate":
+		if len(commands) < 3 || len(commands) > 5 {
+			c.replyWrongArgs("debug")
+			return
+		}
+		count, err := strconv.Atoi(commands[2])
+		if err != nil || count < 0 {
+			c.replyErrorMsg("count is not an integer or out of range")
+			return
+		}
+		prefix, size := "key", -1
+		if len(commands) > 3 {
+			prefix = commands[3]
+		}
+		if len(commands) > 4 {
+			if size, err = strconv.Atoi(commands[4]); err != nil || size < 0 {
+				c.replyErrorMsg("value is out of range, must be positive")
+				return
+			}
+		}
+		s.db(c).Populate(count, prefix, size)
+		c.replyOK()
+	case "sleep":
+		if len(commands) != 3 {
+			c.replyWrongArgs("debug")
+			return
+		}
+		seconds, err := strconv.ParseFloat(commands[2], 64)
+		if err != nil || seconds < 0 {
+			c.replyError(errNotFloat)
+			return
+		}
+		// Like redis, which runs commands one at a time, nothing else runs
+		// meanwhile.
+		unlock, err := s.lockExclusive(c)
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		<-s.clock.After(time.Duration(seconds * float64(time.Second)))
+		unlock()
+		c.replyOK()
+	case "stringmatch-len":
+		if len(commands) != 4 {
+			c.replyWrongArgs("debug")
+			return
+		}
+		matched := 0
+		if globMatch(commands[2], commands[3]) {
+			matched = 1
+		}
+		c.replyInteger(matched)
+	case "change-repl-id":
+		s.replMu.Lock()
+		s.replID = randomID()
+		s.replMu.Unlock()
+		c.replyOK()
+	default:
+		c.replyOK()
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// inData reports whether key is in the data of database 0 of s, whether or
+// not it expired.
+func inData(s *server, key string) bool {
+	s.dbs[0].Mutex.RLock()
+	defer s.dbs[0].Mutex.RUnlock()
+	_, ok := s.dbs[0].Data[key]
+	return ok
+}
+
+func TestDebugSetActiveExpire(t *testing.T) {
+	clk := newFakeClock()
+	s, addr := startServerWithClock(t, clk)
+	go s.activeExpire()
+	// sweep lets the sweeper run once, and waits for it to wait again.
+	sweep := func() {
+		waitFor(t, "the sweeper to wait", func() bool { return clk.timers() == 1 })
+		clk.Advance(activeExpirePeriod)
+		waitFor(t, "the sweeper to run", func() bool { return clk.timers() == 1 })
+	}
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "DEBUG", "SET-ACTIVE-EXPIRE", "0")
+	c.expect(respStatus("OK"), "SET", "key", "value", "PX", "1")
+	sweep()
+	sweep()
+	if !inData(s, "key") {
+		t.Fatal("the key was deleted with active expire disabled")
+	}
+	c.expect(nil, "GET", "key")
+	if inData(s, "key") {
+		t.Fatal("the expired key was not deleted when accessed")
+	}
+
+	c.expect(respStatus("OK"), "DEBUG", "SET-ACTIVE-EXPIRE", "1")
+	c.expect(respStatus("OK"), "SET", "key", "value", "PX", "1")
+	sweep()
+	if inData(s, "key") {
+		t.Fatal("the expired key was not deleted by the sweeper")
+	}
+}
+
+func TestDebugNoOps(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "DEBUG", "JMAP")
+	c.expect(respStatus("OK"), "DEBUG", "QUICKLIST-PACKED-THRESHOLD", "100")
+}
+
+func TestDebugReload(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "string", "binary\x00value")
+	c.expect(respStatus("OK"), "SET", "volatile", "1", "EX", "1000")
+	c.expect(int64(3), "RPUSH", "list", "a", "b", "c")
+	c.expect(int64(2), "SADD", "set", "x", "y")
+	c.expect(int64(3), "SADD", "intset", "1", "2", "3")
+	c.expect(int64(2), "HSET", "hash", "f", "v", "g", "w")
+	c.expect(int64(2), "ZADD", "zset", "1.5", "a", "-2", "b")
+	c.expect("1-1", "XADD", "stream", "1-1", "field", "value")
+	c.expect(respStatus("OK"), "SELECT", "2")
+	c.expect(respStatus("OK"), "SET", "other", "db")
+
+	c.expect(respStatus("OK"), "DEBUG", "RELOAD")
+	c.expect("db", "GET", "other")
+	c.expect(respStatus("OK"), "SELECT", "0")
+	c.expect(int64(8), "DBSIZE")
+	c.expect("binary\x00value", "GET", "string")
+	if ttl, _ := c.do("TTL", "volatile").(int64); ttl < 999 || ttl > 1000 {
+		t.Fatalf("TTL after DEBUG RELOAD: got %d, want 1000", ttl)
+	}
+	c.expect([]any{"a", "b", "c"}, "LRANGE", "list", "0", "-1")
+	c.expect([]any{int64(1), int64(1), int64(0)}, "SMISMEMBER", "set", "x", "y", "z")
+	c.expect("intset", "OBJECT", "ENCODING", "intset")
+	c.expect("w", "HGET", "hash", "g")
+	c.expect("1.5", "ZSCORE", "zset", "a")
+	c.expect("-2", "ZSCORE", "zset", "b")
+	c.expect([]any{[]any{"1-1", []any{"field", "value"}}}, "XRANGE", "stream", "-", "+")
+}
+
+func TestDebugPopulate(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "item:5", "kept")
+	c.expect(respStatus("OK"), "DEBUG", "POPULATE", "1000", "item", "20")
+	c.expect(int64(1000), "DBSIZE")
+	c.expect("kept", "GET", "item:5")
+	if value, _ := c.do("GET", "item:999").(string); len(value) != 20 {
+		t.Fatalf("GET item:999: got %q, want 20 bytes", value)
+	}
+	c.expect(respStatus("OK"), "DEBUG", "POPULATE", "3")
+	c.expect("value:2", "GET", "key:2")
+}
+
+func TestDebugObject(t *testing.T) {
+	_, addr := startServer(t, "list-max-listpack-size", "2")
+	c := dial(t, addr)
+	c.expect(int64(5), "RPUSH", "list", "a", "b", "c", "d", "e")
+	info, _ := c.do("DEBUG", "OBJECT", "list").(respStatus)
+	for _, field := range []string{"encoding:quicklist", "ql_nodes:3", "ql_avg_node:1.67", "ql_uncompressed_size:5"} {
+		if !strings.Contains(string(info), " "+field) {
+			t.Errorf("DEBUG OBJECT of a list lacks %s: %s", field, info)
+		}
+	}
+	if !regexp.MustCompile(` serializedlength:[1-9]`).MatchString(string(info)) {
+		t.Errorf("DEBUG OBJECT of a list lacks the serialized length: %s", info)
+	}
+
+	c.expect(respStatus("OK"), "SET", "string", "value")
+	info, _ = c.do("DEBUG", "OBJECT", "string").(respStatus)
+	if !strings.Contains(string(info), " encoding:embstr serializedlength:") || strings.Contains(string(info), "ql_nodes") {
+		t.Errorf("DEBUG OBJECT of a string: %s", info)
+	}
+	c.expect(respError("ERR no such key"), "DEBUG", "OBJECT", "missing")
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// encodingLimits are the sizes up to which collections keep the compact
+// encodings redis reports for small values. listSize is a number of entries
+// when positive, and -1 to -5 stand for 4kb to 64kb of listpack.
+type encodingLimits struct {
+	listSize            int64
+	setIntsetEntries    int64
+	setListpackEntries  int64
+	setListpackValue    int64
+	hashListpackEntries int64
+	hashListpackValue   int64
+	zsetListpackEntries int64
+	zsetListpackValue   int64
+}
+
+// encodingRanks orders the encodings of sets, hashes and sorted sets, which
+// only ever move up.
+var encodingRanks = map[string]int{
+	"intset":    0,
+	"listpack":  1,
+	"hashtable": 2,
+	"skiplist":  2,
+}
+
+// encoding returns the encoding value would have if it were built from
+// scratch.
+func (l encodingLimits) encoding(value any) string {
+	switch v := value.(type) {
+	case string:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && strconv.FormatInt(n, 10) == v {
+			return "int"
+		}
+		if len(v) <= 44 {
+			return "embstr"
+		}
+		return "raw"
+	case []string:
+		if l.listFits(v, 1) {
+			return "listpack"
+		}
+		return "quicklist"
+	case map[string]struct{}:
+		n := int64(len(v))
+		if n <= l.setIntsetEntries && allIntegers(v) {
+			return "intset"
+		}
+		if n > l.setListpackEntries {
+			return "hashtable"
+		}
+		for member := range v {
+			if int64(len(member)) > l.setListpackValue {
+				return "hashtable"
+			}
+		}
+		return "listpack"
+	case intset:
+		return l.encoding(v.set())
+	case map[string]string:
+		if int64(len(v)) > l.hashListpackEntries {
+			return "hashtable"
+		}
+		for field, val := range v {
+			if int64(len(field)) > l.hashListpackValue || int64(len(val)) > l.hashListpackValue {
+				return "hashtable"
+			}
+		}
+		return "listpack"
+	case *sortedSet:
+		if int64(len(v.scores)) > l.zsetListpackEntries {
+			return "skiplist"
+		}
+		for member := range v.scores {
+			if int64(len(member)) > l.zsetListpackValue {
+				return "skiplist"
+			}
+		}
+		return "listpack"
+	case *stream:
+		return "stream"
+	}
+	return ""
+}
+
+// listFits reports whether list fits in a single listpack whose limit is
+// divided by shrink. Each element is counted with a couple of bytes of
+// encoding and backlen.
+func (l encodingLimits) listFits(list []string, shrink int64) bool {
+	if l.listSize > 0 {
+		return int64(len(list)) <= l.listSize/shrink
+	}
+	limit := int64(4096) << (-l.listSize - 1) / shrink
+	size := int64(7)
+	for _, element := range list {
+		if size += int64(len(element)) + 2; size > limit {
+			return false
+		}
+	}
+	return true
+}
+
+func allIntegers(set map[string]struct{}) bool {
+	for member := range set {
+		if n, err := strconv.ParseInt(member, 10, 64); err != nil || strconv.FormatInt(n, 10) != member {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateEncodings records the encodings keys reached after a write. Sets,
+// hashes and sorted sets keep the largest encoding they reached, while a
+// quicklist turns back into a listpack once it shrinks to half the limit.
+// The sets still encoded as intsets are stored as such.
+func (s *Store) UpdateEncodings(keys []string, limits encodingLimits) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for _, key := range keys {
+		value, ok := s.lookup(key)
+		if !ok {
+			delete(s.Encodings, key)
+			continue
+		}
+		encoding := limits.encoding(value)
+		recorded, ok := s.Encodings[key]
+		switch v := value.(type) {
+		case string:
+			// Only setRaw records the encoding of strings, and the
+			// commands setting them anew drop it.
+		case *stream:
+			delete(s.Encodings, key)
+		case []string:
+			if encoding == "quicklist" || recorded == "quicklist" && !limits.listFits(v, 2) {
+				s.Encodings[key] = "quicklist"
+			} else {
+				s.Encodings[key] = "listpack"
+			}
+		case map[string]struct{}:
+			if !ok || encodingRanks[encoding] > encodingRanks[recorded] {
+				s.Encodings[key], recorded = encoding, encoding
+			}
+			if recorded == "intset" {
+				s.Data[key] = newIntset(v)
+			}
+		default:
+			if !ok || encodingRanks[encoding] > encodingRanks[recorded] {
+				s.Encodings[key] = encoding
+			}
+		}
+	}
+}
+
+// Encoding returns the encoding OBJECT ENCODING reports for key.
+func (s *Store) Encoding(key string, limits encodingLimits) (string, bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	value, ok := s.lookup(key)
+	if !ok {
+		return "", false
+	}
+	return s.encoding(key, value, limits), true
+}
+
+// encoding returns the encoding of value, stored at key: the one recorded by
+// the last write, so that changing the limits only affects the keys written
+// since, or else the one it would have if it were built from scratch. The
+// caller must hold the write lock.
+func (s *Store) encoding(key string, value any, limits encodingLimits) string {
+	if recorded, ok := s.Encodings[key]; ok {
+		return recorded
+	}
+	return limits.encoding(value)
+}
+
+// setRaw stores str at key as a string changed in place, such as by APPEND
+// or SETBIT, which redis reports as raw even if it holds a small integer.
+// The caller must hold the write lock.
+func (s *Store) setRaw(key, str string) {
+	s.Data[key] = str
+	s.Encodings[key] = "raw"
+}
+
+// listNodes returns the number of listpacks a quicklist holding list is made
+// of, each filled up to the limit before the next one starts.
+func (l encodingLimits) listNodes(list []string) int {
+	nodes, start := 0, 0
+	for start < len(list) {
+		end := start + 1
+		for end < len(list) && l.listFits(list[start:end+1], 1) {
+			end++
+		}
+		nodes, start = nodes+1, end
+	}
+	return nodes
+}
+
+// objectCommand implements OBJECT ENCODING key and OBJECT FREQ key.
+func (s *server) objectCommand(c *clientConn, commands []string) {
+	switch strings.ToLower(commands[1]) {
+	case "encoding":
+		if len(commands) != 3 {
+			c.replyWrongArgs("object|encoding")
+			return
+		}
+		encoding, ok := s.db(c).Encoding(commands[2], s.config.encodingLimits())
+		if !ok {
+			c.replyNull()
+			return
+		}
+		c.replyBulk(encoding)
+	case "freq":
+		if len(commands) != 3 {
+			c.replyWrongArgs("object|freq")
+			return
+		}
+		cfg := s.config.eviction()
+		if !cfg.lfu() {
+			c.replyErrorMsg("An LFU maxmemory policy is not selected, access frequency not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust.")
+			return
+		}
+		freq, ok := s.db(c).Frequency(commands[2], cfg)
+		if !ok {
+			c.replyNull()
+			return
+		}
+		c.replyInteger(freq)
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try OBJECT HELP.", commands[1]))
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestHashEncodingThreshold(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	for i := 0; i < 128; i++ {
+		c.expect(int64(1), "HSET", "hash", "field:"+strconv.Itoa(i), "value")
+	}
+	c.expect("listpack", "OBJECT", "ENCODING", "hash")
+	c.expect(int64(1), "HSET", "hash", "one-too-many", "value")
+	c.expect("hashtable", "OBJECT", "ENCODING", "hash")
+	// A value past hash-max-listpack-value flips a small hash too.
+	c.expect(int64(1), "HSET", "long", "field", strings.Repeat("x", 65))
+	c.expect("hashtable", "OBJECT", "ENCODING", "long")
+}
+
+func TestEncodingTransitions(t *testing.T) {
+	_, addr := startServer(t,
+		"list-max-listpack-size", "3",
+		"set-max-intset-entries", "3",
+		"set-max-listpack-entries", "3",
+		"zset-max-listpack-entries", "3",
+	)
+	c := dial(t, addr)
+	c.expect(int64(3), "RPUSH", "list", "a", "b", "c")
+	c.expect("listpack", "OBJECT", "ENCODING", "list")
+	c.expect(int64(4), "RPUSH", "list", "d")
+	c.expect("quicklist", "OBJECT", "ENCODING", "list")
+
+	c.expect(int64(3), "SADD", "ints", "1", "2", "3")
+	c.expect("intset", "OBJECT", "ENCODING", "ints")
+	c.expect(int64(1), "SADD", "ints", "4")
+	c.expect("hashtable", "OBJECT", "ENCODING", "ints")
+	c.expect(int64(2), "SADD", "strings", "a", "b")
+	c.expect("listpack", "OBJECT", "ENCODING", "strings")
+	c.expect(int64(2), "SADD", "strings", "c", "d")
+	c.expect("hashtable", "OBJECT", "ENCODING", "strings")
+
+	c.expect(int64(3), "ZADD", "zset", "1", "a", "2", "b", "3", "c")
+	c.expect("listpack", "OBJECT", "ENCODING", "zset")
+	c.expect(int64(1), "ZADD", "zset", "4", "d")
+	c.expect("skiplist", "OBJECT", "ENCODING", "zset")
+	// An encoding does not flip back as the collection shrinks.
+	c.expect(int64(2), "ZREM", "zset", "a", "b")
+	c.expect("skiplist", "OBJECT", "ENCODING", "zset")
+}
+
+func TestIntsetEncoding(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "SADD", "set", "3", "-1", "2")
+	c.expect("intset", "OBJECT", "ENCODING", "set")
+	c.expect([]any{int64(1), int64(0)}, "SMISMEMBER", "set", "-1", "1")
+	c.expect(int64(1), "SADD", "set", "member")
+	c.expect("listpack", "OBJECT", "ENCODING", "set")
+	c.expect(int64(4), "SINTERCARD", "1", "set")
+	// A member that only looks like an integer is not stored as one.
+	c.expect(int64(1), "SADD", "padded", "01")
+	c.expect("listpack", "OBJECT", "ENCODING", "padded")
+}
+
+func TestEncodingLimitsAtRuntime(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "HSET", "before", "a", "1", "b", "2", "c", "3")
+	c.expect(respStatus("OK"), "CONFIG", "SET", "hash-max-listpack-entries", "2")
+	c.expect([]any{"hash-max-listpack-entries", "2"}, "CONFIG", "GET", "hash-max-listpack-entries")
+	c.expect(int64(2), "HSET", "hash", "a", "1", "b", "2")
+	c.expect("listpack", "OBJECT", "ENCODING", "hash")
+	c.expect(int64(1), "HSET", "hash", "c", "3")
+	c.expect("hashtable", "OBJECT", "ENCODING", "hash")
+	// The keys not written since keep their encoding.
+	c.expect("listpack", "OBJECT", "ENCODING", "before")
+
+	c.expect(respStatus("OK"), "CONFIG", "SET", "zset-max-listpack-entries", "1")
+	c.expect(int64(2), "ZADD", "zset", "1", "a", "2", "b")
+	c.expect("skiplist", "OBJECT", "ENCODING", "zset")
+	c.expect(respStatus("OK"), "CONFIG", "SET", "set-max-listpack-entries", "1")
+	c.expect(int64(2), "SADD", "set", "a", "b")
+	c.expect("hashtable", "OBJECT", "ENCODING", "set")
+	c.expect(respStatus("OK"), "CONFIG", "SET", "list-max-listpack-size", "1")
+	c.expect(int64(2), "RPUSH", "list", "a", "b")
+	c.expect("quicklist", "OBJECT", "ENCODING", "list")
+}
+
+func TestStringEncodings(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "n", "123")
+	c.expect("int", "OBJECT", "ENCODING", "n")
+	c.expect(int64(4), "APPEND", "n", "4")
+	c.expect("raw", "OBJECT", "ENCODING", "n")
+	// INCR stores an integer again.
+	c.expect(int64(1235), "INCR", "n")
+	c.expect("int", "OBJECT", "ENCODING", "n")
+	c.expect(int64(4), "SETRANGE", "n", "0", "9")
+	c.expect("raw", "OBJECT", "ENCODING", "n")
+	c.expect("9235", "GET", "n")
+
+	c.expect(respStatus("OK"), "SET", "padded", "0123")
+	c.expect("embstr", "OBJECT", "ENCODING", "padded")
+	c.expect(respStatus("OK"), "SET", "long", strings.Repeat("x", 45))
+	c.expect("raw", "OBJECT", "ENCODING", "long")
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+var errOOM = errors.New("OOM command not allowed when used memory > 'maxmemory'.")
+
+// lfuInitial is the access counter of new keys, so that they are not evicted
+// before they had a chance to be accessed again.
+const lfuInitial = 5
+
+// evictionConfig is the part of the config eviction follows.
+type evictionConfig struct {
+	maxMemory    int64
+	policy       string
+	samples      int64
+	lfuLogFactor int64
+	lfuDecayTime int64
+}
+
+func (e evictionConfig) lfu() bool {
+	return e.policy == "allkeys-lfu" || e.policy == "volatile-lfu"
+}
+
+// keyAccess is what eviction knows about the accesses to a key: a
+// logarithmic access counter, which grows more slowly the larger it gets and
+// is decremented for every lfu-decay-time minutes without an access.
+type keyAccess struct {
+	counter uint8
+	last    time.Time
+}
+
+// frequency returns the counter decayed for the time since the last access.
+func (a *keyAccess) frequency(now time.Time, decayTime int64) uint8 {
+	if decayTime == 0 {
+		return a.counter
+	}
+	periods := int64(now.Sub(a.last)/time.Minute) / decayTime
+	if periods >= int64(a.counter) {
+		return 0
+	}
+	return a.counter - uint8(periods)
+}
+
+// Touch records an access to each of keys that exists.
+func (s *Store) Touch(keys []string, cfg evictionConfig) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	now := s.Clock.Now()
+	for _, key := range keys {
+		if _, ok := s.lookup(key); !ok {
+			continue
+		}
+		a := s.Access[key]
+		if a == nil {
+			a = &keyAccess{counter: lfuInitial, last: now}
+			s.Access[key] = a
+		}
+		a.counter = a.frequency(now, cfg.lfuDecayTime)
+		if a.counter < 255 {
+			base := max(float64(a.counter)-lfuInitial, 0)
+			if s.Rand.Float64() < 1/(base*float64(cfg.lfuLogFactor)+1) {
+				a.counter++
+			}
+		}
+		a.last = now
+	}
+}
+
+// Frequency returns the access counter OBJECT FREQ reports for key.
+func (s *Store) Frequency(key string, cfg evictionConfig) (int, bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if _, ok := s.lookup(key); !ok {
+		return 0, false
+	}
+	return int(s.frequency(key, cfg)), true
+}
+
+// frequency returns the decayed access counter of key. The caller must hold
+// the write lock.
+func (s *Store) frequency(key string, cfg evictionConfig) uint8 {
+	a := s.Access[key]
+	if a == nil {
+		return lfuInitial
+	}
+	return a.frequency(s.Clock.Now(), cfg.lfuDecayTime)
+}
+
+// valueSize estimates the memory key and its value take, from the size of a
+// few of the elements of collections like MEMORY USAGE does.
+func valueSize(key string, value any) int64 {
+	const (
+		entryOverhead   = 56
+		elementOverhead = 16
+		samples         = 5
+	)
+	size := int64(entryOverhead + len(key))
+	n, sampled, sampledSize := 0, 0, 0
+	sample := func(length int) bool {
+		sampled++
+		sampledSize += length + elementOverhead
+		return sampled < samples
+	}
+	switch v := value.(type) {
+	case string:
+		return size + int64(len(v))
+	case []string:
+		n = len(v)
+		for _, element := range v {
+			if !sample(len(element)) {
+				break
+			}
+		}
+	case map[string]struct{}:
+		n = len(v)
+		for member := range v {
+			if !sample(len(member)) {
+				break
+			}
+		}
+	case intset:
+		return size + int64(8*len(v))
+	case map[string]string:
+		n = len(v)
+		for field, val := range v {
+			if !sample(len(field) + len(val)) {
+				break
+			}
+		}
+	case *sortedSet:
+		n = len(v.scores)
+		for member := range v.scores {
+			if !sample(len(member) + 8) {
+				break
+			}
+		}
+	case *stream:
+		n = len(v.entries)
+		for _, entry := range v.entries {
+			length := 16
+			for _, field := range entry.fields {
+				length += len(field)
+			}
+			if !sample(length) {
+				break
+			}
+		}
+	}
+	if sampled > 0 {
+		size += int64(n) * int64(sampledSize) / int64(sampled)
+	}
+	return size
+}
+
+// UpdateSizes updates the estimated memory used after a write to keys.
+func (s *Store) UpdateSizes(keys []string) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for _, key := range keys {
+		s.Used -= s.Sizes[key]
+		if value, ok := s.lookup(key); ok {
+			s.Sizes[key] = valueSize(key, value)
+			s.Used += s.Sizes[key]
+		} else {
+			delete(s.Sizes, key)
+		}
+	}
+}
+
+// resetSizes estimates the memory used by every key afresh, after the
+// keyspace was replaced. The caller must hold the write lock.
+func (s *Store) resetSizes() {
+	s.Sizes, s.Used = make(map[string]int64), 0
+	s.Access = make(map[string]*keyAccess)
+	for key, value := range s.Data {
+		s.Sizes[key] = valueSize(key, value)
+		s.Used += s.Sizes[key]
+	}
+}
+
+// MemoryStats returns the estimated memory used and the number of keys
+// evicted so far.
+func (s *Store) MemoryStats() (used, evicted int64) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return s.Used, s.Evicted
+}
+
+// evictionPolicies are the values of maxmemory-policy. The volatile policies
+// only evict keys with an expiry.
+var evictionPolicies = []string{
+	"noeviction",
+	"allkeys-lru", "allkeys-lfu", "allkeys-random",
+	"volatile-lru", "volatile-lfu", "volatile-random", "volatile-ttl",
+}
+
+// evictsBefore reports whether key a should be evicted before key b under
+// the policy: the least recently used one for the LRU policies, the least
+// frequently used one for the LFU policies and the one expiring first for
+// volatile-ttl. The caller must hold the write lock.
+func (s *Store) evictsBefore(a, b string, cfg evictionConfig) bool {
+	switch strings.TrimPrefix(strings.TrimPrefix(cfg.policy, "allkeys-"), "volatile-") {
+	case "lru":
+		return s.lastAccess(a).Before(s.lastAccess(b))
+	case "lfu":
+		return s.frequency(a, cfg) < s.frequency(b, cfg)
+	case "ttl":
+		return s.Expiries[a].Before(s.Expiries[b])
+	}
+	return false
+}
+
+// lastAccess returns when key was last accessed, the zero time if it was not
+// since it was loaded. The caller must hold the write lock.
+func (s *Store) lastAccess(key string) time.Time {
+	if a := s.Access[key]; a != nil {
+		return a.last
+	}
+	return time.Time{}
+}
+
+// Evict deletes keys chosen by the eviction policy until the memory used is
+// back under the limit, and returns them. It reports false when it could not
+// free enough memory, because the policy is noeviction or no key is left to
+// evict, which for the volatile policies means no key has an expiry. Each key
+// is the best to evict according to the policy among samples keys picked at
+// random.
+func (s *Store) Evict(cfg evictionConfig) ([]string, bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if cfg.policy == "noeviction" {
+		return nil, s.Used <= cfg.maxMemory
+	}
+	volatile := strings.HasPrefix(cfg.policy, "volatile-")
+	var evicted []string
+	for s.Used > cfg.maxMemory {
+		var candidates []string
+		if volatile {
+			for key := range s.Expiries {
+				if candidates = append(candidates, key); int64(len(candidates)) == cfg.samples {
+					break
+				}
+			}
+		} else {
+			for key := range s.Data {
+				if candidates = append(candidates, key); int64(len(candidates)) == cfg.samples {
+					break
+				}
+			}
+		}
+		if len(candidates) == 0 {
+			return evicted, false
+		}
+		best := candidates[0]
+		for _, key := range candidates[1:] {
+			if s.evictsBefore(key, best, cfg) {
+				best = key
+			}
+		}
+		s.remove(best)
+		s.Evicted++
+		evicted = append(evicted, best)
+	}
+	return evicted, true
+}
+
+// freeMemory evicts keys while the memory used is over maxmemory, feeds their
+// deletion to the replicas and the append only file and notifies their
+// eviction. The databases are
+// evicted from in turn, each down to what the others leave of maxmemory.
+// Replicas leave eviction to their master. It fails with errOOM when not
+// enough memory could be freed.
+func (s *server) freeMemory() error {
+	cfg := s.config.eviction()
+	if cfg.maxMemory == 0 || s.isReplica() {
+		return nil
+	}
+	used, _ := s.memoryStats()
+	for index, db := range s.dbs {
+		if used <= cfg.maxMemory {
+			break
+		}
+		before, _ := db.MemoryStats()
+		limit := cfg
+		limit.maxMemory = max(cfg.maxMemory-(used-before), 0)
+		evicted, _ := db.Evict(limit)
+		for _, key := range evicted {
+			s.feed(index, []string{"DEL", key})
+			s.notify(index, notifyEvicted, "evicted", key)
+		}
+		after, _ := db.MemoryStats()
+		used -= before - after
+	}
+	if used > cfg.maxMemory {
+		return errOOM
+	}
+	return nil
+}
+
+// memoryStats adds up the MemoryStats of the databases.
+func (s *server) memoryStats() (used, evicted int64) {
+	for _, db := range s.dbs {
+		dbUsed, dbEvicted := db.MemoryStats()
+		used, evicted = used+dbUsed, evicted+dbEvicted
+	}
+	return used, evicted
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLFUEviction(t *testing.T) {
+	s, addr := startServer(t, "maxmemory-policy", "allkeys-lfu")
+	c := dial(t, addr)
+	value := strings.Repeat("x", 1000)
+	c.expect(respStatus("OK"), "SET", "hot", value)
+	c.expect(respStatus("OK"), "SET", "cold", value)
+	for i := 0; i < 200; i++ {
+		c.expect(value, "GET", "hot")
+	}
+	hot, _ := c.do("OBJECT", "FREQ", "hot").(int64)
+	cold, _ := c.do("OBJECT", "FREQ", "cold").(int64)
+	if hot <= cold {
+		t.Fatalf("OBJECT FREQ: hot %d, cold %d", hot, cold)
+	}
+
+	used, _ := s.memoryStats()
+	c.expect(respStatus("OK"), "CONFIG", "SET", "maxmemory", strconv.FormatInt(used-500, 10))
+	c.expect(int64(1), "EXISTS", "hot")
+	c.expect(int64(0), "EXISTS", "cold")
+	if evicted := infoField(c.do("INFO", "stats"), "evicted_keys"); evicted != "1" {
+		t.Fatalf("evicted_keys: got %q, want 1", evicted)
+	}
+}
+
+func TestObjectFreqRequiresLFU(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	if _, ok := c.do("OBJECT", "FREQ", "key").(respError); !ok {
+		t.Fatal("OBJECT FREQ did not fail without an LFU policy")
+	}
+}
+
+func TestVolatileTTLEviction(t *testing.T) {
+	s, addr := startServer(t, "maxmemory-policy", "volatile-ttl")
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "persistent", "value")
+	c.expect(respStatus("OK"), "SET", "later", "value", "EX", "1000")
+	c.expect(respStatus("OK"), "SET", "soonest", "value", "EX", "10")
+	c.expect(respStatus("OK"), "SET", "soon", "value", "EX", "100")
+
+	used, _ := s.memoryStats()
+	c.expect(respStatus("OK"), "CONFIG", "SET", "maxmemory", strconv.FormatInt(used-1, 10))
+	c.expect(int64(3), "EXISTS", "persistent", "later", "soon")
+	c.expect(int64(0), "EXISTS", "soonest")
+
+	// Without volatile keys left, nothing can be evicted.
+	c.expect(int64(1), "PERSIST", "later")
+	c.expect(int64(1), "PERSIST", "soon")
+	c.expect(respStatus("OK"), "CONFIG", "SET", "maxmemory", "1")
+	c.expect(respError("OOM command not allowed when used memory > 'maxmemory'."), "SET", "more", "value")
+	c.expect(int64(3), "DBSIZE")
+}
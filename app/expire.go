@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// expiryMillis turns the argument n of an expiry, in seconds unless ms is
+// set and from now unless absolute is set, into the unix time in
+// milliseconds the key expires at. It reports false if that overflows.
+func expiryMillis(n int64, ms, absolute bool, now time.Time) (int64, bool) {
+	if !ms {
+		if n > math.MaxInt64/1000 || n < math.MinInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if !absolute {
+		base := now.UnixMilli()
+		if n > math.MaxInt64-base {
+			return 0, false
+		}
+		n += base
+	}
+	return n, true
+}
+
+// expireCondition is the NX, XX, GT or LT option of the EXPIRE commands.
+type expireCondition struct {
+	nx, xx, gt, lt bool
+}
+
+// allows reports whether the condition lets a key expiring at current, the
+// zero time for none, get expiry instead. A key without an expiry counts as
+// expiring after any time.
+func (e expireCondition) allows(expiry, current time.Time) bool {
+	volatile := !current.IsZero()
+	switch {
+	case e.nx:
+		return !volatile
+	case e.xx && !volatile:
+		return false
+	case e.gt:
+		return volatile && expiry.After(current)
+	case e.lt:
+		return !volatile || expiry.Before(current)
+	}
+	return true
+}
+
+// Expire sets key to expire at expiry as far as cond allows, deleting it if
+// expiry is not in the future. It reports whether it changed the key and
+// whether it deleted it.
+func (s *Store) Expire(key string, expiry time.Time, cond expireCondition) (changed, deleted bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if _, ok := s.lookup(key); !ok {
+		return false, false
+	}
+	if !cond.allows(expiry, s.Expiries[key]) {
+		return false, false
+	}
+	if !expiry.After(s.Clock.Now()) {
+		s.remove(key)
+		return true, true
+	}
+	s.Expiries[key] = expiry
+	return true, false
+}
+
+// Persist drops the expiry of key, and reports whether it had one.
+func (s *Store) Persist(key string) bool {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if _, ok := s.lookup(key); !ok {
+		return false
+	}
+	if _, ok := s.Expiries[key]; !ok {
+		return false
+	}
+	delete(s.Expiries, key)
+	return true
+}
+
+// Expiry returns the time key expires at, the zero time if it has no expiry,
+// and false if it does not exist.
+func (s *Store) Expiry(key string) (time.Time, bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	_, ok := s.lookup(key)
+	s.countLookup(ok)
+	return s.Expiries[key], ok
+}
+
+// expireCommand implements EXPIRE, PEXPIRE, EXPIREAT and PEXPIREAT key
+// time [NX|XX|GT|LT]. The new expiry is propagated as PEXPIREAT, so that
+// replicas and the append only file expire the key when the master does,
+// and a key that expired right away as DEL.
+func (s *server) expireCommand(c *clientConn, commands []string) {
+	command := strings.ToLower(commands[0])
+	n, err := strconv.ParseInt(commands[2], 10, 64)
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	var cond expireCondition
+	for _, option := range commands[3:] {
+		switch strings.ToLower(option) {
+		case "nx":
+			cond.nx = true
+		case "xx":
+			cond.xx = true
+		case "gt":
+			cond.gt = true
+		case "lt":
+			cond.lt = true
+		default:
+			c.replyErrorMsg(fmt.Sprintf("Unsupported option %s", option))
+			return
+		}
+	}
+	if cond.nx && (cond.xx || cond.gt || cond.lt) {
+		c.replyErrorMsg("NX and XX, GT or LT options at the same time are not compatible")
+		return
+	}
+	if cond.gt && cond.lt {
+		c.replyErrorMsg("GT and LT options at the same time are not compatible")
+		return
+	}
+	at, ok := expiryMillis(n, strings.HasPrefix(command, "p"), strings.HasSuffix(command, "at"), s.clock.Now())
+	if !ok {
+		c.replyErrorMsg(fmt.Sprintf("invalid expire time in '%s' command", command))
+		return
+	}
+	changed, deleted := s.db(c).Expire(commands[1], time.UnixMilli(at), cond)
+	if !changed {
+		c.replyInteger(0)
+		return
+	}
+	if deleted {
+		s.propagate(c.db, []string{"DEL", commands[1]})
+	} else {
+		s.propagate(c.db, []string{"PEXPIREAT", commands[1], strconv.FormatInt(at, 10)})
+	}
+	c.replyInteger(1)
+}
+
+// persistCommand implements PERSIST key.
+func (s *server) persistCommand(c *clientConn, commands []string) {
+	if !s.db(c).Persist(commands[1]) {
+		c.replyInteger(0)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(1)
+}
+
+// ttlCommand implements TTL, PTTL, EXPIRETIME and PEXPIRETIME key. They
+// reply -2 for a missing key and -1 for a key without an expiry.
+func (s *server) ttlCommand(c *clientConn, commands []string) {
+	command := strings.ToLower(commands[0])
+	expiry, ok := s.db(c).Expiry(commands[1])
+	switch {
+	case !ok:
+		c.replyInteger(-2)
+	case expiry.IsZero():
+		c.replyInteger(-1)
+	case command == "ttl":
+		ttl := max(expiry.Sub(s.clock.Now()).Milliseconds(), 0)
+		c.replyInteger(int((ttl + 500) / 1000))
+	case command == "pttl":
+		c.replyInteger(int(max(expiry.Sub(s.clock.Now()).Milliseconds(), 0)))
+	case command == "expiretime":
+		c.replyInteger(int(expiry.Unix()))
+	default:
+		c.replyInteger(int(expiry.UnixMilli()))
+	}
+}
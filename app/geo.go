@@ -0,0 +1,377 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Geo members are stored in a sorted set with their 52 bit geohash as the
+// score, using the same limits as redis so that scores are interchangeable.
+const (
+	geoStep        = 26
+	geoLatMin      = -85.05112878
+	geoLatMax      = 85.05112878
+	geoLonMin      = -180.0
+	geoLonMax      = 180.0
+	earthRadiusMts = 6372797.560856
+)
+
+var errGeoUnit = errors.New("ERR unsupported unit provided. please use M, KM, FT, MI")
+
+// geoEncode interleaves the latitude and longitude offsets into a geohash,
+// latitude bits in the even positions and longitude bits in the odd ones.
+func geoEncode(lon, lat float64) uint64 {
+	latOffset := (lat - geoLatMin) / (geoLatMax - geoLatMin)
+	lonOffset := (lon - geoLonMin) / (geoLonMax - geoLonMin)
+	return interleave64(uint32(latOffset*(1<<geoStep)), uint32(lonOffset*(1<<geoStep)))
+}
+
+// geoDecode returns the center of the geohash cell.
+func geoDecode(hash uint64) (lon, lat float64) {
+	latBits, lonBits := deinterleave64(hash)
+	latScale := geoLatMax - geoLatMin
+	lonScale := geoLonMax - geoLonMin
+	latMin := geoLatMin + float64(latBits)/(1<<geoStep)*latScale
+	latMax := geoLatMin + float64(latBits+1)/(1<<geoStep)*latScale
+	lonMin := geoLonMin + float64(lonBits)/(1<<geoStep)*lonScale
+	lonMax := geoLonMin + float64(lonBits+1)/(1<<geoStep)*lonScale
+	lon = math.Max(geoLonMin, math.Min(geoLonMax, (lonMin+lonMax)/2))
+	lat = math.Max(geoLatMin, math.Min(geoLatMax, (latMin+latMax)/2))
+	return lon, lat
+}
+
+func interleave64(x, y uint32) uint64 {
+	var hash uint64
+	for i := 0; i < 32; i++ {
+		hash |= uint64(x>>i&1) << (2 * i)
+		hash |= uint64(y>>i&1) << (2*i + 1)
+	}
+	return hash
+}
+
+func deinterleave64(hash uint64) (x, y uint32) {
+	for i := 0; i < 32; i++ {
+		x |= uint32(hash>>(2*i)&1) << i
+		y |= uint32(hash>>(2*i+1)&1) << i
+	}
+	return x, y
+}
+
+// geoDistance is the haversine distance in meters between two points.
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1r, lon1r := lat1*math.Pi/180, lon1*math.Pi/180
+	lat2r, lon2r := lat2*math.Pi/180, lon2*math.Pi/180
+	u := math.Sin((lat2r - lat1r) / 2)
+	v := math.Sin((lon2r - lon1r) / 2)
+	return 2 * earthRadiusMts * math.Asin(math.Sqrt(u*u+math.Cos(lat1r)*math.Cos(lat2r)*v*v))
+}
+
+// geoUnit returns how many meters one unit is.
+func geoUnit(unit string) (float64, error) {
+	switch strings.ToLower(unit) {
+	case "m":
+		return 1, nil
+	case "km":
+		return 1000, nil
+	case "ft":
+		return 0.3048, nil
+	case "mi":
+		return 1609.34, nil
+	}
+	return 0, errGeoUnit
+}
+
+func parseLonLat(lonArg, latArg string) (float64, float64, error) {
+	lon, err1 := strconv.ParseFloat(lonArg, 64)
+	lat, err2 := strconv.ParseFloat(latArg, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, errNotFloat
+	}
+	if lon < geoLonMin || lon > geoLonMax || lat < geoLatMin || lat > geoLatMax {
+		return 0, 0, fmt.Errorf("ERR invalid longitude,latitude pair %f,%f", lon, lat)
+	}
+	return lon, lat, nil
+}
+
+func formatCoordinate(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func formatDistance(f float64) string {
+	return strconv.FormatFloat(f, 'f', 4, 64)
+}
+
+// geoPositions returns the decoded position of each member, ok reporting
+// whether the member exists.
+func (s *Store) geoPositions(key string, members []string) (lons, lats []float64, ok []bool, err error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, false)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	lons = make([]float64, len(members))
+	lats = make([]float64, len(members))
+	ok = make([]bool, len(members))
+	if z == nil {
+		return lons, lats, ok, nil
+	}
+	for i, member := range members {
+		score, exists := z.scores[member]
+		if !exists {
+			continue
+		}
+		lons[i], lats[i] = geoDecode(uint64(score))
+		ok[i] = true
+	}
+	return lons, lats, ok, nil
+}
+
+// geoaddCommand implements GEOADD key longitude latitude member [...].
+func (s *server) geoaddCommand(c *clientConn, commands []string) {
+	if len(commands) < 5 || (len(commands)-2)%3 != 0 {
+		c.replyWrongArgs("geoadd")
+		return
+	}
+	var scores []float64
+	var members []string
+	for i := 2; i < len(commands); i += 3 {
+		lon, lat, err := parseLonLat(commands[i], commands[i+1])
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		scores = append(scores, float64(geoEncode(lon, lat)))
+		members = append(members, commands[i+2])
+	}
+	added, changed, err := s.db(c).ZAdd(commands[1], scores, members, zaddOptions{})
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if added+changed > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(added)
+}
+
+// geoposCommand implements GEOPOS key [member ...].
+func (s *server) geoposCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("geopos")
+		return
+	}
+	lons, lats, ok, err := s.db(c).geoPositions(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyWith(func(w *respWriter) {
+		w.WriteArray(len(ok))
+		for i := range ok {
+			if !ok[i] {
+				w.WriteNullArray()
+				continue
+			}
+			w.WriteArray(2)
+			w.WriteBulkString(formatCoordinate(lons[i]))
+			w.WriteBulkString(formatCoordinate(lats[i]))
+		}
+	})
+}
+
+// geodistCommand implements GEODIST key member1 member2 [M|KM|FT|MI].
+func (s *server) geodistCommand(c *clientConn, commands []string) {
+	if len(commands) != 4 && len(commands) != 5 {
+		c.replyWrongArgs("geodist")
+		return
+	}
+	unit := 1.0
+	if len(commands) == 5 {
+		var err error
+		if unit, err = geoUnit(commands[4]); err != nil {
+			c.replyError(err)
+			return
+		}
+	}
+	lons, lats, ok, err := s.db(c).geoPositions(commands[1], commands[2:4])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !ok[0] || !ok[1] {
+		c.replyNull()
+		return
+	}
+	dist := geoDistance(lons[0], lats[0], lons[1], lats[1]) / unit
+	c.replyBulk(formatDistance(dist))
+}
+
+type geoResult struct {
+	member   string
+	dist     float64
+	lon, lat float64
+}
+
+// geosearchCommand implements
+// GEOSEARCH key FROMMEMBER member|FROMLONLAT lon lat BYRADIUS radius unit
+// [ASC|DESC] [COUNT n [ANY]] [WITHCOORD] [WITHDIST].
+func (s *server) geosearchCommand(c *clientConn, commands []string) {
+	if len(commands) < 6 {
+		c.replyWrongArgs("geosearch")
+		return
+	}
+	var fromMember string
+	var fromLon, fromLat, radius, unit float64
+	hasFrom, hasRadius := false, false
+	order, count, any := 0, 0, false
+	withCoord, withDist := false, false
+	for i := 2; i < len(commands); i++ {
+		left := len(commands) - i - 1
+		var err error
+		switch strings.ToLower(commands[i]) {
+		case "frommember":
+			if left < 1 || hasFrom {
+				c.replyError(errSyntax)
+				return
+			}
+			fromMember, hasFrom = commands[i+1], true
+			i++
+		case "fromlonlat":
+			if left < 2 || hasFrom {
+				c.replyError(errSyntax)
+				return
+			}
+			if fromLon, fromLat, err = parseLonLat(commands[i+1], commands[i+2]); err != nil {
+				c.replyError(err)
+				return
+			}
+			hasFrom = true
+			i += 2
+		case "byradius":
+			if left < 2 || hasRadius {
+				c.replyError(errSyntax)
+				return
+			}
+			if radius, err = strconv.ParseFloat(commands[i+1], 64); err != nil || radius < 0 {
+				c.replyErrorMsg("need numeric radius")
+				return
+			}
+			if unit, err = geoUnit(commands[i+2]); err != nil {
+				c.replyError(err)
+				return
+			}
+			hasRadius = true
+			i += 2
+		case "asc":
+			order = 1
+		case "desc":
+			order = -1
+		case "count":
+			if left < 1 {
+				c.replyError(errSyntax)
+				return
+			}
+			if count, err = strconv.Atoi(commands[i+1]); err != nil || count <= 0 {
+				c.replyErrorMsg("COUNT must be > 0")
+				return
+			}
+			i++
+		case "any":
+			any = true
+		case "withcoord":
+			withCoord = true
+		case "withdist":
+			withDist = true
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	if !hasFrom {
+		c.replyErrorMsg("exactly one of FROMMEMBER or FROMLONLAT can be specified for GEOSEARCH")
+		return
+	}
+	if !hasRadius {
+		c.replyErrorMsg("exactly one of BYRADIUS and BYBOX can be specified for GEOSEARCH")
+		return
+	}
+	if any && count == 0 {
+		c.replyErrorMsg("the ANY argument requires COUNT argument")
+		return
+	}
+
+	db := s.db(c)
+	db.Mutex.Lock()
+	z, err := db.zset(commands[1], false)
+	var results []geoResult
+	if err == nil && z != nil {
+		if fromMember != "" {
+			score, ok := z.scores[fromMember]
+			if !ok {
+				db.Mutex.Unlock()
+				c.replyErrorMsg("could not decode requested zset member")
+				return
+			}
+			fromLon, fromLat = geoDecode(uint64(score))
+		}
+		for _, m := range z.sorted() {
+			lon, lat := geoDecode(uint64(m.score))
+			dist := geoDistance(fromLon, fromLat, lon, lat)
+			if dist <= radius*unit {
+				results = append(results, geoResult{m.member, dist / unit, lon, lat})
+			}
+		}
+	}
+	db.Mutex.Unlock()
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+
+	if count > 0 && order == 0 && !any {
+		order = 1
+	}
+	if order != 0 {
+		sort.SliceStable(results, func(i, j int) bool {
+			if order > 0 {
+				return results[i].dist < results[j].dist
+			}
+			return results[i].dist > results[j].dist
+		})
+	}
+	if count > 0 && len(results) > count {
+		results = results[:count]
+	}
+
+	c.replyWith(func(w *respWriter) {
+		w.WriteArray(len(results))
+		for _, r := range results {
+			if !withCoord && !withDist {
+				w.WriteBulkString(r.member)
+				continue
+			}
+			fields := 1
+			if withDist {
+				fields++
+			}
+			if withCoord {
+				fields++
+			}
+			w.WriteArray(fields)
+			w.WriteBulkString(r.member)
+			if withDist {
+				w.WriteBulkString(formatDistance(r.dist))
+			}
+			if withCoord {
+				w.WriteArray(2)
+				w.WriteBulkString(formatCoordinate(r.lon))
+				w.WriteBulkString(formatCoordinate(r.lat))
+			}
+		}
+	})
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestGeoDist(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(2), "GEOADD", "sicily", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania")
+	for unit, want := range map[string]float64{"m": 166274.1516, "km": 166.2742} {
+		reply, _ := c.do("GEODIST", "sicily", "Palermo", "Catania", unit).(string)
+		got, err := strconv.ParseFloat(reply, 64)
+		if err != nil || math.Abs(got-want) > want*1e-4 {
+			t.Errorf("GEODIST in %s: got %q, want %v", unit, reply, want)
+		}
+	}
+	c.expect(nil, "GEODIST", "sicily", "Palermo", "Rome")
+}
+
+func TestGeoPos(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(1), "GEOADD", "sicily", "13.361389", "38.115556", "Palermo")
+	reply, _ := c.do("GEOPOS", "sicily", "Palermo", "Rome").([]any)
+	if len(reply) != 2 || reply[1] != nil {
+		t.Fatalf("GEOPOS: got %#v", reply)
+	}
+	pos, _ := reply[0].([]any)
+	if len(pos) != 2 {
+		t.Fatalf("GEOPOS Palermo: got %#v", reply[0])
+	}
+	for i, want := range []float64{13.361389, 38.115556} {
+		got, _ := strconv.ParseFloat(pos[i].(string), 64)
+		if math.Abs(got-want) > 1e-5 {
+			t.Errorf("GEOPOS coordinate %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestGeoSearch(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "GEOADD", "sicily", "13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania", "12.4964", "41.9028", "Rome")
+	for radius, want := range map[string][]string{
+		"100": {"Palermo"},
+		"200": {"Catania", "Palermo"},
+	} {
+		reply, _ := c.do("GEOSEARCH", "sicily", "FROMMEMBER", "Palermo", "BYRADIUS", radius, "km").([]any)
+		var got []string
+		for _, member := range reply {
+			got = append(got, member.(string))
+		}
+		sort.Strings(got)
+		if !slices.Equal(got, want) {
+			t.Errorf("GEOSEARCH BYRADIUS %s km: got %v, want %v", radius, got, want)
+		}
+	}
+}
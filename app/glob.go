@@ -0,0 +1,103 @@
+package main
+
+// globMatch reports whether str matches the glob-style pattern, with the same
+// rules as redis: * matches any sequence, ? any single byte, [abc], [a-z] and
+// [^abc] match classes, and a backslash makes the next byte literal.
+func globMatch(pattern, str string) bool {
+	matched, _ := matchGlob(pattern, str)
+	return matched
+}
+
+// matchGlob is globMatch, and also reports when pattern failed to match after
+// one of its * was tried against every suffix of str. A * before it would
+// only try it against shorter suffixes, so it can fail at once instead of
+// trying every way to split str between stars, which takes exponential time.
+func matchGlob(pattern, str string) (matched, exhausted bool) {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 1 && pattern[1] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 1 {
+				return true, false
+			}
+			for i := 0; i <= len(str); i++ {
+				matched, exhausted := matchGlob(pattern[1:], str[i:])
+				if matched || exhausted {
+					return matched, exhausted
+				}
+			}
+			return false, true
+		case '?':
+			if len(str) == 0 {
+				return false, false
+			}
+			str = str[1:]
+		case '[':
+			if len(str) == 0 {
+				return false, false
+			}
+			matched, pattern = matchClass(pattern[1:], str[0])
+			if !matched {
+				return false, false
+			}
+			str = str[1:]
+			// matchClass leaves pattern on the closing bracket, which
+			// the common step below skips.
+		case '\\':
+			if len(pattern) >= 2 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(str) == 0 || pattern[0] != str[0] {
+				return false, false
+			}
+			str = str[1:]
+		}
+		pattern = pattern[1:]
+	}
+	return len(str) == 0, false
+}
+
+// matchClass matches c against the character class at the start of pattern,
+// just after its opening bracket. It returns whether c matched and the pattern
+// from the closing bracket on; an unterminated class runs to the end of the
+// pattern, whose last byte is then treated as the closing bracket.
+func matchClass(pattern string, c byte) (bool, string) {
+	negate := len(pattern) > 0 && pattern[0] == '^'
+	if negate {
+		pattern = pattern[1:]
+	}
+	matched := false
+	for {
+		switch {
+		case len(pattern) == 0:
+			// Keep a byte for the caller to skip, as if the class had
+			// been closed.
+			return matched != negate, "]"
+		case pattern[0] == '\\' && len(pattern) >= 2:
+			pattern = pattern[1:]
+			if pattern[0] == c {
+				matched = true
+			}
+		case pattern[0] == ']':
+			return matched != negate, pattern
+		case len(pattern) >= 3 && pattern[1] == '-':
+			start, end := pattern[0], pattern[2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			pattern = pattern[2:]
+		default:
+			if pattern[0] == c {
+				matched = true
+			}
+		}
+		pattern = pattern[1:]
+	}
+}
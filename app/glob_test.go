@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	for _, test := range []struct {
+		pattern, str string
+		want         bool
+	}{
+		{"hello", "hello", true},
+		{"hello", "hell", false},
+		{"h?llo", "hallo", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h[a-z]llo", "hello", true},
+		{"h[a-z]llo", "hEllo", false},
+		{"h[z-a]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"[^a]bc", "bbc", true},
+		{"[^a]bc", "abc", false},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{`h\?llo`, "hello", false},
+		{`[\]]`, "]", true},
+		{`[a\-z]`, "b", false},
+		{`[a\-z]`, "-", true},
+		{"*", "", true},
+		{"**a**", "xxaxx", true},
+		{"*a*b", "xaxxb", true},
+		{"*a*b", "xaxxbx", false},
+		{"a*", "", false},
+		// An unterminated class is closed by the end of the pattern.
+		{"[ab", "a", true},
+		{`trailing\`, `trailing\`, true},
+	} {
+		if got := globMatch(test.pattern, test.str); got != test.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", test.pattern, test.str, got, test.want)
+		}
+	}
+}
+
+func TestGlobMatchBacktracking(t *testing.T) {
+	pattern := strings.Repeat("a*", 30) + "b"
+	str := strings.Repeat("a", 60)
+	start := time.Now()
+	if globMatch(pattern, str) {
+		t.Fatal("the pattern matched without a b")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("failing to match took %v", elapsed)
+	}
+}
+
+func TestDebugStringmatchLen(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(1), "DEBUG", "STRINGMATCH-LEN", "h[a-z]llo", "hello")
+	c.expect(int64(0), "DEBUG", "STRINGMATCH-LEN", `h\*llo`, "hello")
+	c.expect(respError("ERR wrong number of arguments for 'debug' command"), "DEBUG", "STRINGMATCH-LEN", "*")
+}
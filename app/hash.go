@@ -0,0 +1,218 @@
+package main
+
+import (
+	"strings"
+)
+
+// hash returns the hash stored at key, creating an empty one if create is set
+// and the key does not exist. The caller must hold the write lock.
+func (s *Store) hash(key string, create bool) (map[string]string, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		h := make(map[string]string)
+		s.Data[key] = h
+		delete(s.Encodings, key)
+		return h, nil
+	}
+	h, ok := val.(map[string]string)
+	if !ok {
+		return nil, errWrongType
+	}
+	return h, nil
+}
+
+// HSet sets the given field/value pairs and returns how many fields are new.
+func (s *Store) HSet(key string, pairs []string) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h, err := s.hash(key, true)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if _, exists := h[pairs[i]]; !exists {
+			added++
+		}
+		h[pairs[i]] = pairs[i+1]
+	}
+	return added, nil
+}
+
+// HSetNX sets field to value unless the hash at key has it already, and
+// reports whether it did.
+func (s *Store) HSetNX(key, field, value string) (bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h, err := s.hash(key, true)
+	if err != nil {
+		return false, err
+	}
+	if _, exists := h[field]; exists {
+		return false, nil
+	}
+	h[field] = value
+	return true, nil
+}
+
+// HDel removes fields from the hash at key and returns how many were there.
+// A hash left empty is deleted.
+func (s *Store) HDel(key string, fields []string) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h, err := s.hash(key, false)
+	if err != nil || h == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, field := range fields {
+		if _, exists := h[field]; exists {
+			delete(h, field)
+			removed++
+		}
+	}
+	if len(h) == 0 {
+		s.remove(key)
+	}
+	return removed, nil
+}
+
+func (s *Store) HGet(key, field string) (string, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h, err := s.hash(key, false)
+	if err != nil {
+		return "", false, err
+	}
+	s.countLookup(h != nil)
+	if h == nil {
+		return "", false, nil
+	}
+	val, ok := h[field]
+	return val, ok, nil
+}
+
+// HRandField picks random fields of the hash at key as described by
+// randomKeys, returning them together with their values.
+func (s *Store) HRandField(key string, count int) ([]string, []string, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h, err := s.hash(key, false)
+	if err != nil || len(h) == 0 || count == 0 {
+		return nil, nil, err
+	}
+	fields := make([]string, 0, len(h))
+	for field := range h {
+		fields = append(fields, field)
+	}
+	fields = randomKeys(s.Rand, fields, count)
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		values[i] = h[field]
+	}
+	return fields, values, nil
+}
+
+func (s *server) hsetCommand(c *clientConn, commands []string) {
+	if len(commands) < 4 || len(commands)%2 != 0 {
+		c.replyWrongArgs("hset")
+		return
+	}
+	added, err := s.db(c).HSet(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(added)
+}
+
+func (s *server) hsetnxCommand(c *clientConn, commands []string) {
+	set, err := s.db(c).HSetNX(commands[1], commands[2], commands[3])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !set {
+		c.replyInteger(0)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(1)
+}
+
+func (s *server) hdelCommand(c *clientConn, commands []string) {
+	removed, err := s.db(c).HDel(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if removed > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(removed)
+}
+
+func (s *server) hgetCommand(c *clientConn, commands []string) {
+	if len(commands) != 3 {
+		c.replyWrongArgs("hget")
+		return
+	}
+	val, ok, err := s.db(c).HGet(commands[1], commands[2])
+	if err != nil {
+		c.replyError(err)
+	} else if !ok {
+		c.replyNull()
+	} else {
+		c.replyBulk(val)
+	}
+}
+
+// hrandfieldCommand implements HRANDFIELD key [count [WITHVALUES]].
+func (s *server) hrandfieldCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 || len(commands) > 4 {
+		c.replyWrongArgs("hrandfield")
+		return
+	}
+	if len(commands) == 2 {
+		fields, _, err := s.db(c).HRandField(commands[1], 1)
+		if err != nil {
+			c.replyError(err)
+		} else if len(fields) == 0 {
+			c.replyNull()
+		} else {
+			c.replyBulk(fields[0])
+		}
+		return
+	}
+	count, err := randomCount(commands[2])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	withValues := false
+	if len(commands) == 4 {
+		if !strings.EqualFold(commands[3], "withvalues") {
+			c.replyError(errSyntax)
+			return
+		}
+		withValues = true
+	}
+	fields, values, err := s.db(c).HRandField(commands[1], count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !withValues {
+		c.replyStrings(fields)
+		return
+	}
+	pairs := make([]string, 0, 2*len(fields))
+	for i := range fields {
+		pairs = append(pairs, fields[i], values[i])
+	}
+	c.replyStrings(pairs)
+}
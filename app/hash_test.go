@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// seedRand makes the random commands of database 0 of s deterministic.
+func seedRand(s *server, seed int64) {
+	s.dbs[0].Mutex.Lock()
+	s.dbs[0].Rand = rand.New(rand.NewSource(seed))
+	s.dbs[0].Mutex.Unlock()
+}
+
+func TestHRandField(t *testing.T) {
+	s, addr := startServer(t)
+	seedRand(s, 1)
+	c := dial(t, addr)
+	c.expect(int64(2), "HSET", "hash", "a", "1", "b", "2")
+	values := map[string]string{"a": "1", "b": "2"}
+
+	pairs, _ := c.do("HRANDFIELD", "hash", "2", "WITHVALUES").([]any)
+	if len(pairs) != 4 {
+		t.Fatalf("HRANDFIELD 2 WITHVALUES: got %#v, want two field and value pairs", pairs)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < len(pairs); i += 2 {
+		field, value := pairs[i].(string), pairs[i+1].(string)
+		if values[field] != value || seen[field] {
+			t.Fatalf("HRANDFIELD 2 WITHVALUES: got %#v", pairs)
+		}
+		seen[field] = true
+	}
+
+	// A count past the size returns each field once, a negative one exactly
+	// that many fields, which then repeat.
+	if fields, _ := c.do("HRANDFIELD", "hash", "10").([]any); len(fields) != 2 {
+		t.Fatalf("HRANDFIELD 10: got %#v, want both fields", fields)
+	}
+	repeated, _ := c.do("HRANDFIELD", "hash", "-10").([]any)
+	if len(repeated) != 10 {
+		t.Fatalf("HRANDFIELD -10: got %d fields, want 10", len(repeated))
+	}
+	for _, field := range repeated {
+		if _, ok := values[field.(string)]; !ok {
+			t.Fatalf("HRANDFIELD -10: got unknown field %q", field)
+		}
+	}
+
+	c.expect(nil, "HRANDFIELD", "missing")
+	c.expect([]any{}, "HRANDFIELD", "missing", "3")
+	c.expect(respError("ERR value is out of range"), "HRANDFIELD", "hash", "-9223372036854775808")
+}
+
+func TestHRandFieldSeeded(t *testing.T) {
+	var picks []any
+	for i := 0; i < 2; i++ {
+		s, addr := startServer(t)
+		seedRand(s, 7)
+		c := dial(t, addr)
+		c.expect(int64(5), "HSET", "hash", "a", "1", "b", "2", "c", "3", "d", "4", "e", "5")
+		picks = append(picks, c.do("HRANDFIELD", "hash", "-20"))
+	}
+	if !reflect.DeepEqual(picks[0], picks[1]) {
+		t.Fatalf("the same seed picked %v and %v", picks[0], picks[1])
+	}
+}
+
+func TestHSetNX(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	c.expect(int64(1), "HSETNX", "hash", "field", "first")
+	r.expectNext("HSETNX", "hash", "field", "first")
+	c.expect(int64(0), "HSETNX", "hash", "field", "second")
+	c.expect("first", "HGET", "hash", "field")
+	// The HSETNX that set nothing is not propagated.
+	c.expect(int64(1), "HSET", "hash", "other", "value")
+	r.expectNext("HSET", "hash", "other", "value")
+
+	c.expect(respStatus("OK"), "SET", "string", "value")
+	c.expect(respError("WRONGTYPE Operation against a key holding the wrong kind of value"), "HSETNX", "string", "field", "value")
+}
@@ -0,0 +1,207 @@
+package main
+
+import "strings"
+
+// commandHelp holds the replies to "<command> HELP" for the commands that take
+// subcommands.
+var commandHelp = map[string][]string{
+	"acl": {
+		"ACL <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"GETUSER <username>",
+		"    Get the user's details.",
+		"LIST",
+		"    Show users details in config file format.",
+		"SETUSER <username> <attribute> [<attribute> ...]",
+		"    Create or modify a user with the specified attributes.",
+		"WHOAMI",
+		"    Return the current connection username.",
+		"HELP",
+		"    Print this help.",
+	},
+	"client": {
+		"CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"GETNAME",
+		"    Return the name of the current connection.",
+		"ID",
+		"    Return the ID of the current connection.",
+		"INFO",
+		"    Return information about the current client connection.",
+		"KILL <ip:port>",
+		"    Kill connection made from <ip:port>.",
+		"KILL <option> <value> [<option> <value> [...]]",
+		"    Kill connections. Options are:",
+		"    * ADDR <ip:port>",
+		"      Kill connection made from <ip:port>.",
+		"    * ID <client-id>",
+		"      Kill connections by client id.",
+		"    * SKIPME (YES|NO)",
+		"      Skip killing current connection (default: yes).",
+		"LIST",
+		"    Return information about client connections.",
+		"NO-EVICT (ON|OFF)",
+		"    Protect current client connection from eviction.",
+		"PAUSE <timeout> [WRITE|ALL]",
+		"    Suspend all, or just write, clients for <timeout> milliseconds.",
+		"REPLY (ON|OFF|SKIP)",
+		"    Control the replies sent to the current connection.",
+		"SETNAME <name>",
+		"    Assign the name <name> to the current connection.",
+		"TRACKING (ON|OFF) [BCAST] [PREFIX <prefix> [...]]",
+		"    Control server assisted client side caching.",
+		"UNPAUSE",
+		"    Stop the current client pause, resuming traffic.",
+		"HELP",
+		"    Print this help.",
+	},
+	"command": {
+		"COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"(no subcommand)",
+		"    Return details about all Redis commands.",
+		"COUNT",
+		"    Return the total number of commands in this Redis server.",
+		"GETKEYS <full-command>",
+		"    Return the keys from a full Redis command.",
+		"INFO [<command-name> ...]",
+		"    Return details about multiple Redis commands.",
+		"    If no command names are given, documentation details for all",
+		"    commands are returned.",
+		"HELP",
+		"    Print this help.",
+	},
+	"cluster": {
+		"CLUSTER <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"INFO",
+		"    Return information about the cluster.",
+		"MYID",
+		"    Return the node id.",
+		"NODES",
+		"    Return cluster configuration seen by node. Output format:",
+		"    <id> <ip:port@bus-port> <flags> <master> <pings> <pongs> <epoch> <link> <slot> ...",
+		"SHARDS",
+		"    Return information about slot range mappings and the nodes associated with them.",
+		"SLOTS",
+		"    Return information about slots range mappings. Each range is made of:",
+		"    start, end, master and replicas IP addresses, ports and ids",
+		"HELP",
+		"    Print this help.",
+	},
+	"config": {
+		"CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"GET <pattern>",
+		"    Return parameters matching the glob-like <pattern> and their values.",
+		"SET <directive> <value>",
+		"    Set the configuration <directive> to <value>.",
+		"RESETSTAT",
+		"    Reset statistics reported by the INFO command.",
+		"REWRITE",
+		"    Rewrite the configuration file.",
+		"HELP",
+		"    Print this help.",
+	},
+	"debug": {
+		"DEBUG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"CHANGE-REPL-ID",
+		"    Change the replication IDs of the instance.",
+		"OBJECT <key>",
+		"    Show low level info about the <key> and associated value.",
+		"POPULATE <count> [<prefix>] [<size>]",
+		"    Create <count> string keys named key:<num>. If <prefix> is specified then",
+		"    it is used instead of the 'key' prefix. These are not propagated to",
+		"    replicas.",
+		"PROTOCOL <type>",
+		"    Reply with a test value of the specified type. <type> can be: verbatim,",
+		"    bignum.",
+		"RELOAD",
+		"    Save the RDB on disk and reload it back to memory.",
+		"SLEEP <seconds>",
+		"    Stop the server for <seconds>. Decimals allowed.",
+		"STRINGMATCH-LEN <pattern> <string>",
+		"    Reply with 1 if <string> matches the glob-style <pattern>, 0 otherwise.",
+		"SET-ACTIVE-EXPIRE <0|1>",
+		"    Setting it to 0 disables expiring keys in background when they are not",
+		"    accessed (otherwise the Redis behavior). Setting it to 1 reenables back the",
+		"    default.",
+		"HELP",
+		"    Print this help.",
+	},
+	"latency": {
+		"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"HISTORY <event>",
+		"    Return time-latency samples for the <event> class.",
+		"LATEST",
+		"    Return the latest latency samples for all events.",
+		"RESET [<event> ...]",
+		"    Reset latency data of one or more <event> classes.",
+		"    (default: reset all data for all event classes)",
+		"HELP",
+		"    Print this help.",
+	},
+	"object": {
+		"OBJECT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"ENCODING <key>",
+		"    Return the kind of internal representation used in order to store the value",
+		"    associated with a <key>.",
+		"FREQ <key>",
+		"    Return the access frequency index of the <key>. The returned integer is",
+		"    proportional to the logarithm of the recent access frequency of the key.",
+		"HELP",
+		"    Print this help.",
+	},
+	"pubsub": {
+		"PUBSUB <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"CHANNELS [<pattern>]",
+		"    Return the currently active channels matching a <pattern> (default: '*').",
+		"NUMPAT",
+		"    Return number of subscriptions to patterns.",
+		"NUMSUB [<channel> ...]",
+		"    Return the number of subscribers for the specified channels, excluding",
+		"    pattern subscriptions(default: no channels).",
+		"SHARDCHANNELS [<pattern>]",
+		"    Return the currently active shard level channels matching a <pattern> (default: '*').",
+		"SHARDNUMSUB [<shardchannel> ...]",
+		"    Return the number of subscribers for the specified shard level channel(s)",
+		"HELP",
+		"    Print this help.",
+	},
+	"script": {
+		"SCRIPT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"EXISTS <sha1> [<sha1> ...]",
+		"    Return information about the existence of the scripts in the script cache.",
+		"FLUSH [ASYNC|SYNC]",
+		"    Flush the Lua scripts cache.",
+		"KILL",
+		"    Kill the currently executing Lua script.",
+		"LOAD <script>",
+		"    Load a script into the scripts cache without executing it.",
+		"HELP",
+		"    Print this help.",
+	},
+	"xgroup": {
+		"XGROUP <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"CREATE <key> <groupname> <id|$> [MKSTREAM]",
+		"    Create a new consumer group. Options are:",
+		"    * MKSTREAM",
+		"      Create the empty stream if it does not exist.",
+		"DESTROY <key> <groupname>",
+		"    Remove the specified group.",
+		"HELP",
+		"    Print this help.",
+	},
+	"xinfo": {
+		"XINFO <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+		"STREAM <key>",
+		"    Show information about the stream.",
+		"HELP",
+		"    Print this help.",
+	},
+}
+
+// isHelpRequest reports whether commands is "<command> HELP" for a command
+// that has help text.
+func isHelpRequest(commands []string) bool {
+	if len(commands) != 2 || !strings.EqualFold(commands[1], "help") {
+		return false
+	}
+	_, ok := commandHelp[commands[0]]
+	return ok
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConfigHelp(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	lines, _ := c.do("CONFIG", "HELP").([]any)
+	if len(lines) == 0 {
+		t.Fatal("CONFIG HELP did not reply a non-empty array")
+	}
+	if first, _ := lines[0].(string); !strings.HasPrefix(first, "CONFIG <subcommand>") {
+		t.Fatalf("CONFIG HELP starts with %q", lines[0])
+	}
+}
+
+func TestSubcommandHelp(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	for command, help := range commandHelp {
+		reply, _ := c.do(strings.ToUpper(command), "help").([]any)
+		if len(reply) != len(help) {
+			t.Errorf("%s HELP: got %d lines, want %d", command, len(reply), len(help))
+		}
+	}
+	if _, ok := c.do("OBJECT", "NOSUCH").(respError); !ok {
+		t.Fatal("an unknown OBJECT subcommand did not fail")
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// HyperLogLogs are stored as strings in the redis dense representation: a 16
+// byte header ("HYLL", the encoding, three unused bytes and a cached
+// cardinality) followed by 2^14 registers of 6 bits each.
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllBits      = 6
+	hllMaxValue  = 1<<hllBits - 1
+	hllHeaderLen = 16
+	hllDenseLen  = hllHeaderLen + (hllRegisters*hllBits+7)/8
+	hllDense     = 0
+	hllAlphaInf  = 0.721347520444481703680 // 0.5/ln(2)
+)
+
+var errNotHLL = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+
+type hyperLogLog []byte
+
+func newHyperLogLog() hyperLogLog {
+	h := make(hyperLogLog, hllDenseLen)
+	copy(h, "HYLL")
+	h[4] = hllDense
+	return h
+}
+
+// parseHyperLogLog validates that str is a dense HyperLogLog and returns a
+// copy of it that can be modified.
+func parseHyperLogLog(str string) (hyperLogLog, error) {
+	if len(str) != hllDenseLen || str[:4] != "HYLL" || str[4] != hllDense {
+		return nil, errNotHLL
+	}
+	return hyperLogLog(str), nil
+}
+
+func (h hyperLogLog) register(i int) uint8 {
+	regs := h[hllHeaderLen:]
+	pos := i * hllBits / 8
+	shift := uint(i*hllBits) & 7
+	v := regs[pos] >> shift
+	if pos+1 < len(regs) {
+		v |= regs[pos+1] << (8 - shift)
+	}
+	return v & hllMaxValue
+}
+
+func (h hyperLogLog) setRegister(i int, v uint8) {
+	regs := h[hllHeaderLen:]
+	pos := i * hllBits / 8
+	shift := uint(i*hllBits) & 7
+	regs[pos] &^= hllMaxValue << shift
+	regs[pos] |= v << shift
+	if pos+1 < len(regs) {
+		regs[pos+1] &^= hllMaxValue >> (8 - shift)
+		regs[pos+1] |= v >> (8 - shift)
+	}
+}
+
+func (h hyperLogLog) invalidateCache() {
+	h[15] |= 1 << 7
+}
+
+// add adds element and reports whether a register changed.
+func (h hyperLogLog) add(element string) bool {
+	hash := murmurHash64A([]byte(element), 0xadc83b19)
+	index := int(hash & (hllRegisters - 1))
+	// The run of zeros is counted on the remaining bits, with a sentinel bit
+	// so that it is at most hllQ+1.
+	hash >>= hllP
+	hash |= 1 << hllQ
+	count := uint8(1)
+	for bit := uint64(1); hash&bit == 0; bit <<= 1 {
+		count++
+	}
+	if count <= h.register(index) {
+		return false
+	}
+	h.setRegister(index, count)
+	h.invalidateCache()
+	return true
+}
+
+// merge sets every register to the maximum of itself and the one in other.
+func (h hyperLogLog) merge(other hyperLogLog) {
+	for i := 0; i < hllRegisters; i++ {
+		if v := other.register(i); v > h.register(i) {
+			h.setRegister(i, v)
+		}
+	}
+	h.invalidateCache()
+}
+
+// count estimates the cardinality with the improved estimator by Otmar Ertl
+// that redis uses, using and refreshing the cached value in the header.
+func (h hyperLogLog) count() uint64 {
+	if h[15]&(1<<7) == 0 {
+		return binary.LittleEndian.Uint64(h[8:16])
+	}
+	var histogram [hllQ + 2]int
+	for i := 0; i < hllRegisters; i++ {
+		histogram[h.register(i)]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histogram[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histogram[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histogram[0])/m)
+	estimate := uint64(math.Round(hllAlphaInf * m * m / z))
+	binary.LittleEndian.PutUint64(h[8:16], estimate)
+	return estimate
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= math.Pow(1-x, 2) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// murmurHash64A is the 64 bit MurmurHash2 variant used by redis to hash
+// HyperLogLog elements.
+func murmurHash64A(data []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+	h := seed ^ uint64(len(data))*m
+	for len(data) >= 8 {
+		k := binary.LittleEndian.Uint64(data)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		data = data[8:]
+	}
+	if len(data) > 0 {
+		for i := len(data) - 1; i >= 0; i-- {
+			h ^= uint64(data[i]) << (8 * i)
+		}
+		h *= m
+	}
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
+
+// hll returns a copy of the HyperLogLog stored at key, or nil if the key does
+// not exist. The caller must hold the write lock.
+func (s *Store) hll(key string) (hyperLogLog, error) {
+	str, ok, err := s.str(key)
+	if err != nil || !ok {
+		return nil, err
+	}
+	h, err := parseHyperLogLog(str)
+	if err != nil {
+		return nil, err
+	}
+	return append(hyperLogLog(nil), h...), nil
+}
+
+// PFAdd adds elements to the HyperLogLog at key, creating it if needed. It
+// reports whether the HyperLogLog changed.
+func (s *Store) PFAdd(key string, elements []string) (bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	h, err := s.hll(key)
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	if h == nil {
+		h = newHyperLogLog()
+		changed = true
+	}
+	for _, element := range elements {
+		if h.add(element) {
+			changed = true
+		}
+	}
+	if changed {
+		s.setRaw(key, string(h))
+	}
+	return changed, nil
+}
+
+// PFCount returns the estimated cardinality of the union of the HyperLogLogs
+// at keys. With a single key the estimate is cached in the value.
+func (s *Store) PFCount(keys []string) (uint64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if len(keys) == 1 {
+		h, err := s.hll(keys[0])
+		if err != nil || h == nil {
+			return 0, err
+		}
+		count := h.count()
+		s.setRaw(keys[0], string(h))
+		return count, nil
+	}
+	union := newHyperLogLog()
+	for _, key := range keys {
+		h, err := s.hll(key)
+		if err != nil {
+			return 0, err
+		}
+		if h != nil {
+			union.merge(h)
+		}
+	}
+	return union.count(), nil
+}
+
+// PFMerge stores the union of the HyperLogLogs at dest and sources in dest.
+func (s *Store) PFMerge(dest string, sources []string) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	union, err := s.hll(dest)
+	if err != nil {
+		return err
+	}
+	if union == nil {
+		union = newHyperLogLog()
+	}
+	for _, key := range sources {
+		h, err := s.hll(key)
+		if err != nil {
+			return err
+		}
+		if h != nil {
+			union.merge(h)
+		}
+	}
+	union.invalidateCache()
+	s.setRaw(dest, string(union))
+	return nil
+}
+
+func (s *server) pfaddCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("pfadd")
+		return
+	}
+	changed, err := s.db(c).PFAdd(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !changed {
+		c.replyInteger(0)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(1)
+}
+
+func (s *server) pfcountCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("pfcount")
+		return
+	}
+	count, err := s.db(c).PFCount(commands[1:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(int(count))
+}
+
+func (s *server) pfmergeCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("pfmerge")
+		return
+	}
+	if err := s.db(c).PFMerge(commands[1], commands[2:]); err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyOK()
+}
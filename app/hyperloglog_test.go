@@ -0,0 +1,38 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestPFCount(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	const n = 10000
+	args := []string{"PFADD", "hll"}
+	for i := 0; i < n; i++ {
+		args = append(args, "element:"+strconv.Itoa(i))
+	}
+	c.expect(int64(1), args...)
+	c.expect(int64(0), "PFADD", "hll", "element:0")
+	count, _ := c.do("PFCOUNT", "hll").(int64)
+	if math.Abs(float64(count)-n) > 0.02*n {
+		t.Fatalf("PFCOUNT: got %d, want %d within 2%%", count, n)
+	}
+}
+
+func TestPFMerge(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(1), "PFADD", "first", "a", "b", "c")
+	c.expect(int64(1), "PFADD", "second", "c", "d")
+	c.expect(int64(4), "PFCOUNT", "first", "second")
+	c.expect(respStatus("OK"), "PFMERGE", "merged", "first", "second")
+	c.expect(int64(4), "PFCOUNT", "merged")
+	c.expect(int64(3), "PFCOUNT", "first")
+	c.expect(respStatus("OK"), "SET", "string", "value")
+	if _, ok := c.do("PFCOUNT", "string").(respError); !ok {
+		t.Fatal("PFCOUNT of a string that is not a HyperLogLog did not fail")
+	}
+}
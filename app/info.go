@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// infoSection is a section of INFO, made of field:value lines.
+type infoSection struct {
+	name string
+	info func(s *server) string
+}
+
+// infoSections lists the sections in the order INFO prints them.
+var infoSections = []infoSection{
+	{"server", (*server).serverInfo},
+	{"clients", (*server).clientsInfo},
+	{"memory", (*server).memoryInfo},
+	{"persistence", (*server).persistenceInfo},
+	{"stats", (*server).statsInfo},
+	{"replication", (*server).replicationInfo},
+	{"errorstats", (*server).errorstatsInfo},
+	{"keyspace", (*server).keyspaceInfo},
+}
+
+// infoCommand implements INFO [section ...]. Without a section, or with
+// all, default or everything, it prints every section.
+func (s *server) infoCommand(c *clientConn, commands []string) {
+	wanted := map[string]bool{}
+	for _, name := range commands[1:] {
+		wanted[strings.ToLower(name)] = true
+	}
+	all := len(wanted) == 0 || wanted["all"] || wanted["default"] || wanted["everything"]
+	var sections []string
+	for _, section := range infoSections {
+		if all || wanted[section.name] {
+			title := strings.ToUpper(section.name[:1]) + section.name[1:]
+			sections = append(sections, "# "+title+"\r\n"+section.info(s))
+		}
+	}
+	c.replyVerbatim("txt", strings.Join(sections, "\r\n"))
+}
+
+// serverInfo is the server section of INFO.
+func (s *server) serverInfo() string {
+	uptime := int(time.Since(s.started).Seconds())
+	var info strings.Builder
+	fmt.Fprintf(&info, "redis_version:%s\r\n", serverVersion)
+	fmt.Fprintf(&info, "os:%s %s\r\n", runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&info, "arch_bits:%d\r\n", strconv.IntSize)
+	fmt.Fprintf(&info, "process_id:%d\r\n", os.Getpid())
+	fmt.Fprintf(&info, "run_id:%s\r\n", s.runID)
+	fmt.Fprintf(&info, "tcp_port:%d\r\n", s.config.port)
+	fmt.Fprintf(&info, "uptime_in_seconds:%d\r\n", uptime)
+	fmt.Fprintf(&info, "uptime_in_days:%d\r\n", uptime/86400)
+	return info.String()
+}
+
+func (s *server) clientsInfo() string {
+	s.clientsMu.Lock()
+	connected := len(s.clients)
+	s.clientsMu.Unlock()
+	return fmt.Sprintf("connected_clients:%d\r\nblocked_clients:%d\r\n", connected, s.blockedClients.Load())
+}
+
+// memoryInfo is the memory section of INFO, with the memory used as the
+// store estimates it.
+func (s *server) memoryInfo() string {
+	used, _ := s.memoryStats()
+	cfg := s.config.eviction()
+	return fmt.Sprintf("used_memory:%d\r\nmaxmemory:%d\r\nmaxmemory_policy:%s\r\n", used, cfg.maxMemory, cfg.policy)
+}
+
+// keyspaceInfo is the keyspace section of INFO, a line for every database
+// that is not empty.
+func (s *server) keyspaceInfo() string {
+	var info strings.Builder
+	for index, db := range s.dbs {
+		if keys, expires, avgTTL := db.KeyCounts(); keys > 0 {
+			fmt.Fprintf(&info, "db%d:keys=%d,expires=%d,avg_ttl=%d\r\n", index, keys, expires, avgTTL)
+		}
+	}
+	return info.String()
+}
+
+func (s *server) statsInfo() string {
+	var hits, misses int64
+	for _, db := range s.dbs {
+		dbHits, dbMisses := db.KeyspaceStats()
+		hits, misses = hits+dbHits, misses+dbMisses
+	}
+	_, evicted := s.memoryStats()
+	var info strings.Builder
+	fmt.Fprintf(&info, "total_connections_received:%d\r\n", s.totalConnections.Load())
+	fmt.Fprintf(&info, "total_commands_processed:%d\r\n", s.totalCommands.Load())
+	fmt.Fprintf(&info, "instantaneous_ops_per_sec:%d\r\n", s.ops.perSecond())
+	fmt.Fprintf(&info, "evicted_keys:%d\r\n", evicted)
+	fmt.Fprintf(&info, "keyspace_hits:%d\r\n", hits)
+	fmt.Fprintf(&info, "keyspace_misses:%d\r\n", misses)
+	fmt.Fprintf(&info, "total_error_replies:%d\r\n", s.errorStats.total())
+	fmt.Fprintf(&info, "sync_full:%d\r\n", s.syncFull.Load())
+	fmt.Fprintf(&info, "sync_partial_ok:%d\r\n", s.syncPartialOK.Load())
+	fmt.Fprintf(&info, "sync_partial_err:%d\r\n", s.syncPartialErr.Load())
+	return info.String()
+}
+
+// maxErrorCodes is the number of error codes errorStats tracks separately,
+// so that scripts raising made up errors cannot grow it without end.
+const maxErrorCodes = 128
+
+// errorStats counts error replies by their code, the first word of their
+// message, such as ERR or WRONGTYPE.
+type errorStats struct {
+	mu      sync.Mutex
+	codes   map[string]int64
+	replies int64
+}
+
+// count records the error reply msg.
+func (e *errorStats) count(msg string) {
+	code, _, _ := strings.Cut(strings.TrimPrefix(msg, "-"), " ")
+	code = strings.TrimRight(code, "\r\n")
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.replies++
+	if _, ok := e.codes[code]; !ok {
+		if len(e.codes) >= maxErrorCodes {
+			return
+		}
+		if e.codes == nil {
+			e.codes = make(map[string]int64)
+		}
+	}
+	e.codes[code]++
+}
+
+func (e *errorStats) total() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.replies
+}
+
+func (e *errorStats) reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.codes, e.replies = nil, 0
+}
+
+// errorstatsInfo is the errorstats section of INFO, a line for every error
+// code replied with since the start or the last CONFIG RESETSTAT.
+func (s *server) errorstatsInfo() string {
+	e := &s.errorStats
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var info strings.Builder
+	for _, code := range sortedKeys(e.codes) {
+		fmt.Fprintf(&info, "errorstat_%s:count=%d\r\n", code, e.codes[code])
+	}
+	return info.String()
+}
+
+// resetStats zeroes the counters of the stats and errorstats sections of
+// INFO, for CONFIG RESETSTAT.
+func (s *server) resetStats() {
+	s.totalConnections.Store(0)
+	s.syncFull.Store(0)
+	s.syncPartialOK.Store(0)
+	s.syncPartialErr.Store(0)
+	s.ops.reset(&s.totalCommands)
+	s.errorStats.reset()
+	for _, db := range s.dbs {
+		db.ResetStats()
+	}
+}
+
+// opsSamples is the number of samples instantaneous_ops_per_sec averages,
+// taken every opsSamplePeriod like redis does.
+const (
+	opsSamples      = 16
+	opsSamplePeriod = 100 * time.Millisecond
+)
+
+// opsMeter keeps the recent command rates, from the number of commands
+// processed between samples. The counter is read under mu, so that a reset
+// does not fall between its reading and the sample.
+type opsMeter struct {
+	mu        sync.Mutex
+	rates     [opsSamples]float64
+	next      int
+	lastCount int64
+	lastTime  time.Time
+}
+
+func (m *opsMeter) sample(counter *atomic.Int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := counter.Load()
+	if !m.lastTime.IsZero() {
+		if elapsed := now.Sub(m.lastTime).Seconds(); elapsed > 0 {
+			m.rates[m.next] = float64(count-m.lastCount) / elapsed
+			m.next = (m.next + 1) % opsSamples
+		}
+	}
+	m.lastCount, m.lastTime = count, now
+}
+
+// reset zeroes counter and forgets the rates measured so far.
+func (m *opsMeter) reset(counter *atomic.Int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	counter.Store(0)
+	m.rates, m.next, m.lastCount = [opsSamples]float64{}, 0, 0
+}
+
+func (m *opsMeter) perSecond() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var sum float64
+	for _, rate := range m.rates {
+		sum += rate
+	}
+	return int(sum / opsSamples)
+}
+
+// measureOps samples the number of commands processed for
+// instantaneous_ops_per_sec.
+func (s *server) measureOps() {
+	for {
+		<-s.clock.After(opsSamplePeriod)
+		s.ops.sample(&s.totalCommands, s.clock.Now())
+	}
+}
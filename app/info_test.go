@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// infoField returns the value of field in the INFO reply info.
+func infoField(info any, field string) string {
+	text, _ := info.(string)
+	for _, line := range strings.Split(text, "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestInfoServer(t *testing.T) {
+	s, addr := startServer(t)
+	c := dial(t, addr)
+	info := c.do("INFO", "server")
+	if runID := infoField(info, "run_id"); !hex40.MatchString(runID) || runID != s.runID {
+		t.Errorf("run_id: got %q, want %q", runID, s.runID)
+	}
+	if _, port, _ := net.SplitHostPort(addr); infoField(info, "tcp_port") != port {
+		t.Errorf("tcp_port: got %q, want %s", infoField(info, "tcp_port"), port)
+	}
+	for _, field := range []string{"redis_version", "os", "arch_bits", "process_id", "uptime_in_seconds"} {
+		if infoField(info, field) == "" {
+			t.Errorf("INFO server lacks %s", field)
+		}
+	}
+}
+
+func TestDebugChangeReplID(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	before := infoField(c.do("INFO", "replication"), "master_replid")
+	c.expect(respStatus("OK"), "DEBUG", "CHANGE-REPL-ID")
+	after := infoField(c.do("INFO", "replication"), "master_replid")
+	if !hex40.MatchString(after) || after == before {
+		t.Fatalf("master_replid went from %q to %q", before, after)
+	}
+}
+
+func TestInfoStats(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	dial(t, addr).expect(respStatus("PONG"), "PING")
+	c.expect(respStatus("OK"), "SET", "present", "value")
+	c.expect("value", "GET", "present")
+	c.expect(nil, "GET", "absent")
+	info := c.do("INFO")
+	for field, want := range map[string]string{
+		"keyspace_hits":              "1",
+		"keyspace_misses":            "1",
+		"connected_clients":          "2",
+		"blocked_clients":            "0",
+		"total_connections_received": "2",
+		"total_commands_processed":   "5",
+	} {
+		if got := infoField(info, field); got != want {
+			t.Errorf("%s: got %q, want %s", field, got, want)
+		}
+	}
+	if infoField(info, "instantaneous_ops_per_sec") == "" {
+		t.Error("INFO lacks instantaneous_ops_per_sec")
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	c.expect("value", "GET", "key")
+	c.expect(nil, "GET", "missing")
+	c.do("NOSUCH")
+	info := c.do("INFO", "everything")
+	for field, want := range map[string]string{"keyspace_hits": "1", "keyspace_misses": "1", "total_error_replies": "1"} {
+		if got := infoField(info, field); got != want {
+			t.Errorf("%s before CONFIG RESETSTAT: got %q, want %s", field, got, want)
+		}
+	}
+
+	if infoField(info, "errorstat_ERR") != "count=1" {
+		t.Errorf("errorstat_ERR before CONFIG RESETSTAT: got %q", infoField(info, "errorstat_ERR"))
+	}
+
+	c.expect(respStatus("OK"), "CONFIG", "RESETSTAT")
+	info = c.do("INFO", "everything")
+	// The INFO command counts itself.
+	for field, want := range map[string]string{
+		"total_commands_processed":   "1",
+		"total_connections_received": "0",
+		"keyspace_hits":              "0",
+		"keyspace_misses":            "0",
+		"total_error_replies":        "0",
+		"errorstat_ERR":              "",
+	} {
+		if got := infoField(info, field); got != want {
+			t.Errorf("%s after CONFIG RESETSTAT: got %q, want %q", field, got, want)
+		}
+	}
+}
+
+func TestErrorstats(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(1), "RPUSH", "list", "a")
+	for i := 0; i < 2; i++ {
+		c.expect(respError("WRONGTYPE Operation against a key holding the wrong kind of value"), "GET", "list")
+	}
+	c.expect(respError("ERR value is not an integer or out of range"), "INCRBY", "list", "x")
+	c.expect(respError("ERR wrong number of arguments for 'get' command"), "GET")
+	info := c.do("INFO", "errorstats")
+	if got := infoField(info, "errorstat_WRONGTYPE"); got != "count=2" {
+		t.Errorf("errorstat_WRONGTYPE: got %q, want count=2", got)
+	}
+	if got := infoField(info, "errorstat_ERR"); got != "count=2" {
+		t.Errorf("errorstat_ERR: got %q, want count=2", got)
+	}
+	if got := infoField(c.do("INFO", "stats"), "total_error_replies"); got != "4" {
+		t.Errorf("total_error_replies: got %q, want 4", got)
+	}
+}
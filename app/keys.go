@@ -0,0 +1,453 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBusyKey = errors.New("BUSYKEY Target key name already exists.")
+
+// Del deletes keys and returns how many existed.
+func (s *Store) Del(keys []string) int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	deleted := 0
+	for _, key := range keys {
+		if _, ok := s.lookup(key); ok {
+			s.remove(key)
+			deleted++
+		}
+	}
+	return deleted
+}
+
+// Exists returns how many of keys exist, counting a key given twice twice.
+func (s *Store) Exists(keys []string) int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	found := 0
+	for _, key := range keys {
+		_, ok := s.lookup(key)
+		s.countLookup(ok)
+		if ok {
+			found++
+		}
+	}
+	return found
+}
+
+// Dump returns the DUMP serialization of the value at key along with its
+// remaining time to live, which is 0 for keys without an expiry.
+func (s *Store) Dump(key string) ([]byte, time.Duration, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	value, ok := s.lookup(key)
+	if !ok {
+		return nil, 0, false, nil
+	}
+	var ttl time.Duration
+	if expiry, ok := s.Expiries[key]; ok {
+		ttl = max(expiry.Sub(s.Clock.Now()), time.Millisecond)
+	}
+	payload, err := dumpValue(value)
+	return payload, ttl, err == nil, err
+}
+
+// Restore stores value at key, expiring at expiry unless it is zero. Unless
+// replace is set it fails with errBusyKey if the key already exists.
+func (s *Store) Restore(key string, value any, expiry time.Time, replace bool) error {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if _, ok := s.lookup(key); ok && !replace {
+		return errBusyKey
+	}
+	s.Data[key] = value
+	delete(s.Encodings, key)
+	if expiry.IsZero() {
+		delete(s.Expiries, key)
+	} else {
+		s.Expiries[key] = expiry
+	}
+	return nil
+}
+
+func (s *server) delCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("del")
+		return
+	}
+	deleted := s.db(c).Del(commands[1:])
+	if deleted > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(deleted)
+}
+
+// DBSize returns the number of keys, counting those that expired but were
+// not deleted yet.
+func (s *Store) DBSize() int {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return len(s.Data)
+}
+
+// KeyCounts returns the number of keys and of those with an expiry, counting
+// those that expired but were not deleted yet like DBSize, and the average
+// time to live in milliseconds of the keys with an expiry.
+func (s *Store) KeyCounts() (keys, expires int, avgTTL int64) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	now := s.Clock.Now()
+	var total int64
+	for _, expiry := range s.Expiries {
+		total += max(expiry.Sub(now).Milliseconds(), 0)
+	}
+	if len(s.Expiries) > 0 {
+		avgTTL = total / int64(len(s.Expiries))
+	}
+	return len(s.Data), len(s.Expiries), avgTTL
+}
+
+func (s *server) dbsizeCommand(c *clientConn, commands []string) {
+	c.replyInteger(s.db(c).DBSize())
+}
+
+// moveTo moves key with everything recorded about it to dst, unless it is
+// missing or dst has a key of that name, and reports whether it did. The
+// caller must hold the write locks of both databases.
+func (s *Store) moveTo(dst *Store, key string) bool {
+	value, ok := s.lookup(key)
+	if !ok {
+		return false
+	}
+	if _, exists := dst.lookup(key); exists {
+		return false
+	}
+	dst.Data[key] = value
+	if expiry, ok := s.Expiries[key]; ok {
+		dst.Expiries[key] = expiry
+	}
+	if encoding, ok := s.Encodings[key]; ok {
+		dst.Encodings[key] = encoding
+	}
+	if access, ok := s.Access[key]; ok {
+		dst.Access[key] = access
+	}
+	dst.Sizes[key] = s.Sizes[key]
+	dst.Used += s.Sizes[key]
+	dst.signalReady()
+	s.remove(key)
+	return true
+}
+
+// copyTo copies key with its expiry to dest in dst, unless it is missing or
+// dest exists and replace is not set, and reports whether it did. The caller
+// must hold the write locks of both databases, which may be the same.
+func (s *Store) copyTo(dst *Store, key, dest string, replace bool) bool {
+	value, ok := s.lookup(key)
+	if !ok {
+		return false
+	}
+	if _, exists := dst.lookup(dest); exists {
+		if !replace {
+			return false
+		}
+		dst.remove(dest)
+	}
+	dst.Data[dest] = cloneValue(value)
+	if expiry, ok := s.Expiries[key]; ok {
+		dst.Expiries[dest] = expiry
+	}
+	if encoding, ok := s.Encodings[key]; ok {
+		dst.Encodings[dest] = encoding
+	}
+	dst.Sizes[dest] = s.Sizes[key]
+	dst.Used += s.Sizes[key]
+	dst.signalReady()
+	return true
+}
+
+// Keys returns the keys matching the glob pattern, sorted.
+func (s *Store) Keys(pattern string) []string {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	keys := []string{}
+	for _, key := range s.liveKeys() {
+		if globMatch(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// RandomKey returns a key picked at random, and false if there is none.
+func (s *Store) RandomKey() (string, bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	keys := s.liveKeys()
+	if len(keys) == 0 {
+		return "", false
+	}
+	return randomKeys(s.Rand, keys, 1)[0], true
+}
+
+// Flush deletes every key.
+func (s *Store) Flush() {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.Data, s.Expiries = make(map[string]any), make(map[string]time.Time)
+	s.Encodings = make(map[string]string)
+	s.scanKeys, s.scanCollections = nil, nil
+	s.resetSizes()
+}
+
+// selectCommand implements SELECT index, which picks the database the
+// following commands of the client run in.
+func (s *server) selectCommand(c *clientConn, commands []string) {
+	index, err := strconv.Atoi(commands[1])
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	if index < 0 || index >= len(s.dbs) {
+		c.replyErrorMsg("DB index is out of range")
+		return
+	}
+	s.clientsMu.Lock()
+	c.db = index
+	s.clientsMu.Unlock()
+	c.replyOK()
+}
+
+// swapdbCommand implements SWAPDB index1 index2. The clients that selected
+// one of the databases see the keys of the other one from then on.
+func (s *server) swapdbCommand(c *clientConn, commands []string) {
+	first, errFirst := strconv.Atoi(commands[1])
+	second, errSecond := strconv.Atoi(commands[2])
+	switch {
+	case errFirst != nil:
+		c.replyErrorMsg("invalid first DB index")
+		return
+	case errSecond != nil:
+		c.replyErrorMsg("invalid second DB index")
+		return
+	case first < 0 || first >= len(s.dbs) || second < 0 || second >= len(s.dbs):
+		c.replyErrorMsg("DB index is out of range")
+		return
+	}
+	// No command runs while the databases are swapped, and the swap is
+	// propagated in the order it happened among the writes.
+	unlock, err := s.lockExclusive(c)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	defer unlock()
+	s.dbs[first], s.dbs[second] = s.dbs[second], s.dbs[first]
+	// The clients blocked on either database look again at the one they
+	// have selected now.
+	for _, db := range []*Store{s.dbs[first], s.dbs[second]} {
+		db.Mutex.Lock()
+		db.signalReady()
+		db.Mutex.Unlock()
+	}
+	s.propagate(c.db, commands)
+	c.replyOK()
+}
+
+// moveCommand implements MOVE key db, which moves key with its expiry to the
+// database db unless a key of that name is there already.
+func (s *server) moveCommand(c *clientConn, commands []string) {
+	index, err := strconv.Atoi(commands[2])
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	if index < 0 || index >= len(s.dbs) {
+		c.replyErrorMsg("DB index is out of range")
+		return
+	}
+	if index == c.db {
+		c.replyErrorMsg("source and destination objects are the same")
+		return
+	}
+	src, dst := s.db(c), s.dbs[index]
+	// The databases are locked in the order of their indexes, so that
+	// opposite moves cannot deadlock.
+	first, second := src, dst
+	if index < c.db {
+		first, second = dst, src
+	}
+	first.Mutex.Lock()
+	second.Mutex.Lock()
+	moved := src.moveTo(dst, commands[1])
+	second.Mutex.Unlock()
+	first.Mutex.Unlock()
+	if !moved {
+		c.replyInteger(0)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(1)
+}
+
+// copyCommand implements COPY source destination [DB destination-db]
+// [REPLACE], which copies source with its expiry to destination, in the
+// selected database unless DB is given, and replies 1 if it did. It does
+// nothing if destination exists, unless REPLACE is given.
+func (s *server) copyCommand(c *clientConn, commands []string) {
+	index, replace := c.db, false
+	for i := 3; i < len(commands); i++ {
+		switch {
+		case strings.EqualFold(commands[i], "replace"):
+			replace = true
+		case strings.EqualFold(commands[i], "db") && i+1 < len(commands):
+			var err error
+			if index, err = strconv.Atoi(commands[i+1]); err != nil {
+				c.replyError(errNotInteger)
+				return
+			}
+			i++
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	if index < 0 || index >= len(s.dbs) {
+		c.replyErrorMsg("DB index is out of range")
+		return
+	}
+	if index == c.db && commands[1] == commands[2] {
+		c.replyErrorMsg("source and destination objects are the same")
+		return
+	}
+	src, dst := s.db(c), s.dbs[index]
+	first, second := src, dst
+	if index < c.db {
+		first, second = dst, src
+	}
+	first.Mutex.Lock()
+	if second != first {
+		second.Mutex.Lock()
+	}
+	copied := src.copyTo(dst, commands[1], commands[2], replace)
+	if second != first {
+		second.Mutex.Unlock()
+	}
+	first.Mutex.Unlock()
+	if !copied {
+		c.replyInteger(0)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(1)
+}
+
+func (s *server) keysCommand(c *clientConn, commands []string) {
+	c.replyStrings(s.db(c).Keys(commands[1]))
+}
+
+func (s *server) randomkeyCommand(c *clientConn, commands []string) {
+	key, ok := s.db(c).RandomKey()
+	if !ok {
+		c.replyNull()
+		return
+	}
+	c.replyBulk(key)
+}
+
+// flushdbCommand implements FLUSHDB and FLUSHALL, which empty the database
+// of the client and every database. The ASYNC and SYNC options are accepted,
+// and both flush synchronously.
+func (s *server) flushdbCommand(c *clientConn, commands []string) {
+	if len(commands) > 2 || len(commands) == 2 && !strings.EqualFold(commands[1], "async") && !strings.EqualFold(commands[1], "sync") {
+		c.replyError(errSyntax)
+		return
+	}
+	if commands[0] == "flushall" {
+		for _, db := range s.dbs {
+			db.Flush()
+		}
+	} else {
+		s.db(c).Flush()
+	}
+	s.propagate(c.db, commands[:1])
+	c.replyOK()
+}
+
+func (s *server) existsCommand(c *clientConn, commands []string) {
+	c.replyInteger(s.db(c).Exists(commands[1:]))
+}
+
+func (s *server) dumpCommand(c *clientConn, commands []string) {
+	if len(commands) != 2 {
+		c.replyWrongArgs("dump")
+		return
+	}
+	payload, _, ok, err := s.db(c).Dump(commands[1])
+	if err != nil {
+		c.replyError(err)
+	} else if !ok {
+		c.replyNull()
+	} else {
+		c.replyBulk(string(payload))
+	}
+}
+
+// restoreCommand implements RESTORE key ttl serialized-value [REPLACE] [ABSTTL].
+func (s *server) restoreCommand(c *clientConn, commands []string) {
+	if len(commands) < 4 {
+		c.replyWrongArgs("restore")
+		return
+	}
+	ttl, err := strconv.ParseInt(commands[2], 10, 64)
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	if ttl < 0 {
+		c.replyErrorMsg("Invalid TTL value, must be >= 0")
+		return
+	}
+	replace, absTTL := false, false
+	for _, option := range commands[4:] {
+		switch strings.ToLower(option) {
+		case "replace":
+			replace = true
+		case "absttl":
+			absTTL = true
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	value, err := restoreValue([]byte(commands[3]))
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	var expiry time.Time
+	if absTTL && ttl > 0 {
+		expiry = time.UnixMilli(ttl)
+	} else if ttl > 0 {
+		expiry = s.clock.Now().Add(time.Duration(ttl) * time.Millisecond)
+	}
+	if err := s.db(c).Restore(commands[1], value, expiry, replace); err != nil {
+		c.replyError(err)
+		return
+	}
+	// Like SET, a relative time to live is propagated as an absolute one.
+	if ttl > 0 && !absTTL {
+		propagated := []string{"RESTORE", commands[1], strconv.FormatInt(expiry.UnixMilli(), 10), commands[3], "ABSTTL"}
+		if replace {
+			propagated = append(propagated, "REPLACE")
+		}
+		commands = propagated
+	}
+	s.propagate(c.db, commands)
+	c.replyOK()
+}
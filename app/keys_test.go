@@ -0,0 +1,158 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDumpRestore(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "binary\x00value\r\n")
+	payload, ok := c.do("DUMP", "key").(string)
+	if !ok {
+		t.Fatal("DUMP did not reply a bulk string")
+	}
+	c.expect(int64(1), "DEL", "key")
+	c.expect(respStatus("OK"), "RESTORE", "key", "0", payload)
+	c.expect("binary\x00value\r\n", "GET", "key")
+	c.expect(int64(-1), "TTL", "key")
+
+	c.expect(respError("BUSYKEY Target key name already exists."), "RESTORE", "key", "0", payload)
+	c.expect(respStatus("OK"), "RESTORE", "key", "100000", payload, "REPLACE")
+	if ttl, _ := c.do("TTL", "key").(int64); ttl < 99 || ttl > 100 {
+		t.Fatalf("TTL after RESTORE with a ttl: got %d, want 100", ttl)
+	}
+
+	corrupt := []byte(payload)
+	corrupt[len(corrupt)-1] ^= 0xff
+	c.expect(respError("ERR DUMP payload version or checksum are wrong"), "RESTORE", "other", "0", string(corrupt))
+	c.expect(nil, "DUMP", "missing")
+}
+
+func TestDumpRestoreCollections(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(2), "HSET", "hash", "a", "1", "b", "2")
+	c.expect(int64(2), "RPUSH", "list", "x", "y")
+	for _, key := range []string{"hash", "list"} {
+		payload, _ := c.do("DUMP", key).(string)
+		c.expect(respStatus("OK"), "RESTORE", key+":copy", "0", payload)
+	}
+	c.expect("1", "HGET", "hash:copy", "a")
+	c.expect("2", "HGET", "hash:copy", "b")
+	c.expect([]any{"x", "y"}, "LRANGE", "list:copy", "0", "-1")
+}
+
+func TestEmptyCollectionsDeleted(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	for _, test := range []struct {
+		create, empty []string
+	}{
+		{[]string{"RPUSH", "key", "a"}, []string{"LPOP", "key"}},
+		{[]string{"RPUSH", "key", "a", "b"}, []string{"RPOP", "key", "5"}},
+		{[]string{"SADD", "key", "a", "b"}, []string{"SREM", "key", "a", "b"}},
+		{[]string{"HSET", "key", "f", "v"}, []string{"HDEL", "key", "f"}},
+		{[]string{"ZADD", "key", "1", "a"}, []string{"ZREM", "key", "a"}},
+		{[]string{"ZADD", "key", "1", "a"}, []string{"ZPOPMIN", "key"}},
+		{[]string{"RPUSH", "key", "a"}, []string{"LMPOP", "1", "key", "LEFT"}},
+	} {
+		c.do(test.create...)
+		c.do(test.empty...)
+		if c.do("EXISTS", "key") != int64(0) || c.do("TYPE", "key") != respStatus("none") {
+			t.Errorf("%v after %v left the key", test.empty, test.create)
+			c.do("DEL", "key")
+		}
+	}
+}
+
+func TestSelectedDatabase(t *testing.T) {
+	_, addr := startServer(t, "notify-keyspace-events", "KE$g")
+	subscriber := dial(t, addr)
+	subscriber.expect([]any{"psubscribe", "__key*@3__:*", int64(1)}, "PSUBSCRIBE", "__key*@3__:*")
+	c, other := dial(t, addr), dial(t, addr)
+	c.expect(respStatus("OK"), "SELECT", "3")
+	c.expect(respStatus("OK"), "SET", "mine", "value")
+	for _, want := range [][]any{
+		{"pmessage", "__key*@3__:*", "__keyspace@3__:mine", "set"},
+		{"pmessage", "__key*@3__:*", "__keyevent@3__:set", "mine"},
+	} {
+		if got := subscriber.read(); !reflect.DeepEqual(got, want) {
+			t.Fatalf("notification: got %#v, want %#v", got, want)
+		}
+	}
+	c.expect(int64(1), "DBSIZE")
+	c.expect([]any{"mine"}, "KEYS", "*")
+	c.expect("mine", "RANDOMKEY")
+	c.expect([]any{"0", []any{"mine"}}, "SCAN", "0")
+
+	other.expect(int64(0), "DBSIZE")
+	other.expect([]any{}, "KEYS", "*")
+	other.expect(nil, "RANDOMKEY")
+	other.expect([]any{"0", []any{}}, "SCAN", "0")
+	other.expect(respStatus("OK"), "SET", "theirs", "value")
+	// FLUSHDB empties only the selected database.
+	other.expect(respStatus("OK"), "FLUSHDB")
+	c.expect(int64(1), "DBSIZE")
+	c.expect(respStatus("OK"), "FLUSHDB")
+	c.expect(int64(0), "DBSIZE")
+}
+
+func TestDatabasesCount(t *testing.T) {
+	_, addr := startServer(t, "databases", "4")
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SELECT", "3")
+	c.expect(respError("ERR DB index is out of range"), "SELECT", "4")
+	c.expect(respError("ERR DB index is out of range"), "SELECT", "-1")
+	c.expect(respError("ERR DB index is out of range"), "MOVE", "key", "4")
+	c.expect([]any{"databases", "4"}, "CONFIG", "GET", "databases")
+	c.expect(respError("ERR CONFIG SET failed (possibly related to argument 'databases') - can't set immutable config"),
+		"CONFIG", "SET", "databases", "8")
+	if err := newConfig().set("databases", "0", true); err == nil {
+		t.Fatal("databases 0 was accepted")
+	}
+}
+
+func TestSwapDB(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value", "EX", "100")
+	r := attachReplica(t, addr)
+	c.expect(respStatus("OK"), "SWAPDB", "0", "1")
+	r.expectNext("SWAPDB", "0", "1")
+	c.expect(nil, "GET", "key")
+	other.expect(respStatus("OK"), "SELECT", "1")
+	other.expect("value", "GET", "key")
+	if ttl, _ := other.do("TTL", "key").(int64); ttl < 99 {
+		t.Fatalf("TTL after SWAPDB: got %d, want 100", ttl)
+	}
+	c.expect(respError("ERR DB index is out of range"), "SWAPDB", "0", "16")
+	c.expect(respError("ERR invalid first DB index"), "SWAPDB", "x", "1")
+}
+
+func TestMove(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value", "EX", "100")
+	c.expect(respStatus("OK"), "SET", "taken", "here")
+	r := attachReplica(t, addr)
+	c.expect(int64(1), "MOVE", "key", "2")
+	r.expectNext("MOVE", "key", "2")
+	c.expect(int64(0), "EXISTS", "key")
+	other.expect(respStatus("OK"), "SELECT", "2")
+	other.expect("value", "GET", "key")
+	if ttl, _ := other.do("TTL", "key").(int64); ttl < 99 {
+		t.Fatalf("TTL after MOVE: got %d, want 100", ttl)
+	}
+
+	// Neither a missing key nor one the target has is moved.
+	c.expect(int64(0), "MOVE", "missing", "2")
+	other.expect(respStatus("OK"), "SET", "taken", "there")
+	r.expectNext("SET", "taken", "there")
+	c.expect(int64(0), "MOVE", "taken", "2")
+	c.expect("here", "GET", "taken")
+	c.expect(respStatus("OK"), "SET", "marker", "1")
+	r.expectNext("SET", "marker", "1")
+	c.expect(respError("ERR source and destination objects are the same"), "MOVE", "taken", "0")
+}
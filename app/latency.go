@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyHistoryLen is the number of samples kept for each latency event.
+const latencyHistoryLen = 160
+
+// latencySample is the worst latency of an event within a second.
+type latencySample struct {
+	time    int64
+	latency int64
+}
+
+// latencyEvent is the recent history of an event, oldest sample first, and
+// the worst latency it ever had.
+type latencyEvent struct {
+	samples []latencySample
+	max     int64
+}
+
+// latencyMonitor records the events that took at least the
+// latency-monitor-threshold, such as commands and expire cycles, for the
+// LATENCY command.
+type latencyMonitor struct {
+	mu     sync.Mutex
+	events map[string]*latencyEvent
+}
+
+// add records that event took latency milliseconds at now. The samples of
+// the same second are merged, keeping the worst one.
+func (m *latencyMonitor) add(event string, latency int64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.events == nil {
+		m.events = make(map[string]*latencyEvent)
+	}
+	e, ok := m.events[event]
+	if !ok {
+		e = &latencyEvent{}
+		m.events[event] = e
+	}
+	e.max = max(e.max, latency)
+	second := now.Unix()
+	if n := len(e.samples); n > 0 && e.samples[n-1].time == second {
+		e.samples[n-1].latency = max(e.samples[n-1].latency, latency)
+		return
+	}
+	if len(e.samples) == latencyHistoryLen {
+		e.samples = e.samples[1:]
+	}
+	e.samples = append(e.samples, latencySample{second, latency})
+}
+
+// recordLatency records event if it took at least the threshold since start.
+func (s *server) recordLatency(event string, start time.Time) {
+	threshold := s.config.latencyThreshold()
+	if threshold == 0 {
+		return
+	}
+	if elapsed := time.Since(start); elapsed >= threshold {
+		s.latency.add(event, elapsed.Milliseconds(), s.clock.Now())
+	}
+}
+
+// latencyCommand implements LATENCY LATEST, LATENCY HISTORY event and
+// LATENCY RESET [event ...].
+func (s *server) latencyCommand(c *clientConn, commands []string) {
+	m := &s.latency
+	switch strings.ToLower(commands[1]) {
+	case "latest":
+		if len(commands) != 2 {
+			c.replyWrongArgs("latency|latest")
+			return
+		}
+		m.mu.Lock()
+		events := sortedKeys(m.events)
+		reply := make([]any, len(events))
+		for i, name := range events {
+			e := m.events[name]
+			last := e.samples[len(e.samples)-1]
+			reply[i] = []any{name, last.time, last.latency, e.max}
+		}
+		m.mu.Unlock()
+		c.replyValue(reply)
+	case "history":
+		if len(commands) != 3 {
+			c.replyWrongArgs("latency|history")
+			return
+		}
+		m.mu.Lock()
+		var reply []any
+		if e, ok := m.events[commands[2]]; ok {
+			for _, sample := range e.samples {
+				reply = append(reply, []any{sample.time, sample.latency})
+			}
+		}
+		m.mu.Unlock()
+		c.replyValue(reply)
+	case "reset":
+		m.mu.Lock()
+		reset := 0
+		if len(commands) == 2 {
+			reset = len(m.events)
+			m.events = nil
+		}
+		for _, name := range commands[2:] {
+			if _, ok := m.events[name]; ok {
+				delete(m.events, name)
+				reset++
+			}
+		}
+		m.mu.Unlock()
+		c.replyInteger(reset)
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try LATENCY HELP.", commands[1]))
+	}
+}
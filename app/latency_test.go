@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLatencyMonitor(t *testing.T) {
+	_, addr := startServer(t, "latency-monitor-threshold", "10")
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "DEBUG", "SLEEP", "0")
+	c.expect([]any{}, "LATENCY", "LATEST")
+
+	c.expect(respStatus("OK"), "DEBUG", "SLEEP", "0.05")
+	now := time.Now().Unix()
+	latest, _ := c.do("LATENCY", "LATEST").([]any)
+	if len(latest) != 1 {
+		t.Fatalf("LATENCY LATEST: got %#v, want a command event", latest)
+	}
+	event, _ := latest[0].([]any)
+	if at, _ := event[1].(int64); len(event) != 4 || event[0] != "command" || at < now-1 || at > now {
+		t.Fatalf("LATENCY LATEST: got %#v", event)
+	}
+	if ms, _ := event[2].(int64); ms < 50 || event[3] != event[2] {
+		t.Fatalf("LATENCY LATEST: got latest %v and max %v, want 50ms or more", event[2], event[3])
+	}
+	c.expect([]any{[]any{event[1], event[2]}}, "LATENCY", "HISTORY", "command")
+
+	c.expect(int64(0), "LATENCY", "RESET", "expire-cycle")
+	c.expect(int64(1), "LATENCY", "RESET")
+	c.expect([]any{}, "LATENCY", "LATEST")
+}
+
+func TestLatencySamples(t *testing.T) {
+	var m latencyMonitor
+	now := time.Unix(1700000000, 0)
+	m.add("command", 20, now)
+	m.add("command", 30, now.Add(500*time.Millisecond))
+	m.add("command", 10, now.Add(time.Second))
+	want := []latencySample{{now.Unix(), 30}, {now.Unix() + 1, 10}}
+	if e := m.events["command"]; !reflect.DeepEqual(e.samples, want) || e.max != 30 {
+		t.Fatalf("got samples %v and max %d, want %v and 30", e.samples, e.max, want)
+	}
+	for i := 0; i < latencyHistoryLen; i++ {
+		m.add("command", 10, now.Add(time.Duration(i+2)*time.Second))
+	}
+	if e := m.events["command"]; len(e.samples) != latencyHistoryLen || e.samples[0].time != now.Unix()+2 {
+		t.Fatalf("got %d samples from %d, want the last %d", len(e.samples), e.samples[0].time, latencyHistoryLen)
+	}
+}
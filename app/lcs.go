@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Strings returns the strings stored at keys, with missing keys read as
+// empty strings.
+func (s *Store) Strings(keys []string) ([]string, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		str, _, err := s.str(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = str
+	}
+	return values, nil
+}
+
+// lcsMatch is a range of the longest common subsequence found in both
+// strings, with inclusive start and end offsets.
+type lcsMatch struct {
+	aStart, aEnd int
+	bStart, bEnd int
+}
+
+// longestCommonSubsequence returns the longest common subsequence of a and b
+// and the ranges it is made of, from the last one to the first, leaving out
+// those shorter than minMatchLen.
+func longestCommonSubsequence(a, b string, minMatchLen int) (string, []lcsMatch) {
+	// dp[i][j] is the length of the LCS of the first i bytes of a and the
+	// first j bytes of b.
+	width := len(b) + 1
+	dp := make([]int, (len(a)+1)*width)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				dp[i*width+j] = dp[(i-1)*width+j-1] + 1
+			} else {
+				dp[i*width+j] = max(dp[(i-1)*width+j], dp[i*width+j-1])
+			}
+		}
+	}
+
+	// Walk back from the end, collecting the bytes of the LCS and growing
+	// the current range backwards while the matches are contiguous.
+	idx := dp[len(a)*width+len(b)]
+	result := make([]byte, idx)
+	var matches []lcsMatch
+	current, inRange := lcsMatch{}, false
+	for i, j := len(a), len(b); i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			result[idx-1] = a[i-1]
+			if !inRange {
+				current = lcsMatch{i - 1, i - 1, j - 1, j - 1}
+				inRange = true
+			} else if current.aStart == i && current.bStart == j {
+				current.aStart--
+				current.bStart--
+			} else {
+				emit = true
+			}
+			// A match with the first byte of either string ends the walk.
+			if current.aStart == 0 || current.bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if dp[(i-1)*width+j] > dp[i*width+j-1] {
+				i--
+			} else {
+				j--
+			}
+			emit = inRange
+		}
+		if emit {
+			if current.aEnd-current.aStart+1 >= minMatchLen {
+				matches = append(matches, current)
+			}
+			inRange = false
+		}
+	}
+	return string(result), matches
+}
+
+// lcsCommand implements LCS key1 key2 [LEN] [IDX] [MINMATCHLEN len]
+// [WITHMATCHLEN].
+func (s *server) lcsCommand(c *clientConn, commands []string) {
+	var getLen, getIdx, withMatchLen bool
+	minMatchLen := 0
+	for i := 3; i < len(commands); i++ {
+		switch strings.ToLower(commands[i]) {
+		case "len":
+			getLen = true
+		case "idx":
+			getIdx = true
+		case "withmatchlen":
+			withMatchLen = true
+		case "minmatchlen":
+			if i+1 == len(commands) {
+				c.replyError(errSyntax)
+				return
+			}
+			i++
+			n, err := strconv.Atoi(commands[i])
+			if err != nil {
+				c.replyError(errNotInteger)
+				return
+			}
+			minMatchLen = max(n, 0)
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	if getLen && getIdx {
+		c.replyErrorMsg("If you want both the length and indexes, please just use IDX.")
+		return
+	}
+	values, err := s.db(c).Strings(commands[1:3])
+	if err != nil {
+		c.replyErrorMsg("The specified keys must contain string values")
+		return
+	}
+	lcs, matches := longestCommonSubsequence(values[0], values[1], minMatchLen)
+	switch {
+	case getLen:
+		c.replyInteger(len(lcs))
+	case getIdx:
+		reply := make([]any, len(matches))
+		for i, m := range matches {
+			match := []any{[]any{m.aStart, m.aEnd}, []any{m.bStart, m.bEnd}}
+			if withMatchLen {
+				match = append(match, m.aEnd-m.aStart+1)
+			}
+			reply[i] = match
+		}
+		c.replyValue(replyMap{{"matches", reply}, {"len", len(lcs)}})
+	default:
+		c.replyBulk(lcs)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLCS(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "MSET", "key1", "ohmytext", "key2", "mynewtext")
+	c.expect("mytext", "LCS", "key1", "key2")
+	c.expect(int64(6), "LCS", "key1", "key2", "LEN")
+	c.expect("", "LCS", "key1", "missing")
+	c.expect([]any{
+		"matches", []any{
+			[]any{[]any{int64(4), int64(7)}, []any{int64(5), int64(8)}},
+			[]any{[]any{int64(2), int64(3)}, []any{int64(0), int64(1)}},
+		},
+		"len", int64(6),
+	}, "LCS", "key1", "key2", "IDX")
+	c.expect([]any{
+		"matches", []any{
+			[]any{[]any{int64(4), int64(7)}, []any{int64(5), int64(8)}, int64(4)},
+		},
+		"len", int64(6),
+	}, "LCS", "key1", "key2", "IDX", "MINMATCHLEN", "4", "WITHMATCHLEN")
+	if _, ok := c.do("LCS", "key1", "key2", "LEN", "IDX").(respError); !ok {
+		t.Fatal("LCS with both LEN and IDX did not fail")
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errCount = errors.New("ERR count should be greater than 0")
+
+// list returns the list stored at key. The caller must hold the write lock.
+func (s *Store) list(key string) ([]string, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	list, ok := val.([]string)
+	if !ok {
+		return nil, errWrongType
+	}
+	return list, nil
+}
+
+// Push inserts elements at the head of the list at key when left is set, or
+// at its tail otherwise, and returns the new length. Elements are pushed one
+// after the other, so LPUSH a b c leaves c at the head. With existing set
+// nothing is pushed unless the list exists, and the length is then 0.
+func (s *Store) Push(key string, elements []string, left, existing bool) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	list, err := s.list(key)
+	if err != nil {
+		return 0, err
+	}
+	if list == nil && existing {
+		return 0, nil
+	}
+	if list == nil {
+		delete(s.Encodings, key)
+	}
+	if left {
+		head := make([]string, 0, len(elements)+len(list))
+		for i := len(elements) - 1; i >= 0; i-- {
+			head = append(head, elements[i])
+		}
+		list = append(head, list...)
+	} else {
+		list = append(list, elements...)
+	}
+	s.Data[key] = list
+	return len(list), nil
+}
+
+// LRange returns the elements between start and stop inclusive, negative
+// indexes counting from the tail.
+func (s *Store) LRange(key string, start, stop int) ([]string, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	list, err := s.list(key)
+	if err != nil {
+		return nil, err
+	}
+	s.countLookup(list != nil)
+	start, stop, ok := normalizeRange(start, stop, len(list))
+	if !ok {
+		return []string{}, nil
+	}
+	return append([]string(nil), list[start:stop+1]...), nil
+}
+
+// LMPop pops up to count elements from the first non-empty list among keys,
+// from the head when left is set, and returns that list's key along with the
+// popped elements. A list left empty is deleted.
+func (s *Store) LMPop(keys []string, left bool, count int) (string, []string, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for _, key := range keys {
+		list, err := s.list(key)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(list) == 0 {
+			continue
+		}
+		count = min(count, len(list))
+		var popped []string
+		if left {
+			popped = append(popped, list[:count]...)
+			list = list[count:]
+		} else {
+			for i := len(list) - 1; i >= len(list)-count; i-- {
+				popped = append(popped, list[i])
+			}
+			list = list[:len(list)-count]
+		}
+		if len(list) == 0 {
+			s.remove(key)
+		} else {
+			s.Data[key] = list
+		}
+		return key, popped, nil
+	}
+	return "", nil, nil
+}
+
+// pushCommand implements LPUSH, RPUSH, and LPUSHX and RPUSHX, which only
+// push to a list that exists.
+func (s *server) pushCommand(c *clientConn, commands []string) {
+	if len(commands) < 3 {
+		c.replyWrongArgs(commands[0])
+		return
+	}
+	left := strings.HasPrefix(commands[0], "l")
+	length, err := s.db(c).Push(commands[1], commands[2:], left, strings.HasSuffix(commands[0], "x"))
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if length > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(length)
+}
+
+func (s *server) lrangeCommand(c *clientConn, commands []string) {
+	if len(commands) != 4 {
+		c.replyWrongArgs("lrange")
+		return
+	}
+	start, err1 := strconv.Atoi(commands[2])
+	stop, err2 := strconv.Atoi(commands[3])
+	if err1 != nil || err2 != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	elements, err := s.db(c).LRange(commands[1], start, stop)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyStrings(elements)
+}
+
+// parseMPop parses the arguments shared by LMPOP and ZMPOP:
+// numkeys key [key ...] <where> [COUNT count], where is one of the two given
+// words. It returns the keys, whether the first word was given, and count.
+func parseMPop(commands []string, first, second string) ([]string, bool, int, error) {
+	numKeys, err := parseNumKeys(commands, 1)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	keys := commands[2 : 2+numKeys]
+	rest := commands[2+numKeys:]
+	if len(rest) == 0 {
+		return nil, false, 0, errSyntax
+	}
+	var isFirst bool
+	switch strings.ToLower(rest[0]) {
+	case first:
+		isFirst = true
+	case second:
+	default:
+		return nil, false, 0, errSyntax
+	}
+	count := 1
+	switch {
+	case len(rest) == 1:
+	case len(rest) == 3 && strings.EqualFold(rest[1], "count"):
+		if count, err = strconv.Atoi(rest[2]); err != nil || count <= 0 {
+			return nil, false, 0, errCount
+		}
+	default:
+		return nil, false, 0, errSyntax
+	}
+	return keys, isFirst, count, nil
+}
+
+// lmpopCommand implements LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count].
+// It is propagated as the LPOP or RPOP of the list it popped from.
+func (s *server) lmpopCommand(c *clientConn, commands []string) {
+	if len(commands) < 4 {
+		c.replyWrongArgs("lmpop")
+		return
+	}
+	keys, left, count, err := parseMPop(commands, "left", "right")
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	key, popped, err := s.db(c).LMPop(keys, left, count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if popped == nil {
+		c.replyNullArray()
+		return
+	}
+	pop := "RPOP"
+	if left {
+		pop = "LPOP"
+	}
+	s.propagate(c.db, []string{pop, key, strconv.Itoa(len(popped))})
+	c.replyValue([]any{key, popped})
+}
+
+// bpopCommand implements BLPOP and BRPOP key [key ...] timeout, which pop an
+// element like LPOP and RPOP from the first non-empty list among keys,
+// blocking as described by block if they are all empty. It replies with the
+// key and the element, and is propagated as the LPOP or RPOP it turned into.
+func (s *server) bpopCommand(c *clientConn, commands []string) {
+	timeout, err := parseTimeout(commands[len(commands)-1])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	keys, left := commands[1:len(commands)-1], commands[0] == "blpop"
+	s.block(c, timeout, func(db *Store) (any, []string, error) {
+		key, popped, err := db.LMPop(keys, left, 1)
+		if len(popped) == 0 {
+			return nil, nil, err
+		}
+		return []string{key, popped[0]}, []string{strings.TrimPrefix(commands[0], "b"), key}, nil
+	})
+}
+
+// popCommand implements LPOP and RPOP key [count]. Without a count it replies
+// with the element alone, with a count with an array of them.
+func (s *server) popCommand(c *clientConn, commands []string) {
+	if len(commands) > 3 {
+		c.replyWrongArgs(commands[0])
+		return
+	}
+	left := commands[0] == "lpop"
+	count := 1
+	if len(commands) == 3 {
+		n, err := strconv.Atoi(commands[2])
+		if err != nil || n < 0 {
+			c.replyErrorMsg("value is out of range, must be positive")
+			return
+		}
+		count = n
+	}
+	if count == 0 {
+		switch s.db(c).Type(commands[1]) {
+		case "none":
+			c.replyNullArray()
+		case "list":
+			c.replyStrings(nil)
+		default:
+			c.replyError(errWrongType)
+		}
+		return
+	}
+	_, popped, err := s.db(c).LMPop(commands[1:2], left, count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	switch {
+	case popped == nil && len(commands) == 3:
+		c.replyNullArray()
+	case popped == nil:
+		c.replyNull()
+	case len(commands) == 3:
+		s.propagate(c.db, commands)
+		c.replyStrings(popped)
+	default:
+		s.propagate(c.db, commands)
+		c.replyBulk(popped[0])
+	}
+}
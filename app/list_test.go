@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestLMPop(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "RPUSH", "list", "a", "b", "c")
+	c.expect([]any{"list", []any{"a", "b"}}, "LMPOP", "2", "empty", "list", "LEFT", "COUNT", "2")
+	c.expect([]any{"list", []any{"c"}}, "LMPOP", "2", "empty", "list", "RIGHT", "COUNT", "5")
+	c.expect(int64(0), "EXISTS", "list")
+	c.expect(nil, "LMPOP", "2", "empty", "list", "LEFT")
+}
+
+func TestPushOrder(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "LPUSH", "left", "a", "b", "c")
+	c.expect([]any{"c", "b", "a"}, "LRANGE", "left", "0", "-1")
+	c.expect(int64(5), "LPUSH", "left", "d", "e")
+	c.expect([]any{"e", "d", "c", "b", "a"}, "LRANGE", "left", "0", "-1")
+
+	c.expect(int64(3), "RPUSH", "right", "a", "b", "c")
+	c.expect(int64(5), "RPUSH", "right", "d", "e")
+	c.expect([]any{"a", "b", "c", "d", "e"}, "LRANGE", "right", "0", "-1")
+}
+
+func TestPushX(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(0), "LPUSHX", "list", "a")
+	c.expect(int64(0), "RPUSHX", "list", "a", "b")
+	c.expect(int64(0), "EXISTS", "list")
+
+	c.expect(int64(1), "RPUSH", "list", "m")
+	c.expect(int64(3), "LPUSHX", "list", "b", "a")
+	c.expect(int64(5), "RPUSHX", "list", "y", "z")
+	c.expect([]any{"a", "b", "m", "y", "z"}, "LRANGE", "list", "0", "-1")
+
+	c.expect(respStatus("OK"), "SET", "string", "value")
+	c.expect(respError("WRONGTYPE Operation against a key holding the wrong kind of value"), "LPUSHX", "string", "a")
+}
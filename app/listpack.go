@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// Listpacks are the compact encoding redis uses for small hashes, sets,
+// sorted sets and lists, and for the nodes of streams. A listpack is a 4 byte
+// total length and a 2 byte element count, both little endian, followed by
+// the elements and a 0xff terminator. Each element is its encoding, its data
+// and the length of both so that the list can be walked backwards.
+
+const listpackEnd = 0xff
+
+// listpackWriter builds a listpack. Integers are stored in the smallest
+// integer encoding, everything else as a string.
+type listpackWriter struct {
+	buf   []byte
+	count int
+}
+
+func newListpackWriter() *listpackWriter {
+	return &listpackWriter{buf: make([]byte, 6)}
+}
+
+func (w *listpackWriter) appendInt(v int64) {
+	var entry []byte
+	switch {
+	case v >= 0 && v <= 127:
+		entry = []byte{byte(v)}
+	case v >= -4096 && v <= 4095:
+		u := uint64(v) & 0x1fff
+		entry = []byte{0xc0 | byte(u>>8), byte(u)}
+	case v >= -32768 && v <= 32767:
+		entry = binary.LittleEndian.AppendUint16([]byte{0xf1}, uint16(v))
+	case v >= -8388608 && v <= 8388607:
+		u := uint32(v)
+		entry = []byte{0xf2, byte(u), byte(u >> 8), byte(u >> 16)}
+	case v >= -2147483648 && v <= 2147483647:
+		entry = binary.LittleEndian.AppendUint32([]byte{0xf3}, uint32(v))
+	default:
+		entry = binary.LittleEndian.AppendUint64([]byte{0xf4}, uint64(v))
+	}
+	w.appendEntry(entry)
+}
+
+func (w *listpackWriter) append(s string) {
+	if v, err := strconv.ParseInt(s, 10, 64); err == nil && strconv.FormatInt(v, 10) == s {
+		w.appendInt(v)
+		return
+	}
+	var entry []byte
+	switch n := len(s); {
+	case n < 64:
+		entry = []byte{0x80 | byte(n)}
+	case n < 4096:
+		entry = []byte{0xe0 | byte(n>>8), byte(n)}
+	default:
+		entry = binary.LittleEndian.AppendUint32([]byte{0xf0}, uint32(n))
+	}
+	w.appendEntry(append(entry, s...))
+}
+
+func (w *listpackWriter) appendEntry(entry []byte) {
+	w.buf = append(w.buf, entry...)
+	w.buf = append(w.buf, listpackBacklen(len(entry))...)
+	w.count++
+}
+
+// listpackBacklen encodes the length of an entry, most significant 7 bits
+// first, with the high bit set on all the bytes but the first.
+func listpackBacklen(n int) []byte {
+	var groups []byte
+	for {
+		groups = append(groups, byte(n&127))
+		n >>= 7
+		if n == 0 {
+			break
+		}
+	}
+	backlen := make([]byte, len(groups))
+	for i := range groups {
+		backlen[i] = groups[len(groups)-1-i]
+		if i > 0 {
+			backlen[i] |= 128
+		}
+	}
+	return backlen
+}
+
+func (w *listpackWriter) bytes() []byte {
+	buf := append(w.buf, listpackEnd)
+	binary.LittleEndian.PutUint32(buf, uint32(len(buf)))
+	count := w.count
+	if count > 65535 {
+		count = 65535
+	}
+	binary.LittleEndian.PutUint16(buf[4:], uint16(count))
+	return buf
+}
+
+// parseListpack returns the elements of a listpack, with integers formatted
+// in decimal.
+func parseListpack(lp []byte) ([]string, error) {
+	if len(lp) < 7 || int(binary.LittleEndian.Uint32(lp)) != len(lp) {
+		return nil, errBadRDB
+	}
+	var elements []string
+	for p := lp[6:]; ; {
+		if len(p) == 0 {
+			return nil, errBadRDB
+		}
+		if p[0] == listpackEnd {
+			return elements, nil
+		}
+		element, n, err := parseListpackEntry(p)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+		n += len(listpackBacklen(n))
+		if n > len(p) {
+			return nil, errBadRDB
+		}
+		p = p[n:]
+	}
+}
+
+// parseListpackEntry decodes the entry at the start of p and returns it with
+// the length of its encoding and data, without the backlen.
+func parseListpackEntry(p []byte) (string, int, error) {
+	b := p[0]
+	need := func(n int) error {
+		if n > len(p) {
+			return errBadRDB
+		}
+		return nil
+	}
+	signed := func(u uint64, bits uint) string {
+		shift := 64 - bits
+		return strconv.FormatInt(int64(u<<shift)>>shift, 10)
+	}
+	switch {
+	case b&0x80 == 0:
+		return strconv.Itoa(int(b)), 1, nil
+	case b&0xc0 == 0x80:
+		n := int(b & 0x3f)
+		if err := need(1 + n); err != nil {
+			return "", 0, err
+		}
+		return string(p[1 : 1+n]), 1 + n, nil
+	case b&0xe0 == 0xc0:
+		if err := need(2); err != nil {
+			return "", 0, err
+		}
+		return signed(uint64(b&0x1f)<<8|uint64(p[1]), 13), 2, nil
+	case b&0xf0 == 0xe0:
+		if err := need(2); err != nil {
+			return "", 0, err
+		}
+		n := int(b&0x0f)<<8 | int(p[1])
+		if err := need(2 + n); err != nil {
+			return "", 0, err
+		}
+		return string(p[2 : 2+n]), 2 + n, nil
+	}
+	switch b {
+	case 0xf0:
+		if err := need(5); err != nil {
+			return "", 0, err
+		}
+		n := int(binary.LittleEndian.Uint32(p[1:]))
+		if err := need(5 + n); err != nil {
+			return "", 0, err
+		}
+		return string(p[5 : 5+n]), 5 + n, nil
+	case 0xf1:
+		if err := need(3); err != nil {
+			return "", 0, err
+		}
+		return signed(uint64(binary.LittleEndian.Uint16(p[1:])), 16), 3, nil
+	case 0xf2:
+		if err := need(4); err != nil {
+			return "", 0, err
+		}
+		return signed(uint64(p[1])|uint64(p[2])<<8|uint64(p[3])<<16, 24), 4, nil
+	case 0xf3:
+		if err := need(5); err != nil {
+			return "", 0, err
+		}
+		return signed(uint64(binary.LittleEndian.Uint32(p[1:])), 32), 5, nil
+	case 0xf4:
+		if err := need(9); err != nil {
+			return "", 0, err
+		}
+		return strconv.FormatInt(int64(binary.LittleEndian.Uint64(p[1:])), 10), 9, nil
+	}
+	return "", 0, errBadRDB
+}
+
+// parseIntset returns the members of an intset: a 4 byte element size and a
+// 4 byte count, followed by the sorted little endian integers.
+func parseIntset(b []byte) ([]string, error) {
+	if len(b) < 8 {
+		return nil, errBadRDB
+	}
+	size := int(binary.LittleEndian.Uint32(b))
+	count := int(binary.LittleEndian.Uint32(b[4:]))
+	if size != 2 && size != 4 && size != 8 || len(b) != 8+size*count {
+		return nil, errBadRDB
+	}
+	members := make([]string, count)
+	for i := range members {
+		p := b[8+i*size:]
+		var v int64
+		switch size {
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(p)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(p)))
+		default:
+			v = int64(binary.LittleEndian.Uint64(p))
+		}
+		members[i] = strconv.FormatInt(v, 10)
+	}
+	return members, nil
+}
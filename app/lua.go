@@ -0,0 +1,1739 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// This is an interpreter for the subset of Lua 5.1 that EVAL scripts use in
+// practice: local variables and multiple assignments, if/elseif/else, numeric
+// and generic for, while and repeat loops with break, return, functions
+// defined by the script with varargs and multiple return values, method
+// calls, table constructors and indexing, metatables with __index,
+// __newindex, __call and __tostring, and the arithmetic, floor division,
+// comparison, logical, concatenation and length operators. The functions
+// scripts call are in lualib.go and luastring.go.
+//
+// Values are nil, bool, float64, string, *luaTable, *luaBuiltin and
+// *luaFunction.
+
+type luaTable struct {
+	array []any
+	hash  map[any]any
+	meta  *luaTable
+}
+
+func newLuaTable(array ...any) *luaTable {
+	return &luaTable{array: array, hash: map[any]any{}}
+}
+
+func (t *luaTable) get(key any) any {
+	if n, ok := key.(float64); ok && n == math.Trunc(n) && n >= 1 && n <= float64(len(t.array)) {
+		return t.array[int(n)-1]
+	}
+	return t.hash[key]
+}
+
+func (t *luaTable) set(key, value any) {
+	if n, ok := key.(float64); ok && n == math.Trunc(n) && n >= 1 && n <= float64(len(t.array)+1) {
+		i := int(n) - 1
+		if i == len(t.array) {
+			if value == nil {
+				return
+			}
+			t.array = append(t.array, value)
+			// Move the entries that now continue the array out of the hash.
+			for next := float64(len(t.array) + 1); t.hash[next] != nil; next++ {
+				t.array = append(t.array, t.hash[next])
+				delete(t.hash, next)
+			}
+			return
+		}
+		t.array[i] = value
+		for len(t.array) > 0 && t.array[len(t.array)-1] == nil {
+			t.array = t.array[:len(t.array)-1]
+		}
+		return
+	}
+	if value == nil {
+		delete(t.hash, key)
+		return
+	}
+	t.hash[key] = value
+}
+
+type luaBuiltin struct {
+	name string
+	fn   func(args []any) ([]any, error)
+}
+
+// luaFunc returns the built in function name, which returns a single value.
+func luaFunc(name string, fn func(args []any) (any, error)) *luaBuiltin {
+	return &luaBuiltin{name: name, fn: func(args []any) ([]any, error) {
+		value, err := fn(args)
+		return []any{value}, err
+	}}
+}
+
+// luaFunction is a function defined by the script, a closure over the scope
+// it was defined in. The arguments past its parameters are its varargs.
+type luaFunction struct {
+	params []string
+	vararg bool
+	body   []luaStmt
+	scope  *luaScope
+}
+
+// Nesting limits. Like Lua, the parser refuses more than luaMaxSyntaxLevels
+// nested blocks and expressions, which bounds the depth of the closures they
+// compile to, and a script fails with a stack overflow past luaMaxCalls
+// nested calls of its functions.
+const (
+	luaMaxSyntaxLevels = 200
+	luaMaxCalls        = 1000
+	// luaMaxMetaLoop bounds the chains of __index, __newindex and __call.
+	luaMaxMetaLoop = 100
+)
+
+// call runs fn with args bound to its parameters, missing ones being nil,
+// and returns the values it returns.
+func (fn *luaFunction) call(args []any) ([]any, error) {
+	run := fn.scope.run
+	if run.calls >= luaMaxCalls {
+		return nil, luaErrorf("stack overflow")
+	}
+	if err := run.check(); err != nil {
+		return nil, err
+	}
+	run.calls++
+	defer func() { run.calls-- }()
+	vars := make(map[string]any, len(fn.params)+1)
+	for i, name := range fn.params {
+		vars[name] = nil
+		if i < len(args) {
+			vars[name] = args[i]
+		}
+	}
+	if fn.vararg {
+		var rest []any
+		if len(args) > len(fn.params) {
+			rest = args[len(fn.params):]
+		}
+		vars["..."] = rest
+	}
+	_, values, err := runLuaBlock(fn.body, fn.scope.child(vars))
+	return values, err
+}
+
+// luaCallValue calls f, a function or a table with a __call metamethod.
+func luaCallValue(f any, args []any) ([]any, error) {
+	for range luaMaxMetaLoop {
+		switch fn := f.(type) {
+		case *luaBuiltin:
+			return fn.fn(args)
+		case *luaFunction:
+			return fn.call(args)
+		case *luaTable:
+			if handler := fn.metamethod("__call"); handler != nil {
+				f, args = handler, append([]any{fn}, args...)
+				continue
+			}
+		}
+		return nil, luaErrorf("attempt to call a %s value", luaTypeName(f))
+	}
+	return nil, luaErrorf("'__call' chain too long; possible loop")
+}
+
+// metamethod returns the field event of the metatable of t, nil if it has
+// none.
+func (t *luaTable) metamethod(event string) any {
+	if t.meta == nil {
+		return nil
+	}
+	return t.meta.get(event)
+}
+
+// luaIndex returns o[key]. A key a table does not have is looked up through
+// the __index of its metatable, and the keys of strings are the functions
+// of the string library of run, if there is one.
+func luaIndex(run *luaRun, o, key any) (any, error) {
+	for range luaMaxMetaLoop {
+		switch t := o.(type) {
+		case *luaTable:
+			v := t.get(key)
+			if v != nil {
+				return v, nil
+			}
+			handler := t.metamethod("__index")
+			if handler == nil {
+				return nil, nil
+			}
+			if _, ok := handler.(*luaTable); !ok {
+				values, err := luaCallValue(handler, []any{t, key})
+				return luaFirst(values), err
+			}
+			o = handler
+		case string:
+			if run == nil || run.strings == nil {
+				return nil, nil
+			}
+			return run.strings.get(key), nil
+		default:
+			return nil, luaErrorf("attempt to index a %s value", luaTypeName(o))
+		}
+	}
+	return nil, luaErrorf("loop in gettable")
+}
+
+// luaSetIndex sets o[key] to value. A key a table does not have is set
+// through the __newindex of its metatable, if it has one.
+func luaSetIndex(o, key, value any) error {
+	for range luaMaxMetaLoop {
+		t, ok := o.(*luaTable)
+		if !ok {
+			return luaErrorf("attempt to index a %s value", luaTypeName(o))
+		}
+		if handler := t.metamethod("__newindex"); handler != nil && t.get(key) == nil {
+			if _, ok := handler.(*luaTable); !ok {
+				_, err := luaCallValue(handler, []any{t, key, value})
+				return err
+			}
+			o = handler
+			continue
+		}
+		switch k := key.(type) {
+		case nil:
+			return luaErrorf("table index is nil")
+		case float64:
+			if math.IsNaN(k) {
+				return luaErrorf("table index is NaN")
+			}
+		}
+		t.set(key, value)
+		return nil
+	}
+	return luaErrorf("loop in settable")
+}
+
+// luaFirst returns the first of values, nil if there are none, which is what
+// a call evaluates to where a single value is expected.
+func luaFirst(values []any) any {
+	if len(values) == 0 {
+		return nil
+	}
+	return values[0]
+}
+
+// luaError is an error raised while running a script. line is 0 until
+// the error is attributed to the line that raised it. Errors from
+// redis.call keep the error reply they carry as msg. value is what the
+// script passed to error when it is not a message to prefix with the line,
+// and fatal errors are not caught by pcall.
+type luaError struct {
+	msg       string
+	line      int
+	fromReply bool
+	value     any
+	fatal     bool
+}
+
+func (e *luaError) Error() string { return e.msg }
+
+// raised returns the value pcall returns for e: the value given to error,
+// a table with an err field for an error reply, or the message prefixed
+// with the line of the script that raised it.
+func (e *luaError) raised() any {
+	switch {
+	case e.value != nil:
+		return e.value
+	case e.fromReply:
+		t := newLuaTable()
+		t.set("err", e.msg)
+		return t
+	case e.line > 0:
+		return fmt.Sprintf("user_script:%d: %s", e.line, e.msg)
+	}
+	return e.msg
+}
+
+func luaErrorf(format string, args ...any) error {
+	return &luaError{msg: fmt.Sprintf(format, args...)}
+}
+
+// atLine attributes err to line unless it already is.
+func atLine(err error, line int) error {
+	if e, ok := err.(*luaError); ok && e.line == 0 {
+		e.line = line
+	}
+	return err
+}
+
+func luaTypeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *luaTable:
+		return "table"
+	}
+	return "function"
+}
+
+func luaTruthy(v any) bool {
+	return v != nil && v != false
+}
+
+// luaNumberString formats n the way Lua 5.1 does, with %.14g.
+func luaNumberString(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(n, 'g', 14, 64)
+}
+
+// luaSpace is the white space Lua allows around a number in a string.
+const luaSpace = " \t\n\v\f\r"
+
+// luaToNumber converts v to a number the way Lua 5.1 converts strings with
+// strtod: decimal and hexadecimal numbers, inf and nan, with white space
+// around them, and nothing else.
+func luaToNumber(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		s := strings.Trim(v, luaSpace)
+		if strings.Contains(s, "_") {
+			return 0, false
+		}
+		digits, negative := s, false
+		if len(digits) > 0 && (digits[0] == '-' || digits[0] == '+') {
+			digits, negative = digits[1:], digits[0] == '-'
+		}
+		if strings.HasPrefix(digits, "0x") || strings.HasPrefix(digits, "0X") {
+			if n, err := strconv.ParseUint(digits[2:], 16, 64); err == nil {
+				if negative {
+					return -float64(n), true
+				}
+				return float64(n), true
+			}
+		}
+		n, err := strconv.ParseFloat(s, 64)
+		return n, err == nil || errors.Is(err, strconv.ErrRange)
+	}
+	return 0, false
+}
+
+func luaToString(v any) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return luaNumberString(v), true
+	}
+	return "", false
+}
+
+// Lexer.
+
+const (
+	luaTokEOF = iota
+	luaTokName
+	luaTokNumber
+	luaTokString
+	luaTokSymbol
+)
+
+type luaToken struct {
+	kind int
+	text string
+	num  float64
+	line int
+}
+
+func luaLex(src string) ([]luaToken, error) {
+	var tokens []luaToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			i += 2
+			if level, ok := luaLongBracket(src[i:]); ok {
+				close := "]" + strings.Repeat("=", level) + "]"
+				end := strings.Index(src[i:], close)
+				if end < 0 {
+					return nil, luaErrorf("user_script:%d: unfinished long comment", line)
+				}
+				line += strings.Count(src[i:i+end], "\n")
+				i += end + len(close)
+				continue
+			}
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			start := i
+			for i < len(src) && (src[i] == '_' || src[i] >= 'a' && src[i] <= 'z' || src[i] >= 'A' && src[i] <= 'Z' || src[i] >= '0' && src[i] <= '9') {
+				i++
+			}
+			tokens = append(tokens, luaToken{kind: luaTokName, text: src[start:i], line: line})
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			start := i
+			for i < len(src) && (src[i] == '.' || src[i] == 'x' || src[i] == 'X' ||
+				src[i] >= '0' && src[i] <= '9' || src[i] >= 'a' && src[i] <= 'f' || src[i] >= 'A' && src[i] <= 'F' ||
+				(src[i] == '-' || src[i] == '+') && (src[i-1] == 'e' || src[i-1] == 'E') && !strings.HasPrefix(src[start:], "0x")) {
+				i++
+			}
+			n, ok := luaToNumber(src[start:i])
+			if !ok {
+				return nil, luaErrorf("user_script:%d: malformed number near '%s'", line, src[start:i])
+			}
+			tokens = append(tokens, luaToken{kind: luaTokNumber, text: src[start:i], num: n, line: line})
+		case c == '"' || c == '\'':
+			str, n, err := luaLexString(src[i:], line)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, luaToken{kind: luaTokString, text: str, line: line})
+			i += n
+		case c == '[':
+			if level, ok := luaLongBracket(src[i:]); ok {
+				open := level + 2
+				close := "]" + strings.Repeat("=", level) + "]"
+				end := strings.Index(src[i+open:], close)
+				if end < 0 {
+					return nil, luaErrorf("user_script:%d: unfinished long string", line)
+				}
+				str := strings.TrimPrefix(src[i+open:i+open+end], "\n")
+				tokens = append(tokens, luaToken{kind: luaTokString, text: str, line: line})
+				line += strings.Count(src[i:i+open+end], "\n")
+				i += open + end + len(close)
+				continue
+			}
+			tokens = append(tokens, luaToken{kind: luaTokSymbol, text: "[", line: line})
+			i++
+		default:
+			symbol := string(c)
+			for _, s := range []string{"...", "==", "~=", "<=", ">=", "..", "//"} {
+				if strings.HasPrefix(src[i:], s) {
+					symbol = s
+					break
+				}
+			}
+			if !strings.Contains("+-*/%^#=<>(){}[];:,.", string(c)) && len(symbol) == 1 {
+				return nil, luaErrorf("user_script:%d: unexpected symbol near '%c'", line, c)
+			}
+			tokens = append(tokens, luaToken{kind: luaTokSymbol, text: symbol, line: line})
+			i += len(symbol)
+		}
+	}
+	return append(tokens, luaToken{kind: luaTokEOF, text: "<eof>", line: line}), nil
+}
+
+// luaLongBracket reports whether s starts with [[ or [=*[, and its level.
+func luaLongBracket(s string) (int, bool) {
+	if !strings.HasPrefix(s, "[") {
+		return 0, false
+	}
+	level := 1
+	for level < len(s) && s[level] == '=' {
+		level++
+	}
+	if level < len(s) && s[level] == '[' {
+		return level - 1, true
+	}
+	return 0, false
+}
+
+// luaLexString reads a quoted string at the start of src and returns it with
+// the number of bytes it took.
+func luaLexString(src string, line int) (string, int, error) {
+	quote := src[0]
+	var b strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, luaErrorf("user_script:%d: unfinished string", line)
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch e := src[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case 'a':
+				b.WriteByte('\a')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'v':
+				b.WriteByte('\v')
+			case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+				n := 0
+				for j := 0; j < 3 && i < len(src) && src[i] >= '0' && src[i] <= '9'; j++ {
+					n = n*10 + int(src[i]-'0')
+					i++
+				}
+				i--
+				if n > 255 {
+					return "", 0, luaErrorf("user_script:%d: escape sequence too large", line)
+				}
+				b.WriteByte(byte(n))
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, luaErrorf("user_script:%d: unfinished string", line)
+}
+
+// Parser. Expressions and statements are compiled to closures.
+
+type luaScope struct {
+	vars   map[string]any
+	parent *luaScope
+	run    *luaRun
+}
+
+// child returns the scope of a block run in sc, with vars as its locals.
+func (sc *luaScope) child(vars map[string]any) *luaScope {
+	return &luaScope{vars: vars, parent: sc, run: sc.run}
+}
+
+// luaRun is the state of a running chunk: the depth of the calls of its
+// functions, the string library, which indexing a string looks in, and
+// interrupt, which is called at every loop iteration and function call and
+// stops the script with the error it returns, if any.
+type luaRun struct {
+	calls     int
+	strings   *luaTable
+	interrupt func() error
+}
+
+func (run *luaRun) check() error {
+	if run.interrupt == nil {
+		return nil
+	}
+	return run.interrupt()
+}
+
+func (sc *luaScope) lookup(name string) (*luaScope, bool) {
+	for ; sc != nil; sc = sc.parent {
+		if _, ok := sc.vars[name]; ok {
+			return sc, true
+		}
+	}
+	return nil, false
+}
+
+type luaExpr func(sc *luaScope) (any, error)
+
+// luaMultiExpr is an expression that evaluates to any number of values: a
+// call, ..., or a list of expressions.
+type luaMultiExpr func(sc *luaScope) ([]any, error)
+
+const (
+	luaNext = iota
+	luaReturn
+	luaBreak
+)
+
+type luaStmt func(sc *luaScope) (ctrl int, values []any, err error)
+
+// luaChunk is a compiled script.
+type luaChunk struct {
+	body []luaStmt
+}
+
+// run runs the chunk with globals as its global variables and returns the
+// first value it returns. interrupt is the check of luaRun, nil for none.
+func (ch *luaChunk) run(globals map[string]any, interrupt func() error) (any, error) {
+	run := &luaRun{interrupt: interrupt}
+	run.strings, _ = globals["string"].(*luaTable)
+	root := &luaScope{vars: globals, run: run}
+	_, values, err := runLuaBlock(ch.body, root.child(map[string]any{"...": []any(nil)}))
+	return luaFirst(values), err
+}
+
+func runLuaBlock(body []luaStmt, sc *luaScope) (int, []any, error) {
+	for _, stmt := range body {
+		ctrl, values, err := stmt(sc)
+		if err != nil || ctrl != luaNext {
+			return ctrl, values, err
+		}
+	}
+	return luaNext, nil, nil
+}
+
+type luaParser struct {
+	tokens []luaToken
+	pos    int
+	// depth is the number of nested blocks and expressions being parsed.
+	depth int
+	// vararg is set while parsing a function that takes varargs, or the
+	// chunk itself, the only places ... can be used.
+	vararg bool
+	// multi is the last expression parsed that evaluates to several
+	// values, and the tokens it spans, for the lists of expressions that end
+	// with it to expand it.
+	multi struct {
+		start, end int
+		expr       luaMultiExpr
+	}
+}
+
+func compileLua(src string) (*luaChunk, error) {
+	tokens, err := luaLex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &luaParser{tokens: tokens, vararg: true}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != luaTokEOF {
+		return nil, p.errorf("'<eof>' expected")
+	}
+	return &luaChunk{body: body}, nil
+}
+
+func (p *luaParser) peek() luaToken { return p.tokens[p.pos] }
+
+// enter counts one more nested syntax level, failing past
+// luaMaxSyntaxLevels. Each call is paired with a deferred leave.
+func (p *luaParser) enter() error {
+	if p.depth++; p.depth > luaMaxSyntaxLevels {
+		return p.errorf("chunk has too many syntax levels")
+	}
+	return nil
+}
+
+func (p *luaParser) leave() { p.depth-- }
+
+func (p *luaParser) next() luaToken {
+	t := p.tokens[p.pos]
+	if t.kind != luaTokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the symbol or keyword text.
+func (p *luaParser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == luaTokSymbol || t.kind == luaTokName) && t.text == text
+}
+
+func (p *luaParser) accept(text string) bool {
+	if p.is(text) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *luaParser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("'%s' expected", text)
+	}
+	return nil
+}
+
+func (p *luaParser) errorf(format string, args ...any) error {
+	t := p.peek()
+	return luaErrorf("user_script:%d: %s near '%s'", t.line, fmt.Sprintf(format, args...), t.text)
+}
+
+var luaKeywords = map[string]bool{
+	"and": true, "break": true, "do": true, "else": true, "elseif": true, "end": true,
+	"false": true, "for": true, "function": true, "if": true, "in": true, "local": true,
+	"nil": true, "not": true, "or": true, "repeat": true, "return": true, "then": true,
+	"true": true, "until": true, "while": true,
+}
+
+func (p *luaParser) name() (string, error) {
+	t := p.peek()
+	if t.kind != luaTokName || luaKeywords[t.text] {
+		return "", p.errorf("<name> expected")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *luaParser) blockEnd() bool {
+	return p.peek().kind == luaTokEOF || p.is("end") || p.is("else") || p.is("elseif") || p.is("until")
+}
+
+func (p *luaParser) block() ([]luaStmt, error) {
+	defer p.leave()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	var body []luaStmt
+	for !p.blockEnd() {
+		if p.accept(";") {
+			continue
+		}
+		if p.is("return") {
+			stmt, err := p.returnStmt()
+			if err != nil {
+				return nil, err
+			}
+			body = append(body, stmt)
+			p.accept(";")
+			if !p.blockEnd() {
+				return nil, p.errorf("'end' expected")
+			}
+			break
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, stmt)
+	}
+	return body, nil
+}
+
+func (p *luaParser) returnStmt() (luaStmt, error) {
+	p.next()
+	if p.blockEnd() || p.is(";") {
+		return func(*luaScope) (int, []any, error) { return luaReturn, nil, nil }, nil
+	}
+	values, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		v, err := values(sc)
+		return luaReturn, v, err
+	}, nil
+}
+
+func (p *luaParser) statement() (luaStmt, error) {
+	line := p.peek().line
+	switch {
+	case p.accept("local"):
+		return p.localStmt()
+	case p.accept("if"):
+		return p.ifStmt()
+	case p.accept("while"):
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.doBlock()
+		if err != nil {
+			return nil, err
+		}
+		return func(sc *luaScope) (int, []any, error) {
+			for {
+				v, err := cond(sc)
+				if err != nil || !luaTruthy(v) {
+					return luaNext, nil, err
+				}
+				if err := sc.run.check(); err != nil {
+					return luaNext, nil, atLine(err, line)
+				}
+				ctrl, values, err := runLuaBlock(body, sc.child(map[string]any{}))
+				if err != nil || ctrl == luaReturn {
+					return ctrl, values, err
+				}
+				if ctrl == luaBreak {
+					return luaNext, nil, nil
+				}
+			}
+		}, nil
+	case p.accept("repeat"):
+		return p.repeatStmt(line)
+	case p.accept("for"):
+		return p.forStmt(line)
+	case p.accept("do"):
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("end"); err != nil {
+			return nil, err
+		}
+		return func(sc *luaScope) (int, []any, error) {
+			return runLuaBlock(body, sc.child(map[string]any{}))
+		}, nil
+	case p.accept("break"):
+		return func(*luaScope) (int, []any, error) { return luaBreak, nil, nil }, nil
+	case p.accept("function"):
+		return p.functionStmt(line)
+	}
+
+	get, set, isCall, err := p.suffixedExpr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.is("=") && !p.is(",") {
+		if !isCall {
+			return nil, p.errorf("syntax error")
+		}
+		return func(sc *luaScope) (int, []any, error) {
+			_, err := get(sc)
+			return luaNext, nil, err
+		}, nil
+	}
+	targets := []func(*luaScope, any) error{set}
+	for p.accept(",") {
+		_, set, _, err := p.suffixedExpr()
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, set)
+	}
+	if !luaAssignable(targets) {
+		return nil, p.errorf("syntax error")
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	values, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		v, err := values(sc)
+		if err != nil {
+			return luaNext, nil, err
+		}
+		for i, set := range targets {
+			var value any
+			if i < len(v) {
+				value = v[i]
+			}
+			if err := set(sc, value); err != nil {
+				return luaNext, nil, atLine(err, line)
+			}
+		}
+		return luaNext, nil, nil
+	}, nil
+}
+
+// luaAssignable reports whether all the targets of an assignment are
+// variables or table fields, which can be assigned to.
+func luaAssignable(targets []func(*luaScope, any) error) bool {
+	for _, set := range targets {
+		if set == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// repeatStmt parses repeat ... until cond, whose condition sees the locals
+// of the body.
+func (p *luaParser) repeatStmt(line int) (luaStmt, error) {
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("until"); err != nil {
+		return nil, err
+	}
+	cond, err := p.expr(0)
+	if err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		for {
+			if err := sc.run.check(); err != nil {
+				return luaNext, nil, atLine(err, line)
+			}
+			inner := sc.child(map[string]any{})
+			ctrl, values, err := runLuaBlock(body, inner)
+			if err != nil || ctrl == luaReturn {
+				return ctrl, values, err
+			}
+			if ctrl == luaBreak {
+				return luaNext, nil, nil
+			}
+			v, err := cond(inner)
+			if err != nil || luaTruthy(v) {
+				return luaNext, nil, err
+			}
+		}
+	}, nil
+}
+
+// functionStmt parses function name[.field ...][:method] funcbody, which
+// assigns the function to the variable or the table field. A method takes
+// the table it is called on as self.
+func (p *luaParser) functionStmt(line int) (luaStmt, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	method := false
+	for p.is(".") || p.is(":") {
+		method = p.next().text == ":"
+		field, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+		if method {
+			break
+		}
+	}
+	function, err := p.funcBody(method)
+	if err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		f, _ := function(sc)
+		owner, ok := sc.lookup(name)
+		if len(fields) == 0 {
+			if !ok || owner.parent == nil {
+				return luaNext, nil, &luaError{msg: fmt.Sprintf("Script attempted to create global variable '%s'", name), line: line}
+			}
+			owner.vars[name] = f
+			return luaNext, nil, nil
+		}
+		var object any
+		if ok {
+			object = owner.vars[name]
+		}
+		for _, field := range fields[:len(fields)-1] {
+			var err error
+			if object, err = luaIndex(sc.run, object, field); err != nil {
+				return luaNext, nil, atLine(err, line)
+			}
+		}
+		return luaNext, nil, atLine(luaSetIndex(object, fields[len(fields)-1], f), line)
+	}, nil
+}
+
+// funcBody parses the parameters and the body of a function up to its end,
+// and returns the expression creating the closure. A method has self as its
+// first parameter.
+func (p *luaParser) funcBody(method bool) (luaExpr, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var params []string
+	if method {
+		params = append(params, "self")
+	}
+	vararg := false
+	for !p.accept(")") {
+		if p.accept("...") {
+			vararg = true
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+		param, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+		if !p.accept(",") {
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	outer := p.vararg
+	p.vararg = vararg
+	body, err := p.block()
+	p.vararg = outer
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("end"); err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (any, error) {
+		return &luaFunction{params: params, vararg: vararg, body: body, scope: sc}, nil
+	}, nil
+}
+
+func (p *luaParser) doBlock() ([]luaStmt, error) {
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return body, p.expect("end")
+}
+
+func (p *luaParser) localStmt() (luaStmt, error) {
+	if p.accept("function") {
+		// The function is in scope in its own body, so that it can
+		// call itself.
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		function, err := p.funcBody(false)
+		if err != nil {
+			return nil, err
+		}
+		return func(sc *luaScope) (int, []any, error) {
+			sc.vars[name] = nil
+			sc.vars[name], _ = function(sc)
+			return luaNext, nil, nil
+		}, nil
+	}
+	names, err := p.names()
+	if err != nil {
+		return nil, err
+	}
+	var values luaMultiExpr
+	if p.accept("=") {
+		if values, err = p.exprList(); err != nil {
+			return nil, err
+		}
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		var evaluated []any
+		if values != nil {
+			var err error
+			if evaluated, err = values(sc); err != nil {
+				return luaNext, nil, err
+			}
+		}
+		for i, name := range names {
+			sc.vars[name] = nil
+			if i < len(evaluated) {
+				sc.vars[name] = evaluated[i]
+			}
+		}
+		return luaNext, nil, nil
+	}, nil
+}
+
+// names parses a list of names separated by commas.
+func (p *luaParser) names() ([]string, error) {
+	var names []string
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+		if !p.accept(",") {
+			return names, nil
+		}
+	}
+}
+
+func (p *luaParser) ifStmt() (luaStmt, error) {
+	type branch struct {
+		cond luaExpr
+		body []luaStmt
+	}
+	var branches []branch
+	for {
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		body, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, branch{cond, body})
+		if !p.accept("elseif") {
+			break
+		}
+	}
+	var elseBody []luaStmt
+	if p.accept("else") {
+		var err error
+		if elseBody, err = p.block(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("end"); err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		for _, b := range branches {
+			v, err := b.cond(sc)
+			if err != nil {
+				return luaNext, nil, err
+			}
+			if luaTruthy(v) {
+				return runLuaBlock(b.body, sc.child(map[string]any{}))
+			}
+		}
+		return runLuaBlock(elseBody, sc.child(map[string]any{}))
+	}, nil
+}
+
+// forStmt parses a numeric for loop, for name = start, limit [, step] do
+// ... end, or a generic one, for name [, name ...] in explist do ... end.
+func (p *luaParser) forStmt(line int) (luaStmt, error) {
+	names, err := p.names()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) > 1 || p.is("in") {
+		return p.genericFor(names, line)
+	}
+	name := names[0]
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	bounds := []luaExpr{}
+	for {
+		e, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, e)
+		if !p.accept(",") {
+			break
+		}
+	}
+	if len(bounds) < 2 || len(bounds) > 3 {
+		return nil, p.errorf("'do' expected")
+	}
+	body, err := p.doBlock()
+	if err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		values := []float64{0, 0, 1}
+		for i, e := range bounds {
+			v, err := e(sc)
+			if err != nil {
+				return luaNext, nil, err
+			}
+			n, ok := luaToNumber(v)
+			if !ok {
+				return luaNext, nil, &luaError{msg: "'for' initial value must be a number", line: line}
+			}
+			values[i] = n
+		}
+		start, limit, step := values[0], values[1], values[2]
+		for i := start; step > 0 && i <= limit || step <= 0 && i >= limit; i += step {
+			if err := sc.run.check(); err != nil {
+				return luaNext, nil, atLine(err, line)
+			}
+			ctrl, values, err := runLuaBlock(body, sc.child(map[string]any{name: i}))
+			if err != nil || ctrl == luaReturn {
+				return ctrl, values, err
+			}
+			if ctrl == luaBreak {
+				break
+			}
+		}
+		return luaNext, nil, nil
+	}, nil
+}
+
+// genericFor parses the rest of a generic for loop. Its expressions give
+// the iterator function, the state passed to it and the initial control
+// value, and the loop ends when the iterator returns nil.
+func (p *luaParser) genericFor(names []string, line int) (luaStmt, error) {
+	if err := p.expect("in"); err != nil {
+		return nil, err
+	}
+	explist, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	body, err := p.doBlock()
+	if err != nil {
+		return nil, err
+	}
+	return func(sc *luaScope) (int, []any, error) {
+		values, err := explist(sc)
+		if err != nil {
+			return luaNext, nil, err
+		}
+		var iterator [3]any
+		copy(iterator[:], values)
+		f, state, control := iterator[0], iterator[1], iterator[2]
+		for {
+			if err := sc.run.check(); err != nil {
+				return luaNext, nil, atLine(err, line)
+			}
+			results, err := luaCallValue(f, []any{state, control})
+			if err != nil {
+				return luaNext, nil, atLine(err, line)
+			}
+			if control = luaFirst(results); control == nil {
+				return luaNext, nil, nil
+			}
+			vars := make(map[string]any, len(names))
+			for i, name := range names {
+				vars[name] = nil
+				if i < len(results) {
+					vars[name] = results[i]
+				}
+			}
+			ctrl, values, err := runLuaBlock(body, sc.child(vars))
+			if err != nil || ctrl == luaReturn {
+				return ctrl, values, err
+			}
+			if ctrl == luaBreak {
+				return luaNext, nil, nil
+			}
+		}
+	}, nil
+}
+
+// Binary operator priorities, left and right, as in the Lua 5.1 parser, with
+// the floor division of Lua 5.3.
+var luaBinaryPriority = map[string][2]int{
+	"or": {1, 1}, "and": {2, 2},
+	"<": {3, 3}, ">": {3, 3}, "<=": {3, 3}, ">=": {3, 3}, "~=": {3, 3}, "==": {3, 3},
+	"..": {5, 4}, "+": {6, 6}, "-": {6, 6}, "*": {7, 7}, "/": {7, 7}, "//": {7, 7}, "%": {7, 7},
+	"^": {10, 9},
+}
+
+const luaUnaryPriority = 8
+
+func (p *luaParser) binaryOp() (string, bool) {
+	t := p.peek()
+	if t.kind != luaTokSymbol && t.kind != luaTokName {
+		return "", false
+	}
+	_, ok := luaBinaryPriority[t.text]
+	return t.text, ok
+}
+
+// expr parses an expression whose binary operators bind tighter than limit.
+func (p *luaParser) expr(limit int) (luaExpr, error) {
+	defer p.leave()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	var left luaExpr
+	line := p.peek().line
+	if p.is("not") || p.is("-") || p.is("#") {
+		op := p.next().text
+		operand, err := p.expr(luaUnaryPriority)
+		if err != nil {
+			return nil, err
+		}
+		left = luaUnary(op, operand, line)
+	} else {
+		var err error
+		if left, err = p.simpleExpr(); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		op, ok := p.binaryOp()
+		if !ok || luaBinaryPriority[op][0] <= limit {
+			return left, nil
+		}
+		line := p.next().line
+		right, err := p.expr(luaBinaryPriority[op][1])
+		if err != nil {
+			return nil, err
+		}
+		left = luaBinary(op, left, right, line)
+	}
+}
+
+// multiExpr parses an expression and, if it is a call or ..., returns it
+// with all the values it evaluates to.
+func (p *luaParser) multiExpr() (luaExpr, luaMultiExpr, error) {
+	start := p.pos
+	e, err := p.expr(0)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.multi.start == start && p.multi.end == p.pos && p.multi.expr != nil {
+		return e, p.multi.expr, nil
+	}
+	return e, nil, nil
+}
+
+// exprList parses a list of expressions separated by commas. The last one
+// is expanded to all its values, the others are cut to their first.
+func (p *luaParser) exprList() (luaMultiExpr, error) {
+	var exprs []luaExpr
+	for {
+		e, multi, err := p.multiExpr()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept(",") {
+			exprs = append(exprs, e)
+			continue
+		}
+		return func(sc *luaScope) ([]any, error) {
+			values := make([]any, 0, len(exprs)+1)
+			for _, e := range exprs {
+				v, err := e(sc)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, v)
+			}
+			if multi == nil {
+				v, err := e(sc)
+				return append(values, v), err
+			}
+			rest, err := multi(sc)
+			return append(values, rest...), err
+		}, nil
+	}
+}
+
+func (p *luaParser) simpleExpr() (luaExpr, error) {
+	t := p.peek()
+	switch {
+	case t.kind == luaTokNumber:
+		p.next()
+		return func(*luaScope) (any, error) { return t.num, nil }, nil
+	case t.kind == luaTokString:
+		p.next()
+		return func(*luaScope) (any, error) { return t.text, nil }, nil
+	case p.accept("nil"):
+		return func(*luaScope) (any, error) { return nil, nil }, nil
+	case p.accept("true"):
+		return func(*luaScope) (any, error) { return true, nil }, nil
+	case p.accept("false"):
+		return func(*luaScope) (any, error) { return false, nil }, nil
+	case p.is("..."):
+		if !p.vararg {
+			return nil, p.errorf("cannot use '...' outside a vararg function")
+		}
+		p.next()
+		varargs := func(sc *luaScope) ([]any, error) {
+			owner, _ := sc.lookup("...")
+			rest, _ := owner.vars["..."].([]any)
+			return rest, nil
+		}
+		p.multi.start, p.multi.end, p.multi.expr = p.pos-1, p.pos, varargs
+		return func(sc *luaScope) (any, error) {
+			rest, _ := varargs(sc)
+			return luaFirst(rest), nil
+		}, nil
+	case p.is("{"):
+		return p.tableConstructor()
+	case p.accept("function"):
+		return p.funcBody(false)
+	}
+	get, _, _, err := p.suffixedExpr()
+	return get, err
+}
+
+func (p *luaParser) tableConstructor() (luaExpr, error) {
+	p.next()
+	type field struct {
+		key, value luaExpr // key is nil for positional fields
+		// multi expands a call or ... ending the constructor.
+		multi luaMultiExpr
+	}
+	var fields []field
+	for !p.accept("}") {
+		var f field
+		switch {
+		case p.accept("["):
+			key, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			f.key = key
+		case p.peek().kind == luaTokName && p.tokens[p.pos+1].text == "=" && p.tokens[p.pos+1].kind == luaTokSymbol:
+			name := p.next().text
+			p.next()
+			f.key = func(*luaScope) (any, error) { return name, nil }
+		}
+		value, multi, err := p.multiExpr()
+		if err != nil {
+			return nil, err
+		}
+		f.value = value
+		if f.key == nil {
+			f.multi = multi
+		}
+		fields = append(fields, f)
+		if !p.accept(",") && !p.accept(";") {
+			if err := p.expect("}"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return func(sc *luaScope) (any, error) {
+		t := newLuaTable()
+		n := 0.0
+		for i, f := range fields {
+			if f.multi != nil && i == len(fields)-1 {
+				values, err := f.multi(sc)
+				if err != nil {
+					return nil, err
+				}
+				for _, value := range values {
+					n++
+					t.set(n, value)
+				}
+				continue
+			}
+			value, err := f.value(sc)
+			if err != nil {
+				return nil, err
+			}
+			if f.key == nil {
+				n++
+				t.set(n, value)
+				continue
+			}
+			key, err := f.key(sc)
+			if err != nil {
+				return nil, err
+			}
+			if key == nil {
+				return nil, luaErrorf("table index is nil")
+			}
+			t.set(key, value)
+		}
+		return t, nil
+	}, nil
+}
+
+// suffixedExpr parses a name or parenthesized expression followed by any
+// number of field accesses, indexes, calls and method calls. set is nil
+// unless the expression can be assigned to, and isCall is set if it ends
+// with a call.
+func (p *luaParser) suffixedExpr() (get luaExpr, set func(*luaScope, any) error, isCall bool, err error) {
+	start := p.pos
+	line := p.peek().line
+	if p.accept("(") {
+		inner, err := p.expr(0)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, nil, false, err
+		}
+		get = inner
+	} else {
+		name, err := p.name()
+		if err != nil {
+			return nil, nil, false, err
+		}
+		get = func(sc *luaScope) (any, error) {
+			owner, ok := sc.lookup(name)
+			if !ok {
+				return nil, &luaError{msg: fmt.Sprintf("Script attempted to access nonexistent global variable '%s'", name), line: line}
+			}
+			return owner.vars[name], nil
+		}
+		set = func(sc *luaScope, v any) error {
+			owner, ok := sc.lookup(name)
+			if !ok || owner.parent == nil {
+				return luaErrorf("Script attempted to create global variable '%s'", name)
+			}
+			owner.vars[name] = v
+			return nil
+		}
+	}
+
+	for {
+		line := p.peek().line
+		switch {
+		case p.accept("."), p.is("["):
+			var key luaExpr
+			if p.accept("[") {
+				if key, err = p.expr(0); err != nil {
+					return nil, nil, false, err
+				}
+				if err := p.expect("]"); err != nil {
+					return nil, nil, false, err
+				}
+			} else {
+				name, err := p.name()
+				if err != nil {
+					return nil, nil, false, err
+				}
+				key = func(*luaScope) (any, error) { return name, nil }
+			}
+			object := get
+			get = func(sc *luaScope) (any, error) {
+				o, err := object(sc)
+				if err != nil {
+					return nil, err
+				}
+				k, err := key(sc)
+				if err != nil {
+					return nil, err
+				}
+				v, err := luaIndex(sc.run, o, k)
+				return v, atLine(err, line)
+			}
+			set = func(sc *luaScope, v any) error {
+				o, err := object(sc)
+				if err != nil {
+					return err
+				}
+				k, err := key(sc)
+				if err != nil {
+					return err
+				}
+				return luaSetIndex(o, k, v)
+			}
+			isCall = false
+		case p.is("("), p.peek().kind == luaTokString, p.is("{"):
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, nil, false, err
+			}
+			call := luaCall(get, args, line)
+			p.multi.start, p.multi.end, p.multi.expr = start, p.pos, call
+			get = func(sc *luaScope) (any, error) {
+				values, err := call(sc)
+				return luaFirst(values), err
+			}
+			set, isCall = nil, true
+		case p.accept(":"):
+			name, err := p.name()
+			if err != nil {
+				return nil, nil, false, err
+			}
+			args, err := p.callArgs()
+			if err != nil {
+				return nil, nil, false, err
+			}
+			call := luaMethodCall(get, name, args, line)
+			p.multi.start, p.multi.end, p.multi.expr = start, p.pos, call
+			get = func(sc *luaScope) (any, error) {
+				values, err := call(sc)
+				return luaFirst(values), err
+			}
+			set, isCall = nil, true
+		default:
+			return get, set, isCall, nil
+		}
+	}
+}
+
+// callArgs parses the arguments of a call: a list of expressions in
+// parentheses, a string or a table constructor. They are nil for none.
+func (p *luaParser) callArgs() (luaMultiExpr, error) {
+	if !p.accept("(") {
+		arg, err := p.simpleExpr()
+		if err != nil {
+			return nil, err
+		}
+		return func(sc *luaScope) ([]any, error) {
+			v, err := arg(sc)
+			return []any{v}, err
+		}, nil
+	}
+	if p.accept(")") {
+		return nil, nil
+	}
+	args, err := p.exprList()
+	if err != nil {
+		return nil, err
+	}
+	return args, p.expect(")")
+}
+
+func luaCall(function luaExpr, args luaMultiExpr, line int) luaMultiExpr {
+	return func(sc *luaScope) ([]any, error) {
+		f, err := function(sc)
+		if err != nil {
+			return nil, err
+		}
+		var values []any
+		if args != nil {
+			if values, err = args(sc); err != nil {
+				return nil, err
+			}
+		}
+		results, err := luaCallValue(f, values)
+		return results, atLine(err, line)
+	}
+}
+
+// luaMethodCall calls the method name of the value of object with the
+// value as its first argument.
+func luaMethodCall(object luaExpr, name string, args luaMultiExpr, line int) luaMultiExpr {
+	return func(sc *luaScope) ([]any, error) {
+		o, err := object(sc)
+		if err != nil {
+			return nil, err
+		}
+		f, err := luaIndex(sc.run, o, name)
+		if err != nil {
+			return nil, atLine(err, line)
+		}
+		if f == nil {
+			return nil, &luaError{msg: fmt.Sprintf("attempt to call method '%s' (a nil value)", name), line: line}
+		}
+		values := []any{o}
+		if args != nil {
+			rest, err := args(sc)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, rest...)
+		}
+		results, err := luaCallValue(f, values)
+		return results, atLine(err, line)
+	}
+}
+
+func luaUnary(op string, operand luaExpr, line int) luaExpr {
+	return func(sc *luaScope) (any, error) {
+		v, err := operand(sc)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "not":
+			return !luaTruthy(v), nil
+		case "-":
+			n, ok := luaToNumber(v)
+			if !ok {
+				return nil, &luaError{msg: fmt.Sprintf("attempt to perform arithmetic on a %s value", luaTypeName(v)), line: line}
+			}
+			return -n, nil
+		}
+		switch v := v.(type) {
+		case string:
+			return float64(len(v)), nil
+		case *luaTable:
+			return float64(len(v.array)), nil
+		}
+		return nil, &luaError{msg: fmt.Sprintf("attempt to get length of a %s value", luaTypeName(v)), line: line}
+	}
+}
+
+// luaLess reports whether a < b, or a <= b if orEqual is set, for two
+// numbers or two strings.
+func luaLess(a, b any, orEqual bool) (bool, error) {
+	switch x := a.(type) {
+	case float64:
+		y, ok := b.(float64)
+		if !ok {
+			return false, luaErrorf("attempt to compare %s with %s", luaTypeName(a), luaTypeName(b))
+		}
+		return x < y || orEqual && x == y, nil
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return false, luaErrorf("attempt to compare %s with %s", luaTypeName(a), luaTypeName(b))
+		}
+		return x < y || orEqual && x == y, nil
+	}
+	if luaTypeName(a) != luaTypeName(b) {
+		return false, luaErrorf("attempt to compare %s with %s", luaTypeName(a), luaTypeName(b))
+	}
+	return false, luaErrorf("attempt to compare two %s values", luaTypeName(a))
+}
+
+func luaBinary(op string, left, right luaExpr, line int) luaExpr {
+	return func(sc *luaScope) (any, error) {
+		a, err := left(sc)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "and":
+			if !luaTruthy(a) {
+				return a, nil
+			}
+			return right(sc)
+		case "or":
+			if luaTruthy(a) {
+				return a, nil
+			}
+			return right(sc)
+		}
+		b, err := right(sc)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "==":
+			return a == b, nil
+		case "~=":
+			return a != b, nil
+		case "..":
+			x, okA := luaToString(a)
+			y, okB := luaToString(b)
+			if !okA || !okB {
+				bad := a
+				if okA {
+					bad = b
+				}
+				return nil, &luaError{msg: fmt.Sprintf("attempt to concatenate a %s value", luaTypeName(bad)), line: line}
+			}
+			return x + y, nil
+		case "<", "<=", ">", ">=":
+			if op == ">" || op == ">=" {
+				a, b = b, a
+			}
+			less, err := luaLess(a, b, op == "<=" || op == ">=")
+			return less, atLine(err, line)
+		}
+		x, okA := luaToNumber(a)
+		y, okB := luaToNumber(b)
+		if !okA || !okB {
+			bad := a
+			if okA {
+				bad = b
+			}
+			return nil, &luaError{msg: fmt.Sprintf("attempt to perform arithmetic on a %s value", luaTypeName(bad)), line: line}
+		}
+		switch op {
+		case "+":
+			return x + y, nil
+		case "-":
+			return x - y, nil
+		case "*":
+			return x * y, nil
+		case "/":
+			return x / y, nil
+		case "//":
+			return math.Floor(x / y), nil
+		case "%":
+			return x - math.Floor(x/y)*y, nil
+		}
+		return math.Pow(x, y), nil
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// runLua runs src with the Lua library and returns what it returns.
+func runLua(t *testing.T, src string) (any, error) {
+	t.Helper()
+	chunk, err := compileLua(src)
+	if err != nil {
+		t.Fatalf("compiling %q: %v", src, err)
+	}
+	return chunk.run(luaLibrary(), nil)
+}
+
+func TestLuaStatements(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want any
+	}{
+		// Generic for, with pairs visiting the array and then the sorted
+		// hash, and ipairs stopping at the first nil.
+		{"local t = {} for k, v in pairs({10, 20, b = 2, a = 1}) do t[#t+1] = k .. '=' .. v end return table.concat(t, ',')", "1=10,2=20,a=1,b=2"},
+		{"local n = 0 for i, v in ipairs({1, 2, nil, 4}) do n = n + v end return n", 3.0},
+		{"local k, v = next({}) return k == nil", true},
+		{"local t = {x = 1} local k, v = next(t) return k .. v", "x1"},
+		// An iterator function of the script's own.
+		{"local function range(n) local i = 0 return function() i = i + 1 if i <= n then return i end end end local s = 0 for i in range(4) do s = s + i end return s", 10.0},
+		// repeat sees the locals of its body in its condition.
+		{"local i = 0 repeat local j = i i = i + 1 until j >= 3 return i", 4.0},
+		{"local i = 0 repeat i = i + 1 if i == 2 then break end until false return i", 2.0},
+		{"return 7 // 2", 3.0},
+		{"return -7 // 2", -4.0},
+		{"return 7.5 // 2 + 2 ^ 2", 7.0},
+		// Multiple values, varargs and select.
+		{"local function f() return 1, 2, 3 end local a, b, c, d = f() return a + b + c + (d == nil and 10 or 0)", 16.0},
+		{"local function f() return 1, 2 end local t = {f(), f()} return #t", 3.0},
+		{"local function f() return 1, 2 end return select('#', (f()))", 1.0},
+		{"local function f(...) return select('#', ...) end return f(nil, nil, 3)", 3.0},
+		{"local function f(...) local a, b = ... return b end return f(1, 2)", 2.0},
+		{"return select(-1, 'a', 'b', 'c')", "c"},
+		{"return select(2, 'a', 'b', 'c')", "b"},
+		{"local a, b = 1, 2 a, b = b, a return a * 10 + b", 21.0},
+		{"return unpack({1, 2, 3}) + select(2, unpack({1, 2, 3}))", 3.0},
+		// pcall catches errors and returns what the function returned.
+		{"local ok, err = pcall(error, 'boom') return tostring(ok) .. ' ' .. err", "false boom"},
+		{"local ok, err = pcall(function() error('boom') end) return err", "user_script:1: boom"},
+		{"local ok, err = pcall(function() error({code = 42}) end) return err.code", 42.0},
+		{"local ok, a, b = pcall(function() return 1, 2 end) return tostring(ok) .. a .. b", "true12"},
+		{"local ok, err = pcall(function() local x = nil return x.y end) return err", "user_script:1: attempt to index a nil value"},
+		{"return select('#', pcall(error))", 2.0},
+		// Metatables.
+		{"local t = setmetatable({}, {__index = function(t, k) return k .. '!' end}) return t.hi", "hi!"},
+		{"local base = {greet = function(self) return 'hi ' .. self.name end} local o = setmetatable({name = 'bob'}, {__index = base}) return o:greet()", "hi bob"},
+		{"local log = {} local t = setmetatable({}, {__newindex = function(t, k, v) rawset(t, k, v * 2) end}) t.x = 2 return t.x", 4.0},
+		{"local t = setmetatable({}, {__call = function(self, a) return a + 1 end}) return t(1)", 2.0},
+		{"return tostring(setmetatable({}, {__tostring = function() return 'obj' end}))", "obj"},
+		{"local mt = {} local t = setmetatable({}, mt) return getmetatable(t) == mt", true},
+		{"local t = setmetatable({}, {__index = {a = 1}}) return rawget(t, 'a') == nil and t.a == 1", true},
+		{"local obj = {n = 0} function obj:inc(by) self.n = self.n + by return self end return obj:inc(2):inc(3).n", 5.0},
+		// tonumber.
+		{"return tonumber('  10  ')", 10.0},
+		{"return tonumber('0x1F')", 31.0},
+		{"return tonumber('-0x10')", -16.0},
+		{"return tonumber('1e2')", 100.0},
+		{"return tonumber('10', 2)", 2.0},
+		{"return tonumber('ff', 16)", 255.0},
+		{"return tonumber('zz', 36)", 1295.0},
+		{"return tonumber('8', 8)", nil},
+		{"return tonumber('')", nil},
+		{"return tonumber('1e')", nil},
+		{"return tonumber('10 1')", nil},
+		{"return tonumber('1_000')", nil},
+		{"return tonumber({})", nil},
+		{"return tonumber(nil)", nil},
+	} {
+		got, err := runLua(t, tc.src)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %#v, %v, want %#v", tc.src, got, err, tc.want)
+		}
+	}
+}
+
+func TestLuaLibraries(t *testing.T) {
+	for _, tc := range []struct {
+		src  string
+		want any
+	}{
+		{"return string.len('abc') + #'de'", 5.0},
+		{"return ('abc'):upper() .. string.lower('DE')", "ABCde"},
+		{"return string.sub('hello', 2, -2)", "ell"},
+		{"return ('hello'):sub(-3)", "llo"},
+		{"return string.rep('ab', 3)", "ababab"},
+		{"return string.reverse('abc')", "cba"},
+		{"return string.char(string.byte('ABC', 1, 3))", "ABC"},
+		{"return string.format('%d %5.2f %s %x %q %%', 3.9, 3.14159, 'x', 255, 'a\"b')", `3  3.14 x ff "a\"b" %`},
+		{"return string.format('%g %g %05d %-3s|', 0.1, 1e20, 42, 'a')", "0.1 1e+20 00042 a  |"},
+		{"return string.find('hello world', 'o w')", 5.0},
+		{"local s, e = string.find('hello', 'l+') return s * 10 + e", 34.0},
+		{"return string.find('a.b', '.', 1, true)", 2.0},
+		{"return string.find('abc', 'x')", nil},
+		{"return string.match('key:123', '(%a+):(%d+)')", "key"},
+		{"local k, v = string.match('key:123', '(%a+):(%d+)') return v", "123"},
+		{"return string.match('  trim  ', '^%s*(.-)%s*$')", "trim"},
+		{"return string.match('f(a(b)c)', '%b()')", "(a(b)c)"},
+		{"return string.match('THE (quick) fox', '%f[%a]%a+', 5)", "quick"},
+		{"return string.match('hello', '()ll()')", 3.0},
+		{"local t = {} for w in string.gmatch('one two three', '%a+') do t[#t+1] = w end return table.concat(t, '|')", "one|two|three"},
+		{"local t = {} for k, v in string.gmatch('a=1, b=2', '(%w+)=(%w+)') do t[#t+1] = v .. k end return table.concat(t)", "1a2b"},
+		{"return (string.gsub('hello world', 'o', '0'))", "hell0 w0rld"},
+		{"return select(2, string.gsub('hello world', 'o', '0'))", 2.0},
+		{"return (string.gsub('hello', 'l', 'L', 1))", "heLlo"},
+		{"return (string.gsub('abc', '%w', '%0%0'))", "aabbcc"},
+		{"return (string.gsub('$name is $age', '%$(%w+)', {name = 'bob', age = 3}))", "bob is 3"},
+		{"return (string.gsub('a b', '%w', function(c) return c:upper() end))", "A B"},
+		{"return (string.gsub('abc', '', '-'))", "-a-b-c-"},
+		{"return (string.gsub('hello', '^h', 'H'))", "Hello"},
+		{"return math.floor(3.7) + math.ceil(3.2) + math.abs(-1)", 8.0},
+		{"return math.max(1, 5, 3) - math.min(4, 2, 6)", 3.0},
+		{"return math.fmod(7, 3) + math.sqrt(16) + math.pow(2, 3)", 13.0},
+		{"local i, f = math.modf(3.25) return i + f * 4", 4.0},
+		{"return math.huge > 1e308 and math.pi > 3.14", true},
+		{"local r = math.random(1, 10) return r >= 1 and r <= 10 and r == math.floor(r)", true},
+		{"local r = math.random() return r >= 0 and r < 1", true},
+		{"local t = {3, 1, 2} table.sort(t) return table.concat(t, ',')", "1,2,3"},
+		{"local t = {'b', 'c', 'a'} table.sort(t, function(a, b) return a > b end) return table.concat(t)", "cba"},
+		{"local t = {1, 2} table.insert(t, 3) table.insert(t, 1, 0) return table.concat(t, ',')", "0,1,2,3"},
+		{"local t = {1, 2, 3} local v = table.remove(t, 1) return v .. ':' .. table.concat(t, ',')", "1:2,3"},
+		{"local t = {1, 2, 3} return table.remove(t) + #t", 5.0},
+		{"return table.remove({}) == nil", true},
+		{"return table.getn({1, 2}) + table.maxn({[10] = 1})", 12.0},
+		{"return cjson.encode({1, 2, 'x'})", `[1,2,"x"]`},
+		{"return cjson.encode({a = 1, b = {true, false}})", `{"a":1,"b":[true,false]}`},
+		{"return cjson.encode({}) .. cjson.encode('a/b\\n') .. cjson.encode(1.5)", `{}"a\/b\n"1.5`},
+		{"return cjson.decode('{\"a\":[1,2,{\"b\":\"c\"}]}').a[3].b", "c"},
+		{"local t = cjson.decode('[1,2,3]') return #t + t[3]", 6.0},
+		{"return cjson.decode('{\"x\": null}').x == nil", true},
+		{"return cjson.decode(cjson.encode({n = 12345, s = 'é'})).s", "é"},
+	} {
+		got, err := runLua(t, tc.src)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: got %#v, %v, want %#v", tc.src, got, err, tc.want)
+		}
+	}
+}
+
+func TestLuaErrors(t *testing.T) {
+	for _, tc := range []struct {
+		src, want string
+	}{
+		{"return string.rep()", "bad argument #1 to 'rep' (string expected, got no value)"},
+		{"return math.floor('x')", "bad argument #1 to 'floor' (number expected, got string)"},
+		{"return tonumber('10', 99)", "bad argument #2 to 'tonumber' (base out of range)"},
+		{"return select(0, 'a')", "bad argument #1 to 'select' (index out of range)"},
+		{"return setmetatable(1, {})", "bad argument #1 to 'setmetatable' (table expected, got number)"},
+		{"local t = setmetatable({}, {__metatable = 'locked'}) return setmetatable(t, {})", "cannot change a protected metatable"},
+		{"return string.format('%y', 1)", "invalid option '%y' to 'format'"},
+		{"return string.find('a', '[a')", "malformed pattern (missing ']')"},
+		{"return string.find('a', '%')", "malformed pattern (ends with '%')"},
+		{"return string.match('a', '(a')", "unfinished capture"},
+		{"return string.gsub('a', '(a)', '%2')", "invalid capture index"},
+		{"return table.concat({1, {}})", "invalid value (at index 2) in table for 'concat'"},
+		{"local t = {1, 'a'} table.sort(t) return 1", "attempt to compare string with number"},
+		{"return cjson.encode(function() end)", "Cannot serialise function: type not supported"},
+		{"return cjson.encode({[1] = 1, [100] = 2})", "Cannot serialise table: excessively sparse array"},
+		{"return cjson.decode('{')", "Expected value but found invalid token at character 1"},
+		{"assert(false, 'nope')", "nope"},
+		{"assert(nil)", "assertion failed!"},
+		{"local t = setmetatable({}, {__newindex = function() error('read only') end}) t.x = 1", "read only"},
+		{"for k in pairs(nil) do end", "bad argument #1 to 'pairs' (table expected, got nil)"},
+		{"local x = ('a'):nosuch()", "attempt to call method 'nosuch' (a nil value)"},
+	} {
+		_, err := runLua(t, tc.src)
+		switch {
+		case tc.want == "" && err != nil:
+			t.Errorf("%s: %v", tc.src, err)
+		case tc.want != "" && (err == nil || !strings.Contains(err.Error(), tc.want)):
+			t.Errorf("%s: got %v, want %q", tc.src, err, tc.want)
+		}
+	}
+
+	for _, src := range []string{
+		"local function f() return ... end",
+		"repeat local x = 1",
+		"for k, v = 1, 2 do end",
+		"f(), g = 1",
+	} {
+		if _, err := compileLua(src); err == nil {
+			t.Errorf("%s: compiled", src)
+		}
+	}
+}
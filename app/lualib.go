@@ -0,0 +1,871 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// luaLibrary returns the global functions of Lua 5.1 that scripts can use,
+// and the string, math, table and cjson libraries. math.random starts from
+// the same seed in every script, as in redis, so that a script replicated
+// as is draws the same numbers on the replicas.
+func luaLibrary() map[string]any {
+	return map[string]any{
+		"assert":       &luaBuiltin{name: "assert", fn: luaAssert},
+		"error":        luaFunc("error", luaErrorFunc),
+		"getmetatable": luaFunc("getmetatable", luaGetMetatable),
+		"ipairs":       &luaBuiltin{name: "ipairs", fn: luaIpairs},
+		"next":         &luaBuiltin{name: "next", fn: luaNextFunc},
+		"pairs":        &luaBuiltin{name: "pairs", fn: luaPairs},
+		"pcall":        &luaBuiltin{name: "pcall", fn: luaPcall},
+		"rawequal":     luaFunc("rawequal", luaRawEqual),
+		"rawget":       luaFunc("rawget", luaRawGet),
+		"rawset":       luaFunc("rawset", luaRawSet),
+		"select":       &luaBuiltin{name: "select", fn: luaSelect},
+		"setmetatable": luaFunc("setmetatable", luaSetMetatable),
+		"tonumber":     luaFunc("tonumber", luaTonumber),
+		"tostring": luaFunc("tostring", func(args []any) (any, error) {
+			if len(args) == 0 {
+				return nil, luaArgError(1, "tostring", "value expected")
+			}
+			return luaToStringMeta(args[0])
+		}),
+		"type": luaFunc("type", func(args []any) (any, error) {
+			if len(args) == 0 {
+				return nil, luaArgError(1, "type", "value expected")
+			}
+			return luaTypeName(args[0]), nil
+		}),
+		"unpack":   &luaBuiltin{name: "unpack", fn: luaUnpack},
+		"string":   luaStringLibrary(),
+		"table":    luaTableLibrary(),
+		"math":     luaMathLibrary(rand.New(rand.NewSource(0))),
+		"cjson":    luaCjsonLibrary(),
+		"_VERSION": "Lua 5.1",
+	}
+}
+
+// luaLibraryTable returns a table of the functions fns.
+func luaLibraryTable(fns map[string]*luaBuiltin) *luaTable {
+	t := newLuaTable()
+	for name, fn := range fns {
+		t.set(name, fn)
+	}
+	return t
+}
+
+// Arguments of the built in functions. i is 0-based, and the messages count
+// from 1 like Lua.
+
+func luaArgError(i int, name, msg string) error {
+	return luaErrorf("bad argument #%d to '%s' (%s)", i, name, msg)
+}
+
+// luaArgTypeError fails argument i of name, which is not of the type want.
+func luaArgTypeError(args []any, i int, name, want string) error {
+	got := "no value"
+	if i < len(args) {
+		got = luaTypeName(args[i])
+	}
+	return luaArgError(i+1, name, fmt.Sprintf("%s expected, got %s", want, got))
+}
+
+func luaArg(args []any, i int) any {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+func luaCheckString(args []any, i int, name string) (string, error) {
+	if s, ok := luaToString(luaArg(args, i)); ok {
+		return s, nil
+	}
+	return "", luaArgTypeError(args, i, name, "string")
+}
+
+func luaCheckNumber(args []any, i int, name string) (float64, error) {
+	if n, ok := luaToNumber(luaArg(args, i)); ok {
+		return n, nil
+	}
+	return 0, luaArgTypeError(args, i, name, "number")
+}
+
+// luaCheckInt is argument i as an integer, truncated like lua_tointeger.
+func luaCheckInt(args []any, i int, name string) (int, error) {
+	n, err := luaCheckNumber(args, i, name)
+	return int(n), err
+}
+
+// luaOptInt is argument i as an integer, def if it is nil or missing.
+func luaOptInt(args []any, i int, name string, def int) (int, error) {
+	if luaArg(args, i) == nil {
+		return def, nil
+	}
+	return luaCheckInt(args, i, name)
+}
+
+func luaCheckTable(args []any, i int, name string) (*luaTable, error) {
+	if t, ok := luaArg(args, i).(*luaTable); ok {
+		return t, nil
+	}
+	return nil, luaArgTypeError(args, i, name, "table")
+}
+
+// luaToStringMeta converts v to a string like tostring, with the __tostring
+// metamethod of a table that has one.
+func luaToStringMeta(v any) (string, error) {
+	if t, ok := v.(*luaTable); ok {
+		if handler := t.metamethod("__tostring"); handler != nil {
+			values, err := luaCallValue(handler, []any{t})
+			if err != nil {
+				return "", err
+			}
+			str, ok := luaFirst(values).(string)
+			if !ok {
+				return "", luaErrorf("'__tostring' must return a string")
+			}
+			return str, nil
+		}
+	}
+	if str, ok := luaToString(v); ok {
+		return str, nil
+	}
+	switch v {
+	case nil:
+		return "nil", nil
+	case true, false:
+		return fmt.Sprint(v), nil
+	}
+	return fmt.Sprintf("%s: %p", luaTypeName(v), v), nil
+}
+
+// The base functions.
+
+func luaAssert(args []any) ([]any, error) {
+	if len(args) == 0 {
+		return nil, luaArgError(1, "assert", "value expected")
+	}
+	if luaTruthy(args[0]) {
+		return args, nil
+	}
+	if len(args) < 2 {
+		return nil, luaErrorf("assertion failed!")
+	}
+	msg, ok := luaToString(args[1])
+	if !ok {
+		return nil, luaArgTypeError(args, 1, "assert", "string")
+	}
+	return nil, luaErrorf("%s", msg)
+}
+
+// luaErrorFunc implements error(message [, level]). A table with an err
+// field is raised as the error reply it holds, like redis.error_reply, and
+// level 0 leaves the line out of the message.
+func luaErrorFunc(args []any) (any, error) {
+	v := luaArg(args, 0)
+	level, err := luaOptInt(args, 1, "error", 1)
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := v.(*luaTable); ok {
+		if msg, ok := t.get("err").(string); ok {
+			return nil, &luaError{msg: msg, fromReply: true, value: t}
+		}
+	}
+	msg, ok := luaToString(v)
+	switch {
+	case !ok:
+		return nil, &luaError{msg: fmt.Sprintf("(error object is a %s value)", luaTypeName(v)), value: v}
+	case level == 0:
+		return nil, &luaError{msg: msg, value: msg}
+	}
+	return nil, &luaError{msg: msg}
+}
+
+func luaGetMetatable(args []any) (any, error) {
+	t, ok := luaArg(args, 0).(*luaTable)
+	if !ok || t.meta == nil {
+		return nil, nil
+	}
+	if protected := t.meta.get("__metatable"); protected != nil {
+		return protected, nil
+	}
+	return t.meta, nil
+}
+
+func luaSetMetatable(args []any) (any, error) {
+	t, err := luaCheckTable(args, 0, "setmetatable")
+	if err != nil {
+		return nil, err
+	}
+	meta, ok := luaArg(args, 1).(*luaTable)
+	if !ok && luaArg(args, 1) != nil {
+		return nil, luaArgError(2, "setmetatable", "nil or table expected")
+	}
+	if t.metamethod("__metatable") != nil {
+		return nil, luaErrorf("cannot change a protected metatable")
+	}
+	t.meta = meta
+	return t, nil
+}
+
+// keys returns the keys of t in the order next and pairs visit them: the
+// array part, then the numbers, the strings, the booleans and the other
+// keys of the hash part.
+func (t *luaTable) keys() []any {
+	keys := make([]any, 0, len(t.array)+len(t.hash))
+	for i := range t.array {
+		keys = append(keys, float64(i+1))
+	}
+	hash := make([]any, 0, len(t.hash))
+	for k := range t.hash {
+		hash = append(hash, k)
+	}
+	rank := func(k any) int {
+		switch k.(type) {
+		case float64:
+			return 0
+		case string:
+			return 1
+		case bool:
+			return 2
+		}
+		return 3
+	}
+	sort.Slice(hash, func(i, j int) bool {
+		a, b := hash[i], hash[j]
+		if rank(a) != rank(b) {
+			return rank(a) < rank(b)
+		}
+		switch a := a.(type) {
+		case float64:
+			return a < b.(float64)
+		case string:
+			return a < b.(string)
+		case bool:
+			return !a && b.(bool)
+		}
+		return fmt.Sprintf("%p", a) < fmt.Sprintf("%p", b)
+	})
+	return append(keys, hash...)
+}
+
+// luaNextFunc implements next(table [, key]), which returns the key after
+// key and its value, or nil after the last one.
+func luaNextFunc(args []any) ([]any, error) {
+	t, err := luaCheckTable(args, 0, "next")
+	if err != nil {
+		return nil, err
+	}
+	keys := t.keys()
+	i := 0
+	if key := luaArg(args, 1); key != nil {
+		for i < len(keys) && keys[i] != key {
+			i++
+		}
+		if i == len(keys) {
+			return nil, luaErrorf("invalid key to 'next'")
+		}
+		i++
+	}
+	for ; i < len(keys); i++ {
+		if v := t.get(keys[i]); v != nil {
+			return []any{keys[i], v}, nil
+		}
+	}
+	return []any{nil}, nil
+}
+
+// luaPairs implements pairs(table). Its iterator walks the keys the table
+// has when the loop starts, skipping those cleared since, like next.
+func luaPairs(args []any) ([]any, error) {
+	t, err := luaCheckTable(args, 0, "pairs")
+	if err != nil {
+		return nil, err
+	}
+	keys, i := t.keys(), 0
+	iterator := &luaBuiltin{name: "next", fn: func([]any) ([]any, error) {
+		for ; i < len(keys); i++ {
+			if v := t.get(keys[i]); v != nil {
+				i++
+				return []any{keys[i-1], v}, nil
+			}
+		}
+		return []any{nil}, nil
+	}}
+	return []any{iterator, t, nil}, nil
+}
+
+var luaIpairsIterator = &luaBuiltin{name: "ipairs_iterator", fn: func(args []any) ([]any, error) {
+	t, err := luaCheckTable(args, 0, "ipairs")
+	if err != nil {
+		return nil, err
+	}
+	i, _ := luaToNumber(luaArg(args, 1))
+	if v := t.get(i + 1); v != nil {
+		return []any{i + 1, v}, nil
+	}
+	return []any{nil}, nil
+}}
+
+// luaIpairs implements ipairs(table), which walks t[1], t[2], ... up to
+// the first nil.
+func luaIpairs(args []any) ([]any, error) {
+	t, err := luaCheckTable(args, 0, "ipairs")
+	if err != nil {
+		return nil, err
+	}
+	return []any{luaIpairsIterator, t, 0.0}, nil
+}
+
+// luaPcall implements pcall(f, ...), which returns true and what f returns,
+// or false and the error f raised.
+func luaPcall(args []any) ([]any, error) {
+	if len(args) == 0 {
+		return nil, luaArgError(1, "pcall", "value expected")
+	}
+	results, err := luaCallValue(args[0], args[1:])
+	if err == nil {
+		return append([]any{true}, results...), nil
+	}
+	var e *luaError
+	if !errors.As(err, &e) || e.fatal {
+		return nil, err
+	}
+	return []any{false, e.raised()}, nil
+}
+
+func luaRawEqual(args []any) (any, error) {
+	if len(args) < 2 {
+		return nil, luaArgError(len(args)+1, "rawequal", "value expected")
+	}
+	return args[0] == args[1], nil
+}
+
+func luaRawGet(args []any) (any, error) {
+	t, err := luaCheckTable(args, 0, "rawget")
+	if err != nil {
+		return nil, err
+	}
+	return t.get(luaArg(args, 1)), nil
+}
+
+func luaRawSet(args []any) (any, error) {
+	t, err := luaCheckTable(args, 0, "rawset")
+	if err != nil {
+		return nil, err
+	}
+	switch k := luaArg(args, 1).(type) {
+	case nil:
+		return nil, luaErrorf("table index is nil")
+	case float64:
+		if math.IsNaN(k) {
+			return nil, luaErrorf("table index is NaN")
+		}
+	}
+	t.set(args[1], luaArg(args, 2))
+	return t, nil
+}
+
+// luaSelect implements select(n, ...), which returns the arguments from the
+// nth, counting from the end if n is negative, and select('#', ...), which
+// returns their number.
+func luaSelect(args []any) ([]any, error) {
+	if s, ok := luaArg(args, 0).(string); ok && s == "#" {
+		return []any{float64(len(args) - 1)}, nil
+	}
+	i, err := luaCheckInt(args, 0, "select")
+	if err != nil {
+		return nil, err
+	}
+	if n := len(args); i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	if i < 1 {
+		return nil, luaArgError(1, "select", "index out of range")
+	}
+	return args[i:], nil
+}
+
+// luaTonumber implements tonumber(v [, base]). Without a base, it converts
+// numbers and the strings Lua reads as numbers. With one, from 2 to 36, it
+// reads an integer in that base, with white space around it.
+func luaTonumber(args []any) (any, error) {
+	if len(args) == 0 {
+		return nil, luaArgError(1, "tonumber", "value expected")
+	}
+	if luaArg(args, 1) == nil {
+		if n, ok := luaToNumber(args[0]); ok {
+			return n, nil
+		}
+		return nil, nil
+	}
+	base, err := luaCheckInt(args, 1, "tonumber")
+	if err != nil {
+		return nil, err
+	}
+	if base < 2 || base > 36 {
+		return nil, luaArgError(2, "tonumber", "base out of range")
+	}
+	s, err := luaCheckString(args, 0, "tonumber")
+	if err != nil {
+		return nil, err
+	}
+	s = strings.Trim(s, luaSpace)
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || strings.ContainsAny(digits, "+-_") {
+		return nil, nil
+	}
+	n, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		return nil, nil
+	}
+	if digits != s {
+		return -float64(n), nil
+	}
+	return float64(n), nil
+}
+
+// luaMaxUnpack is the most values unpack returns, the size of the C stack
+// of Lua 5.1.
+const luaMaxUnpack = 8000
+
+// luaUnpack implements unpack(t [, i [, j]]), which returns t[i] to t[j],
+// by default all of its array.
+func luaUnpack(args []any) ([]any, error) {
+	t, err := luaCheckTable(args, 0, "unpack")
+	if err != nil {
+		return nil, err
+	}
+	i, err := luaOptInt(args, 1, "unpack", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := luaOptInt(args, 2, "unpack", len(t.array))
+	if err != nil {
+		return nil, err
+	}
+	if i > j {
+		return nil, nil
+	}
+	if j-i >= luaMaxUnpack {
+		return nil, luaErrorf("too many results to unpack")
+	}
+	values := make([]any, 0, j-i+1)
+	for k := i; k <= j; k++ {
+		values = append(values, t.get(float64(k)))
+	}
+	return values, nil
+}
+
+// The table library.
+
+func luaTableLibrary() *luaTable {
+	return luaLibraryTable(map[string]*luaBuiltin{
+		"concat": luaFunc("concat", luaTableConcat),
+		"getn": luaFunc("getn", func(args []any) (any, error) {
+			t, err := luaCheckTable(args, 0, "getn")
+			if err != nil {
+				return nil, err
+			}
+			return float64(len(t.array)), nil
+		}),
+		"insert": luaFunc("insert", luaTableInsert),
+		"maxn": luaFunc("maxn", func(args []any) (any, error) {
+			t, err := luaCheckTable(args, 0, "maxn")
+			if err != nil {
+				return nil, err
+			}
+			maxn := float64(len(t.array))
+			for k := range t.hash {
+				if n, ok := k.(float64); ok && n > maxn {
+					maxn = n
+				}
+			}
+			return maxn, nil
+		}),
+		"remove": {name: "remove", fn: luaTableRemove},
+		"sort":   luaFunc("sort", luaTableSort),
+	})
+}
+
+// luaTableConcat implements table.concat(t [, sep [, i [, j]]]).
+func luaTableConcat(args []any) (any, error) {
+	t, err := luaCheckTable(args, 0, "concat")
+	if err != nil {
+		return nil, err
+	}
+	sep := ""
+	if luaArg(args, 1) != nil {
+		if sep, err = luaCheckString(args, 1, "concat"); err != nil {
+			return nil, err
+		}
+	}
+	i, err := luaOptInt(args, 2, "concat", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := luaOptInt(args, 3, "concat", len(t.array))
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	for k := i; k <= j; k++ {
+		str, ok := luaToString(t.get(float64(k)))
+		if !ok {
+			return nil, luaErrorf("invalid value (at index %d) in table for 'concat'", k)
+		}
+		b.WriteString(str)
+		if k < j {
+			b.WriteString(sep)
+		}
+	}
+	return b.String(), nil
+}
+
+// luaTableInsert implements table.insert(t, [pos,] value), which moves the
+// elements from pos up to make room for value, appending it by default.
+func luaTableInsert(args []any) (any, error) {
+	t, err := luaCheckTable(args, 0, "insert")
+	if err != nil {
+		return nil, err
+	}
+	n := len(t.array)
+	switch len(args) {
+	case 2:
+		t.set(float64(n+1), args[1])
+	case 3:
+		pos, err := luaCheckInt(args, 1, "insert")
+		if err != nil {
+			return nil, err
+		}
+		for i := n; i >= pos; i-- {
+			t.set(float64(i+1), t.get(float64(i)))
+		}
+		t.set(float64(pos), args[2])
+	default:
+		return nil, luaErrorf("wrong number of arguments to 'insert'")
+	}
+	return nil, nil
+}
+
+// luaTableRemove implements table.remove(t [, pos]), which returns the
+// element at pos, by default the last one, and moves those after it down.
+// A position out of the array removes nothing.
+func luaTableRemove(args []any) ([]any, error) {
+	t, err := luaCheckTable(args, 0, "remove")
+	if err != nil {
+		return nil, err
+	}
+	n := len(t.array)
+	pos, err := luaOptInt(args, 1, "remove", n)
+	if err != nil {
+		return nil, err
+	}
+	if pos < 1 || pos > n {
+		return nil, nil
+	}
+	value := t.get(float64(pos))
+	for i := pos; i < n; i++ {
+		t.set(float64(i), t.get(float64(i+1)))
+	}
+	t.set(float64(n), nil)
+	return []any{value}, nil
+}
+
+// luaTableSort implements table.sort(t [, comp]), which sorts the array of t
+// in place with < or with comp, stopping at the first error either raises.
+func luaTableSort(args []any) (any, error) {
+	t, err := luaCheckTable(args, 0, "sort")
+	if err != nil {
+		return nil, err
+	}
+	comp := luaArg(args, 1)
+	if comp != nil && luaTypeName(comp) != "function" {
+		return nil, luaArgTypeError(args, 1, "sort", "function")
+	}
+	less := func(a, b any) (bool, error) {
+		if comp == nil {
+			return luaLess(a, b, false)
+		}
+		values, err := luaCallValue(comp, []any{a, b})
+		return luaTruthy(luaFirst(values)), err
+	}
+	elements := t.array
+	sort.Slice(elements, func(i, j int) bool {
+		if err != nil {
+			return false
+		}
+		var ok bool
+		ok, err = less(elements[i], elements[j])
+		return ok
+	})
+	return nil, err
+}
+
+// The math library.
+
+func luaMathLibrary(rng *rand.Rand) *luaTable {
+	unary := func(name string, fn func(float64) float64) *luaBuiltin {
+		return luaFunc(name, func(args []any) (any, error) {
+			x, err := luaCheckNumber(args, 0, name)
+			return fn(x), err
+		})
+	}
+	binary := func(name string, fn func(x, y float64) float64) *luaBuiltin {
+		return luaFunc(name, func(args []any) (any, error) {
+			x, err := luaCheckNumber(args, 0, name)
+			if err != nil {
+				return nil, err
+			}
+			y, err := luaCheckNumber(args, 1, name)
+			return fn(x, y), err
+		})
+	}
+	extreme := func(name string, better func(x, y float64) bool) *luaBuiltin {
+		return luaFunc(name, func(args []any) (any, error) {
+			best, err := luaCheckNumber(args, 0, name)
+			if err != nil {
+				return nil, err
+			}
+			for i := 1; i < len(args); i++ {
+				x, err := luaCheckNumber(args, i, name)
+				if err != nil {
+					return nil, err
+				}
+				if better(x, best) {
+					best = x
+				}
+			}
+			return best, nil
+		})
+	}
+	t := luaLibraryTable(map[string]*luaBuiltin{
+		"abs":   unary("abs", math.Abs),
+		"acos":  unary("acos", math.Acos),
+		"asin":  unary("asin", math.Asin),
+		"atan":  unary("atan", math.Atan),
+		"atan2": binary("atan2", math.Atan2),
+		"ceil":  unary("ceil", math.Ceil),
+		"cos":   unary("cos", math.Cos),
+		"deg":   unary("deg", func(x float64) float64 { return x * 180 / math.Pi }),
+		"exp":   unary("exp", math.Exp),
+		"floor": unary("floor", math.Floor),
+		"fmod":  binary("fmod", math.Mod),
+		"log":   unary("log", math.Log),
+		"log10": unary("log10", math.Log10),
+		"max":   extreme("max", func(x, y float64) bool { return x > y }),
+		"min":   extreme("min", func(x, y float64) bool { return x < y }),
+		"modf": {name: "modf", fn: func(args []any) ([]any, error) {
+			x, err := luaCheckNumber(args, 0, "modf")
+			if err != nil {
+				return nil, err
+			}
+			whole, frac := math.Modf(x)
+			return []any{whole, frac}, nil
+		}},
+		"pow":  binary("pow", math.Pow),
+		"rad":  unary("rad", func(x float64) float64 { return x * math.Pi / 180 }),
+		"sin":  unary("sin", math.Sin),
+		"sqrt": unary("sqrt", math.Sqrt),
+		"tan":  unary("tan", math.Tan),
+		"random": luaFunc("random", func(args []any) (any, error) {
+			r := rng.Float64()
+			switch len(args) {
+			case 0:
+				return r, nil
+			case 1, 2:
+				low, high := 1, 0
+				var err error
+				if len(args) == 1 {
+					high, err = luaCheckInt(args, 0, "random")
+				} else if low, err = luaCheckInt(args, 0, "random"); err == nil {
+					high, err = luaCheckInt(args, 1, "random")
+				}
+				if err != nil {
+					return nil, err
+				}
+				if low > high {
+					return nil, luaArgError(len(args), "random", "interval is empty")
+				}
+				return math.Floor(r*float64(high-low+1)) + float64(low), nil
+			}
+			return nil, luaErrorf("wrong number of arguments")
+		}),
+		"randomseed": luaFunc("randomseed", func(args []any) (any, error) {
+			seed, err := luaCheckNumber(args, 0, "randomseed")
+			rng.Seed(int64(seed))
+			return nil, err
+		}),
+	})
+	t.set("huge", math.Inf(1))
+	t.set("pi", math.Pi)
+	return t
+}
+
+// The cjson library.
+
+// luaMaxJSONDepth is the deepest nesting of tables cjson.encode serializes,
+// as in lua-cjson.
+const luaMaxJSONDepth = 1000
+
+func luaCjsonLibrary() *luaTable {
+	return luaLibraryTable(map[string]*luaBuiltin{
+		"encode": luaFunc("encode", func(args []any) (any, error) {
+			if len(args) != 1 {
+				return nil, luaArgError(1, "encode", "expected 1 argument")
+			}
+			var b strings.Builder
+			if err := luaEncodeJSON(&b, args[0], 1); err != nil {
+				return nil, err
+			}
+			return b.String(), nil
+		}),
+		"decode": luaFunc("decode", func(args []any) (any, error) {
+			s, err := luaCheckString(args, 0, "decode")
+			if err != nil {
+				return nil, err
+			}
+			var v any
+			if err := json.Unmarshal([]byte(s), &v); err != nil {
+				var syntax *json.SyntaxError
+				if errors.As(err, &syntax) {
+					return nil, luaErrorf("Expected value but found invalid token at character %d", syntax.Offset)
+				}
+				return nil, luaErrorf("Expected value but found invalid token")
+			}
+			return luaFromJSON(v), nil
+		}),
+	})
+}
+
+// luaEncodeJSON writes v as JSON the way lua-cjson does: a table whose keys
+// are all positive integers is an array, with null for the missing
+// elements, and any other table, the empty one included, is an object.
+func luaEncodeJSON(b *strings.Builder, v any, depth int) error {
+	switch v := v.(type) {
+	case nil:
+		b.WriteString("null")
+	case bool:
+		b.WriteString(strconv.FormatBool(v))
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return luaErrorf("Cannot serialise number: must not be NaN or Inf")
+		}
+		b.WriteString(luaNumberString(v))
+	case string:
+		luaQuoteJSON(b, v)
+	case *luaTable:
+		if depth > luaMaxJSONDepth {
+			return luaErrorf("Cannot serialise, excessive nesting (%d)", depth)
+		}
+		keys := v.keys()
+		if n, ok := luaJSONArrayLength(keys); ok && n > 0 {
+			if n > 10 && n > 2*len(keys) {
+				return luaErrorf("Cannot serialise table: excessively sparse array")
+			}
+			b.WriteByte('[')
+			for i := 1; i <= n; i++ {
+				if i > 1 {
+					b.WriteByte(',')
+				}
+				if err := luaEncodeJSON(b, v.get(float64(i)), depth+1); err != nil {
+					return err
+				}
+			}
+			b.WriteByte(']')
+			return nil
+		}
+		b.WriteByte('{')
+		for i, k := range keys {
+			name, ok := luaToString(k)
+			if !ok {
+				return luaErrorf("Cannot serialise table: table key must be a number or string")
+			}
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			luaQuoteJSON(b, name)
+			b.WriteByte(':')
+			if err := luaEncodeJSON(b, v.get(k), depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	default:
+		return luaErrorf("Cannot serialise %s: type not supported", luaTypeName(v))
+	}
+	return nil
+}
+
+// luaJSONArrayLength returns the highest of keys if they are all positive
+// integers, which makes their table an array.
+func luaJSONArrayLength(keys []any) (int, bool) {
+	n := 0
+	for _, k := range keys {
+		f, ok := k.(float64)
+		if !ok || f < 1 || f != math.Trunc(f) {
+			return 0, false
+		}
+		n = max(n, int(f))
+	}
+	return n, true
+}
+
+// luaQuoteJSON writes s as a JSON string, escaping / like lua-cjson.
+func luaQuoteJSON(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', '/':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(b, `\u%04x`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+}
+
+// luaFromJSON converts a value decoded by encoding/json to Lua: objects and
+// arrays to tables, and null to nil.
+func luaFromJSON(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		t := newLuaTable()
+		for k, element := range v {
+			t.set(k, luaFromJSON(element))
+		}
+		return t
+	case []any:
+		t := newLuaTable()
+		for i, element := range v {
+			t.set(float64(i+1), luaFromJSON(element))
+		}
+		return t
+	}
+	return v
+}
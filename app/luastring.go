@@ -0,0 +1,823 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// luaMaxStringSize bounds the strings string.rep builds, like
+// proto-max-bulk-len bounds those a client sends.
+const luaMaxStringSize = 512 << 20
+
+// luaStringLibrary returns the string library, which is also what the
+// methods of strings are looked up in.
+func luaStringLibrary() *luaTable {
+	return luaLibraryTable(map[string]*luaBuiltin{
+		"byte": {name: "byte", fn: luaStringByte},
+		"char": luaFunc("char", func(args []any) (any, error) {
+			b := make([]byte, len(args))
+			for i := range args {
+				c, err := luaCheckInt(args, i, "char")
+				if err != nil {
+					return nil, err
+				}
+				if c < 0 || c > 255 {
+					return nil, luaArgError(i+1, "char", "invalid value")
+				}
+				b[i] = byte(c)
+			}
+			return string(b), nil
+		}),
+		"find":   {name: "find", fn: func(args []any) ([]any, error) { return luaStringFind(args, "find") }},
+		"format": luaFunc("format", luaStringFormat),
+		"gmatch": luaFunc("gmatch", luaStringGmatch),
+		"gsub":   {name: "gsub", fn: luaStringGsub},
+		"len": luaFunc("len", func(args []any) (any, error) {
+			s, err := luaCheckString(args, 0, "len")
+			return float64(len(s)), err
+		}),
+		"lower": luaFunc("lower", func(args []any) (any, error) {
+			s, err := luaCheckString(args, 0, "lower")
+			return strings.ToLower(s), err
+		}),
+		"match": {name: "match", fn: func(args []any) ([]any, error) { return luaStringFind(args, "match") }},
+		"rep": luaFunc("rep", func(args []any) (any, error) {
+			s, err := luaCheckString(args, 0, "rep")
+			if err != nil {
+				return nil, err
+			}
+			n, err := luaCheckInt(args, 1, "rep")
+			if err != nil || n <= 0 {
+				return "", err
+			}
+			if len(s) > 0 && n > luaMaxStringSize/len(s) {
+				return nil, luaErrorf("resulting string too large")
+			}
+			return strings.Repeat(s, n), nil
+		}),
+		"reverse": luaFunc("reverse", func(args []any) (any, error) {
+			s, err := luaCheckString(args, 0, "reverse")
+			b := []byte(s)
+			for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+				b[i], b[j] = b[j], b[i]
+			}
+			return string(b), err
+		}),
+		"sub": luaFunc("sub", func(args []any) (any, error) {
+			s, err := luaCheckString(args, 0, "sub")
+			if err != nil {
+				return nil, err
+			}
+			i, err := luaOptInt(args, 1, "sub", 1)
+			if err != nil {
+				return nil, err
+			}
+			j, err := luaOptInt(args, 2, "sub", -1)
+			if err != nil {
+				return nil, err
+			}
+			start, end := max(luaStringPos(i, len(s)), 1), min(luaStringPos(j, len(s)), len(s))
+			if start > end {
+				return "", nil
+			}
+			return s[start-1 : end], nil
+		}),
+		"upper": luaFunc("upper", func(args []any) (any, error) {
+			s, err := luaCheckString(args, 0, "upper")
+			return strings.ToUpper(s), err
+		}),
+	})
+}
+
+// luaStringPos converts a position in a string of length n, negative ones
+// counting from the end, to a position from the start.
+func luaStringPos(pos, n int) int {
+	if pos < 0 {
+		return n + pos + 1
+	}
+	return pos
+}
+
+// luaStringByte implements string.byte(s [, i [, j]]), which returns the
+// codes of the bytes from i to j.
+func luaStringByte(args []any) ([]any, error) {
+	s, err := luaCheckString(args, 0, "byte")
+	if err != nil {
+		return nil, err
+	}
+	i, err := luaOptInt(args, 1, "byte", 1)
+	if err != nil {
+		return nil, err
+	}
+	j, err := luaOptInt(args, 2, "byte", i)
+	if err != nil {
+		return nil, err
+	}
+	start, end := max(luaStringPos(i, len(s)), 1), min(luaStringPos(j, len(s)), len(s))
+	var codes []any
+	for k := start; k <= end; k++ {
+		codes = append(codes, float64(s[k-1]))
+	}
+	return codes, nil
+}
+
+// luaStringFormat implements string.format(format, ...) with the
+// conversions of Lua 5.1: %d, %i, %u, %c, %o, %x, %X, %e, %E, %f, %g, %G,
+// %q, %s and %%, with flags, a width and a precision.
+func luaStringFormat(args []any) (any, error) {
+	format, err := luaCheckString(args, 0, "format")
+	if err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	arg := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			b.WriteByte(format[i])
+			continue
+		}
+		if i++; i < len(format) && format[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		start := i
+		for i < len(format) && strings.IndexByte("-+ #0", format[i]) >= 0 {
+			i++
+		}
+		if i-start > 5 {
+			return nil, luaErrorf("invalid format (repeated flags)")
+		}
+		digits := func() error {
+			n := 0
+			for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+				n++
+			}
+			if n > 2 {
+				return luaErrorf("invalid format (width or precision too long)")
+			}
+			return nil
+		}
+		if err := digits(); err != nil {
+			return nil, err
+		}
+		precision := false
+		if i < len(format) && format[i] == '.' {
+			i++
+			precision = true
+			if err := digits(); err != nil {
+				return nil, err
+			}
+		}
+		if i == len(format) {
+			return nil, luaErrorf("invalid option '%%' to 'format'")
+		}
+		spec, conv := "%"+format[start:i], format[i]
+		arg++
+		switch conv {
+		case 'd', 'i':
+			n, err := luaCheckNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"d", int64(n))
+		case 'u', 'o', 'x', 'X':
+			n, err := luaCheckNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			if conv == 'u' {
+				conv = 'd'
+			}
+			fmt.Fprintf(&b, spec+string(conv), uint64(int64(n)))
+		case 'c':
+			n, err := luaCheckNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"s", string([]byte{byte(n)}))
+		case 'e', 'E', 'f', 'g', 'G':
+			n, err := luaCheckNumber(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			// C prints 6 significant digits for %g by default, Go as
+			// many as needed.
+			if !precision {
+				spec += ".6"
+			}
+			fmt.Fprintf(&b, spec+string(conv), n)
+		case 'q':
+			s, err := luaCheckString(args, arg, "format")
+			if err != nil {
+				return nil, err
+			}
+			luaQuoteString(&b, s)
+		case 's':
+			if arg >= len(args) {
+				return nil, luaArgTypeError(args, arg, "format", "string")
+			}
+			s, err := luaToStringMeta(args[arg])
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(&b, spec+"s", s)
+		default:
+			return nil, luaErrorf("invalid option '%%%c' to 'format'", conv)
+		}
+	}
+	return b.String(), nil
+}
+
+// luaQuoteString writes s quoted so that Lua reads it back, as %q does.
+func luaQuoteString(b *strings.Builder, s string) {
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', '\n':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\r':
+			b.WriteString(`\r`)
+		case 0:
+			b.WriteString(`\000`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
+
+// luaStringFind implements string.find(s, pattern [, init [, plain]]),
+// which returns where the first match of pattern from init starts and ends,
+// and its captures, and string.match(s, pattern [, init]), which returns
+// the captures, or the match if the pattern has none.
+func luaStringFind(args []any, name string) ([]any, error) {
+	s, err := luaCheckString(args, 0, name)
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := luaCheckString(args, 1, name)
+	if err != nil {
+		return nil, err
+	}
+	init, err := luaOptInt(args, 2, name, 1)
+	if err != nil {
+		return nil, err
+	}
+	init = luaStringPos(init, len(s)) - 1
+	if init < 0 {
+		init = 0
+	} else if init > len(s) {
+		init = len(s)
+	}
+	find := name == "find"
+	if find && (luaTruthy(luaArg(args, 3)) || !strings.ContainsAny(pattern, "^$*+?.([%-")) {
+		i := strings.Index(s[init:], pattern)
+		if i < 0 {
+			return []any{nil}, nil
+		}
+		return []any{float64(init + i + 1), float64(init + i + len(pattern))}, nil
+	}
+	m := &luaMatcher{src: s, pattern: pattern}
+	anchor := strings.HasPrefix(pattern, "^")
+	p := 0
+	if anchor {
+		p = 1
+	}
+	for start := init; start <= len(s); start++ {
+		m.level = 0
+		end := m.match(start, p)
+		if m.err != nil {
+			return nil, m.err
+		}
+		if end >= 0 {
+			if find {
+				captures, err := m.captures(start, end, false)
+				return append([]any{float64(start + 1), float64(end)}, captures...), err
+			}
+			return m.captures(start, end, true)
+		}
+		if anchor {
+			break
+		}
+	}
+	return []any{nil}, nil
+}
+
+// luaStringGmatch implements string.gmatch(s, pattern), whose iterator
+// returns the captures of each match in turn.
+func luaStringGmatch(args []any) (any, error) {
+	s, err := luaCheckString(args, 0, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := luaCheckString(args, 1, "gmatch")
+	if err != nil {
+		return nil, err
+	}
+	m := &luaMatcher{src: s, pattern: pattern}
+	pos := 0
+	return &luaBuiltin{name: "gmatch_iterator", fn: func([]any) ([]any, error) {
+		for start := pos; start <= len(s); start++ {
+			m.level = 0
+			end := m.match(start, 0)
+			if m.err != nil {
+				return nil, m.err
+			}
+			if end >= 0 {
+				pos = end
+				if end == start {
+					pos++
+				}
+				return m.captures(start, end, true)
+			}
+		}
+		pos = len(s) + 1
+		return []any{nil}, nil
+	}}, nil
+}
+
+// luaStringGsub implements string.gsub(s, pattern, repl [, n]), which
+// replaces the first n matches of pattern, all by default, and returns the
+// string and the number of matches. repl is a string in which %0 to %9 are
+// the match and its captures, a table indexed by the first capture, or a
+// function called with the captures. A replacement of false or nil keeps
+// the match.
+func luaStringGsub(args []any) ([]any, error) {
+	s, err := luaCheckString(args, 0, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	pattern, err := luaCheckString(args, 1, "gsub")
+	if err != nil {
+		return nil, err
+	}
+	repl := luaArg(args, 2)
+	switch luaTypeName(repl) {
+	case "number", "string", "table", "function":
+	default:
+		return nil, luaArgError(3, "gsub", "string/function/table expected")
+	}
+	limit, err := luaOptInt(args, 3, "gsub", len(s)+1)
+	if err != nil {
+		return nil, err
+	}
+	m := &luaMatcher{src: s, pattern: pattern}
+	anchor := strings.HasPrefix(pattern, "^")
+	p := 0
+	if anchor {
+		p = 1
+	}
+	var b strings.Builder
+	src, n := 0, 0
+	for n < limit {
+		m.level = 0
+		end := m.match(src, p)
+		if m.err != nil {
+			return nil, m.err
+		}
+		if end >= 0 {
+			n++
+			if err := m.replace(&b, src, end, repl); err != nil {
+				return nil, err
+			}
+		}
+		if end > src {
+			src = end
+		} else if src < len(s) {
+			b.WriteByte(s[src])
+			src++
+		} else {
+			break
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(s[src:])
+	return []any{b.String(), float64(n)}, nil
+}
+
+// Lua patterns, after lstrlib.c.
+
+const (
+	luaMaxCaptures       = 32
+	luaMaxMatchDepth     = 200
+	luaCaptureUnfinished = -1
+	luaCapturePosition   = -2
+)
+
+// luaMatcher matches pattern against src. The positions are byte offsets,
+// and match returns the end of the match or -1. err is set by a malformed
+// pattern, which fails the match.
+type luaMatcher struct {
+	src, pattern string
+	level        int
+	capture      [luaMaxCaptures]struct{ start, len int }
+	depth        int
+	err          error
+}
+
+func (m *luaMatcher) fail(format string, args ...any) int {
+	if m.err == nil {
+		m.err = luaErrorf(format, args...)
+	}
+	return -1
+}
+
+// classEnd returns the position after the single character class at p.
+func (m *luaMatcher) classEnd(p int) int {
+	c := m.pattern[p]
+	p++
+	switch c {
+	case '%':
+		if p >= len(m.pattern) {
+			return m.fail("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if p < len(m.pattern) && m.pattern[p] == '^' {
+			p++
+		}
+		for {
+			if p >= len(m.pattern) {
+				return m.fail("malformed pattern (missing ']')")
+			}
+			c := m.pattern[p]
+			p++
+			if c == '%' && p < len(m.pattern) {
+				p++
+			}
+			if p < len(m.pattern) && m.pattern[p] == ']' {
+				return p + 1
+			}
+		}
+	}
+	return p
+}
+
+// luaMatchClass reports whether c is in the class %cl.
+func luaMatchClass(c, cl byte) bool {
+	var res bool
+	lower := cl | 0x20
+	switch lower {
+	case 'a':
+		res = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+	case 'c':
+		res = c < 0x20 || c == 0x7f
+	case 'd':
+		res = c >= '0' && c <= '9'
+	case 'l':
+		res = c >= 'a' && c <= 'z'
+	case 'p':
+		res = c > 0x20 && c < 0x7f && !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9')
+	case 's':
+		res = strings.IndexByte(luaSpace, c) >= 0
+	case 'u':
+		res = c >= 'A' && c <= 'Z'
+	case 'w':
+		res = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+	case 'x':
+		res = c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+	case 'z':
+		res = c == 0
+	default:
+		return cl == c
+	}
+	if cl >= 'A' && cl <= 'Z' {
+		return !res
+	}
+	return res
+}
+
+// matchBracketClass reports whether c is in the set [...] from p to the
+// closing bracket at end.
+func (m *luaMatcher) matchBracketClass(c byte, p, end int) bool {
+	in := true
+	if m.pattern[p+1] == '^' {
+		in = false
+		p++
+	}
+	for p++; p < end; p++ {
+		switch {
+		case m.pattern[p] == '%':
+			p++
+			if luaMatchClass(c, m.pattern[p]) {
+				return in
+			}
+		case m.pattern[p+1] == '-' && p+2 < end:
+			if m.pattern[p] <= c && c <= m.pattern[p+2] {
+				return in
+			}
+			p += 2
+		case m.pattern[p] == c:
+			return in
+		}
+	}
+	return !in
+}
+
+// singleMatch reports whether the character at s matches the class from p
+// to ep.
+func (m *luaMatcher) singleMatch(s, p, ep int) bool {
+	if s >= len(m.src) {
+		return false
+	}
+	c := m.src[s]
+	switch m.pattern[p] {
+	case '.':
+		return true
+	case '%':
+		return luaMatchClass(c, m.pattern[p+1])
+	case '[':
+		return m.matchBracketClass(c, p, ep-1)
+	}
+	return m.pattern[p] == c
+}
+
+func (m *luaMatcher) match(s, p int) int {
+	if m.depth++; m.depth > luaMaxMatchDepth {
+		return m.fail("pattern too complex")
+	}
+	defer func() { m.depth-- }()
+	for m.err == nil {
+		if p == len(m.pattern) {
+			return s
+		}
+		switch m.pattern[p] {
+		case '(':
+			if p+1 < len(m.pattern) && m.pattern[p+1] == ')' {
+				return m.startCapture(s, p+2, luaCapturePosition)
+			}
+			return m.startCapture(s, p+1, luaCaptureUnfinished)
+		case ')':
+			return m.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(m.pattern) {
+				if s == len(m.src) {
+					return s
+				}
+				return -1
+			}
+		case '%':
+			if p+1 >= len(m.pattern) {
+				break
+			}
+			switch next := m.pattern[p+1]; {
+			case next == 'b':
+				if s = m.matchBalance(s, p+2); s < 0 {
+					return -1
+				}
+				p += 4
+				continue
+			case next == 'f':
+				p += 2
+				if p >= len(m.pattern) || m.pattern[p] != '[' {
+					return m.fail("missing '[' after '%%f' in pattern")
+				}
+				ep := m.classEnd(p)
+				if ep < 0 {
+					return -1
+				}
+				var prev, cur byte
+				if s > 0 {
+					prev = m.src[s-1]
+				}
+				if s < len(m.src) {
+					cur = m.src[s]
+				}
+				if m.matchBracketClass(prev, p, ep-1) || !m.matchBracketClass(cur, p, ep-1) {
+					return -1
+				}
+				p = ep
+				continue
+			case next >= '0' && next <= '9':
+				if s = m.matchCapture(s, next); s < 0 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+		ep := m.classEnd(p)
+		if ep < 0 {
+			return -1
+		}
+		matched := m.singleMatch(s, p, ep)
+		if ep < len(m.pattern) {
+			switch m.pattern[ep] {
+			case '?':
+				if matched {
+					if end := m.match(s+1, ep+1); end >= 0 {
+						return end
+					}
+				}
+				p = ep + 1
+				continue
+			case '*':
+				return m.maxExpand(s, p, ep)
+			case '+':
+				if !matched {
+					return -1
+				}
+				return m.maxExpand(s+1, p, ep)
+			case '-':
+				return m.minExpand(s, p, ep)
+			}
+		}
+		if !matched {
+			return -1
+		}
+		s, p = s+1, ep
+	}
+	return -1
+}
+
+func (m *luaMatcher) maxExpand(s, p, ep int) int {
+	i := 0
+	for m.singleMatch(s+i, p, ep) {
+		i++
+	}
+	for ; i >= 0; i-- {
+		if end := m.match(s+i, ep+1); end >= 0 {
+			return end
+		}
+	}
+	return -1
+}
+
+func (m *luaMatcher) minExpand(s, p, ep int) int {
+	for {
+		if end := m.match(s, ep+1); end >= 0 {
+			return end
+		}
+		if !m.singleMatch(s, p, ep) {
+			return -1
+		}
+		s++
+	}
+}
+
+func (m *luaMatcher) startCapture(s, p, what int) int {
+	if m.level >= luaMaxCaptures {
+		return m.fail("too many captures")
+	}
+	m.capture[m.level].start, m.capture[m.level].len = s, what
+	m.level++
+	end := m.match(s, p)
+	if end < 0 {
+		m.level--
+	}
+	return end
+}
+
+func (m *luaMatcher) endCapture(s, p int) int {
+	l := -1
+	for i := m.level - 1; i >= 0; i-- {
+		if m.capture[i].len == luaCaptureUnfinished {
+			l = i
+			break
+		}
+	}
+	if l < 0 {
+		return m.fail("invalid pattern capture")
+	}
+	m.capture[l].len = s - m.capture[l].start
+	end := m.match(s, p)
+	if end < 0 {
+		m.capture[l].len = luaCaptureUnfinished
+	}
+	return end
+}
+
+// matchBalance matches %bxy at p: x, then anything up to the y that
+// balances it.
+func (m *luaMatcher) matchBalance(s, p int) int {
+	if p+1 >= len(m.pattern) {
+		return m.fail("unbalanced pattern")
+	}
+	if s >= len(m.src) || m.src[s] != m.pattern[p] {
+		return -1
+	}
+	open, close := m.pattern[p], m.pattern[p+1]
+	depth := 1
+	for s++; s < len(m.src); s++ {
+		switch m.src[s] {
+		case close:
+			if depth--; depth == 0 {
+				return s + 1
+			}
+		case open:
+			depth++
+		}
+	}
+	return -1
+}
+
+// matchCapture matches %1 to %9, the text of an earlier capture.
+func (m *luaMatcher) matchCapture(s int, digit byte) int {
+	l := int(digit - '1')
+	if l < 0 || l >= m.level || m.capture[l].len == luaCaptureUnfinished {
+		return m.fail("invalid capture index")
+	}
+	c := m.capture[l]
+	if len(m.src)-s >= c.len && m.src[c.start:c.start+c.len] == m.src[s:s+c.len] {
+		return s + c.len
+	}
+	return -1
+}
+
+// captureValue returns capture i of the match from s to end, the whole match for
+// capture 0 of a pattern without captures.
+func (m *luaMatcher) captureValue(i, s, end int) (any, error) {
+	if i >= m.level {
+		if i == 0 {
+			return m.src[s:end], nil
+		}
+		return nil, luaErrorf("invalid capture index")
+	}
+	c := m.capture[i]
+	switch c.len {
+	case luaCaptureUnfinished:
+		return nil, luaErrorf("unfinished capture")
+	case luaCapturePosition:
+		return float64(c.start + 1), nil
+	}
+	return m.src[c.start : c.start+c.len], nil
+}
+
+// captures returns the captures of the match from s to end, and the match
+// itself if the pattern has none and whole is set.
+func (m *luaMatcher) captures(s, end int, whole bool) ([]any, error) {
+	n := m.level
+	if n == 0 && whole {
+		n = 1
+	}
+	values := make([]any, n)
+	for i := range values {
+		var err error
+		if values[i], err = m.captureValue(i, s, end); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// replace writes the replacement repl of the match from s to end for gsub.
+func (m *luaMatcher) replace(b *strings.Builder, s, end int, repl any) error {
+	var value any
+	switch r := repl.(type) {
+	case string, float64:
+		str, _ := luaToString(r)
+		for i := 0; i < len(str); i++ {
+			c := str[i]
+			if c != '%' || i+1 == len(str) {
+				b.WriteByte(c)
+				continue
+			}
+			i++
+			if c = str[i]; c < '0' || c > '9' {
+				b.WriteByte(c)
+				continue
+			}
+			capture := m.src[s:end]
+			if c != '0' {
+				v, err := m.captureValue(int(c-'1'), s, end)
+				if err != nil {
+					return err
+				}
+				capture, _ = luaToString(v)
+			}
+			b.WriteString(capture)
+		}
+		return nil
+	case *luaTable:
+		key, err := m.captureValue(0, s, end)
+		if err != nil {
+			return err
+		}
+		if value, err = luaIndex(nil, r, key); err != nil {
+			return err
+		}
+	default:
+		captures, err := m.captures(s, end, true)
+		if err != nil {
+			return err
+		}
+		values, err := luaCallValue(repl, captures)
+		if err != nil {
+			return err
+		}
+		value = luaFirst(values)
+	}
+	if !luaTruthy(value) {
+		b.WriteString(m.src[s:end])
+		return nil
+	}
+	str, ok := luaToString(value)
+	if !ok {
+		return luaErrorf("invalid replacement value (a %s)", luaTypeName(value))
+	}
+	b.WriteString(str)
+	return nil
+}
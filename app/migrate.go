@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errMigrateConnect = errors.New("IOERR error or timeout connecting to the client")
+	errMigrateWrite   = errors.New("IOERR error or timeout writing to target instance")
+	errMigrateRead    = errors.New("IOERR error or timeout reading to target instance")
+)
+
+// migrateCommand implements
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE]
+// [AUTH password | AUTH2 username password] [KEYS key [key ...]].
+// The keys are serialized with DUMP and sent to the target as RESTORE
+// commands; unless COPY is given they are then deleted here.
+func (s *server) migrateCommand(c *clientConn, commands []string) {
+	if len(commands) < 6 {
+		c.replyWrongArgs("migrate")
+		return
+	}
+	host, port := commands[1], commands[2]
+	db, err := strconv.Atoi(commands[4])
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	timeout, err := strconv.Atoi(commands[5])
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	if timeout <= 0 {
+		timeout = 1000
+	}
+	keys := []string{commands[3]}
+	copyKeys, replace := false, false
+	var auth []string
+	for i := 6; i < len(commands); i++ {
+		left := len(commands) - i - 1
+		switch strings.ToLower(commands[i]) {
+		case "copy":
+			copyKeys = true
+		case "replace":
+			replace = true
+		case "auth":
+			if left < 1 {
+				c.replyError(errSyntax)
+				return
+			}
+			auth = []string{"AUTH", commands[i+1]}
+			i++
+		case "auth2":
+			if left < 2 {
+				c.replyError(errSyntax)
+				return
+			}
+			auth = []string{"AUTH", commands[i+1], commands[i+2]}
+			i += 2
+		case "keys":
+			if commands[3] != "" {
+				c.replyErrorMsg("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+				return
+			}
+			keys = commands[i+1:]
+			i = len(commands)
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+
+	var migrated []string
+	var restores []string
+	for _, key := range keys {
+		payload, ttl, ok, err := s.db(c).Dump(key)
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		if !ok {
+			continue
+		}
+		restore := []string{"RESTORE", key, strconv.FormatInt(ttl.Milliseconds(), 10), string(payload)}
+		if replace {
+			restore = append(restore, "REPLACE")
+		}
+		migrated = append(migrated, key)
+		restores = append(restores, createArrayMsg(restore))
+	}
+	if len(migrated) == 0 {
+		c.replyStatus("NOKEY")
+		return
+	}
+
+	target, err := net.DialTimeout("tcp", net.JoinHostPort(host, port), time.Duration(timeout)*time.Millisecond)
+	if err != nil {
+		c.replyError(errMigrateConnect)
+		return
+	}
+	defer target.Close()
+	reader := bufio.NewReader(target)
+
+	var requests []string
+	if auth != nil {
+		requests = append(requests, createArrayMsg(auth))
+	}
+	if db != 0 {
+		requests = append(requests, createArrayMsg([]string{"SELECT", strconv.Itoa(db)}))
+	}
+	requests = append(requests, restores...)
+	target.SetDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
+	if _, err := target.Write([]byte(strings.Join(requests, ""))); err != nil {
+		c.replyError(errMigrateWrite)
+		return
+	}
+	for range requests {
+		line, err := readLine(reader)
+		if err != nil {
+			c.replyError(errMigrateRead)
+			return
+		}
+		if strings.HasPrefix(line, "-") {
+			c.replyErrorMsg("Target instance replied with error: " + line[1:])
+			return
+		}
+	}
+
+	if !copyKeys {
+		s.db(c).Del(migrated)
+		s.propagate(c.db, append([]string{"DEL"}, migrated...))
+	}
+	c.replyOK()
+}
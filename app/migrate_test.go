@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	_, sourceAddr := startServer(t)
+	_, targetAddr := startServer(t)
+	source, target := dial(t, sourceAddr), dial(t, targetAddr)
+	host, port, _ := net.SplitHostPort(targetAddr)
+
+	source.expect(respStatus("OK"), "SET", "moved", "1")
+	source.expect(respStatus("OK"), "SET", "copied", "2")
+	source.expect(respStatus("OK"), "MIGRATE", host, port, "moved", "0", "1000")
+	source.expect(int64(0), "EXISTS", "moved")
+	target.expect("1", "GET", "moved")
+
+	source.expect(respStatus("OK"), "MIGRATE", host, port, "copied", "1", "1000", "COPY")
+	source.expect("2", "GET", "copied")
+	target.expect(respStatus("OK"), "SELECT", "1")
+	target.expect("2", "GET", "copied")
+
+	source.expect(respStatus("NOKEY"), "MIGRATE", host, port, "missing", "0", "1000")
+}
+
+func TestMigrateUnreachable(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	listener.Close()
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	reply, ok := c.do("MIGRATE", host, port, "key", "0", "500").(respError)
+	if !ok || !strings.HasPrefix(string(reply), "IOERR ") {
+		t.Fatalf("MIGRATE to a closed port: got %#v, want an IOERR", reply)
+	}
+	c.expect("value", "GET", "key")
+}
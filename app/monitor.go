@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// monitorCommand implements MONITOR: from then on the client is sent a line
+// for every command the server runs.
+func (s *server) monitorCommand(c *clientConn, commands []string) {
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+	s.monitors[c] = struct{}{}
+	c.replyOK()
+}
+
+func (s *server) monitoring(c *clientConn) bool {
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+	_, ok := s.monitors[c]
+	return ok
+}
+
+// feedMonitors sends the command c runs to the monitors, formatted like
+// redis does: the time, the database and the client address in brackets,
+// and the quoted arguments. Commands scripts run come from "lua", and those
+// of clients without a connection, such as the one replaying the append only
+// file, are not shown.
+func (s *server) feedMonitors(c *clientConn, commands []string) {
+	addr := c.addr
+	if c.conn == nil && c.captured != nil {
+		addr = "lua"
+	} else if addr == "" {
+		return
+	}
+	s.monitorsMu.Lock()
+	defer s.monitorsMu.Unlock()
+	if len(s.monitors) == 0 {
+		return
+	}
+	now := s.clock.Now()
+	var line strings.Builder
+	fmt.Fprintf(&line, "%d.%06d [%d %s]", now.Unix(), now.Nanosecond()/1000, c.db, addr)
+	for _, arg := range commands {
+		line.WriteString(" " + quoteArg(arg))
+	}
+	msg := encode(func(w *respWriter) { w.WriteSimpleString(line.String()) })
+	for monitor := range s.monitors {
+		monitor.send(msg)
+	}
+}
+
+// quoteArg quotes arg in double quotes, escaping the quotes, backslashes,
+// control characters and non printable bytes.
+func quoteArg(arg string) string {
+	var quoted strings.Builder
+	quoted.WriteByte('"')
+	for i := 0; i < len(arg); i++ {
+		switch b := arg[i]; b {
+		case '\\', '"':
+			quoted.WriteByte('\\')
+			quoted.WriteByte(b)
+		case '\n':
+			quoted.WriteString(`\n`)
+		case '\r':
+			quoted.WriteString(`\r`)
+		case '\t':
+			quoted.WriteString(`\t`)
+		case '\a':
+			quoted.WriteString(`\a`)
+		case '\b':
+			quoted.WriteString(`\b`)
+		default:
+			if b < 0x20 || b > 0x7e {
+				fmt.Fprintf(&quoted, `\x%02x`, b)
+			} else {
+				quoted.WriteByte(b)
+			}
+		}
+	}
+	quoted.WriteByte('"')
+	return quoted.String()
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	_, addr := startServer(t)
+	monitor, c := dial(t, addr), dial(t, addr)
+	monitor.expect(respStatus("OK"), "MONITOR")
+	c.expect(respStatus("OK"), "SELECT", "2")
+	c.expect(respStatus("OK"), "SET", "key", "with \"quotes\"\n")
+	line, _ := monitor.read().(respStatus)
+	// The SELECT is reported from database 0, which it ran in.
+	if !regexp.MustCompile(`^\d+\.\d{6} \[0 127\.0\.0\.1:\d+\] "select" "2"$`).MatchString(string(line)) {
+		t.Fatalf("monitor line: got %q", line)
+	}
+	line, _ = monitor.read().(respStatus)
+	if !regexp.MustCompile(`^\d+\.\d{6} \[2 127\.0\.0\.1:\d+\] "set" "key" "with \\"quotes\\"\\n"$`).MatchString(string(line)) {
+		t.Fatalf("monitor line: got %q", line)
+	}
+}
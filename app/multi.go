@@ -0,0 +1,132 @@
+package main
+
+import (
+	"errors"
+)
+
+var (
+	errExecAbort   = errors.New("EXECABORT Transaction discarded because of previous errors.")
+	errMultiDenied = errors.New("ERR Command not allowed inside a transaction")
+	errNestedMulti = errors.New("ERR MULTI calls can not be nested")
+)
+
+// transactionCommands run right away inside MULTI rather than being
+// queued.
+var transactionCommands = map[string]bool{
+	"multi":   true,
+	"exec":    true,
+	"discard": true,
+	"quit":    true,
+	"reset":   true,
+}
+
+// queue queues a command sent inside MULTI, replying QUEUED. A command that
+// does not exist, has the wrong number of arguments or cannot run inside a
+// transaction, such as PSYNC or SHUTDOWN, is rejected instead, and fails the
+// transaction.
+func (s *server) queue(c *clientConn, commands []string) {
+	handler, ok := commandTable[commands[0]]
+	var err error
+	switch {
+	case isHelpRequest(commands):
+	case !ok:
+		err = errUnknownCommand(commands)
+	case !arityOK(handler.arity, commands):
+		err = errWrongArgs(commands[0])
+	case handler.flags&cmdNoMulti != 0:
+		err = errMultiDenied
+	}
+	s.clientsMu.Lock()
+	if err == nil {
+		c.queued = append(c.queued, commands)
+	} else {
+		c.multiFailed = true
+	}
+	s.clientsMu.Unlock()
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyStatus("QUEUED")
+}
+
+// endMulti ends the transaction of c and returns the commands it queued and
+// whether one of them was rejected.
+func (s *server) endMulti(c *clientConn) ([][]string, bool) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	queued, failed := c.queued, c.multiFailed
+	c.multi, c.queued, c.multiFailed = false, nil, false
+	return queued, failed
+}
+
+// multiCommand implements MULTI, which starts queueing the commands of the
+// client until EXEC or DISCARD.
+func (s *server) multiCommand(c *clientConn, commands []string) {
+	if c.multi {
+		c.replyError(errNestedMulti)
+		return
+	}
+	s.clientsMu.Lock()
+	c.multi = true
+	s.clientsMu.Unlock()
+	c.replyOK()
+}
+
+// discardCommand implements DISCARD, which drops the commands queued since
+// MULTI.
+func (s *server) discardCommand(c *clientConn, commands []string) {
+	if !c.multi {
+		c.replyErrorMsg("DISCARD without MULTI")
+		return
+	}
+	s.endMulti(c)
+	c.replyOK()
+}
+
+// execCommand implements EXEC, which runs the commands queued since MULTI
+// and replies with an array of their replies. They run with execMu held for
+// writing, so that no other command runs in between, and are subject to the
+// checks execute makes: writes wait out CLIENT PAUSE beforehand, and are
+// rejected on a replica and over maxmemory, and the keys read are tracked.
+// The commands that take execMu themselves, such as EVAL and SWAPDB, run
+// under the lock EXEC holds, and the blocking ones do not block: they reply
+// as if their timeout passed when there is nothing to pop or wait for.
+func (s *server) execCommand(c *clientConn, commands []string) {
+	if !c.multi {
+		c.replyErrorMsg("EXEC without MULTI")
+		return
+	}
+	queued, failed := s.endMulti(c)
+	if failed {
+		c.replyError(errExecAbort)
+		return
+	}
+	if !c.master {
+		for _, command := range queued {
+			s.waitWhilePaused(command[0])
+		}
+	}
+	if err := s.lockExec(true); err != nil {
+		c.replyError(err)
+		return
+	}
+	defer s.execMu.Unlock()
+	c.execLocked = true
+	defer func() { c.execLocked = false }()
+	c.replyWith(func(w *respWriter) { w.WriteArray(len(queued)) })
+	for _, command := range queued {
+		if !c.master && commandHas(command[0], cmdWrite) && s.isReplica() {
+			c.replyError(errReadOnly)
+			continue
+		}
+		if err := s.freeMemory(); err != nil && commandHas(command[0], cmdDenyOOM) {
+			c.replyError(err)
+			continue
+		}
+		if commandHas(command[0], cmdRead) {
+			s.trackRead(c, command)
+		}
+		s.dispatch(c, command)
+	}
+}
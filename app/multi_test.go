@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestExecCommandsTakingTheLock(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "MULTI")
+	c.expect(respStatus("QUEUED"), "SET", "key", "1")
+	c.expect(respStatus("QUEUED"), "EVAL", "return redis.call('get', KEYS[1])", "1", "key")
+	c.expect(respStatus("QUEUED"), "SWAPDB", "0", "1")
+	c.expect(respStatus("QUEUED"), "EXISTS", "key")
+	c.expect(respStatus("QUEUED"), "DEBUG", "SLEEP", "0")
+	c.expect(respStatus("QUEUED"), "WAIT", "1", "0")
+	c.expect(respStatus("QUEUED"), "WAITAOF", "0", "1", "0")
+	c.expect([]any{
+		respStatus("OK"),
+		"1",
+		respStatus("OK"),
+		int64(0),
+		respStatus("OK"),
+		int64(0),
+		[]any{int64(0), int64(0)},
+	}, "EXEC")
+	c.expect(respStatus("OK"), "SELECT", "1")
+	c.expect("1", "GET", "key")
+}
+
+func TestMultiRejectsCommands(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "MULTI")
+	c.expect(respError("ERR MULTI calls can not be nested"), "MULTI")
+	for _, command := range [][]string{{"PSYNC", "?", "-1"}, {"SHUTDOWN"}, {"FAILOVER"}} {
+		c.expect(respError("ERR Command not allowed inside a transaction"), command...)
+	}
+	c.expect(respError("EXECABORT Transaction discarded because of previous errors."), "EXEC")
+	c.expect(respStatus("PONG"), "PING")
+}
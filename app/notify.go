@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The keyspace event flags of notify-keyspace-events. notifyKeyspace and
+// notifyKeyevent choose the channels the events are published to, the
+// others the classes of events published.
+const (
+	notifyKeyspace = 1 << iota
+	notifyKeyevent
+	notifyGeneric
+	notifyString
+	notifyList
+	notifySet
+	notifyHash
+	notifyZset
+	notifyExpired
+	notifyEvicted
+	notifyStream
+
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash |
+		notifyZset | notifyExpired | notifyEvicted | notifyStream
+)
+
+// keyspaceEventFlags are the characters of the flags, in the order CONFIG GET
+// lists them. A stands for all the classes.
+var keyspaceEventFlags = []struct {
+	flag int
+	char byte
+}{
+	{notifyGeneric, 'g'},
+	{notifyString, '$'},
+	{notifyList, 'l'},
+	{notifySet, 's'},
+	{notifyHash, 'h'},
+	{notifyZset, 'z'},
+	{notifyExpired, 'x'},
+	{notifyEvicted, 'e'},
+	{notifyStream, 't'},
+	{notifyKeyspace, 'K'},
+	{notifyKeyevent, 'E'},
+}
+
+var errKeyspaceEvents = errors.New("Invalid event class character. Use 'Ag$lshzxeKEt'.")
+
+// parseKeyspaceEvents parses the value of notify-keyspace-events.
+func parseKeyspaceEvents(value string) (int, error) {
+	flags := 0
+next:
+	for i := 0; i < len(value); i++ {
+		if value[i] == 'A' {
+			flags |= notifyAll
+			continue
+		}
+		for _, f := range keyspaceEventFlags {
+			if value[i] == f.char {
+				flags |= f.flag
+				continue next
+			}
+		}
+		return 0, errKeyspaceEvents
+	}
+	return flags, nil
+}
+
+// formatKeyspaceEvents formats flags the way parseKeyspaceEvents reads them,
+// with A for all the classes.
+func formatKeyspaceEvents(flags int) string {
+	var value strings.Builder
+	if flags&notifyAll == notifyAll {
+		value.WriteByte('A')
+		flags &^= notifyAll
+	}
+	for _, f := range keyspaceEventFlags {
+		if flags&f.flag != 0 {
+			value.WriteByte(f.char)
+		}
+	}
+	return value.String()
+}
+
+// notify publishes the event of class that happened to key in database db,
+// as the message of __keyspace@<db>__:<key> and as the channel of
+// __keyevent@<db>__:<event>, as far as notify-keyspace-events enables them.
+func (s *server) notify(db, class int, event, key string) {
+	flags := s.config.keyspaceEvents()
+	if flags&class == 0 {
+		return
+	}
+	if flags&notifyKeyspace != 0 {
+		s.publish(fmt.Sprintf("__keyspace@%d__:%s", db, key), event)
+	}
+	if flags&notifyKeyevent != 0 {
+		s.publish(fmt.Sprintf("__keyevent@%d__:%s", db, event), key)
+	}
+}
+
+// keyspaceEvent is an event a write notifies. deletes is set for the
+// events after which the key may be gone, having been left empty, in which
+// case a del event follows, and stores for those of the commands storing a
+// result, which notify del instead when the result was empty.
+type keyspaceEvent struct {
+	db      int
+	class   int
+	event   string
+	key     string
+	deletes bool
+	stores  bool
+}
+
+// notifyWrite publishes the keyspace events of a write command run in
+// database db, as it is propagated.
+func (s *server) notifyWrite(db int, commands []string) {
+	if s.config.keyspaceEvents() == 0 {
+		return
+	}
+	for _, e := range writeEvents(db, commands) {
+		gone := (e.deletes || e.stores) && s.dbs[e.db].Type(e.key) == "none"
+		if !gone || !e.stores {
+			s.notify(e.db, e.class, e.event, e.key)
+		}
+		if gone {
+			s.notify(e.db, notifyGeneric, "del", e.key)
+		}
+	}
+}
+
+// writeEvents returns the keyspace events of a write command run in database
+// db, as it is propagated: LMPOP as LPOP or RPOP, a SET with an expiry with
+// PXAT, EXPIRE as PEXPIREAT and so on.
+func writeEvents(db int, commands []string) []keyspaceEvent {
+	name := strings.ToLower(commands[0])
+	on := func(class int, event string, keys ...string) []keyspaceEvent {
+		events := make([]keyspaceEvent, len(keys))
+		for i, key := range keys {
+			events[i] = keyspaceEvent{db: db, class: class, event: event, key: key}
+		}
+		return events
+	}
+	deleting := func(events []keyspaceEvent) []keyspaceEvent {
+		for i := range events {
+			events[i].deletes = true
+		}
+		return events
+	}
+	storing := func(events []keyspaceEvent) []keyspaceEvent {
+		for i := range events {
+			events[i].stores = true
+		}
+		return events
+	}
+	switch name {
+	case "del":
+		return on(notifyGeneric, "del", commands[1:]...)
+	case "pexpireat":
+		return on(notifyGeneric, "expire", commands[1])
+	case "persist":
+		return on(notifyGeneric, "persist", commands[1])
+	case "restore":
+		return on(notifyGeneric, "restore", commands[1])
+	case "getex":
+		if strings.EqualFold(commands[2], "persist") {
+			return on(notifyGeneric, "persist", commands[1])
+		}
+		return on(notifyGeneric, "expire", commands[1])
+	case "copy":
+		to := db
+		for i := 3; i < len(commands)-1; i++ {
+			if strings.EqualFold(commands[i], "db") {
+				to, _ = strconv.Atoi(commands[i+1])
+			}
+		}
+		return []keyspaceEvent{{db: to, class: notifyGeneric, event: "copy_to", key: commands[2]}}
+	case "move":
+		to, _ := strconv.Atoi(commands[2])
+		return []keyspaceEvent{
+			{db: db, class: notifyGeneric, event: "move_from", key: commands[1]},
+			{db: to, class: notifyGeneric, event: "move_to", key: commands[1]},
+		}
+	case "set":
+		events := on(notifyString, "set", commands[1])
+		if len(commands) > 3 {
+			events = append(events, on(notifyGeneric, "expire", commands[1])...)
+		}
+		return events
+	case "mset":
+		keys := make([]string, 0, len(commands)/2)
+		for i := 1; i < len(commands); i += 2 {
+			keys = append(keys, commands[i])
+		}
+		return on(notifyString, "set", keys...)
+	case "incr", "decr", "incrby", "decrby":
+		return on(notifyString, "incrby", commands[1])
+	case "setrange", "append", "setbit":
+		return on(notifyString, name, commands[1])
+	case "bitop":
+		return storing(on(notifyString, "set", commands[2]))
+	case "pfadd", "pfmerge":
+		return on(notifyString, "pfadd", commands[1])
+	case "lpush", "rpush", "lpushx", "rpushx":
+		return on(notifyList, strings.TrimSuffix(name, "x"), commands[1])
+	case "lpop", "rpop":
+		return deleting(on(notifyList, name, commands[1]))
+	case "sort":
+		return storing(on(notifyList, "sortstore", sortKeys(commands)[1]))
+	case "sadd":
+		return on(notifySet, "sadd", commands[1])
+	case "srem":
+		return deleting(on(notifySet, "srem", commands[1]))
+	case "hset", "hsetnx":
+		return on(notifyHash, "hset", commands[1])
+	case "hdel":
+		return deleting(on(notifyHash, "hdel", commands[1]))
+	case "zadd", "geoadd":
+		// The options come before the first score.
+		for _, arg := range commands[2:] {
+			if _, err := strconv.ParseFloat(arg, 64); err == nil {
+				break
+			}
+			if strings.EqualFold(arg, "incr") {
+				return on(notifyZset, "zincr", commands[1])
+			}
+		}
+		return on(notifyZset, "zadd", commands[1])
+	case "zrem", "zpopmin", "zpopmax":
+		return deleting(on(notifyZset, name, commands[1]))
+	case "zunionstore", "zinterstore", "zdiffstore":
+		return storing(on(notifyZset, name, commands[1]))
+	case "xadd", "xdel", "xtrim":
+		return on(notifyStream, name, commands[1])
+	case "xgroup":
+		return on(notifyStream, "xgroup-"+strings.ToLower(commands[1]), commands[2])
+	}
+	return nil
+}
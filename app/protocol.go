@@ -0,0 +1,96 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+var errNoProto = errors.New("NOPROTO unsupported protocol version")
+
+const serverVersion = "7.2.0"
+
+// resp3 reports whether the client switched to RESP3 with HELLO 3. Every
+// other client, including those without a connection, speaks RESP2.
+func (c *clientConn) resp3() bool {
+	return c.proto.Load() == 3
+}
+
+// replyVerbatim replies with text as a RESP3 verbatim string of the given
+// three letter format, such as "txt", or as a bulk string to RESP2 clients.
+func (c *clientConn) replyVerbatim(format, text string) {
+	c.replyWith(func(w *respWriter) { w.WriteVerbatim(format, text) })
+}
+
+// replyNullArray replies with the null array RESP2 clients get when a
+// command that replies with an array has nothing to return, which is the
+// same null as any other to RESP3 clients.
+func (c *clientConn) replyNullArray() {
+	c.replyWith(func(w *respWriter) { w.WriteNullArray() })
+}
+
+// replyBigNumber replies with the decimal integer n as a RESP3 big number, or
+// as a bulk string to RESP2 clients.
+func (c *clientConn) replyBigNumber(n string) {
+	c.replyWith(func(w *respWriter) { w.WriteBigNumber(n) })
+}
+
+// helloCommand implements HELLO [protover], which switches the protocol of
+// the connection and replies with a few facts about the server, as a map to
+// RESP3 clients and as a flat array to RESP2 ones.
+func (s *server) helloCommand(c *clientConn, commands []string) {
+	if len(commands) > 2 {
+		c.replyError(errSyntax)
+		return
+	}
+	if len(commands) == 2 {
+		proto, err := strconv.Atoi(commands[1])
+		if err != nil {
+			c.replyErrorMsg("Protocol version is not an integer or out of range")
+			return
+		}
+		if proto != 2 && proto != 3 {
+			c.replyError(errNoProto)
+			return
+		}
+		c.proto.Store(int32(proto))
+	}
+	role := "master"
+	if s.isReplica() {
+		role = "replica"
+	}
+	proto := 2
+	if c.resp3() {
+		proto = 3
+	}
+
+	c.replyValue(replyMap{
+		{"server", "redis"},
+		{"version", serverVersion},
+		{"proto", proto},
+		{"id", c.id},
+		{"mode", "standalone"},
+		{"role", role},
+		{"modules", []any{}},
+	})
+}
+
+// lolwutCommand implements LOLWUT [VERSION version]. There is no artwork,
+// only the version line redis ends it with.
+func (s *server) lolwutCommand(c *clientConn, commands []string) {
+	if len(commands) != 1 && len(commands) != 3 {
+		c.replyError(errSyntax)
+		return
+	}
+	if len(commands) == 3 {
+		if !strings.EqualFold(commands[1], "version") {
+			c.replyError(errSyntax)
+			return
+		}
+		if _, err := strconv.Atoi(commands[2]); err != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+	}
+	c.replyVerbatim("txt", "Redis ver. "+serverVersion+"\n")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// subscribers maps a channel or a pattern to the clients subscribed to it.
+type subscribers map[string]map[*clientConn]struct{}
+
+func (subs subscribers) add(name string, c *clientConn) {
+	if subs[name] == nil {
+		subs[name] = map[*clientConn]struct{}{}
+	}
+	subs[name][c] = struct{}{}
+}
+
+func (subs subscribers) remove(name string, c *clientConn) {
+	delete(subs[name], c)
+	if len(subs[name]) == 0 {
+		delete(subs, name)
+	}
+}
+
+// replyPush sends an out of band message made of the elements, encoded with
+// WriteReply: a push to RESP3 clients, which can tell it apart from the reply
+// to a command, and a plain array to RESP2 ones.
+func (c *clientConn) replyPush(elements ...any) {
+	var msg strings.Builder
+	w := newRespWriter(&msg, c.resp3())
+	w.WritePush(len(elements))
+	for _, element := range elements {
+		w.WriteReply(element)
+	}
+	c.send(msg.String())
+}
+
+// subscriptionKind tells regular channels, patterns and the shard channels
+// of sharded pub/sub apart.
+type subscriptionKind int
+
+const (
+	channelSubscription subscriptionKind = iota
+	patternSubscription
+	shardSubscription
+)
+
+// subscriptions is the number of subscriptions of c that the confirmation
+// of one of the given kind counts: its shard channels for sharded pub/sub,
+// its channels and patterns otherwise. The caller must hold pubsubMu.
+func (c *clientConn) subscriptions(kind subscriptionKind) int {
+	if kind == shardSubscription {
+		return len(c.shardChannels)
+	}
+	return len(c.channels) + len(c.patterns)
+}
+
+// registry returns the subscriptions of c of the given kind and the server
+// registry they are recorded in. The caller must hold pubsubMu.
+func (s *server) registry(c *clientConn, kind subscriptionKind) (*map[string]struct{}, subscribers) {
+	switch kind {
+	case patternSubscription:
+		return &c.patterns, s.patterns
+	case shardSubscription:
+		return &c.shardChannels, s.shardChannels
+	}
+	return &c.channels, s.channels
+}
+
+// subscribedCommands are the commands a RESP2 client may run while it is
+// subscribed, since the replies to any other would be mistaken for messages.
+var subscribedCommands = map[string]bool{
+	"subscribe":    true,
+	"psubscribe":   true,
+	"ssubscribe":   true,
+	"unsubscribe":  true,
+	"punsubscribe": true,
+	"sunsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+func (s *server) subscribed(c *clientConn) bool {
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+	return len(c.channels)+len(c.patterns)+len(c.shardChannels) > 0
+}
+
+func (s *server) subscribeCommand(c *clientConn, commands []string) {
+	s.subscribe(c, commands, channelSubscription)
+}
+
+func (s *server) psubscribeCommand(c *clientConn, commands []string) {
+	s.subscribe(c, commands, patternSubscription)
+}
+
+func (s *server) ssubscribeCommand(c *clientConn, commands []string) {
+	s.subscribe(c, commands, shardSubscription)
+}
+
+func (s *server) unsubscribeCommand(c *clientConn, commands []string) {
+	s.unsubscribe(c, commands, channelSubscription)
+}
+
+func (s *server) punsubscribeCommand(c *clientConn, commands []string) {
+	s.unsubscribe(c, commands, patternSubscription)
+}
+
+func (s *server) sunsubscribeCommand(c *clientConn, commands []string) {
+	s.unsubscribe(c, commands, shardSubscription)
+}
+
+// subscribe implements SUBSCRIBE, PSUBSCRIBE and SSUBSCRIBE. Each channel or
+// pattern is confirmed with its own message carrying the number of
+// subscriptions of the client.
+func (s *server) subscribe(c *clientConn, commands []string, kind subscriptionKind) {
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+	own, registry := s.registry(c, kind)
+	for _, name := range commands[1:] {
+		if *own == nil {
+			*own = map[string]struct{}{}
+		}
+		(*own)[name] = struct{}{}
+		registry.add(name, c)
+		c.replyPush(commands[0], name, c.subscriptions(kind))
+	}
+	c.limitOutput()
+}
+
+// limitOutput applies the output buffer limit of subscribers to c while it
+// has subscriptions. The caller must hold pubsubMu.
+func (c *clientConn) limitOutput() {
+	var limit int64
+	if len(c.channels)+len(c.patterns)+len(c.shardChannels) > 0 {
+		limit = pubsubOutputLimit
+	}
+	c.outLimit.Store(limit)
+}
+
+// unsubscribe implements UNSUBSCRIBE, PUNSUBSCRIBE and SUNSUBSCRIBE. Without
+// arguments the client leaves every channel, or every pattern, of the kind
+// it is subscribed to.
+func (s *server) unsubscribe(c *clientConn, commands []string, kind subscriptionKind) {
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+	own, registry := s.registry(c, kind)
+	names := commands[1:]
+	if len(names) == 0 {
+		names = sortedKeys(*own)
+		if len(names) == 0 {
+			c.replyPush(commands[0], nil, c.subscriptions(kind))
+			return
+		}
+	}
+	for _, name := range names {
+		delete(*own, name)
+		registry.remove(name, c)
+		c.replyPush(commands[0], name, c.subscriptions(kind))
+	}
+	c.limitOutput()
+}
+
+// unsubscribeAll drops every subscription of c.
+func (s *server) unsubscribeAll(c *clientConn) {
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+	for name := range c.channels {
+		s.channels.remove(name, c)
+	}
+	for name := range c.patterns {
+		s.patterns.remove(name, c)
+	}
+	for name := range c.shardChannels {
+		s.shardChannels.remove(name, c)
+	}
+	c.channels, c.patterns, c.shardChannels = nil, nil, nil
+	c.limitOutput()
+}
+
+// publishCommand implements PUBLISH channel message and replies with the
+// number of clients that received it.
+func (s *server) publishCommand(c *clientConn, commands []string) {
+	if len(commands) != 3 {
+		c.replyWrongArgs("publish")
+		return
+	}
+	c.replyInteger(s.publish(commands[1], commands[2]))
+}
+
+// publish sends message to the clients subscribed to channel and to those
+// subscribed to a matching pattern, and returns how many received it,
+// counting a client once per matching subscription. The messages are queued
+// to the subscribers, so a subscriber that does not read holds up neither
+// the publisher nor pubsubMu.
+func (s *server) publish(channel, message string) int {
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+	receivers := 0
+	for sub := range s.channels[channel] {
+		sub.replyPush("message", channel, message)
+		receivers++
+	}
+	for pattern, subs := range s.patterns {
+		if !globMatch(pattern, channel) {
+			continue
+		}
+		for sub := range subs {
+			sub.replyPush("pmessage", pattern, channel, message)
+			receivers++
+		}
+	}
+	return receivers
+}
+
+// spublishCommand implements SPUBLISH shardchannel message, which reaches
+// the clients subscribed to the shard channel with SSUBSCRIBE only.
+func (s *server) spublishCommand(c *clientConn, commands []string) {
+	channel, message := commands[1], commands[2]
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+	for sub := range s.shardChannels[channel] {
+		sub.replyPush("smessage", channel, message)
+	}
+	c.replyInteger(len(s.shardChannels[channel]))
+}
+
+// pubsubCommand implements PUBSUB CHANNELS [pattern], PUBSUB NUMSUB [channel
+// ...] and PUBSUB NUMPAT, and their SHARDCHANNELS and SHARDNUMSUB
+// counterparts for shard channels.
+func (s *server) pubsubCommand(c *clientConn, commands []string) {
+	s.pubsubMu.Lock()
+	defer s.pubsubMu.Unlock()
+	subcommand := strings.ToLower(commands[1])
+	registry := s.channels
+	if strings.HasPrefix(subcommand, "shard") {
+		registry = s.shardChannels
+	}
+	switch subcommand {
+	case "channels", "shardchannels":
+		if len(commands) > 3 {
+			c.replyWrongArgs("pubsub|" + subcommand)
+			return
+		}
+		channels := []string{}
+		for _, channel := range sortedKeys(registry) {
+			if len(commands) == 2 || globMatch(commands[2], channel) {
+				channels = append(channels, channel)
+			}
+		}
+		c.replyStrings(channels)
+	case "numsub", "shardnumsub":
+		c.replyWith(func(w *respWriter) {
+			w.WriteMap(len(commands) - 2)
+			for _, channel := range commands[2:] {
+				w.WriteBulkString(channel)
+				w.WriteInteger(int64(len(registry[channel])))
+			}
+		})
+	case "numpat":
+		if len(commands) != 2 {
+			c.replyWrongArgs("pubsub|numpat")
+			return
+		}
+		c.replyInteger(len(s.patterns))
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try PUBSUB HELP.", commands[1]))
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPushMessages(t *testing.T) {
+	_, addr := startServer(t)
+	subscriber, publisher := dial(t, addr), dial(t, addr)
+	subscriber.hello3()
+	subscriber.send("SUBSCRIBE", "channel")
+	if reply := subscriber.readRaw(); !strings.HasPrefix(reply, ">3\r\n$9\r\nsubscribe\r\n") {
+		t.Fatalf("SUBSCRIBE under RESP3: got %q, want a push", reply)
+	}
+	publisher.expect(int64(1), "PUBLISH", "channel", "hello")
+	if reply := subscriber.readRaw(); reply != ">3\r\n$7\r\nmessage\r\n$7\r\nchannel\r\n$5\r\nhello\r\n" {
+		t.Fatalf("message under RESP3: got %q, want a push", reply)
+	}
+	// Regular replies are still regular under RESP3.
+	subscriber.send("PING")
+	if reply := subscriber.readRaw(); reply != "+PONG\r\n" {
+		t.Fatalf("PING while subscribed under RESP3: got %q", reply)
+	}
+}
+
+func TestMessagesUnderRESP2(t *testing.T) {
+	_, addr := startServer(t)
+	subscriber, publisher := dial(t, addr), dial(t, addr)
+	subscriber.expect([]any{"subscribe", "channel", int64(1)}, "SUBSCRIBE", "channel")
+	publisher.expect(int64(1), "PUBLISH", "channel", "hello")
+	if reply := subscriber.read(); !reflect.DeepEqual(reply, []any{"message", "channel", "hello"}) {
+		t.Fatalf("message under RESP2: got %#v", reply)
+	}
+}
+
+func TestSubscribeCounts(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect([]any{"subscribe", "a", int64(1)}, "SUBSCRIBE", "a")
+	c.send("SUBSCRIBE", "b", "c")
+	for i, channel := range []string{"b", "c"} {
+		if reply := c.read(); !reflect.DeepEqual(reply, []any{"subscribe", channel, int64(i + 2)}) {
+			t.Fatalf("SUBSCRIBE b c: got %#v", reply)
+		}
+	}
+	c.expect([]any{"psubscribe", "p*", int64(4)}, "PSUBSCRIBE", "p*")
+	// Subscribing again to a channel does not count it twice.
+	c.expect([]any{"subscribe", "a", int64(4)}, "SUBSCRIBE", "a")
+	c.expect([]any{"unsubscribe", "b", int64(3)}, "UNSUBSCRIBE", "b")
+
+	// The count includes the pattern, which UNSUBSCRIBE leaves.
+	c.send("UNSUBSCRIBE")
+	left := map[any]bool{}
+	for count := int64(2); count >= 1; count-- {
+		reply, _ := c.read().([]any)
+		if len(reply) != 3 || reply[0] != "unsubscribe" || reply[2] != count {
+			t.Fatalf("UNSUBSCRIBE: got %#v", reply)
+		}
+		left[reply[1]] = true
+	}
+	if !left["a"] || !left["c"] {
+		t.Fatalf("UNSUBSCRIBE did not unsubscribe from a and c: %v", left)
+	}
+	c.expect([]any{"unsubscribe", nil, int64(1)}, "UNSUBSCRIBE")
+	c.expect([]any{"punsubscribe", "p*", int64(0)}, "PUNSUBSCRIBE")
+}
+
+func TestPubSubIntrospection(t *testing.T) {
+	_, addr := startServer(t)
+	first, second, c := dial(t, addr), dial(t, addr), dial(t, addr)
+	first.expect([]any{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	second.expect([]any{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	second.expect([]any{"subscribe", "sports", int64(2)}, "SUBSCRIBE", "sports")
+	second.expect([]any{"psubscribe", "n*", int64(3)}, "PSUBSCRIBE", "n*")
+
+	c.expect([]any{"news", int64(2), "sports", int64(1), "weather", int64(0)}, "PUBSUB", "NUMSUB", "news", "sports", "weather")
+	c.expect(int64(1), "PUBSUB", "NUMPAT")
+	channels, _ := c.do("PUBSUB", "CHANNELS").([]any)
+	if len(channels) != 2 {
+		t.Fatalf("PUBSUB CHANNELS: got %#v", channels)
+	}
+	c.expect([]any{"sports"}, "PUBSUB", "CHANNELS", "s*")
+	c.expect(int64(3), "PUBLISH", "news", "hello")
+}
+
+func TestShardedPubSub(t *testing.T) {
+	_, addr := startServer(t)
+	sharded, regular, publisher := dial(t, addr), dial(t, addr), dial(t, addr)
+	sharded.expect([]any{"ssubscribe", "orders", int64(1)}, "SSUBSCRIBE", "orders")
+	regular.expect([]any{"subscribe", "orders", int64(1)}, "SUBSCRIBE", "orders")
+
+	publisher.expect(int64(1), "SPUBLISH", "orders", "shard")
+	if reply := sharded.read(); !reflect.DeepEqual(reply, []any{"smessage", "orders", "shard"}) {
+		t.Fatalf("SPUBLISH to the shard subscriber: got %#v", reply)
+	}
+	publisher.expect(int64(1), "PUBLISH", "orders", "plain")
+	if reply := regular.read(); !reflect.DeepEqual(reply, []any{"message", "orders", "plain"}) {
+		t.Fatalf("PUBLISH to the regular subscriber: got %#v", reply)
+	}
+	// Neither got the message of the other kind.
+	sharded.expect([]any{"sunsubscribe", "orders", int64(0)}, "SUNSUBSCRIBE", "orders")
+	regular.expect([]any{"unsubscribe", "orders", int64(0)}, "UNSUBSCRIBE", "orders")
+	publisher.expect([]any{"orders", int64(0)}, "PUBSUB", "SHARDNUMSUB", "orders")
+}
+
+func TestSubscriptionsDroppedOnClose(t *testing.T) {
+	s, addr := startServer(t)
+	subscriber, c := dial(t, addr), dial(t, addr)
+	subscriber.expect([]any{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	subscriber.expect([]any{"psubscribe", "n*", int64(2)}, "PSUBSCRIBE", "n*")
+	subscriber.expect([]any{"ssubscribe", "orders", int64(1)}, "SSUBSCRIBE", "orders")
+	c.expect([]any{"news"}, "PUBSUB", "CHANNELS")
+
+	subscriber.conn.Close()
+	waitFor(t, "the subscriber to be unregistered", func() bool { return clientCount(s) == 1 })
+	c.expect([]any{}, "PUBSUB", "CHANNELS")
+	c.expect([]any{}, "PUBSUB", "SHARDCHANNELS")
+	c.expect(int64(0), "PUBSUB", "NUMPAT")
+	c.expect(int64(0), "PUBLISH", "news", "hello")
+	c.expect(int64(0), "SPUBLISH", "orders", "hello")
+}
+
+func TestCommandsWhileSubscribed(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect([]any{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	c.expect(respError("ERR Can't execute 'set': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context"),
+		"SET", "key", "value")
+	c.expect([]any{"pong", ""}, "PING")
+	c.expect([]any{"psubscribe", "n*", int64(2)}, "PSUBSCRIBE", "n*")
+	c.expect([]any{"unsubscribe", "news", int64(1)}, "UNSUBSCRIBE")
+	c.expect([]any{"punsubscribe", "n*", int64(0)}, "PUNSUBSCRIBE")
+	c.expect(respStatus("OK"), "SET", "key", "value")
+
+	resp3 := dial(t, addr)
+	resp3.hello3()
+	resp3.send("SUBSCRIBE", "news")
+	resp3.readRaw()
+	resp3.send("SET", "key", "other")
+	if reply := resp3.readRaw(); reply != "+OK\r\n" {
+		t.Fatalf("SET while subscribed under RESP3: got %q", reply)
+	}
+}
+
+func TestSubscribeInMulti(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "MULTI")
+	c.expect(respStatus("QUEUED"), "SUBSCRIBE", "news")
+	c.expect(respStatus("QUEUED"), "PING")
+	c.expect([]any{[]any{"subscribe", "news", int64(1)}, []any{"pong", ""}}, "EXEC")
+	if _, ok := c.do("GET", "key").(respError); !ok {
+		t.Fatal("GET was allowed after subscribing in a transaction")
+	}
+}
+
+func TestConcurrentPublishers(t *testing.T) {
+	const publishers, messages = 8, 100
+	_, addr := startServer(t)
+	subscriber := dial(t, addr)
+	subscriber.expect([]any{"subscribe", "news", int64(1)}, "SUBSCRIBE", "news")
+	payload := strings.Repeat("x", 4096)
+	errs := make(chan error, publishers)
+	for p := 0; p < publishers; p++ {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		go func() {
+			var pipeline strings.Builder
+			for i := 0; i < messages; i++ {
+				pipeline.WriteString(createArrayMsg([]string{"PUBLISH", "news", fmt.Sprintf("%d:%d:%s", p, i, payload)}))
+			}
+			if _, err := conn.Write([]byte(pipeline.String())); err != nil {
+				errs <- err
+				return
+			}
+			reader := bufio.NewReader(conn)
+			for i := 0; i < messages; i++ {
+				if _, err := readReply(reader); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- nil
+		}()
+	}
+	// Every message arrives whole, and those of each publisher in order.
+	next := make([]int, publishers)
+	for n := 0; n < publishers*messages; n++ {
+		reply, _ := subscriber.read().([]any)
+		if len(reply) != 3 || reply[0] != "message" || reply[1] != "news" {
+			t.Fatalf("message %d: got %.100q", n, reply)
+		}
+		var p, i int
+		var rest string
+		if _, err := fmt.Sscanf(reply[2].(string), "%d:%d:%s", &p, &i, &rest); err != nil || rest != payload || p < 0 || p >= publishers || i != next[p] {
+			t.Fatalf("message %d: got %.40q, out of order or cut", n, reply[2])
+		}
+		next[p]++
+	}
+	for p := 0; p < publishers; p++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHelloWhilePublishing(t *testing.T) {
+	_, addr := startServer(t)
+	subscriber, publisher := dial(t, addr), dial(t, addr)
+	subscriber.hello3()
+	subscriber.send("SUBSCRIBE", "channel")
+	subscriber.readRaw()
+	// The publisher reads the protocol of the subscriber while it switches
+	// it again.
+	const n = 100
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			publisher.send("PUBLISH", "channel", "hello")
+		}
+	}()
+	for i := 0; i < n; i++ {
+		subscriber.send("HELLO", "3")
+	}
+	<-done
+	message := ">3\r\n$7\r\nmessage\r\n$7\r\nchannel\r\n$5\r\nhello\r\n"
+	var out string
+	for strings.Count(out, message) < n {
+		raw := subscriber.readRaw()
+		if raw == "" {
+			t.Fatalf("got %d messages, want %d pushes", strings.Count(out, message), n)
+		}
+		out += raw
+	}
+}
@@ -0,0 +1,1196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// rdbVersion is the RDB format version written by this server, the one used
+// by redis 7.2.
+const rdbVersion = 11
+
+// RDB value types. The listpack, intset and quicklist ones are only read:
+// values are written in the plain encodings.
+const (
+	rdbTypeString           = 0
+	rdbTypeList             = 1
+	rdbTypeSet              = 2
+	rdbTypeZset             = 3
+	rdbTypeHash             = 4
+	rdbTypeZset2            = 5
+	rdbTypeSetIntset        = 11
+	rdbTypeStreamListpacks  = 15
+	rdbTypeHashListpack     = 16
+	rdbTypeZsetListpack     = 17
+	rdbTypeListQuicklist2   = 18
+	rdbTypeStreamListpacks2 = 19
+	rdbTypeSetListpack      = 20
+	rdbTypeStreamListpacks3 = 21
+)
+
+// RDB opcodes, found in place of a value type in RDB files.
+const (
+	rdbOpFunction2    = 0xf5
+	rdbOpModuleAux    = 0xf7
+	rdbOpIdle         = 0xf8
+	rdbOpFreq         = 0xf9
+	rdbOpAux          = 0xfa
+	rdbOpResizeDB     = 0xfb
+	rdbOpExpireTimeMs = 0xfc
+	rdbOpExpireTime   = 0xfd
+	rdbOpSelectDB     = 0xfe
+	rdbOpEOF          = 0xff
+)
+
+// Quicklist node containers.
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// streamNodeMaxEntries is how many entries are written per stream node.
+const streamNodeMaxEntries = 100
+
+// RDB length encodings. The two most significant bits of the first byte of a
+// length tell how it is encoded; rdbEncVal instead marks a string stored in
+// one of the special rdbEnc* encodings.
+const (
+	rdbLen6Bit  = 0
+	rdbLen14Bit = 1
+	rdbLen32Bit = 0x80
+	rdbLen64Bit = 0x81
+	rdbEncVal   = 3
+
+	rdbEncInt8  = 0
+	rdbEncInt16 = 1
+	rdbEncInt32 = 2
+	rdbEncLZF   = 3
+)
+
+var errBadDump = errors.New("ERR DUMP payload version or checksum are wrong")
+var errBadRDB = errors.New("ERR Bad data format")
+
+// crcTable computes the CRC-64/Jones checksum redis puts at the end of DUMP
+// payloads and RDB files.
+var crcTable = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// crc64Jones returns the redis crc64 of p, continuing from crc. Redis starts
+// from 0 and does not invert the result, unlike hash/crc64.
+func crc64Jones(crc uint64, p []byte) uint64 {
+	return ^crc64.Update(^crc, crcTable, p)
+}
+
+func writeRDBLength(w *bytes.Buffer, n uint64) {
+	switch {
+	case n < 1<<6:
+		w.WriteByte(byte(n))
+	case n < 1<<14:
+		w.WriteByte(byte(n>>8) | rdbLen14Bit<<6)
+		w.WriteByte(byte(n))
+	case n <= 0xffffffff:
+		w.WriteByte(rdbLen32Bit)
+		binary.Write(w, binary.BigEndian, uint32(n))
+	default:
+		w.WriteByte(rdbLen64Bit)
+		binary.Write(w, binary.BigEndian, n)
+	}
+}
+
+func writeRDBString(w *bytes.Buffer, str string) {
+	writeRDBLength(w, uint64(len(str)))
+	w.WriteString(str)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeRDBValue writes the RDB type byte of value followed by its encoding.
+func writeRDBValue(w *bytes.Buffer, value any) error {
+	switch v := value.(type) {
+	case string:
+		w.WriteByte(rdbTypeString)
+		writeRDBString(w, v)
+	case []string:
+		w.WriteByte(rdbTypeList)
+		writeRDBLength(w, uint64(len(v)))
+		for _, element := range v {
+			writeRDBString(w, element)
+		}
+	case map[string]struct{}:
+		w.WriteByte(rdbTypeSet)
+		writeRDBLength(w, uint64(len(v)))
+		for _, member := range sortedKeys(v) {
+			writeRDBString(w, member)
+		}
+	case intset:
+		w.WriteByte(rdbTypeSet)
+		writeRDBLength(w, uint64(len(v)))
+		for _, member := range v.members() {
+			writeRDBString(w, member)
+		}
+	case *sortedSet:
+		w.WriteByte(rdbTypeZset2)
+		members := v.sorted()
+		writeRDBLength(w, uint64(len(members)))
+		for _, m := range members {
+			writeRDBString(w, m.member)
+			binary.Write(w, binary.LittleEndian, math.Float64bits(m.score))
+		}
+	case map[string]string:
+		w.WriteByte(rdbTypeHash)
+		writeRDBLength(w, uint64(len(v)))
+		for _, field := range sortedKeys(v) {
+			writeRDBString(w, field)
+			writeRDBString(w, v[field])
+		}
+	case *stream:
+		w.WriteByte(rdbTypeStreamListpacks3)
+		writeRDBStream(w, v)
+	default:
+		return fmt.Errorf("ERR cannot serialize a value of type %T", value)
+	}
+	return nil
+}
+
+func writeStreamID(w *bytes.Buffer, id streamID) {
+	writeRDBLength(w, id.ms)
+	writeRDBLength(w, id.seq)
+}
+
+// rawStreamID is the 16 byte big endian form of id used for stream node keys
+// and pending entries.
+func rawStreamID(id streamID) []byte {
+	raw := binary.BigEndian.AppendUint64(nil, id.ms)
+	return binary.BigEndian.AppendUint64(raw, id.seq)
+}
+
+// writeRDBStream writes a stream in the RDB_TYPE_STREAM_LISTPACKS_3 format:
+// its entries grouped in listpack nodes, its metadata, and its consumer
+// groups with their pending entries lists.
+func writeRDBStream(w *bytes.Buffer, st *stream) {
+	nodes := (len(st.entries) + streamNodeMaxEntries - 1) / streamNodeMaxEntries
+	writeRDBLength(w, uint64(nodes))
+	for start := 0; start < len(st.entries); start += streamNodeMaxEntries {
+		node := st.entries[start:min(start+streamNodeMaxEntries, len(st.entries))]
+		master := node[0]
+		writeRDBString(w, string(rawStreamID(master.id)))
+
+		// The master entry: the entry counts and the field names of the
+		// first entry, which the entries with the same fields omit.
+		lp := newListpackWriter()
+		lp.appendInt(int64(len(node)))
+		lp.appendInt(0)
+		lp.appendInt(int64(len(master.fields) / 2))
+		for i := 0; i < len(master.fields); i += 2 {
+			lp.append(master.fields[i])
+		}
+		lp.appendInt(0)
+		for _, entry := range node {
+			sameFields := len(entry.fields) == len(master.fields)
+			for i := 0; sameFields && i < len(entry.fields); i += 2 {
+				sameFields = entry.fields[i] == master.fields[i]
+			}
+			numFields := len(entry.fields) / 2
+			if sameFields {
+				lp.appendInt(streamItemSameFields)
+			} else {
+				lp.appendInt(0)
+			}
+			lp.appendInt(int64(entry.id.ms - master.id.ms))
+			lp.appendInt(int64(entry.id.seq - master.id.seq))
+			if sameFields {
+				for i := 1; i < len(entry.fields); i += 2 {
+					lp.append(entry.fields[i])
+				}
+				lp.appendInt(int64(numFields + 3))
+			} else {
+				lp.appendInt(int64(numFields))
+				for _, field := range entry.fields {
+					lp.append(field)
+				}
+				lp.appendInt(int64(2*numFields + 4))
+			}
+		}
+		writeRDBString(w, string(lp.bytes()))
+	}
+
+	writeRDBLength(w, uint64(len(st.entries)))
+	writeStreamID(w, st.lastID)
+	var first streamID
+	if len(st.entries) > 0 {
+		first = st.entries[0].id
+	}
+	writeStreamID(w, first)
+	writeStreamID(w, st.maxDeletedID)
+	writeRDBLength(w, st.entriesAdded)
+
+	writeRDBLength(w, uint64(len(st.groups)))
+	for _, name := range sortedKeys(st.groups) {
+		g := st.groups[name]
+		writeRDBString(w, name)
+		writeStreamID(w, g.lastID)
+		// The number of entries read by the group is not tracked, which
+		// redis records as -1.
+		writeRDBLength(w, math.MaxUint64)
+		ids := g.pendingIDs("")
+		writeRDBLength(w, uint64(len(ids)))
+		for _, id := range ids {
+			p := g.pending[id]
+			w.Write(rawStreamID(id))
+			binary.Write(w, binary.LittleEndian, p.delivered.UnixMilli())
+			writeRDBLength(w, uint64(p.deliveries))
+		}
+		consumers := sortedKeys(g.consumers)
+		writeRDBLength(w, uint64(len(consumers)))
+		for _, consumer := range consumers {
+			writeRDBString(w, consumer)
+			// Neither the seen time nor the active time are tracked.
+			binary.Write(w, binary.LittleEndian, int64(0))
+			binary.Write(w, binary.LittleEndian, int64(0))
+			owned := g.pendingIDs(consumer)
+			writeRDBLength(w, uint64(len(owned)))
+			for _, id := range owned {
+				w.Write(rawStreamID(id))
+			}
+		}
+	}
+}
+
+// readRDBLength reads a length. encoded is set when the length is instead one
+// of the special string encodings (integers or LZF).
+func readRDBLength(r *bufio.Reader) (n uint64, encoded bool, err error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case rdbLen6Bit:
+		return uint64(first & 0x3f), false, nil
+	case rdbLen14Bit:
+		next, err := r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case rdbEncVal:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case rdbLen32Bit:
+		var n32 uint32
+		err := binary.Read(r, binary.BigEndian, &n32)
+		return uint64(n32), false, err
+	case rdbLen64Bit:
+		err := binary.Read(r, binary.BigEndian, &n)
+		return n, false, err
+	}
+	return 0, false, errBadRDB
+}
+
+func readRDBString(r *bufio.Reader) (string, error) {
+	n, encoded, err := readRDBLength(r)
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+	switch n {
+	case rdbEncInt8:
+		b, err := r.ReadByte()
+		return strconv.Itoa(int(int8(b))), err
+	case rdbEncInt16:
+		var v int16
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return strconv.Itoa(int(v)), err
+	case rdbEncInt32:
+		var v int32
+		err := binary.Read(r, binary.LittleEndian, &v)
+		return strconv.Itoa(int(v)), err
+	case rdbEncLZF:
+		compressedLen, _, err := readRDBLength(r)
+		if err != nil {
+			return "", err
+		}
+		rawLen, _, err := readRDBLength(r)
+		if err != nil {
+			return "", err
+		}
+		compressed := make([]byte, compressedLen)
+		if _, err := io.ReadFull(r, compressed); err != nil {
+			return "", err
+		}
+		raw, err := lzfDecompress(compressed, int(rawLen))
+		return string(raw), err
+	}
+	return "", errBadRDB
+}
+
+func readRDBStrings(r *bufio.Reader, n uint64) ([]string, error) {
+	var strs []string
+	for i := uint64(0); i < n; i++ {
+		str, err := readRDBString(r)
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, str)
+	}
+	return strs, nil
+}
+
+// readRDBCollection reads a length prefixed list of strings.
+func readRDBCollection(r *bufio.Reader) ([]string, error) {
+	n, _, err := readRDBLength(r)
+	if err != nil {
+		return nil, err
+	}
+	return readRDBStrings(r, n)
+}
+
+// readRDBListpack reads a listpack stored as a string and returns its
+// elements.
+func readRDBListpack(r *bufio.Reader) ([]string, error) {
+	lp, err := readRDBString(r)
+	if err != nil {
+		return nil, err
+	}
+	return parseListpack([]byte(lp))
+}
+
+// readRDBScore reads a sorted set score stored as a string, whose length
+// byte is one of 253, 254 and 255 for NaN and the infinities.
+func readRDBScore(r *bufio.Reader) (float64, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+func newSet(members []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(members))
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return set
+}
+
+func newHash(pairs []string) (map[string]string, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errBadRDB
+	}
+	hash := make(map[string]string, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		hash[pairs[i]] = pairs[i+1]
+	}
+	return hash, nil
+}
+
+// readRDBValue reads a value of the given RDB type.
+func readRDBValue(r *bufio.Reader, valueType byte) (any, error) {
+	switch valueType {
+	case rdbTypeString:
+		return readRDBString(r)
+	case rdbTypeList:
+		list, err := readRDBCollection(r)
+		if err == nil && len(list) == 0 {
+			return nil, errBadRDB
+		}
+		return list, err
+	case rdbTypeListQuicklist2:
+		nodes, _, err := readRDBLength(r)
+		if err != nil {
+			return nil, err
+		}
+		var list []string
+		for i := uint64(0); i < nodes; i++ {
+			container, _, err := readRDBLength(r)
+			if err != nil {
+				return nil, err
+			}
+			var elements []string
+			switch container {
+			case quicklistNodePlain:
+				var element string
+				element, err = readRDBString(r)
+				elements = []string{element}
+			case quicklistNodePacked:
+				elements, err = readRDBListpack(r)
+			default:
+				return nil, errBadRDB
+			}
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, elements...)
+		}
+		if len(list) == 0 {
+			return nil, errBadRDB
+		}
+		return list, nil
+	case rdbTypeSet, rdbTypeSetIntset, rdbTypeSetListpack:
+		var members []string
+		var err error
+		switch valueType {
+		case rdbTypeSet:
+			members, err = readRDBCollection(r)
+		case rdbTypeSetListpack:
+			members, err = readRDBListpack(r)
+		default:
+			var intset string
+			if intset, err = readRDBString(r); err == nil {
+				members, err = parseIntset([]byte(intset))
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(members) == 0 {
+			return nil, errBadRDB
+		}
+		return newSet(members), nil
+	case rdbTypeZset, rdbTypeZset2:
+		n, _, err := readRDBLength(r)
+		if err != nil {
+			return nil, err
+		}
+		zset := newSortedSet()
+		for i := uint64(0); i < n; i++ {
+			member, err := readRDBString(r)
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if valueType == rdbTypeZset2 {
+				var bits uint64
+				err = binary.Read(r, binary.LittleEndian, &bits)
+				score = math.Float64frombits(bits)
+			} else {
+				score, err = readRDBScore(r)
+			}
+			if err != nil {
+				return nil, err
+			}
+			if math.IsNaN(score) {
+				return nil, errBadRDB
+			}
+			zset.set(member, score)
+		}
+		if len(zset.scores) == 0 {
+			return nil, errBadRDB
+		}
+		return zset, nil
+	case rdbTypeZsetListpack:
+		elements, err := readRDBListpack(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(elements) == 0 || len(elements)%2 != 0 {
+			return nil, errBadRDB
+		}
+		zset := newSortedSet()
+		for i := 0; i < len(elements); i += 2 {
+			score, err := strconv.ParseFloat(elements[i+1], 64)
+			if err != nil || math.IsNaN(score) {
+				return nil, errBadRDB
+			}
+			zset.set(elements[i], score)
+		}
+		return zset, nil
+	case rdbTypeHash:
+		n, _, err := readRDBLength(r)
+		if err != nil {
+			return nil, err
+		}
+		pairs, err := readRDBStrings(r, 2*n)
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs) == 0 {
+			return nil, errBadRDB
+		}
+		return newHash(pairs)
+	case rdbTypeHashListpack:
+		pairs, err := readRDBListpack(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(pairs) == 0 {
+			return nil, errBadRDB
+		}
+		return newHash(pairs)
+	case rdbTypeStreamListpacks, rdbTypeStreamListpacks2, rdbTypeStreamListpacks3:
+		return readRDBStream(r, valueType)
+	}
+	return nil, errBadRDB
+}
+
+func readStreamID(r *bufio.Reader) (streamID, error) {
+	ms, _, err := readRDBLength(r)
+	if err != nil {
+		return streamID{}, err
+	}
+	seq, _, err := readRDBLength(r)
+	return streamID{ms, seq}, err
+}
+
+func readRawStreamID(r *bufio.Reader) (streamID, error) {
+	var raw [16]byte
+	if _, err := io.ReadFull(r, raw[:]); err != nil {
+		return streamID{}, err
+	}
+	return streamID{binary.BigEndian.Uint64(raw[:]), binary.BigEndian.Uint64(raw[8:])}, nil
+}
+
+func parseListpackInt(s string) (int64, error) {
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, errBadRDB
+	}
+	return v, nil
+}
+
+// Stream entry flags in listpack nodes.
+const (
+	streamItemDeleted    = 1
+	streamItemSameFields = 2
+)
+
+// readStreamNode appends the live entries of the listpack node whose first
+// entry has the id master to st.
+func readStreamNode(st *stream, master streamID, elements []string) error {
+	next := func() (int64, error) {
+		if len(elements) == 0 {
+			return 0, errBadRDB
+		}
+		element := elements[0]
+		elements = elements[1:]
+		return parseListpackInt(element)
+	}
+	take := func(n int64) ([]string, error) {
+		if n < 0 || n > int64(len(elements)) {
+			return nil, errBadRDB
+		}
+		taken := elements[:n]
+		elements = elements[n:]
+		return taken, nil
+	}
+	if _, err := next(); err != nil { // valid entries
+		return err
+	}
+	if _, err := next(); err != nil { // deleted entries
+		return err
+	}
+	numMasterFields, err := next()
+	if err != nil {
+		return err
+	}
+	masterFields, err := take(numMasterFields)
+	if err != nil {
+		return err
+	}
+	if terminator, err := next(); err != nil || terminator != 0 {
+		return errBadRDB
+	}
+	for len(elements) > 0 {
+		flags, err := next()
+		if err != nil {
+			return err
+		}
+		msDiff, err := next()
+		if err != nil {
+			return err
+		}
+		seqDiff, err := next()
+		if err != nil {
+			return err
+		}
+		var fields []string
+		if flags&streamItemSameFields != 0 {
+			values, err := take(int64(len(masterFields)))
+			if err != nil {
+				return err
+			}
+			for i, field := range masterFields {
+				fields = append(fields, field, values[i])
+			}
+		} else {
+			numFields, err := next()
+			if err != nil {
+				return err
+			}
+			pairs, err := take(2 * numFields)
+			if err != nil {
+				return err
+			}
+			fields = append(fields, pairs...)
+		}
+		if _, err := next(); err != nil { // lp-count
+			return err
+		}
+		if flags&streamItemDeleted != 0 {
+			continue
+		}
+		id := streamID{master.ms + uint64(msDiff), master.seq + uint64(seqDiff)}
+		st.entries = append(st.entries, streamEntry{id, fields})
+	}
+	return nil
+}
+
+// readRDBStream reads a stream in any of the listpack formats.
+func readRDBStream(r *bufio.Reader, valueType byte) (*stream, error) {
+	st := &stream{}
+	nodes, _, err := readRDBLength(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		key, err := readRDBString(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) != 16 {
+			return nil, errBadRDB
+		}
+		master := streamID{binary.BigEndian.Uint64([]byte(key)), binary.BigEndian.Uint64([]byte(key[8:]))}
+		elements, err := readRDBListpack(r)
+		if err != nil {
+			return nil, err
+		}
+		if err := readStreamNode(st, master, elements); err != nil {
+			return nil, err
+		}
+	}
+	length, _, err := readRDBLength(r)
+	if err != nil {
+		return nil, err
+	}
+	if length != uint64(len(st.entries)) {
+		return nil, errBadRDB
+	}
+	if st.lastID, err = readStreamID(r); err != nil {
+		return nil, err
+	}
+	st.entriesAdded = length
+	if valueType >= rdbTypeStreamListpacks2 {
+		if _, err := readStreamID(r); err != nil { // first entry id
+			return nil, err
+		}
+		if st.maxDeletedID, err = readStreamID(r); err != nil {
+			return nil, err
+		}
+		if st.entriesAdded, _, err = readRDBLength(r); err != nil {
+			return nil, err
+		}
+	}
+
+	groups, _, err := readRDBLength(r)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		name, err := readRDBString(r)
+		if err != nil {
+			return nil, err
+		}
+		lastID, err := readStreamID(r)
+		if err != nil {
+			return nil, err
+		}
+		if valueType >= rdbTypeStreamListpacks2 {
+			if _, _, err := readRDBLength(r); err != nil { // entries read
+				return nil, err
+			}
+		}
+		g := newConsumerGroup(lastID)
+		pending, _, err := readRDBLength(r)
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < pending; j++ {
+			id, err := readRawStreamID(r)
+			if err != nil {
+				return nil, err
+			}
+			var delivered int64
+			if err := binary.Read(r, binary.LittleEndian, &delivered); err != nil {
+				return nil, err
+			}
+			deliveries, _, err := readRDBLength(r)
+			if err != nil {
+				return nil, err
+			}
+			g.pending[id] = &pendingEntry{delivered: time.UnixMilli(delivered), deliveries: int(deliveries)}
+		}
+		consumers, _, err := readRDBLength(r)
+		if err != nil {
+			return nil, err
+		}
+		for j := uint64(0); j < consumers; j++ {
+			consumer, err := readRDBString(r)
+			if err != nil {
+				return nil, err
+			}
+			times := 1
+			if valueType >= rdbTypeStreamListpacks3 {
+				times = 2
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(8*times)); err != nil {
+				return nil, err
+			}
+			g.consumers[consumer] = struct{}{}
+			owned, _, err := readRDBLength(r)
+			if err != nil {
+				return nil, err
+			}
+			for k := uint64(0); k < owned; k++ {
+				id, err := readRawStreamID(r)
+				if err != nil {
+					return nil, err
+				}
+				p, ok := g.pending[id]
+				if !ok {
+					return nil, errBadRDB
+				}
+				p.consumer = consumer
+			}
+		}
+		if st.groups == nil {
+			st.groups = map[string]*consumerGroup{}
+		}
+		st.groups[name] = g
+	}
+	return st, nil
+}
+
+// lzfDecompress expands LZF compressed data, used by redis for long strings.
+func lzfDecompress(in []byte, rawLen int) ([]byte, error) {
+	out := make([]byte, 0, rawLen)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// A literal run of ctrl+1 bytes.
+			if i+ctrl+1 > len(in) {
+				return nil, errBadRDB
+			}
+			out = append(out, in[i:i+ctrl+1]...)
+			i += ctrl + 1
+			continue
+		}
+		// A back reference: the length is in the top 3 bits, extended by
+		// the next byte when they are all set.
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, errBadRDB
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errBadRDB
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errBadRDB
+		}
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != rawLen {
+		return nil, errBadRDB
+	}
+	return out, nil
+}
+
+// dumpValue serializes value the way DUMP does: the RDB encoding of the value,
+// the RDB version as two little endian bytes, and a little endian crc64 of
+// everything before it.
+func dumpValue(value any) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeRDBValue(&buf, value); err != nil {
+		return nil, err
+	}
+	binary.Write(&buf, binary.LittleEndian, uint16(rdbVersion))
+	binary.Write(&buf, binary.LittleEndian, crc64Jones(0, buf.Bytes()))
+	return buf.Bytes(), nil
+}
+
+// restoreValue is the inverse of dumpValue: it checks the version and the
+// checksum of payload and decodes the value in it.
+func restoreValue(payload []byte) (any, error) {
+	if len(payload) < 10 {
+		return nil, errBadDump
+	}
+	footer := payload[len(payload)-10:]
+	version := binary.LittleEndian.Uint16(footer)
+	if version > rdbVersion {
+		return nil, errBadDump
+	}
+	if binary.LittleEndian.Uint64(footer[2:]) != crc64Jones(0, payload[:len(payload)-8]) {
+		return nil, errBadDump
+	}
+	body := payload[:len(payload)-10]
+	if len(body) == 0 {
+		return nil, errBadDump
+	}
+	rest := bytes.NewReader(body[1:])
+	r := bufio.NewReader(rest)
+	value, err := readRDBValue(r, body[0])
+	if err != nil || r.Buffered()+rest.Len() != 0 {
+		return nil, errBadRDB
+	}
+	return value, nil
+}
+
+// snapshot returns the databases as an RDB file. Every database is locked
+// while it is written, so that the file holds them as they were at a single
+// point in time.
+func (s *server) snapshot() []byte {
+	for _, db := range s.dbs {
+		db.Mutex.Lock()
+		defer db.Mutex.Unlock()
+	}
+	var buf bytes.Buffer
+	writeRDBHeader(&buf, s.clock.Now())
+	for index, db := range s.dbs {
+		db.writeRDB(&buf, index)
+	}
+	writeRDBFooter(&buf)
+	return buf.Bytes()
+}
+
+// writeRDB writes the database as the database index of an RDB file, unless
+// it is empty. Keys are written in order so that equal datasets give equal
+// files. The caller must hold the write lock.
+func (s *Store) writeRDB(buf *bytes.Buffer, index int) {
+	keys := s.liveKeys()
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	writeRDBDatabase(buf, index, len(keys), len(s.Expiries))
+	for _, key := range keys {
+		writeRDBKey(buf, key, s.Data[key], s.Expiries[key])
+	}
+}
+
+// liveKeys returns the keys that have not expired, deleting those that have.
+// The caller must hold the write lock.
+func (s *Store) liveKeys() []string {
+	var keys []string
+	for key := range s.Data {
+		if _, ok := s.lookup(key); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// writeRDBHeader starts an RDB file created at now.
+func writeRDBHeader(buf *bytes.Buffer, now time.Time) {
+	fmt.Fprintf(buf, "REDIS%04d", rdbVersion)
+	aux := [][2]string{
+		{"redis-ver", "7.2.0"},
+		{"redis-bits", "64"},
+		{"ctime", strconv.FormatInt(now.Unix(), 10)},
+		{"aof-base", "0"},
+	}
+	for _, field := range aux {
+		buf.WriteByte(rdbOpAux)
+		writeRDBString(buf, field[0])
+		writeRDBString(buf, field[1])
+	}
+}
+
+// writeRDBDatabase starts the database index of an RDB file, which holds
+// keys keys, expires of them with an expiry.
+func writeRDBDatabase(buf *bytes.Buffer, index, keys, expires int) {
+	buf.WriteByte(rdbOpSelectDB)
+	writeRDBLength(buf, uint64(index))
+	buf.WriteByte(rdbOpResizeDB)
+	writeRDBLength(buf, uint64(keys))
+	writeRDBLength(buf, uint64(expires))
+}
+
+// writeRDBKey writes a key of an RDB file with its value and its expiry,
+// if it is not zero. Values that cannot be serialized are left out.
+func writeRDBKey(buf *bytes.Buffer, key string, value any, expiry time.Time) {
+	var encoded bytes.Buffer
+	if err := writeRDBValue(&encoded, value); err != nil {
+		return
+	}
+	if !expiry.IsZero() {
+		buf.WriteByte(rdbOpExpireTimeMs)
+		binary.Write(buf, binary.LittleEndian, expiry.UnixMilli())
+	}
+	// The key goes between the type byte and the value.
+	buf.WriteByte(encoded.Bytes()[0])
+	writeRDBString(buf, key)
+	buf.Write(encoded.Bytes()[1:])
+}
+
+// writeRDBFooter ends an RDB file with its checksum.
+func writeRDBFooter(buf *bytes.Buffer) {
+	buf.WriteByte(rdbOpEOF)
+	binary.Write(buf, binary.LittleEndian, crc64Jones(0, buf.Bytes()))
+}
+
+// rdbDatabase is a database read from an RDB file.
+type rdbDatabase struct {
+	values   map[string]any
+	expiries map[string]time.Time
+}
+
+func newRDBDatabase() *rdbDatabase {
+	return &rdbDatabase{values: map[string]any{}, expiries: map[string]time.Time{}}
+}
+
+// readRDB parses the RDB file data into its databases, by index.
+func readRDB(data []byte) (map[int]*rdbDatabase, error) {
+	if len(data) < 9 || string(data[:5]) != "REDIS" {
+		return nil, errBadRDB
+	}
+	version, err := strconv.Atoi(string(data[5:9]))
+	if err != nil || version < 1 || version > rdbVersion {
+		return nil, errBadRDB
+	}
+	if version >= 5 {
+		// A zero checksum means the file was written with checksums off.
+		if len(data) < 17 {
+			return nil, errBadRDB
+		}
+		sum := binary.LittleEndian.Uint64(data[len(data)-8:])
+		if sum != 0 && sum != crc64Jones(0, data[:len(data)-8]) {
+			return nil, errBadRDB
+		}
+	}
+
+	r := bufio.NewReader(bytes.NewReader(data[9:]))
+	dbs := map[int]*rdbDatabase{}
+	// Keys before any SELECTDB belong to database 0.
+	db := newRDBDatabase()
+	dbs[0] = db
+	var expiry time.Time
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, errBadRDB
+		}
+		switch op {
+		case rdbOpEOF:
+			return dbs, nil
+		case rdbOpAux:
+			if _, err := readRDBStrings(r, 2); err != nil {
+				return nil, errBadRDB
+			}
+		case rdbOpResizeDB:
+			if _, _, err := readRDBLength(r); err != nil {
+				return nil, errBadRDB
+			}
+			if _, _, err := readRDBLength(r); err != nil {
+				return nil, errBadRDB
+			}
+		case rdbOpSelectDB:
+			index, _, err := readRDBLength(r)
+			if err != nil || index > math.MaxInt32 {
+				return nil, errBadRDB
+			}
+			if db = dbs[int(index)]; db == nil {
+				db = newRDBDatabase()
+				dbs[int(index)] = db
+			}
+		case rdbOpIdle:
+			if _, _, err := readRDBLength(r); err != nil {
+				return nil, errBadRDB
+			}
+		case rdbOpFreq:
+			if _, err := r.ReadByte(); err != nil {
+				return nil, errBadRDB
+			}
+		case rdbOpFunction2:
+			if _, err := readRDBString(r); err != nil {
+				return nil, errBadRDB
+			}
+		case rdbOpExpireTimeMs:
+			var ms int64
+			if err := binary.Read(r, binary.LittleEndian, &ms); err != nil {
+				return nil, errBadRDB
+			}
+			expiry = time.UnixMilli(ms)
+		case rdbOpExpireTime:
+			var sec int32
+			if err := binary.Read(r, binary.LittleEndian, &sec); err != nil {
+				return nil, errBadRDB
+			}
+			expiry = time.Unix(int64(sec), 0)
+		case rdbOpModuleAux:
+			return nil, errBadRDB
+		default:
+			key, err := readRDBString(r)
+			if err != nil {
+				return nil, errBadRDB
+			}
+			value, err := readRDBValue(r, op)
+			if err != nil {
+				return nil, errBadRDB
+			}
+			db.values[key] = value
+			if !expiry.IsZero() {
+				db.expiries[key] = expiry
+				expiry = time.Time{}
+			}
+		}
+	}
+}
+
+// loadSnapshot replaces the databases with the contents of the RDB file
+// data. The keys already expired are dropped. On error the databases are
+// left unchanged.
+func (s *server) loadSnapshot(data []byte) error {
+	dbs, err := readRDB(data)
+	if err != nil {
+		return err
+	}
+	for index := range dbs {
+		if index >= len(s.dbs) {
+			return fmt.Errorf("the snapshot has database %d, out of the %d databases", index, len(s.dbs))
+		}
+	}
+	for _, db := range s.dbs {
+		db.Mutex.Lock()
+		defer db.Mutex.Unlock()
+	}
+	limits := s.config.encodingLimits()
+	for index, db := range s.dbs {
+		if dbs[index] == nil {
+			dbs[index] = newRDBDatabase()
+		}
+		db.load(dbs[index], limits)
+	}
+	return nil
+}
+
+// load replaces the keyspace with loaded, dropping the keys already expired.
+// The sets that fit an intset under limits are stored as one. The caller must
+// hold the write lock.
+func (s *Store) load(loaded *rdbDatabase, limits encodingLimits) {
+	now := s.Clock.Now()
+	for key, at := range loaded.expiries {
+		if now.After(at) {
+			delete(loaded.values, key)
+			delete(loaded.expiries, key)
+		}
+	}
+	// The encodings are recorded as of the load, like those of the keys
+	// written.
+	encodings := make(map[string]string)
+	for key, value := range loaded.values {
+		if _, ok := value.(string); ok {
+			continue
+		}
+		encodings[key] = limits.encoding(value)
+		if set, ok := value.(map[string]struct{}); ok && encodings[key] == "intset" {
+			loaded.values[key] = newIntset(set)
+		}
+	}
+	s.Data, s.Expiries, s.Encodings = loaded.values, loaded.expiries, encodings
+	s.resetSizes()
+	s.signalReady()
+}
+
+// save writes a snapshot of the keyspace to path.
+func (s *server) save(path string) error {
+	dirty := s.dirty.Load()
+	if err := writeSnapshot(path, s.snapshot()); err != nil {
+		return err
+	}
+	s.saved(dirty)
+	return nil
+}
+
+// writeSnapshot writes the RDB file snapshot to path, replacing the previous
+// one only once the new one is complete.
+func writeSnapshot(path string, snapshot []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(snapshot)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// loadRDB replaces the keyspace with the snapshot at path.
+func (s *server) loadRDB(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return s.loadSnapshot(data)
+}
+
+// saveCommand implements SAVE, which writes the snapshot in the foreground.
+func (s *server) saveCommand(c *clientConn, commands []string) {
+	if len(commands) != 1 {
+		c.replyWrongArgs("save")
+		return
+	}
+	if s.bgsaveInProgress() {
+		c.replyError(errBgsaveInProgress)
+		return
+	}
+	if err := s.save(s.config.rdbPath()); err != nil {
+		fmt.Println("Failed to save the snapshot: ", err)
+		c.replyErrorMsg("Background save failed")
+		return
+	}
+	c.replyOK()
+}
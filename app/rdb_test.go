@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveLocation(t *testing.T) {
+	dir := t.TempDir()
+	_, addr := startServer(t, "dir", dir, "dbfilename", "snapshot.rdb")
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	c.expect(respStatus("OK"), "SAVE")
+	if _, err := os.Stat(filepath.Join(dir, "snapshot.rdb")); err != nil {
+		t.Fatalf("SAVE did not write the snapshot to dir/dbfilename: %v", err)
+	}
+
+	restarted, _ := startServer(t, "dir", dir, "dbfilename", "snapshot.rdb")
+	if value, ok, _ := restarted.dbs[0].Get("key"); !ok || value != "value" {
+		t.Fatalf("the restarted server got %q, %v", value, ok)
+	}
+}
+
+func TestPrepareDir(t *testing.T) {
+	nested := filepath.Join(t.TempDir(), "a", "b")
+	if err := prepareDir(nested); err != nil {
+		t.Fatalf("preparing a missing directory: %v", err)
+	}
+	if info, err := os.Stat(nested); err != nil || !info.IsDir() {
+		t.Fatalf("the directory was not created: %v", err)
+	}
+	entries, _ := os.ReadDir(nested)
+	if len(entries) != 0 {
+		t.Fatalf("the write probe was left behind: %v", entries)
+	}
+
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := prepareDir(file); err == nil {
+		t.Fatal("a regular file was accepted as the directory")
+	}
+}
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errReadOnly = errors.New("READONLY You can't write against a read only replica.")
+
+// replAckPeriod is how often a replica acknowledges its offset unasked.
+const replAckPeriod = time.Second
+
+var errNoMasterLink = errors.New("NOMASTERLINK Can't SYNC while not connected with my master")
+
+// replica is a client that completed PSYNC and is fed the replication
+// stream through its output queue. addr is the host and the listening port
+// the replica announced, ack the last offset it acknowledged and ackTime when
+// it did, from which INFO reports its lag. fsynced is the last offset it
+// reported fsynced to its append only file, for WAITAOF, -1 until it does.
+// ack, ackTime and fsynced are guarded by the server's slavesMu.
+type replica struct {
+	client  *clientConn
+	addr    string
+	ack     int64
+	ackTime time.Time
+	fsynced int64
+}
+
+func (s *server) isReplica() bool {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+	return s.masterHost != ""
+}
+
+func (s *server) replicationID() string {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+	return s.replID
+}
+
+// replicaOf makes the server a replica of host:port, dropping the link to
+// the previous master if there is one.
+func (s *server) replicaOf(host, port string) {
+	s.replMu.Lock()
+	if s.masterLink != nil {
+		s.masterLink.Close()
+		s.masterLink = nil
+	}
+	s.masterHost, s.masterPort = host, port
+	s.masterGen++
+	gen := s.masterGen
+	s.linkUp = false
+	s.replMu.Unlock()
+	go s.replicate(host, port, gen)
+}
+
+// promote turns a replica into a master: the link to the master is closed,
+// the data is kept, and a new replication id starts a new history.
+func (s *server) promote() {
+	s.replMu.Lock()
+	defer s.replMu.Unlock()
+	if s.masterLink != nil {
+		s.masterLink.Close()
+		s.masterLink = nil
+	}
+	s.masterHost, s.masterPort = "", ""
+	s.masterGen++
+	s.linkUp = false
+	s.replID = randomID()
+}
+
+// The delays between the attempts to connect to the master, doubled after
+// every failed one.
+const (
+	replRetryMin = 100 * time.Millisecond
+	replRetryMax = 5 * time.Second
+)
+
+// replicate keeps the server in sync with the master at host:port, the
+// master of generation gen, connecting again whenever the link is lost
+// until replication is reconfigured. After the first synchronization it
+// asks to continue from where it stopped, which a master that kept the
+// stream can accept without sending the whole dataset again.
+func (s *server) replicate(host, port string, gen int) {
+	var cached *clientConn
+	for delay := replRetryMin; ; delay = min(2*delay, replRetryMax) {
+		if master := s.syncWithMaster(host, port, gen, cached); master != nil {
+			cached, delay = master, replRetryMin
+		}
+		<-s.clock.After(delay)
+		s.replMu.Lock()
+		current := s.masterGen == gen
+		s.replMu.Unlock()
+		if !current {
+			return
+		}
+	}
+}
+
+// syncWithMaster connects to the master at host:port, performs the
+// handshake and the resynchronization, and then applies the commands the
+// master streams until the link is closed. cached is the client that applied
+// the stream of the previous link, nil if there was none, from which the
+// stream continues if the master accepts to. It returns the client that
+// applied the stream, or nil if the link never came up.
+func (s *server) syncWithMaster(host, port string, gen int, cached *clientConn) *clientConn {
+	conn, err := net.Dial("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		fmt.Printf("Failed to connect to master at %s:%s: %v\n", host, port, err)
+		return nil
+	}
+	defer conn.Close()
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(s.config.replNoDelay())
+	}
+	s.replMu.Lock()
+	if s.masterGen != gen {
+		// Replication was reconfigured while dialing.
+		s.replMu.Unlock()
+		return nil
+	}
+	s.masterLink = conn
+	s.replMu.Unlock()
+	defer func() {
+		s.replMu.Lock()
+		if s.masterLink == conn {
+			s.masterLink = nil
+			s.linkUp = false
+		}
+		s.replMu.Unlock()
+	}()
+
+	reader := bufio.NewReader(conn)
+	handshake := [][]string{
+		{"PING"},
+		{"REPLCONF", "listening-port", strconv.FormatInt(s.config.port, 10)},
+		{"REPLCONF", "capa", "psync2"},
+	}
+	for _, command := range handshake {
+		conn.Write([]byte(createArrayMsg(command)))
+		reply, err := readReply(reader)
+		if err != nil {
+			fmt.Println("Failed to read the handshake reply from master: ", err)
+			return nil
+		}
+		if e, ok := reply.(respError); ok {
+			fmt.Printf("Master replied to %s with an error: %s\n", command[0], e)
+			return nil
+		}
+	}
+
+	psync := []string{"PSYNC", "?", "-1"}
+	if cached != nil {
+		s.replMu.Lock()
+		psync = []string{"PSYNC", s.replID, strconv.FormatInt(s.processed+1, 10)}
+		s.replMu.Unlock()
+	}
+	// A master that is itself a replica refuses PSYNC until it is in sync
+	// with its own master.
+	var line string
+	for {
+		conn.Write([]byte(createArrayMsg(psync)))
+		line, err = readLine(reader)
+		if err != nil {
+			fmt.Println("Failed to read the PSYNC reply from master: ", err)
+			return nil
+		}
+		if !strings.HasPrefix(line, "-NOMASTERLINK") {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	fields := strings.Fields(line)
+	master := &clientConn{master: true, reader: reader, addr: conn.RemoteAddr().String()}
+	if cached != nil && len(fields) > 0 && len(fields) <= 2 && fields[0] == "+CONTINUE" {
+		// The stream goes on from the offset asked for, in the database
+		// it last selected.
+		master.db = cached.db
+		s.replMu.Lock()
+		if s.masterLink != conn {
+			s.replMu.Unlock()
+			return nil
+		}
+		if len(fields) == 2 {
+			s.replID = fields[1]
+		}
+		s.linkUp = true
+		s.replMu.Unlock()
+	} else if !s.fullSync(conn, reader, fields) {
+		return nil
+	}
+
+	// The offset is acknowledged every second, which the master reports
+	// the lag from, and whenever GETACK asks for it, along with the offset
+	// fsynced to the append only file.
+	var ackMu sync.Mutex
+	ack := func() error {
+		s.replMu.Lock()
+		offset := strconv.FormatInt(s.processed, 10)
+		fsynced := strconv.FormatInt(s.fsyncedReplOffset(), 10)
+		s.replMu.Unlock()
+		ackMu.Lock()
+		defer ackMu.Unlock()
+		_, err := conn.Write([]byte(createArrayMsg([]string{"REPLCONF", "ACK", offset, "FACK", fsynced})))
+		return err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(replAckPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if ack() != nil {
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	// The offset moves by the size of every command, and GETACK is
+	// answered with the offset before it. The strings the master sends
+	// are not limited, as they were already accepted there.
+	for {
+		commands, err := master.next(0)
+		if err != nil {
+			return master
+		}
+		if len(commands) == 0 {
+			continue
+		}
+		if len(commands) == 3 && strings.EqualFold(commands[0], "replconf") && strings.EqualFold(commands[1], "getack") {
+			if err := ack(); err != nil {
+				fmt.Println("Failed to acknowledge the offset to master: ", err)
+				return master
+			}
+		} else {
+			s.execute(master, commands)
+		}
+		s.replMu.Lock()
+		s.processed += int64(len(createArrayMsg(commands)))
+		if s.aof != nil {
+			s.aofMarks = append(s.aofMarks, aofMark{aof: s.aof.currentOffset(), repl: s.processed})
+		}
+		s.replMu.Unlock()
+	}
+}
+
+// aofMark is the size the append only file reached once the replication
+// stream up to repl was applied.
+type aofMark struct {
+	aof, repl int64
+}
+
+// fsyncedReplOffset returns the offset of the replication stream up to which
+// what the replica applied is fsynced to the append only file, or -1 if it
+// has none or never fsyncs it. The caller must hold replMu.
+func (s *server) fsyncedReplOffset() int64 {
+	if s.aof == nil || s.aof.fsync == "no" {
+		return -1
+	}
+	synced := s.aof.syncedOffset()
+	for len(s.aofMarks) > 0 && s.aofMarks[0].aof <= synced {
+		s.fsyncedRepl = s.aofMarks[0].repl
+		s.aofMarks = s.aofMarks[1:]
+	}
+	return s.fsyncedRepl
+}
+
+// fullSync loads the snapshot the master sends after replying to PSYNC
+// with the fields of a +FULLRESYNC line, in place of the dataset. It reports
+// whether the link is up then.
+func (s *server) fullSync(conn net.Conn, reader *bufio.Reader, fields []string) bool {
+	var offset int64
+	var err error
+	if len(fields) == 3 && fields[0] == "+FULLRESYNC" {
+		offset, err = strconv.ParseInt(fields[2], 10, 64)
+	}
+	if len(fields) != 3 || fields[0] != "+FULLRESYNC" || err != nil {
+		fmt.Println("Unexpected PSYNC reply from master: ", strings.Join(fields, " "))
+		return false
+	}
+	// The snapshot is a bulk string without the trailing CRLF.
+	line, err := readLine(reader)
+	if err != nil || !strings.HasPrefix(line, "$") {
+		fmt.Println("Failed to read the snapshot from master: ", err)
+		return false
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		fmt.Println("Invalid snapshot length from master: ", line)
+		return false
+	}
+	snapshot := make([]byte, n)
+	if _, err := io.ReadFull(reader, snapshot); err != nil {
+		fmt.Println("Failed to read the snapshot from master: ", err)
+		return false
+	}
+
+	// The dataset is replaced while no command runs, and the replicas of
+	// this server are dropped since they followed the previous dataset.
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+	s.replMu.Lock()
+	if s.masterLink != conn {
+		s.replMu.Unlock()
+		return false
+	}
+	if err := s.loadSnapshot(snapshot); err != nil {
+		s.replMu.Unlock()
+		fmt.Println("Failed to load the snapshot from master: ", err)
+		return false
+	}
+	s.replID = fields[1]
+	s.processed = offset
+	s.fsyncedRepl, s.aofMarks = offset, nil
+	s.linkUp = true
+	s.replMu.Unlock()
+	s.slavesMu.Lock()
+	for _, slave := range s.slaves {
+		slave.client.conn.Close()
+	}
+	s.slaves, s.backlog = nil, nil
+	s.slavesMu.Unlock()
+	return true
+}
+
+// replicationInfo is the replication section of INFO.
+func (s *server) replicationInfo() string {
+	s.replMu.Lock()
+	var info strings.Builder
+	if s.masterHost == "" {
+		info.WriteString("role:master\r\n")
+	} else {
+		status := "down"
+		if s.linkUp {
+			status = "up"
+		}
+		fmt.Fprintf(&info, "role:slave\r\nmaster_host:%s\r\nmaster_port:%s\r\nmaster_link_status:%s\r\n",
+			s.masterHost, s.masterPort, status)
+	}
+	replID := s.replID
+	offset := s.processed
+	isReplica := s.masterHost != ""
+	s.replMu.Unlock()
+
+	s.slavesMu.Lock()
+	fmt.Fprintf(&info, "connected_slaves:%d\r\n", len(s.slaves))
+	for i, slave := range s.slaves {
+		host, port, _ := net.SplitHostPort(slave.addr)
+		lag := int(s.clock.Now().Sub(slave.ackTime).Seconds())
+		fmt.Fprintf(&info, "slave%d:ip=%s,port=%s,state=online,offset=%d,lag=%d\r\n", i, host, port, slave.ack, lag)
+	}
+	if !isReplica {
+		offset = s.replOffset
+	}
+	backlogActive, backlogLen := 0, len(s.backlog)
+	backlogFirst := s.replOffset - int64(backlogLen) + 1
+	if s.backlog != nil {
+		backlogActive = 1
+	} else {
+		backlogFirst = 0
+	}
+	s.slavesMu.Unlock()
+	fmt.Fprintf(&info, "master_replid:%s\r\nmaster_repl_offset:%d\r\n", replID, offset)
+	fmt.Fprintf(&info, "repl_backlog_active:%d\r\nrepl_backlog_size:%d\r\n", backlogActive, s.config.replBacklogSize())
+	fmt.Fprintf(&info, "repl_backlog_first_byte_offset:%d\r\nrepl_backlog_histlen:%d\r\n", backlogFirst, backlogLen)
+	return info.String()
+}
+
+// replconfCommand records the listening port a replica announces during the
+// handshake, used to tell replicas apart in INFO and FAILOVER, and the
+// offsets replicas acknowledge with REPLCONF ACK offset [FACK offset], which
+// get no reply. FACK is the offset up to which the replica fsynced the stream
+// to its append only file.
+func (s *server) replconfCommand(c *clientConn, commands []string) {
+	if (len(commands) == 3 || len(commands) == 5) && strings.EqualFold(commands[1], "ack") {
+		offset, err := strconv.ParseInt(commands[2], 10, 64)
+		fsynced := int64(-1)
+		if err == nil && len(commands) == 5 {
+			if !strings.EqualFold(commands[3], "fack") {
+				return
+			}
+			fsynced, err = strconv.ParseInt(commands[4], 10, 64)
+		}
+		if c.replica == nil || err != nil {
+			return
+		}
+		s.slavesMu.Lock()
+		c.replica.ackTime = s.clock.Now()
+		if offset > c.replica.ack || fsynced > c.replica.fsynced {
+			c.replica.ack = max(c.replica.ack, offset)
+			c.replica.fsynced = max(c.replica.fsynced, fsynced)
+			close(s.replAcked)
+			s.replAcked = make(chan struct{})
+		}
+		s.slavesMu.Unlock()
+		return
+	}
+	if len(commands) == 3 && strings.EqualFold(commands[1], "listening-port") {
+		c.listeningPort = commands[2]
+	}
+	c.replyOK()
+}
+
+// psyncCommand implements PSYNC replicationid offset. A replica that asks to
+// continue the stream of this server from an offset the backlog still holds
+// gets the rest of it after +CONTINUE. Any other is fully resynchronized from
+// a snapshot of the dataset. Either way it is then fed the replication
+// stream. A replica serves its own replicas the same way once it is in sync
+// with its master, and forwards them the stream it applies. execMu is held so
+// that no write falls between the snapshot and the stream, which are queued
+// to c rather than written while holding it.
+func (s *server) psyncCommand(c *clientConn, commands []string) {
+	s.execMu.Lock()
+	defer s.execMu.Unlock()
+	s.replMu.Lock()
+	refuse := s.masterHost != "" && !s.linkUp
+	replID := s.replID
+	s.replMu.Unlock()
+	if refuse {
+		c.replyError(errNoMasterLink)
+		return
+	}
+	s.slavesMu.Lock()
+	if rest, ok := s.backlogFrom(commands[2]); ok && commands[1] == replID {
+		c.replyStatus("CONTINUE " + replID)
+		c.reply(string(rest))
+		s.addReplica(c, s.replOffset-int64(len(rest)))
+		s.slavesMu.Unlock()
+		s.syncPartialOK.Add(1)
+		return
+	}
+	s.slavesMu.Unlock()
+	if commands[1] != "?" {
+		s.syncPartialErr.Add(1)
+	}
+	s.syncFull.Add(1)
+
+	snapshot := s.snapshot()
+	s.slavesMu.Lock()
+	defer s.slavesMu.Unlock()
+	// The new replica starts in database 0, whichever the stream selected.
+	s.replDB = -1
+	c.replyStatus(fmt.Sprintf("FULLRESYNC %s %d", replID, s.replOffset))
+	c.reply(fmt.Sprintf("$%d\r\n%s", len(snapshot), snapshot))
+	if s.backlog == nil {
+		s.backlog = []byte{}
+	}
+	s.addReplica(c, s.replOffset)
+}
+
+// backlogFrom returns the replication stream from offset on, the offset of
+// the first byte a reconnecting replica asks for, if the backlog still holds
+// it. The caller must hold slavesMu.
+func (s *server) backlogFrom(offset string) ([]byte, bool) {
+	from, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil || s.backlog == nil {
+		return nil, false
+	}
+	first := s.replOffset - int64(len(s.backlog)) + 1
+	if from < first || from > s.replOffset+1 {
+		return nil, false
+	}
+	return s.backlog[from-first:], true
+}
+
+// addReplica starts feeding the replication stream to c, a replica that
+// acknowledged offset. The caller must hold slavesMu.
+func (s *server) addReplica(c *clientConn, ack int64) {
+	if tcp, ok := c.conn.(*net.TCPConn); ok {
+		tcp.SetNoDelay(s.config.replNoDelay())
+	}
+	c.outLimit.Store(replicaOutputLimit)
+	host, _, _ := net.SplitHostPort(c.addr)
+	slave := &replica{client: c, addr: net.JoinHostPort(host, c.listeningPort), ack: ack, ackTime: s.clock.Now(), fsynced: -1}
+	s.clientsMu.Lock()
+	c.replica = slave
+	s.clientsMu.Unlock()
+	s.slaves = append(s.slaves, slave)
+}
+
+// removeReplica stops feeding the replication stream to slave.
+func (s *server) removeReplica(slave *replica) {
+	s.slavesMu.Lock()
+	defer s.slavesMu.Unlock()
+	for i, other := range s.slaves {
+		if other == slave {
+			s.slaves = append(s.slaves[:i], s.slaves[i+1:]...)
+			return
+		}
+	}
+}
+
+// feedReplicas queues msg on the replication stream of every replica. A
+// replica whose connection failed, or that fell further behind than its
+// output buffer limit, is dropped, since it would never acknowledge the
+// offset WAIT waits for. The caller must hold slavesMu.
+func (s *server) feedReplicas(msg string) {
+	live := s.slaves[:0]
+	for _, slave := range s.slaves {
+		if slave.client.send(msg); slave.client.broken.Load() {
+			continue
+		}
+		live = append(live, slave)
+	}
+	clear(s.slaves[len(live):])
+	s.slaves = live
+	s.replOffset += int64(len(msg))
+	if s.backlog != nil {
+		s.backlog = append(s.backlog, msg...)
+		if limit := s.config.replBacklogSize(); len(s.backlog) > limit {
+			s.backlog = s.backlog[len(s.backlog)-limit:]
+		}
+	}
+}
+
+// acked counts the replicas that acknowledged offset, and returns the channel
+// closed on the next acknowledgment.
+func (s *server) acked(offset int64) (int, <-chan struct{}) {
+	s.slavesMu.Lock()
+	defer s.slavesMu.Unlock()
+	count := 0
+	for _, slave := range s.slaves {
+		if slave.ack >= offset {
+			count++
+		}
+	}
+	return count, s.replAcked
+}
+
+// aofAcked counts the replicas that fsynced the replication stream up to
+// offset, and returns the channel closed on the next acknowledgment.
+func (s *server) aofAcked(offset int64) (int, <-chan struct{}) {
+	s.slavesMu.Lock()
+	defer s.slavesMu.Unlock()
+	count := 0
+	for _, slave := range s.slaves {
+		if slave.fsynced >= offset {
+			count++
+		}
+	}
+	return count, s.replAcked
+}
+
+// waitCommand implements WAIT numreplicas timeout. It blocks until
+// numreplicas replicas acknowledged the writes propagated so far, or until
+// timeout milliseconds pass, and replies with the number of replicas that
+// did. A timeout of 0 waits forever. The offset to acknowledge is taken when
+// WAIT starts, so writes of other clients meanwhile do not push it further.
+func (s *server) waitCommand(c *clientConn, commands []string) {
+	if len(commands) != 3 {
+		c.replyWrongArgs("wait")
+		return
+	}
+	numReplicas, err := strconv.Atoi(commands[1])
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	timeout, err := strconv.Atoi(commands[2])
+	if err != nil {
+		c.replyErrorMsg("timeout is not an integer or out of range")
+		return
+	}
+	if timeout < 0 {
+		c.replyErrorMsg("timeout is negative")
+		return
+	}
+	if s.isReplica() {
+		c.replyErrorMsg("WAIT cannot be used with replica instances. Please also note that since Redis 4.0 if a replica is configured to be writable (which is not the default) writes to replicas are just local and are not propagated.")
+		return
+	}
+
+	s.slavesMu.Lock()
+	target := s.replOffset
+	s.slavesMu.Unlock()
+	if c.execLocked {
+		// Nothing else runs until EXEC is done, so WAIT does not block.
+		count, _ := s.acked(target)
+		c.replyInteger(count)
+		return
+	}
+	c.replyInteger(s.waitReplicas(target, numReplicas, time.Duration(timeout)*time.Millisecond))
+}
+
+// waitReplicas blocks until numReplicas replicas acknowledged the
+// replication stream up to offset target, or until timeout passes unless it
+// is zero, and returns the number of replicas that did.
+func (s *server) waitReplicas(target int64, numReplicas int, timeout time.Duration) int {
+	count, ackCh := s.acked(target)
+	if count >= numReplicas {
+		return count
+	}
+	// Ask the replicas where they are. GETACK travels on the replication
+	// stream after the writes waited for. Without replicas there is nobody
+	// to ask, nor anything to move the offset for: only the deadline ends
+	// the wait.
+	getAck := createArrayMsg([]string{"REPLCONF", "GETACK", "*"})
+	s.slavesMu.Lock()
+	if len(s.slaves) > 0 {
+		s.feedReplicas(getAck)
+	}
+	s.slavesMu.Unlock()
+	s.blockedClients.Add(1)
+	defer s.blockedClients.Add(-1)
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		deadline = s.clock.After(timeout)
+	}
+	for {
+		select {
+		case <-ackCh:
+		case <-deadline:
+			count, _ = s.acked(target)
+			return count
+		case <-s.shuttingDown:
+			count, _ = s.acked(target)
+			return count
+		}
+		if count, ackCh = s.acked(target); count >= numReplicas {
+			return count
+		}
+	}
+}
+
+// executeAcked runs a write command of c like execute, but holds back its
+// reply until numReplicas replicas acknowledged the writes propagated by
+// then, or until timeout passes, for wait-on-write. Only the replies of the
+// command are captured meanwhile: the messages other clients send c, which
+// do not go through reply, are delivered as they come.
+func (s *server) executeAcked(c *clientConn, commands []string, numReplicas int, timeout time.Duration) {
+	s.slavesMu.Lock()
+	before := s.replOffset
+	s.slavesMu.Unlock()
+	var held strings.Builder
+	c.captured = &held
+	s.execute(c, commands)
+	c.captured = nil
+	s.slavesMu.Lock()
+	target := s.replOffset
+	s.slavesMu.Unlock()
+	if target > before {
+		s.waitReplicas(target, numReplicas, timeout)
+	}
+	if held.Len() > 0 {
+		c.reply(held.String())
+	}
+}
+
+// replicaofCommand implements REPLICAOF host port and REPLICAOF NO ONE,
+// which promotes a replica to master.
+func (s *server) replicaofCommand(c *clientConn, commands []string) {
+	if len(commands) != 3 {
+		c.replyWrongArgs(commands[0])
+		return
+	}
+	if strings.EqualFold(commands[1], "no") && strings.EqualFold(commands[2], "one") {
+		if s.isReplica() {
+			s.promote()
+		}
+		c.replyOK()
+		return
+	}
+	if _, err := strconv.ParseUint(commands[2], 10, 16); err != nil {
+		c.replyErrorMsg("Invalid master port")
+		return
+	}
+	s.replMu.Lock()
+	same := s.masterHost == commands[1] && s.masterPort == commands[2]
+	s.replMu.Unlock()
+	if same {
+		c.replyStatus("OK Already connected to specified master")
+		return
+	}
+	s.replicaOf(commands[1], commands[2])
+	c.replyOK()
+}
+
+// failoverCommand implements FAILOVER [TO host port]: the master hands over
+// to one of its replicas, by default the first one, and becomes its replica.
+// Writes are paused meanwhile, and the promotion travels on the replication
+// stream so that the replica applies every earlier write first.
+func (s *server) failoverCommand(c *clientConn, commands []string) {
+	target := ""
+	switch len(commands) {
+	case 1:
+	case 4:
+		if !strings.EqualFold(commands[1], "to") {
+			c.replyError(errSyntax)
+			return
+		}
+		target = net.JoinHostPort(commands[2], commands[3])
+	default:
+		c.replyError(errSyntax)
+		return
+	}
+	if s.isReplica() {
+		c.replyErrorMsg("FAILOVER is not valid when server is a replica.")
+		return
+	}
+
+	s.slavesMu.Lock()
+	var chosen *replica
+	for _, slave := range s.slaves {
+		if target == "" || slave.addr == target {
+			chosen = slave
+			break
+		}
+	}
+	s.slavesMu.Unlock()
+	if chosen == nil {
+		if target == "" {
+			c.replyErrorMsg("FAILOVER requires connected replicas.")
+		} else {
+			c.replyErrorMsg("FAILOVER target HOST and PORT is not a replica.")
+		}
+		return
+	}
+
+	// Hold back new writes, and wait for the ones already running to be
+	// propagated.
+	s.pauseMu.Lock()
+	s.pauseEnd = s.clock.Now().Add(time.Hour)
+	s.pauseAll = false
+	s.pauseMu.Unlock()
+	defer s.unpause()
+	s.execMu.Lock()
+	s.execMu.Unlock()
+
+	// The replica leaves the replicas fed the stream and gets the promotion
+	// in one step, so that nothing is propagated to it in between, and the
+	// promotion is queued after the writes of the stream it still has to
+	// apply.
+	s.slavesMu.Lock()
+	for i, other := range s.slaves {
+		if other == chosen {
+			s.slaves = append(s.slaves[:i], s.slaves[i+1:]...)
+			break
+		}
+	}
+	chosen.client.send(createArrayMsg([]string{"REPLICAOF", "NO", "ONE"}))
+	s.slavesMu.Unlock()
+	host, port, _ := net.SplitHostPort(chosen.addr)
+	s.replicaOf(host, port)
+	c.replyOK()
+}
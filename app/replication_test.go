@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeMaster is a master for a server under test to replicate from, which
+// the test drives over the replication link.
+type fakeMaster struct {
+	t        *testing.T
+	listener net.Listener
+	link     *testClient
+}
+
+func newFakeMaster(t *testing.T) *fakeMaster {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	return &fakeMaster{t: t, listener: listener}
+}
+
+// hostPort returns the host and the port replicas connect to.
+func (m *fakeMaster) hostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(m.listener.Addr().String())
+	return host, port
+}
+
+// accept accepts the link of a replica, answers its handshake and fully
+// resynchronizes it from snapshot, as replication id replID at offset 0.
+func (m *fakeMaster) accept(replID string, snapshot []byte) {
+	m.t.Helper()
+	m.handshake()
+	fmt.Fprintf(m.link.conn, "+FULLRESYNC %s 0\r\n$%d\r\n%s", replID, len(snapshot), snapshot)
+}
+
+// handshake accepts the link of a replica and answers its handshake up to
+// PSYNC, whose replication id and offset it returns unanswered.
+func (m *fakeMaster) handshake() (replID, offset string) {
+	m.t.Helper()
+	conn, err := m.listener.Accept()
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.t.Cleanup(func() { conn.Close() })
+	m.link = &testClient{t: m.t, conn: conn, reader: bufio.NewReader(conn)}
+	for {
+		command, _ := m.link.read().([]any)
+		if len(command) == 0 {
+			m.t.Fatalf("unexpected handshake command %#v", command)
+		}
+		switch strings.ToUpper(command[0].(string)) {
+		case "PING":
+			conn.Write([]byte("+PONG\r\n"))
+		case "REPLCONF":
+			conn.Write([]byte("+OK\r\n"))
+		case "PSYNC":
+			return command[1].(string), command[2].(string)
+		}
+	}
+}
+
+// restart closes the listener and the link, and listens again on the same
+// address, like a master that was restarted.
+func (m *fakeMaster) restart() {
+	m.t.Helper()
+	addr := m.listener.Addr().String()
+	m.listener.Close()
+	m.link.conn.Close()
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	m.t.Cleanup(func() { listener.Close() })
+	m.listener = listener
+}
+
+// send streams a command to the replica.
+func (m *fakeMaster) send(args ...string) {
+	m.t.Helper()
+	m.link.send(args...)
+}
+
+// snapshotOf returns the snapshot of a dataset holding the given keys and
+// string values in database 0.
+func snapshotOf(pairs ...string) []byte {
+	s := newServer()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		s.dbs[0].Set(pairs[i], pairs[i+1], time.Time{})
+	}
+	return s.snapshot()
+}
+
+// startReplica starts a server replicating from m, which it syncs from
+// snapshot, and returns it once the link is up.
+func startReplica(t *testing.T, m *fakeMaster, snapshot []byte, params ...string) (*server, string) {
+	t.Helper()
+	s, addr := startServer(t, params...)
+	host, port := m.hostPort()
+	dial(t, addr).expect(respStatus("OK"), "REPLICAOF", host, port)
+	t.Cleanup(s.promote)
+	m.accept(randomID(), snapshot)
+	waitFor(t, "the link to the master", func() bool {
+		s.replMu.Lock()
+		defer s.replMu.Unlock()
+		return s.linkUp
+	})
+	return s, addr
+}
+
+// fakeReplica is a connection that completed PSYNC, through which the test
+// reads the replication stream and acknowledges offsets.
+type fakeReplica struct {
+	*testClient
+	replID   string
+	offset   int64
+	snapshot []byte
+}
+
+// attachReplica connects to the server at addr as a replica and reads the
+// snapshot of the full resynchronization.
+func attachReplica(t *testing.T, addr string) *fakeReplica {
+	t.Helper()
+	c := dial(t, addr)
+	c.expect(respStatus("PONG"), "PING")
+	c.expect(respStatus("OK"), "REPLCONF", "listening-port", "6380")
+	c.expect(respStatus("OK"), "REPLCONF", "capa", "psync2")
+	c.send("PSYNC", "?", "-1")
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := readLine(c.reader)
+	fields := strings.Fields(line)
+	if err != nil || len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+		t.Fatalf("PSYNC: got %q, %v", line, err)
+	}
+	offset, _ := strconv.ParseInt(fields[2], 10, 64)
+	line, err = readLine(c.reader)
+	n, _ := strconv.Atoi(strings.TrimPrefix(line, "$"))
+	snapshot := make([]byte, n)
+	if _, err := io.ReadFull(c.reader, snapshot); err != nil {
+		t.Fatalf("reading the snapshot: %v", err)
+	}
+	return &fakeReplica{testClient: c, replID: fields[1], offset: offset, snapshot: snapshot}
+}
+
+// next reads the next command of the replication stream, moving the offset
+// past it.
+func (r *fakeReplica) next() []string {
+	r.t.Helper()
+	reply, ok := r.read().([]any)
+	if !ok {
+		r.t.Fatalf("unexpected replication stream reply %#v", reply)
+	}
+	command := make([]string, len(reply))
+	for i, arg := range reply {
+		command[i] = arg.(string)
+	}
+	r.offset += int64(len(createArrayMsg(command)))
+	return command
+}
+
+// expectNext fails the test unless the next command of the stream is want,
+// skipping SELECT and PING. The command name may differ in case.
+func (r *fakeReplica) expectNext(want ...string) {
+	r.t.Helper()
+	for {
+		command := r.next()
+		switch strings.ToUpper(command[0]) {
+		case "SELECT", "PING":
+			continue
+		}
+		if !strings.EqualFold(command[0], want[0]) || strings.Join(command[1:], " ") != strings.Join(want[1:], " ") {
+			r.t.Fatalf("replication stream: got %q, want %q", command, want)
+		}
+		return
+	}
+}
+
+// ack acknowledges the offset read so far.
+func (r *fakeReplica) ack() {
+	r.t.Helper()
+	r.send("REPLCONF", "ACK", strconv.FormatInt(r.offset, 10))
+}
+
+func TestPromoteReplica(t *testing.T) {
+	m := newFakeMaster(t)
+	_, addr := startReplica(t, m, snapshotOf("synced", "value"))
+	c := dial(t, addr)
+	c.expect("value", "GET", "synced")
+	m.send("SET", "streamed", "1")
+	waitFor(t, "the streamed SET", func() bool { return c.do("GET", "streamed") == "1" })
+	c.expect(respError(errReadOnly.Error()), "SET", "key", "value")
+
+	c.expect(respStatus("OK"), "REPLICAOF", "NO", "ONE")
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	c.expect("value", "GET", "synced")
+	if info, _ := c.do("INFO", "replication").(string); !strings.Contains(info, "role:master\r\n") {
+		t.Fatalf("INFO replication after REPLICAOF NO ONE: %q", info)
+	}
+}
+
+func TestChainedReplication(t *testing.T) {
+	m := newFakeMaster(t)
+	_, addr := startReplica(t, m, snapshotOf("synced", "value"))
+	sub := attachReplica(t, addr)
+	dbs, err := readRDB(sub.snapshot)
+	if err != nil || dbs[0] == nil || dbs[0].values["synced"] != "value" {
+		t.Fatalf("the sub-replica snapshot does not hold the synced key: %v", err)
+	}
+	m.send("SET", "streamed", "1")
+	sub.expectNext("SET", "streamed", "1")
+	m.send("DEL", "synced")
+	sub.expectNext("DEL", "synced")
+}
+
+func TestReplicaRefusesPSyncWithoutMasterLink(t *testing.T) {
+	m := newFakeMaster(t)
+	_, addr := startServer(t)
+	host, port := m.hostPort()
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "REPLICAOF", host, port)
+	defer c.expect(respStatus("OK"), "REPLICAOF", "NO", "ONE")
+	c.expect(respError(errNoMasterLink.Error()), "PSYNC", "?", "-1")
+}
+
+func TestWaitWithoutReplicas(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	start := time.Now()
+	c.expect(int64(0), "WAIT", "0", "100")
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("WAIT 0 100 took %v, want an immediate reply", elapsed)
+	}
+
+	start = time.Now()
+	c.send("WAIT", "1", "50")
+	// Other clients are served meanwhile.
+	other.expect(respStatus("PONG"), "PING")
+	if reply := c.read(); reply != int64(0) {
+		t.Fatalf("WAIT 1 50: got %#v, want 0", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("WAIT 1 50 replied after %v, before its timeout", elapsed)
+	}
+}
+
+func TestWaitAlreadyAcked(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	r.ack()
+	waitFor(t, "the acknowledgment", func() bool {
+		info, _ := c.do("INFO", "replication").(string)
+		return strings.Contains(info, fmt.Sprintf(",offset=%d,", r.offset))
+	})
+	c.expect(int64(1), "WAIT", "1", "0")
+}
+
+func TestWaitSkipsDeadReplica(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	live, dead := attachReplica(t, addr), attachReplica(t, addr)
+	dead.conn.Close()
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	live.expectNext("SET", "key", "value")
+	live.ack()
+	start := time.Now()
+	c.expect(int64(1), "WAIT", "1", "500")
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Fatalf("WAIT 1 500 took %v with a live replica that acknowledged", elapsed)
+	}
+	waitFor(t, "the dead replica to be dropped", func() bool {
+		return infoField(c.do("INFO", "replication"), "connected_slaves") == "1"
+	})
+}
+
+func TestReplicasPerServer(t *testing.T) {
+	_, addr := startServer(t)
+	_, otherAddr := startServer(t)
+	c, other := dial(t, addr), dial(t, otherAddr)
+	r := attachReplica(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	info := other.do("INFO", "replication")
+	if slaves, offset := infoField(info, "connected_slaves"), infoField(info, "master_repl_offset"); slaves != "0" || offset != "0" {
+		t.Fatalf("other server has %s replicas at offset %s, want 0 at 0", slaves, offset)
+	}
+	other.expect(int64(0), "WAIT", "1", "10")
+}
+
+func TestExpiryPropagatedAbsolute(t *testing.T) {
+	clk := newFakeClock()
+	_, addr := startServerWithClock(t, clk)
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	now := clk.Now().UnixMilli()
+	at := func(ms int64) string { return strconv.FormatInt(now+ms, 10) }
+
+	c.expect(respStatus("OK"), "SET", "key", "value", "EX", "100")
+	r.expectNext("SET", "key", "value", "PXAT", at(100000))
+	c.expect(int64(1), "EXPIRE", "key", "200")
+	r.expectNext("PEXPIREAT", "key", at(200000))
+	c.expect(int64(1), "PEXPIRE", "key", "300")
+	r.expectNext("PEXPIREAT", "key", at(300))
+	c.expect("value", "GETEX", "key", "EX", "50")
+	r.expectNext("GETEX", "key", "PXAT", at(50000))
+	c.expect(respStatus("OK"), "SET", "other", "value", "PX", "10")
+	r.expectNext("SET", "other", "value", "PXAT", at(10))
+	// The conditions and GET are not propagated, nor a SET they held back.
+	c.expect("value", "SET", "other", "kept", "NX", "GET")
+	c.expect(respStatus("OK"), "SET", "other", "new", "XX", "KEEPTTL")
+	r.expectNext("SET", "other", "new", "KEEPTTL")
+	c.expect("new", "SET", "other", "newer", "XX", "GET", "PXAT", at(20))
+	r.expectNext("SET", "other", "newer", "PXAT", at(20))
+	// An expiry in the past deletes the key.
+	c.expect(int64(1), "EXPIRE", "key", "-1")
+	r.expectNext("DEL", "key")
+}
+
+func TestWaitTargetsOffsetAtStart(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	r := attachReplica(t, addr)
+	for _, key := range []string{"a", "b", "c"} {
+		c.expect(respStatus("OK"), "SET", key, "value")
+		r.expectNext("SET", key, "value")
+	}
+	before := r.offset
+
+	c.send("WAIT", "1", "5000")
+	r.expectNext("REPLCONF", "GETACK", "*")
+	// Writes while WAIT runs do not move its target: acknowledging the
+	// writes before it is enough.
+	other.expect(respStatus("OK"), "SET", "d", "value")
+	r.expectNext("SET", "d", "value")
+	r.send("REPLCONF", "ACK", strconv.FormatInt(before, 10))
+	if reply := c.read(); reply != int64(1) {
+		t.Fatalf("WAIT: got %#v, want 1", reply)
+	}
+
+	// A WAIT after the write needs it acknowledged, along with the GETACK
+	// of the WAIT before.
+	c.expect(int64(0), "WAIT", "1", "100")
+	r.expectNext("REPLCONF", "GETACK", "*")
+	r.ack()
+	c.expect(int64(1), "WAIT", "1", "1000")
+}
+
+func TestReplconfAckRecordsOffset(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	offsetIs := func(offset int64) func() bool {
+		return func() bool {
+			info, _ := c.do("INFO", "replication").(string)
+			return strings.Contains(info, fmt.Sprintf(",offset=%d,", offset))
+		}
+	}
+	r.ack()
+	waitFor(t, "the acknowledged offset", offsetIs(r.offset))
+	// An older acknowledgment, arriving late, does not move it back, and
+	// neither gets a reply: the next thing read is the next write.
+	r.send("REPLCONF", "ACK", "1")
+	c.expect(respStatus("OK"), "SET", "other", "value")
+	r.expectNext("SET", "other", "value")
+	if !offsetIs(r.offset - int64(len(createArrayMsg([]string{"SET", "other", "value"}))))() {
+		t.Fatal("an older acknowledgment moved the offset back")
+	}
+}
+
+func TestReplicaAcksProcessedOffset(t *testing.T) {
+	m := newFakeMaster(t)
+	startReplica(t, m, snapshotOf())
+	m.send("SET", "key", "value")
+	set := int64(len(createArrayMsg([]string{"SET", "key", "value"})))
+	m.send("REPLCONF", "GETACK", "*")
+	// A periodic acknowledgment may come first, with an older offset.
+	for {
+		command, _ := m.link.read().([]any)
+		if len(command) != 5 || command[1] != "ACK" {
+			t.Fatalf("got %#v, want REPLCONF ACK offset FACK offset", command)
+		}
+		offset, _ := strconv.ParseInt(command[2].(string), 10, 64)
+		if offset == set {
+			// Without an append only file nothing is fsynced.
+			if command[3] != "FACK" || command[4] != "-1" {
+				t.Fatalf("REPLCONF ACK: got %#v, want FACK -1 without an append only file", command)
+			}
+			return
+		}
+		if offset > set {
+			t.Fatalf("REPLCONF ACK: got offset %d, want %d", offset, set)
+		}
+	}
+}
+
+func TestGetDelPropagation(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	// Neither changes anything, so the next command streamed is the SET
+	// after them.
+	c.expect(nil, "GETDEL", "missing")
+	c.expect("value", "GETEX", "key")
+	c.expect(respStatus("OK"), "SET", "marker", "1")
+	r.expectNext("SET", "marker", "1")
+
+	c.expect("value", "GETDEL", "key")
+	r.expectNext("DEL", "key")
+}
+
+func TestReplicaReconnects(t *testing.T) {
+	m := newFakeMaster(t)
+	_, addr := startReplica(t, m, snapshotOf("old", "value"))
+	c := dial(t, addr)
+	linkStatus := func() string { return infoField(c.do("INFO", "replication"), "master_link_status") }
+	m.restart()
+	waitFor(t, "the link to go down", func() bool { return linkStatus() == "down" })
+
+	// The restarted master has a new history, so the replica syncs anew.
+	m.accept(randomID(), snapshotOf("new", "value"))
+	m.send("SET", "after", "restart")
+	waitFor(t, "the write after the resync", func() bool { return c.do("GET", "after") == "restart" })
+	c.expect(nil, "GET", "old")
+	c.expect("value", "GET", "new")
+	if status := linkStatus(); status != "up" {
+		t.Fatalf("master_link_status after the resync: got %q, want up", status)
+	}
+}
+
+func TestReplicaContinuesStream(t *testing.T) {
+	m := newFakeMaster(t)
+	s, addr := startReplica(t, m, snapshotOf())
+	s.replMu.Lock()
+	replID := s.replID
+	s.replMu.Unlock()
+	m.send("SET", "before", "value")
+	c := dial(t, addr)
+	waitFor(t, "the first write", func() bool { return c.do("GET", "before") == "value" })
+	m.restart()
+
+	// The replica asks to continue from the byte after the write.
+	gotID, offset := m.handshake()
+	if want := strconv.Itoa(len(createArrayMsg([]string{"SET", "before", "value"})) + 1); gotID != replID || offset != want {
+		t.Fatalf("PSYNC %s %s, want PSYNC %s %s", gotID, offset, replID, want)
+	}
+	m.link.conn.Write([]byte("+CONTINUE\r\n"))
+	m.send("SET", "after", "value")
+	waitFor(t, "the write after the reconnection", func() bool { return c.do("GET", "after") == "value" })
+	c.expect("value", "GET", "before")
+}
+
+func TestPartialResync(t *testing.T) {
+	masterServer, masterAddr := startServer(t)
+	replicaServer, replicaAddr := startServer(t)
+	master, replica := dial(t, masterAddr), dial(t, replicaAddr)
+	host, port, _ := net.SplitHostPort(masterAddr)
+	replica.expect(respStatus("OK"), "REPLICAOF", host, port)
+	t.Cleanup(replicaServer.promote)
+	master.expect(respStatus("OK"), "SET", "before", "1")
+	waitFor(t, "the first write", func() bool { return replica.do("GET", "before") == "1" })
+
+	// The link drops, and the write made meanwhile comes from the backlog
+	// once the replica is back.
+	masterServer.slavesMu.Lock()
+	link := masterServer.slaves[0].client.conn
+	masterServer.slavesMu.Unlock()
+	link.Close()
+	master.expect(respStatus("OK"), "SET", "after", "2")
+	waitFor(t, "the write after the reconnection", func() bool { return replica.do("GET", "after") == "2" })
+	replica.expect("1", "GET", "before")
+	info := master.do("INFO", "stats")
+	if full, partial := infoField(info, "sync_full"), infoField(info, "sync_partial_ok"); full != "1" || partial != "1" {
+		t.Fatalf("got %s full and %s partial resynchronizations, want 1 of each", full, partial)
+	}
+}
+
+func TestPartialResyncOutsideBacklog(t *testing.T) {
+	_, addr := startServer(t, "repl-backlog-size", "16kb")
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	psync := func(replID string, offset int64) string {
+		other := dial(t, addr)
+		other.send("PSYNC", replID, strconv.FormatInt(offset, 10))
+		other.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := readLine(other.reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return line
+	}
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	if line := psync(r.replID, r.offset+1); line != "+CONTINUE "+r.replID {
+		t.Fatalf("PSYNC from the last offset: got %q", line)
+	}
+	if line := psync(randomID(), r.offset+1); !strings.HasPrefix(line, "+FULLRESYNC ") {
+		t.Fatalf("PSYNC of another replication id: got %q", line)
+	}
+	// The backlog no longer holds the start of the stream.
+	c.expect(respStatus("OK"), "SET", "big", strings.Repeat("x", 32<<10))
+	if line := psync(r.replID, 1); !strings.HasPrefix(line, "+FULLRESYNC ") {
+		t.Fatalf("PSYNC from before the backlog: got %q", line)
+	}
+	if errs := infoField(c.do("INFO", "stats"), "sync_partial_err"); errs != "2" {
+		t.Fatalf("sync_partial_err:%s, want 2", errs)
+	}
+}
+
+func TestWaitOnWrite(t *testing.T) {
+	_, addr := startServer(t, "wait-on-write", "1", "wait-on-write-timeout", "0")
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	c.send("SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	r.expectNext("REPLCONF", "GETACK", "*")
+	// Reads are not held back, but the SET still is.
+	other := dial(t, addr)
+	other.expect("value", "GET", "key")
+	c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := c.reader.Peek(1); err == nil {
+		t.Fatal("SET replied before the replica acknowledged it")
+	}
+	r.ack()
+	if reply := c.read(); reply != respStatus("OK") {
+		t.Fatalf("SET: got %#v", reply)
+	}
+}
+
+func TestWaitOnWriteDeliversMessages(t *testing.T) {
+	_, addr := startServer(t, "wait-on-write", "1", "wait-on-write-timeout", "0")
+	c, publisher := dial(t, addr), dial(t, addr)
+	c.hello3()
+	c.send("SUBSCRIBE", "news")
+	c.readRaw()
+	r := attachReplica(t, addr)
+	c.send("SET", "key", "value")
+	r.expectNext("SET", "key", "value")
+	r.expectNext("REPLCONF", "GETACK", "*")
+	// The message is pushed while the SET waits, ahead of its reply.
+	publisher.expect(int64(1), "PUBLISH", "news", "hello")
+	if reply := c.readRaw(); reply != ">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n" {
+		t.Fatalf("while the SET waits: got %q, want the message alone", reply)
+	}
+	r.ack()
+	if reply := c.readRaw(); reply != "+OK\r\n" {
+		t.Fatalf("SET once acknowledged: got %q", reply)
+	}
+}
+
+func TestWaitOnWriteConcurrentMessages(t *testing.T) {
+	_, addr := startServer(t, "wait-on-write", "1", "wait-on-write-timeout", "1")
+	c, publisher := dial(t, addr), dial(t, addr)
+	c.hello3()
+	c.send("SUBSCRIBE", "news")
+	c.readRaw()
+	// The replica never acknowledges, so every SET waits out the timeout
+	// while the messages are published.
+	attachReplica(t, addr)
+	const n = 50
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < n; i++ {
+			publisher.send("PUBLISH", "news", "hello")
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for i := 0; i < n; i++ {
+		c.send("SET", "key", strconv.Itoa(i))
+	}
+	<-done
+	message := ">3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n"
+	var out string
+	for strings.Count(out, message) < n || strings.Count(out, "+OK\r\n") < n {
+		raw := c.readRaw()
+		if raw == "" {
+			t.Fatalf("got %d messages and %d replies, want %d of each",
+				strings.Count(out, message), strings.Count(out, "+OK\r\n"), n)
+		}
+		out += raw
+	}
+}
+
+func TestWaitOnWriteTimeout(t *testing.T) {
+	_, addr := startServer(t, "wait-on-write", "1", "wait-on-write-timeout", "100")
+	c := dial(t, addr)
+	attachReplica(t, addr)
+	start := time.Now()
+	c.expect(respStatus("OK"), "SET", "key", "value")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("SET replied after %v, before the timeout", elapsed)
+	}
+	// A write that propagates nothing does not wait.
+	start = time.Now()
+	c.expect(int64(0), "DEL", "missing")
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Fatalf("a DEL of nothing waited %v", elapsed)
+	}
+}
+
+func TestWaitTimeoutWithoutReplicas(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	start := time.Now()
+	c.expect(int64(0), "WAIT", "1", "200")
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("WAIT 1 200 took %v, want about 200ms", elapsed)
+	}
+}
+
+func TestScriptEffectsPropagated(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	r := attachReplica(t, addr)
+	c.expect(int64(2), "EVAL", "redis.call('SET', KEYS[1], ARGV[1]) redis.call('GET', KEYS[1]) return redis.call('INCRBY', KEYS[2], 2)",
+		"2", "key", "counter", "value")
+	r.expectNext("SET", "key", "value")
+	r.expectNext("INCRBY", "counter", "2")
+}
+
+func TestScriptWriteOnReplica(t *testing.T) {
+	m := newFakeMaster(t)
+	_, addr := startReplica(t, m, snapshotOf("key", "value"))
+	c := dial(t, addr)
+	c.expect("value", "EVAL", "return redis.call('GET', KEYS[1])", "1", "key")
+	reply, _ := c.do("EVAL", "return redis.call('SET', KEYS[1], 'other')", "1", "key").(respError)
+	if !strings.HasPrefix(string(reply), "READONLY ") {
+		t.Fatalf("a script writing on a replica: got %q", reply)
+	}
+	c.expect("value", "GET", "key")
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// respWriter encodes replies to w. The RESP3 types are written as such when
+// resp3 is set, and as their RESP2 counterparts otherwise: maps as flat
+// arrays of keys and values, null as a null bulk string, doubles, verbatim
+// strings and big numbers as bulk strings, and pushes as arrays. The first
+// error of w is kept in err, and nothing is written after it.
+type respWriter struct {
+	w     io.Writer
+	resp3 bool
+	err   error
+	buf   []byte
+}
+
+func newRespWriter(w io.Writer, resp3 bool) *respWriter {
+	return &respWriter{w: w, resp3: resp3}
+}
+
+// replyWith sends the reply write encodes, in a single write so that it is
+// not interleaved with messages pushed to the client meanwhile.
+func (c *clientConn) replyWith(write func(w *respWriter)) {
+	var reply strings.Builder
+	write(newRespWriter(&reply, c.resp3()))
+	c.reply(reply.String())
+}
+
+// flush writes the encoded buf to w.
+func (rw *respWriter) flush() {
+	if rw.err == nil {
+		_, rw.err = rw.w.Write(rw.buf)
+	}
+	rw.buf = rw.buf[:0]
+}
+
+// header writes a type byte followed by n, the way lengths and integers are
+// encoded.
+func (rw *respWriter) header(kind byte, n int64) {
+	rw.buf = append(rw.buf, kind)
+	rw.buf = strconv.AppendInt(rw.buf, n, 10)
+	rw.buf = append(rw.buf, '\r', '\n')
+	rw.flush()
+}
+
+// line writes a type byte followed by s, which must not contain CR or LF.
+func (rw *respWriter) line(kind byte, s string) {
+	rw.buf = append(rw.buf, kind)
+	rw.buf = append(rw.buf, s...)
+	rw.buf = append(rw.buf, '\r', '\n')
+	rw.flush()
+}
+
+func (rw *respWriter) WriteSimpleString(s string) {
+	rw.line('+', s)
+}
+
+// WriteError writes an error reply. msg starts with the error code, such as
+// ERR or WRONGTYPE.
+func (rw *respWriter) WriteError(msg string) {
+	rw.line('-', msg)
+}
+
+func (rw *respWriter) WriteInteger(n int64) {
+	rw.header(':', n)
+}
+
+// WriteBulk writes b as a bulk string, which may hold any bytes.
+func (rw *respWriter) WriteBulk(b []byte) {
+	rw.header('$', int64(len(b)))
+	rw.buf = append(rw.buf, b...)
+	rw.buf = append(rw.buf, '\r', '\n')
+	rw.flush()
+}
+
+func (rw *respWriter) WriteBulkString(s string) {
+	rw.header('$', int64(len(s)))
+	rw.buf = append(rw.buf, s...)
+	rw.buf = append(rw.buf, '\r', '\n')
+	rw.flush()
+}
+
+func (rw *respWriter) WriteNull() {
+	if rw.resp3 {
+		rw.line('_', "")
+		return
+	}
+	rw.header('$', -1)
+}
+
+// WriteNullArray writes the null array RESP2 replies with when there is no
+// array at all, such as the timeout of a blocking command.
+func (rw *respWriter) WriteNullArray() {
+	if rw.resp3 {
+		rw.line('_', "")
+		return
+	}
+	rw.header('*', -1)
+}
+
+// WriteArray starts an array of n elements, to be written next.
+func (rw *respWriter) WriteArray(n int) {
+	rw.header('*', int64(n))
+}
+
+// WriteMap starts a map of n pairs, to be written next as n keys each
+// followed by its value.
+func (rw *respWriter) WriteMap(n int) {
+	if rw.resp3 {
+		rw.header('%', int64(n))
+		return
+	}
+	rw.header('*', int64(2*n))
+}
+
+// WritePush starts an out of band message of n elements.
+func (rw *respWriter) WritePush(n int) {
+	if rw.resp3 {
+		rw.header('>', int64(n))
+		return
+	}
+	rw.header('*', int64(n))
+}
+
+// WriteDouble writes f formatted the way redis formats scores.
+func (rw *respWriter) WriteDouble(f float64) {
+	if rw.resp3 {
+		rw.line(',', formatFloat(f))
+		return
+	}
+	rw.WriteBulkString(formatFloat(f))
+}
+
+// WriteVerbatim writes text as a verbatim string of the given three letter
+// format, such as "txt".
+func (rw *respWriter) WriteVerbatim(format, text string) {
+	if !rw.resp3 {
+		rw.WriteBulkString(text)
+		return
+	}
+	rw.header('=', int64(len(text)+4))
+	rw.buf = append(rw.buf, format...)
+	rw.buf = append(rw.buf, ':')
+	rw.buf = append(rw.buf, text...)
+	rw.buf = append(rw.buf, '\r', '\n')
+	rw.flush()
+}
+
+// WriteBigNumber writes the decimal integer n.
+func (rw *respWriter) WriteBigNumber(n string) {
+	if rw.resp3 {
+		rw.line('(', n)
+		return
+	}
+	rw.WriteBulkString(n)
+}
+
+// replyMap is a map reply whose pairs keep their order, unlike a go map.
+type replyMap []replyPair
+
+type replyPair struct {
+	key   string
+	value any
+}
+
+// nullArray stands for the null array in a reply built from go values.
+var nullArray = nullArrayReply{}
+
+type nullArrayReply struct{}
+
+// WriteReply encodes a reply built from go values: strings and byte slices as
+// bulk strings, integers, float64 as doubles, errors as error replies, nil
+// as null, nullArray as the null array, slices as arrays and maps, whose
+// keys are sorted, and replyMaps as maps. The elements of slices and maps are encoded the same way.
+func (rw *respWriter) WriteReply(value any) {
+	switch v := value.(type) {
+	case nil:
+		rw.WriteNull()
+	case nullArrayReply:
+		rw.WriteNullArray()
+	case string:
+		rw.WriteBulkString(v)
+	case []byte:
+		rw.WriteBulk(v)
+	case int:
+		rw.WriteInteger(int64(v))
+	case int64:
+		rw.WriteInteger(v)
+	case float64:
+		rw.WriteDouble(v)
+	case error:
+		rw.WriteError(v.Error())
+	case []string:
+		rw.WriteArray(len(v))
+		for _, element := range v {
+			rw.WriteBulkString(element)
+		}
+	case []any:
+		rw.WriteArray(len(v))
+		for _, element := range v {
+			rw.WriteReply(element)
+		}
+	case map[string]any:
+		rw.WriteMap(len(v))
+		for _, key := range sortedKeys(v) {
+			rw.WriteBulkString(key)
+			rw.WriteReply(v[key])
+		}
+	case replyMap:
+		rw.WriteMap(len(v))
+		for _, pair := range v {
+			rw.WriteBulkString(pair.key)
+			rw.WriteReply(pair.value)
+		}
+	default:
+		panic(fmt.Sprintf("cannot encode a %T reply", value))
+	}
+}
+
+// replyValue sends value encoded with WriteReply.
+func (c *clientConn) replyValue(value any) {
+	c.replyWith(func(w *respWriter) { w.WriteReply(value) })
+}
+
+// encode returns what write encodes the RESP2 way, for what is encoded ahead
+// of being sent to a client: the commands fed to replicas and the append only
+// file, and the replies kept for later, such as those of the commands a
+// script runs.
+func encode(write func(w *respWriter)) string {
+	var msg strings.Builder
+	write(newRespWriter(&msg, false))
+	return msg.String()
+}
+
+func (c *clientConn) replyStatus(s string) {
+	c.replyWith(func(w *respWriter) { w.WriteSimpleString(s) })
+}
+
+func (c *clientConn) replyOK() {
+	c.replyStatus("OK")
+}
+
+// replyError replies with err, whose message starts with its error code, such
+// as errWrongType.
+func (c *clientConn) replyError(err error) {
+	c.replyWith(func(w *respWriter) { w.WriteError(err.Error()) })
+}
+
+// replyErrorMsg replies with msg as an error of the generic ERR code.
+func (c *clientConn) replyErrorMsg(msg string) {
+	c.replyWith(func(w *respWriter) { w.WriteError("ERR " + msg) })
+}
+
+func (c *clientConn) replyWrongArgs(command string) {
+	c.replyError(errWrongArgs(command))
+}
+
+func (c *clientConn) replyInteger(n int) {
+	c.replyWith(func(w *respWriter) { w.WriteInteger(int64(n)) })
+}
+
+func (c *clientConn) replyBulk(s string) {
+	c.replyWith(func(w *respWriter) { w.WriteBulkString(s) })
+}
+
+func (c *clientConn) replyNull() {
+	c.replyWith(func(w *respWriter) { w.WriteNull() })
+}
+
+// replyStrings replies with items as an array of bulk strings.
+func (c *clientConn) replyStrings(items []string) {
+	c.replyValue(items)
+}
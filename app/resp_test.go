@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRespWriter(t *testing.T) {
+	for _, test := range []struct {
+		write func(w *respWriter)
+		want  string
+	}{
+		{func(w *respWriter) { w.WriteSimpleString("OK") }, "+OK\r\n"},
+		{func(w *respWriter) { w.WriteError("ERR bad") }, "-ERR bad\r\n"},
+		{func(w *respWriter) { w.WriteInteger(-42) }, ":-42\r\n"},
+		{func(w *respWriter) { w.WriteBulk([]byte("a\r\nb\x00")) }, "$5\r\na\r\nb\x00\r\n"},
+		{func(w *respWriter) { w.WriteBulk(nil) }, "$0\r\n\r\n"},
+		{func(w *respWriter) { w.WriteBulkString("") }, "$0\r\n\r\n"},
+		{func(w *respWriter) { w.WriteNull() }, "$-1\r\n"},
+		{func(w *respWriter) { w.WriteNullArray() }, "*-1\r\n"},
+		{func(w *respWriter) { w.WriteArray(0) }, "*0\r\n"},
+		{func(w *respWriter) { w.WriteArray(2); w.WriteInteger(1); w.WriteBulkString("x") }, "*2\r\n:1\r\n$1\r\nx\r\n"},
+		{func(w *respWriter) { w.WriteDouble(1.5) }, "$3\r\n1.5\r\n"},
+	} {
+		var out strings.Builder
+		test.write(newRespWriter(&out, false))
+		if out.String() != test.want {
+			t.Errorf("got %q, want %q", out.String(), test.want)
+		}
+	}
+}
+
+func TestRespWriterFallbacks(t *testing.T) {
+	for _, test := range []struct {
+		write        func(w *respWriter)
+		resp3, resp2 string
+	}{
+		{func(w *respWriter) { w.WriteVerbatim("txt", "hi") }, "=6\r\ntxt:hi\r\n", "$2\r\nhi\r\n"},
+		{func(w *respWriter) { w.WriteBigNumber("123456789012345678901234567890") },
+			"(123456789012345678901234567890\r\n", "$30\r\n123456789012345678901234567890\r\n"},
+		{func(w *respWriter) { w.WriteNull() }, "_\r\n", "$-1\r\n"},
+		{func(w *respWriter) { w.WriteNullArray() }, "_\r\n", "*-1\r\n"},
+		{func(w *respWriter) { w.WriteDouble(1.5) }, ",1.5\r\n", "$3\r\n1.5\r\n"},
+		{func(w *respWriter) { w.WriteMap(1); w.WriteBulkString("k"); w.WriteInteger(1) },
+			"%1\r\n$1\r\nk\r\n:1\r\n", "*2\r\n$1\r\nk\r\n:1\r\n"},
+		{func(w *respWriter) { w.WritePush(1); w.WriteBulkString("m") }, ">1\r\n$1\r\nm\r\n", "*1\r\n$1\r\nm\r\n"},
+	} {
+		for resp3, want := range map[bool]string{true: test.resp3, false: test.resp2} {
+			var out strings.Builder
+			test.write(newRespWriter(&out, resp3))
+			if out.String() != want {
+				t.Errorf("resp3=%v: got %q, want %q", resp3, out.String(), want)
+			}
+		}
+	}
+}
+
+func TestLolwutVerbatim(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	if _, ok := c.do("LOLWUT").(string); !ok {
+		t.Fatal("LOLWUT is not a bulk string under RESP2")
+	}
+	c.hello3()
+	c.send("LOLWUT")
+	if reply := c.readRaw(); !strings.HasPrefix(reply, "=") || !strings.Contains(reply, "\r\ntxt:") {
+		t.Fatalf("LOLWUT under RESP3: got %q, want a verbatim string", reply)
+	}
+	c.send("DEBUG", "PROTOCOL", "BIGNUM")
+	if reply := c.readRaw(); reply != "(1234567999999999999999999999999999999\r\n" {
+		t.Fatalf("DEBUG PROTOCOL BIGNUM under RESP3: got %q", reply)
+	}
+}
+
+func TestWriteReply(t *testing.T) {
+	nested := []any{"a", int64(1), []any{[]byte("b"), nil, []string{"c"}}, errors.New("ERR nested")}
+	hash := map[string]any{"z": int64(26), "a": []any{1.5}}
+	for _, test := range []struct {
+		value        any
+		resp3, resp2 string
+	}{
+		{nested,
+			"*4\r\n$1\r\na\r\n:1\r\n*3\r\n$1\r\nb\r\n_\r\n*1\r\n$1\r\nc\r\n-ERR nested\r\n",
+			"*4\r\n$1\r\na\r\n:1\r\n*3\r\n$1\r\nb\r\n$-1\r\n*1\r\n$1\r\nc\r\n-ERR nested\r\n"},
+		{hash,
+			"%2\r\n$1\r\na\r\n*1\r\n,1.5\r\n$1\r\nz\r\n:26\r\n",
+			"*4\r\n$1\r\na\r\n*1\r\n$3\r\n1.5\r\n$1\r\nz\r\n:26\r\n"},
+		{replyMap{{"second", 2}, {"first", 1}},
+			"%2\r\n$6\r\nsecond\r\n:2\r\n$5\r\nfirst\r\n:1\r\n",
+			"*4\r\n$6\r\nsecond\r\n:2\r\n$5\r\nfirst\r\n:1\r\n"},
+	} {
+		for resp3, want := range map[bool]string{true: test.resp3, false: test.resp2} {
+			var out strings.Builder
+			newRespWriter(&out, resp3).WriteReply(test.value)
+			if out.String() != want {
+				t.Errorf("WriteReply(%#v) resp3=%v: got %q, want %q", test.value, resp3, out.String(), want)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"errors"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errInvalidCursor = errors.New("ERR invalid cursor")
+
+// scanHash orders elements for cursor based iteration. A cursor is the hash
+// an iteration resumes from, so that, as with redis, an element present for
+// the whole iteration is returned regardless of what else is added or
+// removed in the meantime.
+func scanHash(element string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(element))
+	return h.Sum64()
+}
+
+type scanEntry struct {
+	hash    uint64
+	element string
+}
+
+// scanIndex holds the elements an iteration goes through ordered by hash,
+// those sharing a hash by name.
+type scanIndex []scanEntry
+
+func newScanIndex(elements []string) scanIndex {
+	index := make(scanIndex, len(elements))
+	for i, element := range elements {
+		index[i] = scanEntry{scanHash(element), element}
+	}
+	sort.Slice(index, func(i, j int) bool {
+		if index[i].hash != index[j].hash {
+			return index[i].hash < index[j].hash
+		}
+		return index[i].element < index[j].element
+	})
+	return index
+}
+
+// page returns about count of the elements of the index starting at cursor
+// for which present reports they still exist, along with the cursor to
+// continue from. The next cursor is the hash of an element after one that
+// was looked at, so it is never 0 but once the iteration is over. Elements
+// sharing a hash are never split across two calls.
+func (index scanIndex) page(cursor uint64, count int, present func(string) bool) ([]string, uint64) {
+	var page []string
+	start := sort.Search(len(index), func(i int) bool { return index[i].hash >= cursor })
+	for i := start; i < len(index); i++ {
+		if i-start >= count && index[i].hash != index[i-1].hash {
+			return page, index[i].hash
+		}
+		if present(index[i].element) {
+			page = append(page, index[i].element)
+		}
+	}
+	return page, 0
+}
+
+// typeName is the name TYPE reports for value.
+func typeName(value any) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case []string:
+		return "list"
+	case map[string]struct{}, intset:
+		return "set"
+	case *sortedSet:
+		return "zset"
+	case map[string]string:
+		return "hash"
+	case *stream:
+		return "stream"
+	}
+	return "none"
+}
+
+// Type returns the type name of the value at key, or "none".
+func (s *Store) Type(key string) string {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	value, ok := s.lookup(key)
+	if !ok {
+		return "none"
+	}
+	return typeName(value)
+}
+
+// Scan returns a page of keys. Keys not matching the glob match, if set, or
+// whose type is not typ, if set, are filtered out of the page.
+func (s *Store) Scan(cursor uint64, count int, match, typ string) ([]string, uint64) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if cursor == 0 || s.scanKeys == nil {
+		keys := make([]string, 0, len(s.Data))
+		for key := range s.Data {
+			keys = append(keys, key)
+		}
+		s.scanKeys = newScanIndex(keys)
+	}
+	page, next := s.scanKeys.page(cursor, count, func(key string) bool {
+		value, ok := s.lookup(key)
+		return ok && (match == "" || globMatch(match, key)) && (typ == "" || typeName(value) == typ)
+	})
+	if next == 0 {
+		s.scanKeys = nil
+	}
+	return page, next
+}
+
+// ScanCollection returns a page of the hash, set or sorted set at key as a
+// flat list: fields and values (just fields with noValues), members, or
+// members and scores. Elements not matching the glob match, if set, are
+// filtered out. It fails if the key holds a value of another type than typ.
+func (s *Store) ScanCollection(typ, key string, cursor uint64, count int, match string, noValues bool) ([]string, uint64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	value, ok := s.lookup(key)
+	if !ok {
+		return nil, 0, nil
+	}
+	if typeName(value) != typ {
+		return nil, 0, errWrongType
+	}
+	index := s.scanCollections[key]
+	if cursor == 0 || index == nil {
+		var elements []string
+		switch v := value.(type) {
+		case map[string]string:
+			for field := range v {
+				elements = append(elements, field)
+			}
+		case map[string]struct{}:
+			for member := range v {
+				elements = append(elements, member)
+			}
+		case intset:
+			elements = v.members()
+		case *sortedSet:
+			for member := range v.scores {
+				elements = append(elements, member)
+			}
+		}
+		index = newScanIndex(elements)
+		if s.scanCollections == nil {
+			s.scanCollections = map[string]scanIndex{}
+		}
+		s.scanCollections[key] = index
+	}
+	page, next := index.page(cursor, count, func(element string) bool {
+		if match != "" && !globMatch(match, element) {
+			return false
+		}
+		switch v := value.(type) {
+		case map[string]string:
+			_, ok := v[element]
+			return ok
+		case map[string]struct{}:
+			_, ok := v[element]
+			return ok
+		case intset:
+			return v.contains(element)
+		case *sortedSet:
+			_, ok := v.scores[element]
+			return ok
+		}
+		return false
+	})
+	if next == 0 {
+		delete(s.scanCollections, key)
+	}
+	var reply []string
+	for _, element := range page {
+		reply = append(reply, element)
+		switch v := value.(type) {
+		case map[string]string:
+			if !noValues {
+				reply = append(reply, v[element])
+			}
+		case *sortedSet:
+			reply = append(reply, formatFloat(v.scores[element]))
+		}
+	}
+	return reply, next, nil
+}
+
+type scanOptions struct {
+	cursor   uint64
+	count    int
+	match    string
+	typ      string
+	noValues bool
+}
+
+// parseScanOptions parses cursor [MATCH pattern] [COUNT count], also
+// accepting the options listed in extra: "type" or "novalues".
+func parseScanOptions(args []string, extra ...string) (scanOptions, error) {
+	opts := scanOptions{count: 10}
+	cursor, err := strconv.ParseUint(args[0], 10, 64)
+	if err != nil {
+		return opts, errInvalidCursor
+	}
+	opts.cursor = cursor
+	allowed := func(option string) bool {
+		for _, e := range extra {
+			if e == option {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 1; i < len(args); i++ {
+		option := strings.ToLower(args[i])
+		hasValue := i+1 < len(args)
+		switch {
+		case option == "match" && hasValue:
+			opts.match = args[i+1]
+			i++
+		case option == "count" && hasValue:
+			count, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			if count < 1 {
+				return opts, errSyntax
+			}
+			opts.count = count
+			i++
+		case option == "type" && hasValue && allowed("type"):
+			opts.typ = strings.ToLower(args[i+1])
+			i++
+		case option == "novalues" && allowed("novalues"):
+			opts.noValues = true
+		default:
+			return opts, errSyntax
+		}
+	}
+	return opts, nil
+}
+
+func scanReply(next uint64, elements []string) any {
+	return []any{strconv.FormatUint(next, 10), elements}
+}
+
+// scanCommand implements SCAN cursor [MATCH pattern] [COUNT count] [TYPE type].
+func (s *server) scanCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("scan")
+		return
+	}
+	opts, err := parseScanOptions(commands[1:], "type")
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	keys, next := s.db(c).Scan(opts.cursor, opts.count, opts.match, opts.typ)
+	c.replyValue(scanReply(next, keys))
+}
+
+// collectionScanCommand implements HSCAN, SSCAN and ZSCAN:
+// <command> key cursor [MATCH pattern] [COUNT count], plus [NOVALUES] for
+// HSCAN. NOVALUES only makes sense for hashes, whose elements are pairs, so
+// the other two reject it as a syntax error.
+func (s *server) collectionScanCommand(c *clientConn, commands []string) {
+	if len(commands) < 3 {
+		c.replyWrongArgs(commands[0])
+		return
+	}
+	typ := map[string]string{"hscan": "hash", "sscan": "set", "zscan": "zset"}[commands[0]]
+	var extra []string
+	if commands[0] == "hscan" {
+		extra = append(extra, "novalues")
+	}
+	opts, err := parseScanOptions(commands[2:], extra...)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	elements, next, err := s.db(c).ScanCollection(typ, commands[1], opts.cursor, opts.count, opts.match, opts.noValues)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyValue(scanReply(next, elements))
+}
+
+func (s *server) typeCommand(c *clientConn, commands []string) {
+	if len(commands) != 2 {
+		c.replyWrongArgs("type")
+		return
+	}
+	c.replyStatus(s.db(c).Type(commands[1]))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// scanAll runs a SCAN style command, given without its cursor, from 0 until it returns to 0, and
+// returns the elements of all the replies.
+func scanAll(t *testing.T, c *testClient, args ...string) []string {
+	t.Helper()
+	var elements []string
+	cursor := "0"
+	for {
+		// The cursor follows the key, if the command takes one.
+		at := 1
+		if args[0] != "SCAN" {
+			at = 2
+		}
+		command := append(append(slices.Clip(args[:at]), cursor), args[at:]...)
+		reply, _ := c.do(command...).([]any)
+		if len(reply) != 2 {
+			t.Fatalf("%v: got %#v", command, reply)
+		}
+		for _, element := range reply[1].([]any) {
+			elements = append(elements, element.(string))
+		}
+		if cursor = reply[0].(string); cursor == "0" {
+			return elements
+		}
+	}
+}
+
+func TestHScanLargeHash(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	const n = 1000
+	args := []string{"HSET", "hash"}
+	for i := 0; i < n; i++ {
+		args = append(args, "field:"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	c.expect(int64(n), args...)
+	elements := scanAll(t, c, "HSCAN", "hash", "COUNT", "50")
+	seen := map[string]string{}
+	for i := 0; i < len(elements); i += 2 {
+		seen[elements[i]] = elements[i+1]
+	}
+	if len(seen) != n {
+		t.Fatalf("HSCAN returned %d distinct fields, want %d", len(seen), n)
+	}
+	for i := 0; i < n; i++ {
+		if seen["field:"+strconv.Itoa(i)] != strconv.Itoa(i) {
+			t.Fatalf("HSCAN missed or garbled field:%d", i)
+		}
+	}
+}
+
+func TestScanType(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	for i := 0; i < 20; i++ {
+		c.expect(respStatus("OK"), "SET", "string:"+strconv.Itoa(i), "value")
+	}
+	c.expect(int64(1), "RPUSH", "list:a", "x")
+	c.expect(int64(1), "LPUSH", "list:b", "y")
+	c.expect(int64(1), "SADD", "set", "z")
+	keys := scanAll(t, c, "SCAN", "TYPE", "list", "COUNT", "5")
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "list:a" || keys[1] != "list:b" {
+		t.Fatalf("SCAN TYPE list: got %v", keys)
+	}
+}
+
+func TestSScanZScan(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "SADD", "set", "a", "b", "ab")
+	members := scanAll(t, c, "SSCAN", "set", "MATCH", "a*")
+	sort.Strings(members)
+	if len(members) != 2 || members[0] != "a" || members[1] != "ab" {
+		t.Fatalf("SSCAN MATCH a*: got %v", members)
+	}
+	c.expect(int64(2), "ZADD", "zset", "1", "a", "2", "b")
+	pairs := scanAll(t, c, "ZSCAN", "zset")
+	scores := map[string]string{}
+	for i := 0; i < len(pairs); i += 2 {
+		scores[pairs[i]] = pairs[i+1]
+	}
+	if len(scores) != 2 || scores["a"] != "1" || scores["b"] != "2" {
+		t.Fatalf("ZSCAN: got %v", pairs)
+	}
+}
+
+func TestHScanNoValues(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "HSET", "hash", "a", "1", "b", "2", "c", "3")
+	all := scanAll(t, c, "HSCAN", "hash")
+	fields := scanAll(t, c, "HSCAN", "hash", "NOVALUES")
+	if len(fields)*2 != len(all) {
+		t.Fatalf("HSCAN NOVALUES returned %d elements, HSCAN %d", len(fields), len(all))
+	}
+	sort.Strings(fields)
+	if !slices.Equal(fields, []string{"a", "b", "c"}) {
+		t.Fatalf("HSCAN NOVALUES: got %v, want the field names", fields)
+	}
+	c.expect(int64(1), "SADD", "set", "a")
+	if _, ok := c.do("SSCAN", "set", "0", "NOVALUES").(respError); !ok {
+		t.Fatal("SSCAN NOVALUES did not fail")
+	}
+}
+
+func TestScanIndexPage(t *testing.T) {
+	index := scanIndex{{0, "a"}, {0, "b"}, {5, "c"}, {9, "d"}}
+	all := func(string) bool { return true }
+	// Elements sharing a hash, 0 included, are returned together.
+	if page, next := index.page(0, 1, all); !slices.Equal(page, []string{"a", "b"}) || next != 5 {
+		t.Fatalf("page from 0: got %v, %d", page, next)
+	}
+	if page, next := index.page(5, 1, func(e string) bool { return e != "c" }); len(page) != 0 || next != 9 {
+		t.Fatalf("page from 5 without c: got %v, %d", page, next)
+	}
+	if page, next := index.page(9, 1, all); !slices.Equal(page, []string{"d"}) || next != 0 {
+		t.Fatalf("page from 9: got %v, %d", page, next)
+	}
+}
+
+func TestScanDuringWrites(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	const n = 500
+	for i := 0; i < n; i++ {
+		c.expect(respStatus("OK"), "SET", "key:"+strconv.Itoa(i), "value")
+	}
+	seen := map[string]bool{}
+	cursor, calls := "0", 0
+	for {
+		reply, _ := c.do("SCAN", cursor, "COUNT", "10").([]any)
+		for _, key := range reply[1].([]any) {
+			seen[key.(string)] = true
+		}
+		calls++
+		// Keys are removed and others added as the scan goes, but none from
+		// n/2 on.
+		c.do("DEL", "key:"+strconv.Itoa(calls))
+		c.do("SET", "new:"+strconv.Itoa(calls), "value")
+		if cursor = reply[0].(string); cursor == "0" {
+			break
+		}
+	}
+	for i := n / 2; i < n; i++ {
+		if !seen["key:"+strconv.Itoa(i)] {
+			t.Fatalf("SCAN missed key:%d, present for the whole iteration", i)
+		}
+	}
+	if calls > n/10+1 {
+		t.Fatalf("SCAN took %d calls of COUNT 10 for %d keys", calls, n)
+	}
+	c.expect(int64(0), "EXISTS", "key:1")
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errNoScript    = errors.New("NOSCRIPT No matching script. Please use EVAL.")
+	errBusy        = errors.New("BUSY Redis is busy running a script. You can only call SCRIPT KILL or SHUTDOWN NOSAVE.")
+	errNotBusy     = errors.New("NOTBUSY No scripts in execution right now.")
+	errNoScriptCmd = errors.New("ERR This Redis command is not allowed from script")
+	errUnkillable  = errors.New("UNKILLABLE Sorry the script already executed write commands against the dataset. You can either wait the script termination or kill the server in a hard way using the SHUTDOWN NOSAVE command.")
+	errScriptKill  = &luaError{msg: "ERR Script killed by user with SCRIPT KILL...", fromReply: true, fatal: true}
+)
+
+// scriptRun is a script running: when it started, whether it ran a write
+// command, after which SCRIPT KILL refuses to stop it, and whether it was
+// killed, which stops it at its next loop iteration or function call.
+type scriptRun struct {
+	start  time.Time
+	wrote  atomic.Bool
+	killed atomic.Bool
+}
+
+// loadScript compiles src and adds it to the script cache, returning its sha1.
+func (s *server) loadScript(src string) (string, *luaChunk, error) {
+	sum := sha1.Sum([]byte(src))
+	sha := hex.EncodeToString(sum[:])
+	s.scriptsMu.Lock()
+	defer s.scriptsMu.Unlock()
+	if chunk, ok := s.scripts[sha]; ok {
+		return sha, chunk, nil
+	}
+	chunk, err := compileLua(src)
+	if err != nil {
+		return "", nil, fmt.Errorf("ERR Error compiling script (new function): %s", err)
+	}
+	s.scripts[sha] = chunk
+	return sha, chunk, nil
+}
+
+// scriptCall runs a command on behalf of a script through client, the client
+// of the script, and converts its reply to a Lua value. Error replies are raised, unless protected is set, in which
+// case they are returned as a table with an err field like redis.pcall does.
+// The command is subject to the ACL rules of the caller's user and to the
+// checks execute makes, except for CLIENT PAUSE, which held back the script
+// as a whole: writes are rejected on a replica and over maxmemory, and the
+// keys read are tracked for the caller.
+func (s *server) scriptCall(caller, client *clientConn, args []any, protected bool) (any, error) {
+	if len(args) == 0 {
+		return nil, luaErrorf("Please specify at least one argument for this redis lib call")
+	}
+	commands := make([]string, len(args))
+	for i, arg := range args {
+		str, ok := luaToString(arg)
+		if !ok {
+			return nil, luaErrorf("Lua redis lib command arguments must be strings or integers")
+		}
+		commands[i] = str
+	}
+	commands[0] = strings.ToLower(commands[0])
+	var reply string
+	if commandHas(commands[0], cmdNoScript) {
+		reply = createErrorReply(errNoScriptCmd)
+	} else if commandHas(commands[0], cmdWrite) && s.isReplica() {
+		reply = createErrorReply(errReadOnly)
+	} else if err := s.freeMemory(); err != nil && commandHas(commands[0], cmdDenyOOM) {
+		reply = createErrorReply(err)
+	} else {
+		if commandHas(commands[0], cmdRead) {
+			s.trackRead(caller, commands)
+		}
+		if commandHas(commands[0], cmdWrite) {
+			if run := s.script.Load(); run != nil {
+				run.wrote.Store(true)
+			}
+		}
+		client.captured.Reset()
+		s.dispatch(client, commands)
+		reply = client.captured.String()
+	}
+	value, err := readReply(bufio.NewReader(strings.NewReader(reply)))
+	if err != nil {
+		return nil, luaErrorf("Unexpected reply from %s", commands[0])
+	}
+	if e, ok := value.(respError); ok && !protected {
+		return nil, &luaError{msg: string(e), fromReply: true}
+	}
+	return respToLua(value), nil
+}
+
+// respToLua converts a reply to a Lua value the way redis does: integers to
+// numbers, nulls to false, and status and error replies to tables with an ok
+// and an err field.
+func respToLua(value any) any {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case string:
+		return v
+	case respStatus:
+		t := newLuaTable()
+		t.set("ok", string(v))
+		return t
+	case respError:
+		t := newLuaTable()
+		t.set("err", string(v))
+		return t
+	case []any:
+		t := newLuaTable()
+		for i, element := range v {
+			t.set(float64(i+1), respToLua(element))
+		}
+		return t
+	}
+	return false
+}
+
+// writeLuaReply writes the value returned by a script as a reply: numbers
+// are truncated to integers, tables become arrays up to their first nil
+// unless they have an ok or err field, true is 1 and false and nil are a null
+// reply.
+func writeLuaReply(w *respWriter, value any) {
+	switch v := value.(type) {
+	case float64:
+		w.WriteInteger(int64(math.Trunc(v)))
+		return
+	case string:
+		w.WriteBulkString(v)
+		return
+	case bool:
+		if v {
+			w.WriteInteger(1)
+			return
+		}
+	case *luaTable:
+		if msg, ok := v.get("err").(string); ok {
+			w.WriteError(msg)
+			return
+		}
+		if msg, ok := v.get("ok").(string); ok {
+			w.WriteSimpleString(msg)
+			return
+		}
+		w.WriteArray(len(v.array))
+		for _, element := range v.array {
+			writeLuaReply(w, element)
+		}
+		return
+	}
+	w.WriteNull()
+}
+
+// scriptGlobals returns the global variables visible to a script: KEYS,
+// ARGV, the redis library and the Lua library of luaLibrary. The commands
+// the script runs share a client, which starts in the database of the caller
+// and keeps the one SELECT picks for the rest of the script.
+func (s *server) scriptGlobals(caller *clientConn, keys, args []string) map[string]any {
+	client := &clientConn{captured: &strings.Builder{}, user: caller.user, db: caller.db, execLocked: true}
+	toTable := func(values []string) *luaTable {
+		t := newLuaTable()
+		for i, v := range values {
+			t.set(float64(i+1), v)
+		}
+		return t
+	}
+	replyTable := func(field string) func(args []any) (any, error) {
+		return func(args []any) (any, error) {
+			msg, ok := "", len(args) > 0
+			if ok {
+				msg, ok = args[0].(string)
+			}
+			if !ok {
+				return nil, luaErrorf("wrong number or type of arguments")
+			}
+			t := newLuaTable()
+			t.set(field, msg)
+			return t, nil
+		}
+	}
+	redis := newLuaTable()
+	redis.set("call", luaFunc("call", func(args []any) (any, error) { return s.scriptCall(caller, client, args, false) }))
+	redis.set("pcall", luaFunc("pcall", func(args []any) (any, error) { return s.scriptCall(caller, client, args, true) }))
+	redis.set("error_reply", luaFunc("error_reply", replyTable("err")))
+	redis.set("status_reply", luaFunc("status_reply", replyTable("ok")))
+	redis.set("sha1hex", luaFunc("sha1hex", func(args []any) (any, error) {
+		str, ok := "", len(args) == 1
+		if ok {
+			str, ok = luaToString(args[0])
+		}
+		if !ok {
+			return nil, luaErrorf("wrong number of arguments")
+		}
+		sum := sha1.Sum([]byte(str))
+		return hex.EncodeToString(sum[:]), nil
+	}))
+
+	globals := luaLibrary()
+	globals["KEYS"] = toTable(keys)
+	globals["ARGV"] = toTable(args)
+	globals["redis"] = redis
+	return globals
+}
+
+// runScript runs chunk for c with execMu held, as the script SCRIPT KILL
+// stops. The deferred unlock keeps the server usable if the script panics.
+func (s *server) runScript(c *clientConn, chunk *luaChunk, globals map[string]any) (any, error) {
+	unlock, err := s.lockExclusive(c)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	run := &scriptRun{start: time.Now()}
+	s.script.Store(run)
+	defer s.script.Store(nil)
+	return chunk.run(globals, func() error {
+		if run.killed.Load() {
+			return errScriptKill
+		}
+		return nil
+	})
+}
+
+// busy reports whether a script has been running for longer than
+// busy-reply-threshold, after which the other clients are told so rather
+// than left waiting.
+func (s *server) busy() bool {
+	run := s.script.Load()
+	return run != nil && time.Since(run.start) > s.config.busyThreshold()
+}
+
+// lockExec takes execMu, for writing if exclusive is set, unless a script
+// holding it runs for too long. The commands then fail with errBusy, and only
+// SCRIPT KILL and SHUTDOWN NOSAVE, which do not need execMu, can run.
+func (s *server) lockExec(exclusive bool) error {
+	try, lock := s.execMu.TryRLock, s.execMu.RLock
+	if exclusive {
+		try, lock = s.execMu.TryLock, s.execMu.Lock
+	}
+	for !try() {
+		if s.script.Load() == nil {
+			lock()
+			return nil
+		}
+		if s.busy() {
+			return errBusy
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+// lockExclusive takes execMu for writing for a command of c, like
+// lockExec(true), unless the command runs inside EXEC, which holds it
+// already. It returns the function that releases what it took.
+func (s *server) lockExclusive(c *clientConn) (func(), error) {
+	if c.execLocked {
+		return func() {}, nil
+	}
+	if err := s.lockExec(true); err != nil {
+		return nil, err
+	}
+	return s.execMu.Unlock, nil
+}
+
+func (s *server) evalCommand(c *clientConn, commands []string) {
+	s.eval(c, commands, false)
+}
+
+func (s *server) evalshaCommand(c *clientConn, commands []string) {
+	s.eval(c, commands, true)
+}
+
+// eval implements EVAL script numkeys [key ...] [arg ...] and, when bySHA is
+// set, EVALSHA sha1 numkeys [key ...] [arg ...]. The script runs with execMu
+// held, so no other command runs until it is done.
+func (s *server) eval(c *clientConn, commands []string, bySHA bool) {
+	if len(commands) < 3 {
+		c.replyWrongArgs(commands[0])
+		return
+	}
+	numKeys, err := strconv.Atoi(commands[2])
+	if err != nil {
+		c.replyError(errNotInteger)
+		return
+	}
+	if numKeys < 0 {
+		c.replyErrorMsg("Number of keys can't be negative")
+		return
+	}
+	if numKeys > len(commands)-3 {
+		c.replyErrorMsg("Number of keys can't be greater than number of args")
+		return
+	}
+
+	var sha string
+	var chunk *luaChunk
+	if bySHA {
+		sha = strings.ToLower(commands[1])
+		s.scriptsMu.Lock()
+		chunk = s.scripts[sha]
+		s.scriptsMu.Unlock()
+		if chunk == nil {
+			c.replyError(errNoScript)
+			return
+		}
+	} else if sha, chunk, err = s.loadScript(commands[1]); err != nil {
+		c.replyError(err)
+		return
+	}
+
+	keys := commands[3 : 3+numKeys]
+	args := commands[3+numKeys:]
+	value, err := s.runScript(c, chunk, s.scriptGlobals(c, keys, args))
+	var e *luaError
+	if errors.As(err, &e) {
+		msg := e.msg
+		if !e.fromReply {
+			msg = fmt.Sprintf("ERR user_script:%d: %s", e.line, msg)
+		}
+		c.replyError(fmt.Errorf("%s script: %s, on @user_script:%d.", msg, sha, e.line))
+		return
+	}
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyWith(func(w *respWriter) { writeLuaReply(w, value) })
+}
+
+// scriptCommand implements SCRIPT LOAD, SCRIPT EXISTS, SCRIPT FLUSH and
+// SCRIPT KILL, which runs without execMu since the script it stops holds it.
+func (s *server) scriptCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("script")
+		return
+	}
+	switch strings.ToLower(commands[1]) {
+	case "load":
+		if len(commands) != 3 {
+			c.replyWrongArgs("script|load")
+			return
+		}
+		sha, _, err := s.loadScript(commands[2])
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		c.replyBulk(sha)
+	case "exists":
+		if len(commands) < 3 {
+			c.replyWrongArgs("script|exists")
+			return
+		}
+		s.scriptsMu.Lock()
+		defer s.scriptsMu.Unlock()
+		c.replyWith(func(w *respWriter) {
+			w.WriteArray(len(commands) - 2)
+			for _, sha := range commands[2:] {
+				if _, ok := s.scripts[strings.ToLower(sha)]; ok {
+					w.WriteInteger(1)
+				} else {
+					w.WriteInteger(0)
+				}
+			}
+		})
+	case "flush":
+		if len(commands) > 3 || len(commands) == 3 && !strings.EqualFold(commands[2], "sync") && !strings.EqualFold(commands[2], "async") {
+			c.replyErrorMsg("SCRIPT FLUSH only support SYNC|ASYNC option")
+			return
+		}
+		s.scriptsMu.Lock()
+		s.scripts = map[string]*luaChunk{}
+		s.scriptsMu.Unlock()
+		c.replyOK()
+	case "kill":
+		if len(commands) != 2 {
+			c.replyWrongArgs("script|kill")
+			return
+		}
+		run := s.script.Load()
+		switch {
+		case run == nil:
+			c.replyError(errNotBusy)
+		case run.wrote.Load():
+			c.replyError(errUnkillable)
+		default:
+			run.killed.Store(true)
+			c.replyOK()
+		}
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try SCRIPT HELP.", commands[1]))
+	}
+}
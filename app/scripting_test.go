@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEvalSetGet(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	script := "redis.call('SET', KEYS[1], ARGV[1]) return redis.call('GET', KEYS[1])"
+	c.expect("value", "EVAL", script, "1", "key", "value")
+	c.expect("value", "GET", "key")
+}
+
+func TestEvalSHA(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	script := "return {KEYS[1], ARGV[1], 3}"
+	sha, _ := c.do("SCRIPT", "LOAD", script).(string)
+	if !hex40.MatchString(sha) {
+		t.Fatalf("SCRIPT LOAD: got %q, want a SHA1", sha)
+	}
+	c.expect([]any{int64(1), int64(0)}, "SCRIPT", "EXISTS", sha, "0000000000000000000000000000000000000000")
+	c.expect([]any{"key", "arg", int64(3)}, "EVALSHA", sha, "1", "key", "arg")
+	c.expect(respStatus("OK"), "SCRIPT", "FLUSH")
+	c.expect(respError("NOSCRIPT No matching script. Please use EVAL."), "EVALSHA", sha, "0")
+}
+
+func TestEvalErrors(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "SET", "string", "value")
+	// redis.pcall hands the error to the script, redis.call raises it.
+	c.expect("caught", "EVAL", "local r = redis.pcall('LPUSH', KEYS[1], 'x') if r.err then return 'caught' end", "1", "string")
+	if _, ok := c.do("EVAL", "return redis.call('LPUSH', KEYS[1], 'x')", "1", "string").(respError); !ok {
+		t.Fatal("a failing redis.call did not fail the script")
+	}
+}
+
+func TestEvalLibraries(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "RPUSH", "list", "1", "2", "3")
+	// The replies of redis.call are walked with ipairs and the results of
+	// the commands it dispatches come back converted.
+	script := `local sum = 0
+for _, v in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do sum = sum + tonumber(v) end
+redis.call('SET', KEYS[2], cjson.encode({sum = sum, items = redis.call('EXISTS', KEYS[1])}))
+return cjson.decode(redis.call('GET', KEYS[2])).sum`
+	c.expect(int64(6), "EVAL", script, "2", "list", "json")
+	c.expect(`{"items":1,"sum":6}`, "GET", "json")
+	// The error of a redis.call caught by pcall is a table with the reply.
+	c.expect("WRONGTYPE Operation against a key holding the wrong kind of value",
+		"EVAL", "local ok, err = pcall(redis.call, 'GET', KEYS[1]) return err.err", "1", "list")
+	if reply, _ := c.do("EVAL", "error({err = 'MY own error'})", "0").(respError); !strings.HasPrefix(string(reply), "MY own error script: ") {
+		t.Fatalf("a script raising an error reply: got %q", reply)
+	}
+	c.expect([]any{"a", "b"}, "EVAL", "return {string.match(ARGV[1], '(%a+)-(%a+)')}", "0", "a-b")
+}
+
+func TestScriptKillNotCaughtByPcall(t *testing.T) {
+	_, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	c.send("EVAL", "while true do pcall(function() end) end", "0")
+	waitFor(t, "SCRIPT KILL", func() bool {
+		_, ok := other.do("SCRIPT", "KILL").(respStatus)
+		return ok
+	})
+	if reply, ok := c.read().(respError); !ok || !strings.HasPrefix(string(reply), "ERR Script killed by user with SCRIPT KILL...") {
+		t.Fatalf("the killed script replied %#v", reply)
+	}
+}
@@ -1,218 +1,1003 @@
 package main
 
 import (
+	"bufio"
+	"crypto/rand"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"os"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
-	pingCommand      = "PING"
-	pingMessage      = "*1\r\n$4\r\nPING\r\n"
-	echoCommand      = "ECHO"
-	setCommand       = "SET"
-	getCommand       = "GET"
-	pingResponse     = "+PONG\r\n"
-	okResponse       = "+OK\r\n"
-	notFoundResponse = "$-1\r\n"
+	pingCommand = "PING"
+	pingMessage = "*1\r\n$4\r\nPING\r\n"
+	echoCommand = "ECHO"
+	setCommand  = "SET"
+	getCommand  = "GET"
 )
 
-var replicaOf = flag.String("replicaof", "", "Replicate to another server")
-var emptyRDB, _ = hex.DecodeString("524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2")
-var slaves = []net.Conn{}
+var (
+	errNotInteger = errors.New("ERR value is not an integer or out of range")
+	errOutOfRange = errors.New("ERR value is out of range")
+	errNotFloat   = errors.New("ERR value is not a valid float")
+	errSyntax     = errors.New("ERR syntax error")
+	errOverflow   = errors.New("ERR increment or decrement would overflow")
+	errTooLong    = errors.New("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+)
+
+// server holds the state shared by every connection: the keyspace and the
+// replication identity of this instance.
+type server struct {
+	clock clock
+	// dbs are the databases, which clients pick with SELECT.
+	dbs   []*Store
+	runID string
+	// started is when the server started, for the uptime in INFO.
+	started time.Time
+	config  *config
+	acl     *acl
+
+	// replMu guards the replication role. masterHost is empty on a master,
+	// and masterLink is the connection to the master while there is one.
+	// masterGen changes every time the master does, which stops the
+	// goroutine replicating the previous one.
+	replMu     sync.Mutex
+	replID     string
+	masterHost string
+	masterPort string
+	masterGen  int
+	masterLink net.Conn
+	linkUp     bool
+	// processed is the offset in the replication stream of the master up
+	// to which a replica applied the commands, and fsyncedRepl the one up
+	// to which they are fsynced to the append only file. aofMarks are the
+	// offsets applied since, with the size of the file after them.
+	processed   int64
+	fsyncedRepl int64
+	aofMarks    []aofMark
+
+	// slavesMu guards the replicas fed the replication stream. replOffset
+	// counts the bytes written to the stream, and replAcked is closed, and
+	// replaced, every time a replica acknowledges an offset. replDB is the
+	// database the stream last selected, -1 when the next command must
+	// select its database anyway. backlog holds the end of the stream, up
+	// to replOffset, from which a replica that reconnects continues. It
+	// is nil until the first replica connects.
+	slavesMu   sync.Mutex
+	slaves     []*replica
+	replOffset int64
+	replAcked  chan struct{}
+	replDB     int
+	backlog    []byte
+
+	clientsMu    sync.Mutex
+	clients      map[int64]*clientConn
+	nextClientID atomic.Int64
+
+	// Counters for INFO. blockedClients counts the clients waiting in WAIT,
+	// WAITAOF or a blocking pop.
+	blockedClients   atomic.Int64
+	totalConnections atomic.Int64
+	totalCommands    atomic.Int64
+	syncFull         atomic.Int64
+	syncPartialOK    atomic.Int64
+	syncPartialErr   atomic.Int64
+	ops              opsMeter
+	errorStats       errorStats
+	latency          latencyMonitor
+
+	pauseMu  sync.Mutex
+	pauseEnd time.Time
+	pauseAll bool
+	unpaused chan struct{}
+
+	// execMu is held for reading while a command runs, and for writing
+	// while a script does so that scripts run atomically. It also guards
+	// the order of dbs, which SWAPDB changes.
+	execMu    sync.RWMutex
+	scriptsMu sync.Mutex
+	scripts   map[string]*luaChunk
+	// script is the script running, nil when none is.
+	script atomic.Pointer[scriptRun]
+
+	pubsubMu      sync.Mutex
+	channels      subscribers
+	patterns      subscribers
+	shardChannels subscribers
+
+	// activeExpireOff stops the background deletion of expired keys.
+	activeExpireOff atomic.Bool
+
+	trackingMu  sync.Mutex
+	trackedKeys subscribers
+	broadcasts  map[*clientConn]struct{}
+
+	monitorsMu sync.Mutex
+	monitors   map[*clientConn]struct{}
+
+	aof *aof
+
+	// dirty counts the writes since the last successful save. saveMu
+	// guards the state of the background save and lastSave, when the
+	// last save succeeded.
+	dirty         atomic.Int64
+	saveMu        sync.Mutex
+	bgsaving      bool
+	bgsaveStarted time.Time
+	bgsaveErr     error
+	lastSave      time.Time
 
-type Store struct {
-	Data     map[string]string
-	Expiries map[string]time.Time
-	Mutex    sync.RWMutex
+	// shuttingDown is closed when the server shuts down, which wakes the
+	// clients blocked in WAIT, WAITAOF and the blocking pops.
+	shuttingDown chan struct{}
+	shutdownOnce sync.Once
 }
 
-func NewStore() *Store {
-	return &Store{
-		Data:     make(map[string]string),
-		Expiries: make(map[string]time.Time),
+func newServer() *server {
+	return newServerWithClock(realClock{})
+}
+
+// newServerWithClock creates a server whose expiries and timeouts follow clk.
+func newServerWithClock(clk clock) *server {
+	cfg := newConfig()
+	s := &server{
+		clock:    clk,
+		lastSave: clk.Now(),
+		replID:   randomID(),
+		replDB:   -1,
+		runID:    randomID(),
+		config:   cfg,
+		acl:      newACL(),
+		clients:  make(map[int64]*clientConn),
+		unpaused: make(chan struct{}),
+
+		replAcked: make(chan struct{}),
+
+		scripts:  make(map[string]*luaChunk),
+		channels: make(subscribers),
+		patterns: make(subscribers),
+
+		shardChannels: make(subscribers),
+
+		trackedKeys: make(subscribers),
+		broadcasts:  make(map[*clientConn]struct{}),
+		monitors:    make(map[*clientConn]struct{}),
+
+		shuttingDown: make(chan struct{}),
 	}
+	s.dbs = s.newDatabases(int(cfg.databases))
+	return s
 }
 
-func (s *Store) Set(key, value string, ttl time.Duration) {
-	s.Mutex.Lock()
-	defer s.Mutex.Unlock()
-	s.Data[key] = value
-	if ttl > 0 {
-		s.Expiries[key] = time.Now().Add(ttl)
-	} else {
-		delete(s.Expiries, key)
+// newDatabases creates n databases, which notify the keys that expire.
+func (s *server) newDatabases(n int) []*Store {
+	dbs := make([]*Store, n)
+	for i := range dbs {
+		db := NewStore(s.clock)
+		db.expired = func(key string) {
+			s.notify(s.dbIndex(db), notifyExpired, "expired", key)
+		}
+		dbs[i] = db
 	}
+	return dbs
 }
 
-func (s *Store) Get(key string) (string, bool) {
-	s.Mutex.RLock()
-	defer s.Mutex.RUnlock()
-	if expiry, exists := s.Expiries[key]; exists && time.Now().After(expiry) {
-		delete(s.Data, key)
-		delete(s.Expiries, key)
-		return "", false
+// dbIndex returns the index db is at, which SWAPDB changes. The caller must
+// hold execMu.
+func (s *server) dbIndex(db *Store) int {
+	for i, other := range s.dbs {
+		if other == db {
+			return i
+		}
 	}
-	val, ok := s.Data[key]
-	return val, ok
+	return -1
+}
+
+// db returns the database c selected.
+func (s *server) db(c *clientConn) *Store {
+	return s.dbs[c.db]
+}
+
+// randomID returns a random 40 character hex string, the format redis uses for
+// both the run id and the replication id.
+func randomID() string {
+	b := make([]byte, 20)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 func main() {
 	// You can use print statements as follows for debugging, they'll be visible when running tests.
 	fmt.Println("Logs from your program will appear here!")
-	store := NewStore()
+	srv := newServer()
+	srv.started = time.Now()
 
 	// Uncomment this block to pass the first stage
 
-	port := flag.Int("port", 6379, "The port which the redis server listens")
+	// The other flags are named after the config parameters they set, and
+	// override those of the config file.
+	configFile := flag.String("config", "", "Load the config parameters from this file")
+	flag.Int("port", 6379, "The port which the redis server listens")
+	flag.String("replicaof", "", "Replicate to another server")
+	flag.String("appendonly", "no", "Log every write to the append only file")
+	flag.String("appendfilename", "appendonly.aof", "Name of the append only file")
+	flag.String("dir", ".", "The directory the snapshot and the append only file are in")
+	flag.String("dbfilename", "dump.rdb", "Name of the snapshot file")
+	flag.String("save", "3600 1 300 100 60 10000", "Save after the given number of seconds if that many writes happened, as pairs of numbers")
+	flag.String("appendfsync", "everysec", "When to fsync the append only file: always, everysec or no")
+	flag.String("maxmemory", "0", "The memory limit, such as 100mb")
+	flag.String("maxmemory-policy", "noeviction", "How keys are evicted under maxmemory: noeviction, allkeys-lru, allkeys-lfu, allkeys-random, volatile-lru, volatile-lfu, volatile-random or volatile-ttl")
+	flag.Int("databases", 16, "The number of databases")
+	flag.String("read-buffer-size", "16kb", "The size of the buffer the commands of each client are read into, such as 64kb")
+	flag.Int("wait-on-write", 0, "Hold back the replies to writes until this many replicas acknowledged them")
+	flag.Int("wait-on-write-timeout", 1000, "How long in milliseconds writes wait for the replicas with wait-on-write, 0 to wait forever")
 	flag.Parse()
+	cfg := srv.config
+	if *configFile != "" {
+		if err := cfg.load(*configFile); err != nil {
+			fmt.Println("Failed to load the config file: ", err)
+			os.Exit(1)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "config" {
+			return
+		}
+		if err := cfg.set(f.Name, f.Value.String(), true); err != nil {
+			fmt.Printf("Invalid value for --%s: %v\n", f.Name, err)
+			os.Exit(1)
+		}
+	})
+	srv.dbs = srv.newDatabases(int(cfg.databases))
+
+	if err := prepareDir(cfg.dir); err != nil {
+		fmt.Println("Can't use the working directory: ", err)
+		os.Exit(1)
+	}
+	if cfg.appendOnly {
+		path := filepath.Join(cfg.dir, cfg.appendFilename)
+		if err := srv.loadAOF(path); err != nil {
+			fmt.Println("Failed to load the append only file: ", err)
+			os.Exit(1)
+		}
+		a, err := openAOF(path, cfg.appendFsync)
+		if err != nil {
+			fmt.Println("Failed to open the append only file: ", err)
+			os.Exit(1)
+		}
+		srv.aof = a
+	} else if err := srv.loadRDB(cfg.rdbPath()); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Failed to load the snapshot: ", err)
+		os.Exit(1)
+	}
 
-	if *replicaOf != "" {
-		go replicateMaster(*replicaOf)
+	if cfg.replicaOf != "" {
+		host, masterPort, ok := strings.Cut(cfg.replicaOf, " ")
+		if !ok {
+			fmt.Println("Invalid master address format. Expected <MASTER_HOST> <MASTER_PORT>")
+			os.Exit(1)
+		}
+		srv.replicaOf(host, masterPort)
 	}
 
-	listener, err := net.Listen("tcp", "0.0.0.0:"+strconv.Itoa(*port))
+	listener, err := net.Listen("tcp", "0.0.0.0:"+strconv.FormatInt(cfg.port, 10))
 	if err != nil {
-		fmt.Printf("Failed to bind to port %v", port)
+		fmt.Printf("Failed to bind to port %v\n", cfg.port)
 		os.Exit(1)
 	}
 	defer listener.Close()
+	go srv.activeExpire()
+	go srv.measureOps()
+	go srv.autoSave()
+	go srv.shutdownOnSignal()
 
+	srv.serve(listener)
+}
+
+// serve accepts the connections of listener and handles each of them in its
+// own goroutine, until the listener is closed.
+func (s *server) serve(listener net.Listener) {
 	for {
 		connection, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			fmt.Println("Error accepting connection: ", err.Error())
 			continue
 		}
+		// Keepalive probes detect the clients that went away without
+		// closing their connection.
+		if tcp, ok := connection.(*net.TCPConn); ok {
+			// Replies are written as soon as they are ready, so batching
+			// them only delays them.
+			tcp.SetNoDelay(true)
+			if period := s.config.keepAlive(); period > 0 {
+				tcp.SetKeepAlive(true)
+				tcp.SetKeepAlivePeriod(period)
+			} else {
+				tcp.SetKeepAlive(false)
+			}
+		}
 		// to listen to multiple ping's from same user.
-		go handleConnection(connection, store)
+		go s.handleConnection(connection)
 	}
 }
 
-func handleConnection(connection net.Conn, store *Store) {
-	defer connection.Close()
-	// smallest tcp packet
-	buff := make([]byte, 1024)
+// prepareDir creates dir if it does not exist yet, and checks that files can
+// be written there, so that a wrong directory is noticed at startup rather
+// than at the first save.
+func prepareDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(dir, "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+func (s *server) handleConnection(connection net.Conn) {
+	client, err := s.registerClient(connection)
+	if err != nil {
+		connection.Write([]byte(createErrorReply(err)))
+		connection.Close()
+		return
+	}
+	defer func() { client.close(time.Now().Add(flushTimeout)) }()
+	defer s.unregisterClient(client)
+	// A command that panics only costs its own connection, the locks it
+	// held being released by their deferred unlocks.
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Printf("Panic running a command of client %d: %v\n%s", client.id, r, debug.Stack())
+			client.captured = nil
+			client.replyErrorMsg("internal error")
+		}
+	}()
 	for {
-		n, err := connection.Read(buff)
-		if err != nil || n == 0 {
+		// Replicas, subscribers and monitors legitimately stay silent, so
+		// the idle timeout does not apply to them.
+		var deadline time.Time
+		if timeout := s.config.idleTimeout(); timeout > 0 && client.replica == nil && !s.subscribed(client) && !s.monitoring(client) {
+			deadline = time.Now().Add(timeout)
+		}
+		connection.SetReadDeadline(deadline)
+		commands, err := client.next(s.config.bulkLimit())
+		var protoErr protocolError
+		if errors.As(err, &protoErr) {
+			client.replyErrorMsg(protoErr.Error())
+		}
+		if err != nil {
 			return
 		}
-		commands := parse(buff[:n])
 		if len(commands) == 0 {
 			continue
 		}
+		client.startCommand()
+		if n, timeout := s.config.waitOnWrite(); n > 0 && commandHas(strings.ToLower(commands[0]), cmdWrite) {
+			s.executeAcked(client, commands, n, timeout)
+		} else {
+			s.execute(client, commands)
+		}
+		// The commands pipelined after a reply that could not be sent, or
+		// after QUIT, are not run.
+		if client.broken.Load() || client.closeAfterReply {
+			return
+		}
+	}
+}
 
-		switch commands[0] {
-		case "echo":
-			connection.Write([]byte(createResponseMsg(commands[1])))
-		case "ping":
-			connection.Write([]byte(pingResponse))
-		case "set":
-			if len(commands) >= 3 {
-				ttl := time.Duration(0)
-				if len(commands) == 5 && commands[3] == "px" {
-					if parsedTTL, err := strconv.Atoi(commands[4]); err == nil {
-						ttl = time.Duration(parsedTTL) * time.Millisecond
-					}
-				}
-				for _, slave := range slaves {
-					slave.Write([]byte(fmt.Sprintf("*3\r\n$3\r\nSET\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(commands[1]), commands[1], len(commands[2]), commands[2])))
-				}
-				store.Set(commands[1], commands[2], ttl)
-				connection.Write([]byte(okResponse))
-			}
-		case "get":
-			val, ok := store.Get(commands[1])
-			if !ok {
-				connection.Write([]byte(notFoundResponse))
-			} else {
-				connection.Write([]byte(createResponseMsg(val)))
-			}
-		case "info":
-			infoResponse := "role:master"
-			infoResponse += fmt.Sprintf("master_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb\r\n")
-			infoResponse += fmt.Sprintf("master_repl_offset:0\r\n")
-			if *replicaOf != "" {
-				infoResponse = "role:slave"
+// execute runs a single command on behalf of c. The command name is matched
+// case-insensitively; the arguments are passed through untouched.
+func (s *server) execute(c *clientConn, commands []string) {
+	commands[0] = strings.ToLower(commands[0])
+	if !c.resp3() && !subscribedCommands[commands[0]] && s.subscribed(c) {
+		c.replyErrorMsg(fmt.Sprintf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", commands[0]))
+		return
+	}
+	if c.multi && !transactionCommands[commands[0]] {
+		s.queue(c, commands)
+		return
+	}
+	// The replication stream is applied as is: it is neither paused nor
+	// rejected as a write to a replica.
+	if !c.master {
+		s.waitWhilePaused(commands[0])
+		if commandHas(commands[0], cmdWrite) && s.isReplica() {
+			c.replyError(errReadOnly)
+			return
+		}
+	}
+	if !commandHas(commands[0], cmdUnlocked) {
+		if err := s.lockExec(false); err != nil {
+			c.replyError(err)
+			return
+		}
+		defer s.execMu.RUnlock()
+		if err := s.freeMemory(); err != nil && commandHas(commands[0], cmdDenyOOM) {
+			c.replyError(err)
+			return
+		}
+	}
+	// The keys are tracked both before and after the read, so that a write
+	// racing with it still invalidates the value the client gets.
+	if commandHas(commands[0], cmdRead) {
+		s.trackRead(c, commands)
+		defer s.trackRead(c, commands)
+	}
+	s.dispatch(c, commands)
+}
+
+// dispatch runs the command with the already lowercased name commands[0].
+func (s *server) dispatch(c *clientConn, commands []string) {
+	if isHelpRequest(commands) {
+		c.replyStrings(commandHelp[commands[0]])
+		return
+	}
+	handler, ok := commandTable[commands[0]]
+	if !ok {
+		c.replyError(errUnknownCommand(commands))
+		return
+	}
+	if !arityOK(handler.arity, commands) {
+		c.replyWrongArgs(commands[0])
+		return
+	}
+	if err := s.acl.check(c, commands, handler.flags); err != nil {
+		c.replyError(err)
+		return
+	}
+	s.totalCommands.Add(1)
+	c.setCommandName(commands)
+	if handler.flags&cmdSkipMonitor == 0 {
+		s.feedMonitors(c, commands)
+	}
+	start := time.Now()
+	handler.fn(s, c, commands)
+	if handler.flags&cmdBlocking == 0 {
+		s.recordLatency("command", start)
+	}
+	if handler.flags&(cmdRead|cmdWrite) != 0 && handler.flags&cmdNoTouch == 0 {
+		s.db(c).Touch(commandKeys(commands), s.config.eviction())
+	}
+}
+
+// quitCommand implements QUIT. The connection is closed once the reply is
+// sent, by the read loop, which runs no command the client sent after it.
+func (s *server) quitCommand(c *clientConn, commands []string) {
+	c.replyOK()
+	c.closeAfterReply = true
+}
+
+func (s *server) echoCommand(c *clientConn, commands []string) {
+	c.replyBulk(commands[1])
+}
+
+// pingCommand implements PING [message]. A subscribed RESP2 client gets a
+// pong message instead, like the other messages it receives.
+func (s *server) pingCommand(c *clientConn, commands []string) {
+	if len(commands) > 2 {
+		c.replyWrongArgs("ping")
+		return
+	}
+	message := ""
+	if len(commands) == 2 {
+		message = commands[1]
+	}
+	switch {
+	case !c.resp3() && s.subscribed(c):
+		c.replyPush("pong", message)
+	case len(commands) == 2:
+		c.replyBulk(message)
+	default:
+		c.replyStatus("PONG")
+	}
+}
+
+// setCommand implements SET key value [NX | XX] [GET] [EX seconds |
+// PX milliseconds | EXAT unix-time-seconds | PXAT unix-time-milliseconds |
+// KEEPTTL]. NX and XX set the key only if it is missing or exists, and GET
+// replies with the string it held before rather than OK. A key set to
+// expire is propagated with PXAT, so that replicas and the append only file
+// expire it when the master does rather than relative to when they apply it,
+// and only a key that was set is propagated, without its conditions.
+func (s *server) setCommand(c *clientConn, commands []string) {
+	var expiry time.Time
+	var keepTTL, get bool
+	cond := setAlways
+	for i := 3; i < len(commands); i++ {
+		option := strings.ToLower(commands[i])
+		switch {
+		case option == "nx" && cond != setIfExists:
+			cond = setIfMissing
+		case option == "xx" && cond != setIfMissing:
+			cond = setIfExists
+		case option == "get":
+			get = true
+		case option == "keepttl" && expiry.IsZero():
+			keepTTL = true
+		case isExpiryOption(option) && expiry.IsZero() && !keepTTL && i+1 < len(commands):
+			var err error
+			if expiry, err = s.parseExpiry(commands[0], option, commands[i+1]); err != nil {
+				c.replyError(err)
+				return
 			}
-			connection.Write([]byte(createResponseMsg(infoResponse)))
-		case "replconf":
-			connection.Write([]byte(okResponse))
-		case "psync":
-			slaves = append(slaves, connection)
-			connection.Write([]byte("+FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0\r\n"))
-			connection.Write(append([]byte(fmt.Sprintf("$%d\r\n", len(emptyRDB))), emptyRDB...))
+			i++
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	old, existed, set, err := s.db(c).SetIf(commands[1], commands[2], expiry, keepTTL, cond, get)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	propagated := []string{"SET", commands[1], commands[2]}
+	switch {
+	case !expiry.IsZero():
+		propagated = append(propagated, "PXAT", strconv.FormatInt(expiry.UnixMilli(), 10))
+	case keepTTL:
+		propagated = append(propagated, "KEEPTTL")
+	}
+	s.propagateIfDirty(c.db, set, propagated)
+	switch {
+	case get && existed:
+		c.replyBulk(old)
+	case get || !set:
+		c.replyNull()
+	default:
+		c.replyOK()
+	}
+}
+
+func isExpiryOption(option string) bool {
+	switch strings.ToLower(option) {
+	case "ex", "px", "exat", "pxat":
+		return true
+	}
+	return false
+}
+
+// parseExpiry returns the time a key expires at given the EX, PX, EXAT or
+// PXAT option of command and its argument, which must be positive and not
+// overflow once in milliseconds.
+func (s *server) parseExpiry(command, option, arg string) (time.Time, error) {
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return time.Time{}, errNotInteger
+	}
+	option = strings.ToLower(option)
+	at, ok := expiryMillis(n, option[0] == 'p', strings.HasSuffix(option, "at"), s.clock.Now())
+	if n <= 0 || !ok {
+		return time.Time{}, fmt.Errorf("ERR invalid expire time in '%s' command", command)
+	}
+	return time.UnixMilli(at), nil
+}
+
+// propagateIfDirty propagates commands only when they changed the dataset,
+// for the commands that read a key and may or may not change it too.
+func (s *server) propagateIfDirty(db int, dirty bool, commands []string) {
+	if dirty {
+		s.propagate(db, commands)
+	}
+}
+
+// getdelCommand implements GETDEL key, propagated as a DEL when the key
+// existed.
+func (s *server) getdelCommand(c *clientConn, commands []string) {
+	val, ok, err := s.db(c).GetDel(commands[1])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagateIfDirty(c.db, ok, []string{"DEL", commands[1]})
+	if !ok {
+		c.replyNull()
+		return
+	}
+	c.replyBulk(val)
+}
+
+// getexCommand implements GETEX key [EX seconds | PX milliseconds | EXAT
+// unix-time-seconds | PXAT unix-time-milliseconds | PERSIST]. A new expiry is
+// propagated as PXAT, like SET does, and GETEX without options propagates
+// nothing.
+func (s *server) getexCommand(c *clientConn, commands []string) {
+	var expiry time.Time
+	persist := false
+	switch {
+	case len(commands) == 3 && strings.EqualFold(commands[2], "persist"):
+		persist = true
+	case len(commands) == 4 && isExpiryOption(commands[2]):
+		var err error
+		if expiry, err = s.parseExpiry(commands[0], commands[2], commands[3]); err != nil {
+			c.replyError(err)
+			return
 		}
+	case len(commands) != 2:
+		c.replyError(errSyntax)
+		return
+	}
+	val, ok, changed, err := s.db(c).GetEx(commands[1], expiry, persist)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !ok {
+		c.replyNull()
+		return
 	}
+	propagated := []string{"GETEX", commands[1], "PERSIST"}
+	if !persist {
+		propagated = []string{"GETEX", commands[1], "PXAT", strconv.FormatInt(expiry.UnixMilli(), 10)}
+	}
+	s.propagateIfDirty(c.db, changed, propagated)
+	c.replyBulk(val)
 }
 
-func replicateMaster(address string) {
-	parts := strings.Split(address, " ")
-	if len(parts) != 2 {
-		fmt.Println("Invalid master address format. Expected <MASTER_HOST> <MASTER_PORT>")
+// incrCommand implements INCR key, DECR key, INCRBY key increment and DECRBY
+// key decrement.
+func (s *server) incrCommand(c *clientConn, commands []string) {
+	command := strings.ToLower(commands[0])
+	delta := int64(1)
+	if len(commands) == 3 {
+		var ok bool
+		if delta, ok = parseInteger(commands[2]); !ok {
+			c.replyError(errNotInteger)
+			return
+		}
 	}
-	masterHost := parts[0]
-	masterPort := parts[1]
-	masterConn, err := net.Dial("tcp", masterHost+":"+masterPort)
+	if command == "decr" || command == "decrby" {
+		if delta == math.MinInt64 {
+			c.replyErrorMsg("decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+	n, err := s.db(c).IncrBy(commands[1], delta)
 	if err != nil {
-		fmt.Print("failed to connect to master at %s:%s\n", masterHost, masterPort)
+		c.replyError(err)
+		return
 	}
-	defer masterConn.Close()
-	// send ping
-	_, err = masterConn.Write([]byte(pingMessage))
+	s.propagate(c.db, commands)
+	c.replyInteger(int(n))
+}
+
+// setrangeCommand implements SETRANGE key offset value.
+func (s *server) setrangeCommand(c *clientConn, commands []string) {
+	offset, err := strconv.Atoi(commands[2])
 	if err != nil {
-		fmt.Println("Failed to send PING to master: ", err)
-		os.Exit(1)
+		c.replyError(errNotInteger)
+		return
+	}
+	if offset < 0 {
+		c.replyErrorMsg("offset is out of range")
+		return
 	}
+	n, err := s.db(c).SetRange(commands[1], offset, commands[3], s.config.bulkLimit())
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagateIfDirty(c.db, commands[3] != "", commands)
+	c.replyInteger(n)
+}
 
-	time.Sleep(1 * time.Second)
-	masterConn.Write([]byte("*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$4\r\n6380\r\n"))
-	time.Sleep(1 * time.Second)
-	masterConn.Write([]byte("*3\r\n$8\r\nREPLCONF\r\n$4\r\ncapa\r\n$6\r\npsync2\r\n"))
-	time.Sleep(1 * time.Second)
-	masterConn.Write([]byte("*3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n"))
+// appendCommand implements APPEND key value.
+func (s *server) appendCommand(c *clientConn, commands []string) {
+	n, err := s.db(c).Append(commands[1], commands[2], s.config.bulkLimit())
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(n)
+}
 
-	buff := make([]byte, 1024)
-	_, err = masterConn.Read(buff)
+func (s *server) getCommand(c *clientConn, commands []string) {
+	val, ok, err := s.db(c).Get(commands[1])
 	if err != nil {
-		fmt.Println("Failed to read PING response from master: ", err)
+		c.replyError(err)
+	} else if !ok {
+		c.replyNull()
+	} else {
+		c.replyBulk(val)
 	}
-	fmt.Println("Recieved ping response from master: ", string(buff))
 }
 
-func createResponseMsg(msg string) string {
-	return fmt.Sprintf("$%d\r\n%s\r\n", len(msg), msg)
+// msetCommand implements MSET key value [key value ...].
+func (s *server) msetCommand(c *clientConn, commands []string) {
+	if len(commands)%2 == 0 {
+		c.replyWrongArgs("mset")
+		return
+	}
+	s.db(c).MSet(commands[1:])
+	s.propagate(c.db, commands)
+	c.replyOK()
 }
 
-func parse(input []byte) []string {
-	rawInput := string(input)
-	commands := strings.Split(rawInput, "\r\n")
-	var parsedCommands []string
-	if strings.HasPrefix(commands[0], "*") {
-		_, err := strconv.Atoi(commands[0][1:])
-		if err != nil {
-			return []string{"Encountered error"}
-		}
-		checkLengthFlag := false
-		for _, v := range commands[1:] {
-			if strings.HasPrefix(v, "$") {
-				_, err := strconv.Atoi(v[1:])
-				if err != nil {
-					return []string{"Encountered error while parsing $"}
+// mgetCommand implements MGET key [key ...]. Keys that are missing or do not
+// hold a string are nil in the reply.
+func (s *server) mgetCommand(c *clientConn, commands []string) {
+	values, found := s.db(c).MGet(commands[1:])
+	c.replyWith(func(w *respWriter) {
+		w.WriteArray(len(values))
+		for i, value := range values {
+			if found[i] {
+				w.WriteBulkString(value)
+			} else {
+				w.WriteNull()
+			}
+		}
+	})
+}
+
+// Active expiry samples activeExpireSample keys with an expiry every
+// activeExpirePeriod, and samples again right away while more than a quarter
+// of them had expired.
+const (
+	activeExpirePeriod = 100 * time.Millisecond
+	activeExpireSample = 20
+)
+
+// activeExpire deletes expired keys in the background, so that keys that
+// are never read again do not stay in memory. It can be stopped with DEBUG
+// SET-ACTIVE-EXPIRE 0.
+func (s *server) activeExpire() {
+	for {
+		<-s.clock.After(activeExpirePeriod)
+		if s.activeExpireOff.Load() {
+			continue
+		}
+		start := time.Now()
+		s.execMu.RLock()
+		for _, db := range s.dbs {
+			for {
+				sampled, expired := db.ExpireSample(activeExpireSample)
+				if sampled < activeExpireSample || expired*4 <= sampled {
+					break
 				}
-				checkLengthFlag = true
-			} else if checkLengthFlag {
-				checkLengthFlag = false
-				parsedCommands = append(parsedCommands, strings.ToLower(v))
 			}
 		}
+		s.execMu.RUnlock()
+		s.recordLatency("expire-cycle", start)
+	}
+}
+
+// propagate feeds a write command to the connected slaves and to the append
+// only file, and publishes its keyspace events.
+func (s *server) propagate(db int, commands []string) {
+	s.feed(db, commands)
+	s.notifyWrite(db, commands)
+}
+
+// feed feeds a write command to the connected slaves and to the append only
+// file. Both are preceded by a SELECT when the command was run in another
+// database than the previous one. The clients blocked on a key of the
+// database are woken to look at it again, whichever command created it.
+func (s *server) feed(db int, commands []string) {
+	msg := createArrayMsg(commands)
+	s.dirty.Add(1)
+	s.slavesMu.Lock()
+	if db != s.replDB {
+		s.feedReplicas(createSelectMsg(db))
+		s.replDB = db
+	}
+	s.feedReplicas(msg)
+	s.slavesMu.Unlock()
+	if s.aof != nil {
+		s.aof.write(db, msg)
+	}
+	keys := commandKeys(commands)
+	s.dbs[db].UpdateEncodings(keys, s.config.encodingLimits())
+	s.dbs[db].UpdateSizes(keys)
+	s.dbs[db].WakeWaiters()
+	s.invalidate(commands)
+}
+
+func createSelectMsg(db int) string {
+	return createArrayMsg([]string{"SELECT", strconv.Itoa(db)})
+}
+
+// createArrayMsg encodes items as an array of bulk strings, the way
+// commands are sent.
+func createArrayMsg(items []string) string {
+	return encode(func(w *respWriter) { w.WriteReply(items) })
+}
+
+// createErrorReply turns an error whose message already carries its prefix,
+// such as errWrongType, into an error reply.
+func createErrorReply(err error) string {
+	return encode(func(w *respWriter) { w.WriteError(err.Error()) })
+}
+
+func errUnknownCommand(commands []string) error {
+	var args strings.Builder
+	for _, arg := range commands[1:] {
+		fmt.Fprintf(&args, "'%s' ", arg)
+	}
+	return fmt.Errorf("ERR unknown command '%s', with args beginning with: %s", commands[0], args.String())
+}
+
+func errWrongArgs(command string) error {
+	return fmt.Errorf("ERR wrong number of arguments for '%s' command", command)
+}
+
+// formatFloat formats a score the way redis replies with doubles.
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case f == 0 || (math.Abs(f) >= 1e-4 && math.Abs(f) < 1e17):
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// respStatus and respError are the status and error replies read by
+// readReply.
+type respStatus string
+type respError string
+
+// readReply reads a RESP2 reply from r: a respStatus, a respError, an int64, a
+// string for bulk strings, nil for null replies, or a []any for arrays.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return respStatus(line[1:]), nil
+	case '-':
+		return respError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		elements := make([]any, n)
+		for i := range elements {
+			if elements[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return elements, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
+
+// maxMultibulkLen is the number of arguments a client may send in a single
+// command.
+const maxMultibulkLen = 1024 * 1024
+
+// maxInlineLen is the length an inline command, or the header of a RESP
+// array or bulk string, can reach before its terminator.
+const maxInlineLen = 64 * 1024
+
+var errLineTooLong = errors.New("line too long")
+
+// protocolError is a malformed command, after which the rest of what the
+// client sent cannot be parsed.
+type protocolError string
+
+func (e protocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+// readCommand reads the next command from r, either a RESP array of bulk
+// strings or an inline command terminated by a newline. Commands of more than
+// maxMultibulkLen arguments, bulk strings longer than maxBulkLen and lines
+// longer than maxInlineLen are refused before anything is allocated for them,
+// unless maxBulkLen is zero, which lifts the limits for the trusted readers
+// of the append only file and the replication stream.
+func readCommand(r *bufio.Reader, maxBulkLen int) ([]string, error) {
+	lineLimit := 0
+	if maxBulkLen > 0 {
+		lineLimit = maxInlineLen
+	}
+	line, err := readLimitedLine(r, lineLimit)
+	if errors.Is(err, errLineTooLong) {
+		if strings.HasPrefix(line, "*") {
+			return nil, protocolError("too big mbulk count string")
+		}
+		return nil, protocolError("too big inline request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || (maxBulkLen > 0 && count > maxMultibulkLen) {
+		return nil, protocolError("invalid multibulk length")
+	}
+	if count <= 0 {
+		return nil, nil
+	}
+	commands := make([]string, 0, min(count, maxMultibulkLen))
+	for i := 0; i < count; i++ {
+		header, err := readLimitedLine(r, lineLimit)
+		if errors.Is(err, errLineTooLong) {
+			return nil, protocolError("too big bulk count string")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, protocolError(fmt.Sprintf("expected '$', got '%.1s'", header))
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil || size < 0 || (maxBulkLen > 0 && size > maxBulkLen) {
+			return nil, protocolError("invalid bulk length")
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		commands = append(commands, string(buf[:size]))
+	}
+	return commands, nil
+}
+
+// readLine reads a line terminated by \r\n (or a bare \n) without the
+// terminator.
+func readLine(r *bufio.Reader) (string, error) {
+	return readLimitedLine(r, 0)
+}
+
+// readLimitedLine is readLine, but fails with errLineTooLong once the line is
+// longer than limit, unless limit is zero, returning what it read of it. A
+// line without a terminator fails as soon as the reader filled its buffer
+// past limit, so no more than a buffer beyond limit is held for it.
+func readLimitedLine(r *bufio.Reader, limit int) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if limit > 0 && len(strings.TrimRight(string(line), "\r\n")) > limit {
+			return string(line), errLineTooLong
+		}
+		if err == nil {
+			break
+		}
+		if err != bufio.ErrBufferFull {
+			return "", err
+		}
 	}
-	return parsedCommands
+	return strings.TrimRight(string(line), "\r\n"), nil
 }
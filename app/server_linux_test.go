@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+// socketOption reads an integer option of the socket of conn.
+func socketOption(t *testing.T, conn net.Conn, level, option int) int {
+	t.Helper()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value int
+	var optErr error
+	if err := raw.Control(func(fd uintptr) {
+		value, optErr = syscall.GetsockoptInt(int(fd), level, option)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if optErr != nil {
+		t.Fatal(optErr)
+	}
+	return value
+}
+
+// acceptedConn returns the server side of the only connection of s.
+func acceptedConn(t *testing.T, s *server) net.Conn {
+	t.Helper()
+	waitFor(t, "the connection to be registered", func() bool { return clientCount(s) == 1 })
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	for _, c := range s.clients {
+		return c.conn
+	}
+	return nil
+}
+
+func TestKeepAlive(t *testing.T) {
+	s, addr := startServer(t, "tcp-keepalive", "60")
+	dial(t, addr)
+	conn := acceptedConn(t, s)
+	if socketOption(t, conn, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) == 0 {
+		t.Fatal("keepalive is not enabled on the accepted connection")
+	}
+	if idle := socketOption(t, conn, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); idle != 60 {
+		t.Fatalf("the keepalive period is %ds, want 60s", idle)
+	}
+}
+
+func TestKeepAliveDisabled(t *testing.T) {
+	s, addr := startServer(t, "tcp-keepalive", "0")
+	dial(t, addr)
+	if socketOption(t, acceptedConn(t, s), syscall.SOL_SOCKET, syscall.SO_KEEPALIVE) != 0 {
+		t.Fatal("keepalive is enabled with tcp-keepalive 0")
+	}
+}
+
+func TestNoDelay(t *testing.T) {
+	s, addr := startServer(t)
+	dial(t, addr)
+	if socketOption(t, acceptedConn(t, s), syscall.IPPROTO_TCP, syscall.TCP_NODELAY) == 0 {
+		t.Fatal("TCP_NODELAY is not set on the accepted connection")
+	}
+}
+
+func TestReplicaLinkNoDelay(t *testing.T) {
+	for _, disabled := range []string{"no", "yes"} {
+		t.Run(disabled, func(t *testing.T) {
+			s, addr := startServer(t, "repl-disable-tcp-nodelay", disabled)
+			attachReplica(t, addr)
+			set := socketOption(t, acceptedConn(t, s), syscall.IPPROTO_TCP, syscall.TCP_NODELAY) != 0
+			if set != (disabled == "no") {
+				t.Errorf("TCP_NODELAY set is %v on the replica link", set)
+			}
+		})
+	}
+}
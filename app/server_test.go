@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startServer starts a server listening on a free port of the loopback
+// interface, with the config params given as name and value pairs and its
+// files in a temporary directory. It returns the server and its address.
+func startServer(t testing.TB, params ...string) (*server, string) {
+	t.Helper()
+	return startServerWithClock(t, realClock{}, params...)
+}
+
+// startServerWithClock starts a server like startServer whose expiries and
+// timeouts follow clk.
+func startServerWithClock(t testing.TB, clk clock, params ...string) (*server, string) {
+	t.Helper()
+	s := newServerWithClock(clk)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	params = append([]string{"port", port, "dir", t.TempDir(), "save", ""}, params...)
+	for i := 0; i+1 < len(params); i += 2 {
+		if err := s.config.set(params[i], params[i+1], true); err != nil {
+			t.Fatalf("setting %s: %v", params[i], err)
+		}
+	}
+	s.dbs = s.newDatabases(int(s.config.databases))
+	if s.config.appendOnly {
+		path := filepath.Join(s.config.dir, s.config.appendFilename)
+		if err := s.loadAOF(path); err != nil {
+			t.Fatal(err)
+		}
+		a, err := openAOF(path, s.config.appendFsync)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.aof = a
+	} else if err := s.loadRDB(s.config.rdbPath()); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	go s.serve(listener)
+	return s, listener.Addr().String()
+}
+
+// pipeClient registers a client of s connected through a pipe, for calling
+// the handlers directly, and returns it with a reader of its replies.
+func pipeClient(t *testing.T, s *server) (*clientConn, *bufio.Reader) {
+	t.Helper()
+	conn, peer := net.Pipe()
+	t.Cleanup(func() { peer.Close() })
+	c, err := s.registerClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.unregisterClient(c) })
+	return c, bufio.NewReader(peer)
+}
+
+// testClient is a RESP2 connection to a test server.
+type testClient struct {
+	t      testing.TB
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// dial connects to the server at addr, and closes the connection when the
+// test ends.
+func dial(t testing.TB, addr string) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send sends a command without waiting for its reply.
+func (c *testClient) send(args ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(createArrayMsg(args))); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// read reads the next reply, as readReply returns it, failing the test if
+// none comes within a few seconds.
+func (c *testClient) read() any {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply, err := readReply(c.reader)
+	if err != nil {
+		c.t.Fatalf("reading the reply: %v", err)
+	}
+	return reply
+}
+
+// do sends a command and returns its reply.
+func (c *testClient) do(args ...string) any {
+	c.t.Helper()
+	c.send(args...)
+	return c.read()
+}
+
+// expect sends a command and fails the test unless it replies want.
+func (c *testClient) expect(want any, args ...string) {
+	c.t.Helper()
+	if got := c.do(args...); !reflect.DeepEqual(got, want) {
+		c.t.Fatalf("%s: got %#v, want %#v", strings.Join(args, " "), got, want)
+	}
+}
+
+// readRaw reads what the server sends until it stops for a moment, for the
+// replies readReply does not parse.
+func (c *testClient) readRaw() string {
+	c.t.Helper()
+	var out []byte
+	buf := make([]byte, 64<<10)
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, err := c.reader.Read(buf)
+		out = append(out, buf[:n]...)
+		if err != nil {
+			return string(out)
+		}
+	}
+}
+
+// hello3 switches the connection to RESP3, whose replies are then read with
+// readRaw.
+func (c *testClient) hello3() {
+	c.t.Helper()
+	c.send("HELLO", "3")
+	c.readRaw()
+}
+
+// closed reports whether the server closed the connection within a few
+// seconds.
+func (c *testClient) closed() bool {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, err := c.reader.ReadByte(); err != nil {
+			var netErr net.Error
+			return !errors.As(err, &netErr) || !netErr.Timeout()
+		}
+	}
+}
+
+var hex40 = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+func TestRandomIDs(t *testing.T) {
+	first, second := newServer(), newServer()
+	for _, id := range []string{first.runID, first.replID, second.runID, second.replID} {
+		if !hex40.MatchString(id) {
+			t.Errorf("id %q is not 40 hex characters", id)
+		}
+	}
+	if first.runID == second.runID || first.replID == second.replID {
+		t.Errorf("two servers got the same ids")
+	}
+}
+
+// waitFor fails the test unless cond holds within a few seconds.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); !cond(); time.Sleep(5 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestReadCommandLimits(t *testing.T) {
+	for _, test := range []struct {
+		input string
+		want  error
+	}{
+		{"*100000000\r\n", protocolError("invalid multibulk length")},
+		{"*1\r\n$2000000000\r\n", protocolError("invalid bulk length")},
+		{"*1\r\n$1048577\r\n", protocolError("invalid bulk length")},
+		{"*1\r\n$-1\r\n", protocolError("invalid bulk length")},
+		{"*" + strings.Repeat("1", maxInlineLen+1) + "\r\n", protocolError("too big mbulk count string")},
+		{strings.Repeat("x", maxInlineLen+1), protocolError("too big inline request")},
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := readCommand(bufio.NewReader(strings.NewReader(test.input)), 1<<20)
+		runtime.ReadMemStats(&after)
+		if err != test.want {
+			t.Errorf("reading %.20q: got %v, want %v", test.input, err, test.want)
+		}
+		// Nothing is allocated for the arguments announced.
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("reading %.20q allocated %d bytes", test.input, allocated)
+		}
+	}
+	// The trusted readers have no limits.
+	if _, err := readCommand(bufio.NewReader(strings.NewReader("*1\r\n$1048577\r\n")), 0); err != io.EOF {
+		t.Errorf("reading a long argument without limits: got %v, want the end of the input", err)
+	}
+}
+
+func TestProtocolErrorClosesConnection(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	if _, err := c.conn.Write([]byte("*100000000\r\n")); err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.read(); reply != respError("ERR Protocol error: invalid multibulk length") {
+		t.Fatalf("an absurd multibulk length: got %#v", reply)
+	}
+	if !c.closed() {
+		t.Fatal("the connection was not closed after the protocol error")
+	}
+}
+
+func TestInlineTooBig(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("PONG"), "PING")
+	// The line never ends; the server gives up on it past 64kb.
+	if _, err := c.conn.Write([]byte(strings.Repeat("x", 128<<10))); err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.read(); reply != respError("ERR Protocol error: too big inline request") {
+		t.Fatalf("a 128kb inline line: got %#v", reply)
+	}
+	if !c.closed() {
+		t.Fatal("the connection was not closed after the protocol error")
+	}
+}
+
+func TestUnterminatedLineNotBuffered(t *testing.T) {
+	// Without a terminator the line is refused once a buffer past the limit
+	// was read, not once the whole of it arrived.
+	r := bufio.NewReaderSize(io.MultiReader(strings.NewReader("*"), infiniteReader('1')), 16<<10)
+	if _, err := readCommand(r, 1<<20); err != protocolError("too big mbulk count string") {
+		t.Fatalf("an endless multibulk count: got %v", err)
+	}
+}
+
+// infiniteReader endlessly reads the same byte.
+type infiniteReader byte
+
+func (b infiniteReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
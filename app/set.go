@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// intset is a set made only of integers, stored as a sorted slice the way
+// redis stores them while they have no more than set-max-intset-entries
+// members. An intset is never changed in place: it is turned into a map to
+// be written to, and back into an intset by UpdateEncodings if it still fits.
+type intset []int64
+
+// newIntset returns set, whose members are all integers, as an intset.
+func newIntset(set map[string]struct{}) intset {
+	is := make(intset, 0, len(set))
+	for member := range set {
+		n, _ := strconv.ParseInt(member, 10, 64)
+		is = append(is, n)
+	}
+	slices.Sort(is)
+	return is
+}
+
+// members returns the members of is in ascending order.
+func (is intset) members() []string {
+	members := make([]string, len(is))
+	for i, n := range is {
+		members[i] = strconv.FormatInt(n, 10)
+	}
+	return members
+}
+
+// contains reports whether member is a member of is.
+func (is intset) contains(member string) bool {
+	n, ok := parseInteger(member)
+	if !ok {
+		return false
+	}
+	_, found := slices.BinarySearch(is, n)
+	return found
+}
+
+// set returns the members of is as a map.
+func (is intset) set() map[string]struct{} {
+	set := make(map[string]struct{}, len(is))
+	for _, n := range is {
+		set[strconv.FormatInt(n, 10)] = struct{}{}
+	}
+	return set
+}
+
+// set returns the set stored at key to be written to, creating an empty one
+// if create is set and the key does not exist. The caller must hold the
+// write lock.
+func (s *Store) set(key string, create bool) (map[string]struct{}, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		set := make(map[string]struct{})
+		s.Data[key] = set
+		delete(s.Encodings, key)
+		return set, nil
+	}
+	switch v := val.(type) {
+	case map[string]struct{}:
+		return v, nil
+	case intset:
+		set := v.set()
+		s.Data[key] = set
+		return set, nil
+	}
+	return nil, errWrongType
+}
+
+// readSet returns the set stored at key like set does, but leaves an intset
+// stored as it is. The caller must hold the write lock, and must not change
+// the set.
+func (s *Store) readSet(key string) (map[string]struct{}, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case map[string]struct{}:
+		return v, nil
+	case intset:
+		return v.set(), nil
+	}
+	return nil, errWrongType
+}
+
+// SAdd adds members to the set at key and returns how many were new.
+func (s *Store) SAdd(key string, members []string) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	set, err := s.set(key, true)
+	if err != nil {
+		return 0, err
+	}
+	added := 0
+	for _, member := range members {
+		if _, exists := set[member]; !exists {
+			set[member] = struct{}{}
+			added++
+		}
+	}
+	return added, nil
+}
+
+// SRem removes members from the set at key and returns how many were there.
+// A set left empty is deleted.
+func (s *Store) SRem(key string, members []string) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	set, err := s.set(key, false)
+	if err != nil || set == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if _, exists := set[member]; exists {
+			delete(set, member)
+			removed++
+		}
+	}
+	if len(set) == 0 {
+		s.remove(key)
+	}
+	return removed, nil
+}
+
+// SMIsMember reports, for each member, whether it belongs to the set at key.
+func (s *Store) SMIsMember(key string, members []string) ([]bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	set, err := s.readSet(key)
+	if err != nil {
+		return nil, err
+	}
+	s.countLookup(set != nil)
+	found := make([]bool, len(members))
+	for i, member := range members {
+		_, found[i] = set[member]
+	}
+	return found, nil
+}
+
+// SInterCard returns the cardinality of the intersection of the sets at keys,
+// stopping early once limit is reached unless limit is 0. The intersection is
+// counted by walking the smallest set, without building it.
+func (s *Store) SInterCard(keys []string, limit int) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	sets := make([]map[string]struct{}, len(keys))
+	smallest := 0
+	for i, key := range keys {
+		set, err := s.readSet(key)
+		if err != nil {
+			return 0, err
+		}
+		sets[i] = set
+		if len(set) < len(sets[smallest]) {
+			smallest = i
+		}
+	}
+	count := 0
+	for member := range sets[smallest] {
+		inAll := true
+		for i, set := range sets {
+			if i == smallest {
+				continue
+			}
+			if _, ok := set[member]; !ok {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			count++
+			if count == limit {
+				break
+			}
+		}
+	}
+	return count, nil
+}
+
+// parseNumKeys parses the numkeys argument at commands[at] of commands such as
+// SINTERCARD and LMPOP and checks that that many keys follow it.
+func parseNumKeys(commands []string, at int) (int, error) {
+	numKeys, err := strconv.Atoi(commands[at])
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if numKeys <= 0 {
+		return 0, errors.New("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(commands)-at-1 {
+		return 0, errors.New("ERR Number of keys can't be greater than number of args")
+	}
+	return numKeys, nil
+}
+
+func (s *server) saddCommand(c *clientConn, commands []string) {
+	if len(commands) < 3 {
+		c.replyWrongArgs("sadd")
+		return
+	}
+	added, err := s.db(c).SAdd(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(added)
+}
+
+func (s *server) sremCommand(c *clientConn, commands []string) {
+	removed, err := s.db(c).SRem(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if removed > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(removed)
+}
+
+func (s *server) smismemberCommand(c *clientConn, commands []string) {
+	if len(commands) < 3 {
+		c.replyWrongArgs("smismember")
+		return
+	}
+	found, err := s.db(c).SMIsMember(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	reply := make([]any, len(found))
+	for i, ok := range found {
+		reply[i] = 0
+		if ok {
+			reply[i] = 1
+		}
+	}
+	c.replyValue(reply)
+}
+
+// sintercardCommand implements SINTERCARD numkeys key [key ...] [LIMIT limit].
+func (s *server) sintercardCommand(c *clientConn, commands []string) {
+	if len(commands) < 3 {
+		c.replyWrongArgs("sintercard")
+		return
+	}
+	numKeys, err := parseNumKeys(commands, 1)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	limit := 0
+	rest := commands[2+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "limit") {
+			c.replyError(errSyntax)
+			return
+		}
+		if limit, err = strconv.Atoi(rest[1]); err != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+		if limit < 0 {
+			c.replyErrorMsg("LIMIT can't be negative")
+			return
+		}
+	}
+	count, err := s.db(c).SInterCard(commands[2:2+numKeys], limit)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(count)
+}
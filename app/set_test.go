@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSInterCard(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(4), "SADD", "first", "a", "b", "c", "d")
+	c.expect(int64(3), "SADD", "second", "b", "c", "d")
+	c.expect(int64(3), "SINTERCARD", "2", "first", "second")
+	c.expect(int64(2), "SINTERCARD", "2", "first", "second", "LIMIT", "2")
+	c.expect(int64(3), "SINTERCARD", "2", "first", "second", "LIMIT", "0")
+	c.expect(int64(0), "SINTERCARD", "2", "first", "missing")
+	if _, ok := c.do("SINTERCARD", "3", "first", "second").(respError); !ok {
+		t.Fatal("SINTERCARD with fewer keys than numkeys did not fail")
+	}
+}
+
+func TestSMIsMember(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(2), "SADD", "set", "a", "b")
+	c.expect([]any{int64(1), int64(0), int64(1)}, "SMISMEMBER", "set", "a", "c", "b")
+	c.expect([]any{int64(0)}, "SMISMEMBER", "missing", "a")
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var errShutdown = errors.New("ERR Errors trying to SHUTDOWN. Check logs.")
+
+// shutdownGrace is how long the shutdown waits for the clients it woke up to
+// get their replies, and then for the replies to be written, before the
+// connections are closed.
+const shutdownGrace = time.Second
+
+// shutdown saves the snapshot if save is set, flushes the append only file,
+// wakes the blocked clients and closes every connection before the process
+// exits. It returns only if the save failed, leaving the server running.
+func (s *server) shutdown(save bool) error {
+	// Without a save the running script, if any, is stopped rather than
+	// waited for, however long it runs.
+	if run := s.script.Load(); run != nil && !save {
+		run.killed.Store(true)
+	}
+	s.execMu.Lock()
+	if save {
+		if err := s.save(s.config.rdbPath()); err != nil {
+			s.execMu.Unlock()
+			fmt.Println("Error trying to save the DB, can't exit: ", err)
+			return errShutdown
+		}
+	}
+	if s.aof != nil {
+		s.aof.mu.Lock()
+		s.aof.syncLocked()
+		s.aof.mu.Unlock()
+	}
+	// The blocked clients reply as if their timeout passed, which they do
+	// without execMu.
+	s.shutdownOnce.Do(func() { close(s.shuttingDown) })
+	for start := time.Now(); s.blockedClients.Load() > 0 && time.Since(start) < shutdownGrace; {
+		time.Sleep(time.Millisecond)
+	}
+	deadline := time.Now().Add(shutdownGrace)
+	s.clientsMu.Lock()
+	for _, c := range s.clients {
+		c.close(deadline)
+	}
+	s.clientsMu.Unlock()
+	fmt.Println("Redis is now ready to exit, bye bye...")
+	os.Exit(0)
+	return nil
+}
+
+// shutdownOnSignal shuts the server down on SIGTERM or SIGINT, saving the
+// snapshot if save rules are configured.
+func (s *server) shutdownOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	for sig := range signals {
+		fmt.Printf("Received %v scheduling shutdown...\n", sig)
+		s.shutdown(len(s.config.saveRules()) > 0)
+	}
+}
+
+// shutdownCommand implements SHUTDOWN [NOSAVE|SAVE]. It saves the snapshot
+// if save rules are configured, unless NOSAVE is given, or anyway with SAVE.
+func (s *server) shutdownCommand(c *clientConn, commands []string) {
+	save := len(s.config.saveRules()) > 0
+	for _, option := range commands[1:] {
+		switch strings.ToLower(option) {
+		case "nosave":
+			save = false
+		case "save":
+			save = true
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	if err := s.shutdown(save); err != nil {
+		c.replyError(err)
+	}
+}
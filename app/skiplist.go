@@ -0,0 +1,166 @@
+package main
+
+import "math/rand"
+
+// skiplistMaxLevel and skiplistP are the most levels a node of a skiplist
+// has and the chance it has one more, as in redis.
+const (
+	skiplistMaxLevel = 32
+	skiplistP        = 0.25
+)
+
+// skiplist orders the members of a sorted set by score, and by member for
+// equal scores, the way redis does: each level skips over the nodes of the
+// levels below it, and records how many nodes it skips so that the rank of
+// a node is found as it is reached.
+type skiplist struct {
+	head   *skiplistNode
+	tail   *skiplistNode
+	length int
+	level  int
+}
+
+type skiplistNode struct {
+	zmember
+	backward *skiplistNode
+	levels   []skiplistLevel
+}
+
+// skiplistLevel is the next node at a level and the number of nodes the
+// link spans, the next one included.
+type skiplistLevel struct {
+	forward *skiplistNode
+	span    int
+}
+
+func newSkiplist() *skiplist {
+	return &skiplist{head: &skiplistNode{levels: make([]skiplistLevel, skiplistMaxLevel)}, level: 1}
+}
+
+// less reports whether n comes before member with score.
+func (n *skiplistNode) less(score float64, member string) bool {
+	return n.score < score || n.score == score && n.member < member
+}
+
+func randomSkiplistLevel() int {
+	level := 1
+	for level < skiplistMaxLevel && rand.Float64() < skiplistP {
+		level++
+	}
+	return level
+}
+
+// insert adds member with score, which must not be in the list already.
+func (l *skiplist) insert(member string, score float64) {
+	var update [skiplistMaxLevel]*skiplistNode
+	var rank [skiplistMaxLevel]int
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		if i < l.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for next := x.levels[i].forward; next != nil && next.less(score, member); next = x.levels[i].forward {
+			rank[i] += x.levels[i].span
+			x = next
+		}
+		update[i] = x
+	}
+	level := randomSkiplistLevel()
+	if level > l.level {
+		for i := l.level; i < level; i++ {
+			update[i] = l.head
+			update[i].levels[i].span = l.length
+		}
+		l.level = level
+	}
+	n := &skiplistNode{zmember: zmember{member, score}, levels: make([]skiplistLevel, level)}
+	for i := 0; i < level; i++ {
+		n.levels[i].forward = update[i].levels[i].forward
+		update[i].levels[i].forward = n
+		n.levels[i].span = update[i].levels[i].span - (rank[0] - rank[i])
+		update[i].levels[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < l.level; i++ {
+		update[i].levels[i].span++
+	}
+	if update[0] != l.head {
+		n.backward = update[0]
+	}
+	if n.levels[0].forward != nil {
+		n.levels[0].forward.backward = n
+	} else {
+		l.tail = n
+	}
+	l.length++
+}
+
+// remove deletes member with score, and reports whether it was there.
+func (l *skiplist) remove(member string, score float64) bool {
+	var update [skiplistMaxLevel]*skiplistNode
+	x := l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for next := x.levels[i].forward; next != nil && next.less(score, member); next = x.levels[i].forward {
+			x = next
+		}
+		update[i] = x
+	}
+	n := x.levels[0].forward
+	if n == nil || n.score != score || n.member != member {
+		return false
+	}
+	for i := 0; i < l.level; i++ {
+		if update[i].levels[i].forward == n {
+			update[i].levels[i].span += n.levels[i].span - 1
+			update[i].levels[i].forward = n.levels[i].forward
+		} else {
+			update[i].levels[i].span--
+		}
+	}
+	if n.levels[0].forward != nil {
+		n.levels[0].forward.backward = n.backward
+	} else {
+		l.tail = n.backward
+	}
+	for l.level > 1 && l.head.levels[l.level-1].forward == nil {
+		l.level--
+	}
+	l.length--
+	return true
+}
+
+// first returns the node with the lowest score, nil if the list is empty.
+func (l *skiplist) first() *skiplistNode {
+	return l.head.levels[0].forward
+}
+
+// byRank returns the node at the 0-based rank, nil if it is out of range.
+func (l *skiplist) byRank(rank int) *skiplistNode {
+	if rank < 0 || rank >= l.length {
+		return nil
+	}
+	x, traversed := l.head, 0
+	for i := l.level - 1; i >= 0; i-- {
+		for x.levels[i].forward != nil && traversed+x.levels[i].span <= rank+1 {
+			traversed += x.levels[i].span
+			x = x.levels[i].forward
+		}
+		if traversed == rank+1 {
+			return x
+		}
+	}
+	return nil
+}
+
+// seek returns the first node for which before is false, and its 0-based
+// rank, which is the length of the list if there is none. before must be
+// true for the nodes up to some point and false for all the following ones.
+func (l *skiplist) seek(before func(n *skiplistNode) bool) (*skiplistNode, int) {
+	x, traversed := l.head, 0
+	for i := l.level - 1; i >= 0; i-- {
+		for next := x.levels[i].forward; next != nil && before(next); next = x.levels[i].forward {
+			traversed += x.levels[i].span
+			x = next
+		}
+	}
+	return x.levels[0].forward, traversed
+}
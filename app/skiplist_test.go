@@ -0,0 +1,66 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestSkiplistMatchesSortedOrder(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	z := newSortedSet()
+	want := map[string]float64{}
+	for i := 0; i < 5000; i++ {
+		member := "m" + strconv.Itoa(rng.Intn(500))
+		if rng.Intn(3) == 0 {
+			z.remove(member)
+			delete(want, member)
+			continue
+		}
+		score := float64(rng.Intn(50))
+		z.set(member, score)
+		want[member] = score
+	}
+
+	sorted := make([]zmember, 0, len(want))
+	for member, score := range want {
+		sorted = append(sorted, zmember{member, score})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].score != sorted[j].score {
+			return sorted[i].score < sorted[j].score
+		}
+		return sorted[i].member < sorted[j].member
+	})
+	got := z.sorted()
+	if len(got) != len(sorted) || z.zsl.length != len(sorted) {
+		t.Fatalf("%d members in order, %d in the list, want %d", len(got), z.zsl.length, len(sorted))
+	}
+	for i, m := range sorted {
+		if got[i] != m {
+			t.Fatalf("member %d: got %v, want %v", i, got[i], m)
+		}
+		if n := z.zsl.byRank(i); n == nil || n.zmember != m {
+			t.Fatalf("byRank(%d): got %v, want %v", i, n, m)
+		}
+		n, rank := z.zsl.seek(func(n *skiplistNode) bool { return n.less(m.score, m.member) })
+		if n == nil || n.zmember != m || rank != i {
+			t.Fatalf("seek to %v: got %v at %d, want rank %d", m, n, rank, i)
+		}
+	}
+	// The backward links walk the same order from the tail.
+	i := len(sorted) - 1
+	for n := z.zsl.tail; n != nil; n = n.backward {
+		if n.zmember != sorted[i] {
+			t.Fatalf("backward from the tail, member %d: got %v, want %v", i, n.zmember, sorted[i])
+		}
+		i--
+	}
+	if i != -1 {
+		t.Fatalf("the backward links skipped %d members", i+1)
+	}
+	if z.zsl.byRank(len(sorted)) != nil || z.zsl.byRank(-1) != nil {
+		t.Fatal("byRank out of range returned a node")
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var errSortScore = errors.New("ERR One or more scores can't be converted into double")
+
+// sortOptions are the options of SORT. by is the BY pattern, and a pattern
+// without a * leaves the elements in their order. gets are the GET patterns,
+// and a count below 0 means no limit.
+type sortOptions struct {
+	by            string
+	gets          []string
+	offset, count int
+	desc, alpha   bool
+	store         string
+}
+
+// sortValue is an element of the SORT reply, missing when a GET pattern
+// refers to a key or field that does not exist.
+type sortValue struct {
+	value string
+	ok    bool
+}
+
+// lookupPattern returns the value a BY or GET pattern refers to for element:
+// the first * is replaced with the element, and a -> after it separates the
+// key of a hash from one of its fields. The caller must hold the write lock.
+func (s *Store) lookupPattern(pattern, element string) (string, bool) {
+	if pattern == "#" {
+		return element, true
+	}
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return "", false
+	}
+	key, field := pattern[:star]+element+pattern[star+1:], ""
+	if arrow := strings.Index(pattern[star+1:], "->"); arrow >= 0 && star+1+arrow+2 < len(pattern) {
+		key = pattern[:star] + element + pattern[star+1:star+1+arrow]
+		field = pattern[star+1+arrow+2:]
+	}
+	value, ok := s.lookup(key)
+	if !ok {
+		return "", false
+	}
+	if field != "" {
+		h, ok := value.(map[string]string)
+		if !ok {
+			return "", false
+		}
+		v, ok := h[field]
+		return v, ok
+	}
+	str, ok := value.(string)
+	return str, ok
+}
+
+// Sort returns the elements of the list, set or sorted set at key sorted as
+// opts tell, or the values their GET patterns refer to. With a store
+// destination the result is saved there as a list, replacing its value.
+func (s *Store) Sort(key string, opts sortOptions) ([]sortValue, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var elements []string
+	dontSort := opts.by != "" && !strings.Contains(opts.by, "*")
+	if value, ok := s.lookup(key); ok {
+		switch v := value.(type) {
+		case []string:
+			elements = append([]string(nil), v...)
+		case map[string]struct{}:
+			elements = sortedKeys(v)
+		case intset:
+			elements = v.members()
+		case *sortedSet:
+			for _, m := range v.sorted() {
+				elements = append(elements, m.member)
+			}
+			// Sorted sets have an order of their own, which DESC
+			// reverses even when BY leaves the elements unsorted.
+			if dontSort && opts.desc {
+				for i, j := 0, len(elements)-1; i < j; i, j = i+1, j-1 {
+					elements[i], elements[j] = elements[j], elements[i]
+				}
+			}
+		default:
+			return nil, errWrongType
+		}
+	}
+
+	if !dontSort {
+		weights := make([]string, len(elements))
+		present := make([]bool, len(elements))
+		scores := make([]float64, len(elements))
+		for i, element := range elements {
+			weights[i], present[i] = element, true
+			if opts.by != "" {
+				weights[i], present[i] = s.lookupPattern(opts.by, element)
+			}
+			if opts.alpha || !present[i] {
+				continue
+			}
+			score, err := strconv.ParseFloat(weights[i], 64)
+			if err != nil {
+				return nil, errSortScore
+			}
+			scores[i] = score
+		}
+		order := make([]int, len(elements))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(x, y int) bool {
+			i, j := order[x], order[y]
+			cmp := 0
+			switch {
+			case opts.alpha && present[i] != present[j]:
+				if !present[i] {
+					cmp = -1
+				} else {
+					cmp = 1
+				}
+			case opts.alpha:
+				cmp = strings.Compare(weights[i], weights[j])
+			case scores[i] < scores[j]:
+				cmp = -1
+			case scores[i] > scores[j]:
+				cmp = 1
+			}
+			// Equal weights are ordered by the elements, so that the
+			// result does not depend on the order they were found in.
+			if cmp == 0 {
+				cmp = bytes.Compare([]byte(elements[i]), []byte(elements[j]))
+			}
+			if opts.desc {
+				return cmp > 0
+			}
+			return cmp < 0
+		})
+		sorted := make([]string, len(elements))
+		for x, i := range order {
+			sorted[x] = elements[i]
+		}
+		elements = sorted
+	}
+
+	start := min(max(opts.offset, 0), len(elements))
+	end := len(elements)
+	if opts.count >= 0 && start+opts.count < end {
+		end = start + opts.count
+	}
+	elements = elements[start:end]
+
+	var result []sortValue
+	for _, element := range elements {
+		if len(opts.gets) == 0 {
+			result = append(result, sortValue{element, true})
+		}
+		for _, pattern := range opts.gets {
+			value, ok := s.lookupPattern(pattern, element)
+			result = append(result, sortValue{value, ok})
+		}
+	}
+	if opts.store != "" {
+		if len(result) == 0 {
+			s.remove(opts.store)
+		} else {
+			list := make([]string, len(result))
+			for i, v := range result {
+				list[i] = v.value
+			}
+			s.remove(opts.store)
+			s.Data[opts.store] = list
+		}
+	}
+	return result, nil
+}
+
+func (s *server) sortCommand(c *clientConn, commands []string) {
+	s.sort(c, commands, false)
+}
+
+func (s *server) sortROCommand(c *clientConn, commands []string) {
+	s.sort(c, commands, true)
+}
+
+// sort implements SORT key [BY pattern] [LIMIT offset count] [GET pattern
+// ...] [ASC|DESC] [ALPHA] [STORE destination] and, when readOnly is set,
+// SORT_RO, which has no STORE option.
+func (s *server) sort(c *clientConn, commands []string, readOnly bool) {
+	opts := sortOptions{count: -1}
+	for i := 2; i < len(commands); i++ {
+		left := len(commands) - i - 1
+		switch strings.ToLower(commands[i]) {
+		case "asc":
+			opts.desc = false
+		case "desc":
+			opts.desc = true
+		case "alpha":
+			opts.alpha = true
+		case "limit":
+			if left < 2 {
+				c.replyError(errSyntax)
+				return
+			}
+			offset, err1 := strconv.Atoi(commands[i+1])
+			count, err2 := strconv.Atoi(commands[i+2])
+			if err1 != nil || err2 != nil {
+				c.replyError(errNotInteger)
+				return
+			}
+			opts.offset, opts.count = offset, count
+			i += 2
+		case "by":
+			if left < 1 {
+				c.replyError(errSyntax)
+				return
+			}
+			i++
+			opts.by = commands[i]
+		case "get":
+			if left < 1 {
+				c.replyError(errSyntax)
+				return
+			}
+			i++
+			opts.gets = append(opts.gets, commands[i])
+		case "store":
+			if readOnly || left < 1 {
+				c.replyError(errSyntax)
+				return
+			}
+			i++
+			opts.store = commands[i]
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	result, err := s.db(c).Sort(commands[1], opts)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if opts.store != "" {
+		s.propagate(c.db, commands)
+		c.replyInteger(len(result))
+		return
+	}
+	c.replyWith(func(w *respWriter) {
+		w.WriteArray(len(result))
+		for _, v := range result {
+			if v.ok {
+				w.WriteBulkString(v.value)
+			} else {
+				w.WriteNull()
+			}
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestSortNumbers(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(5), "RPUSH", "list", "3", "10", "-1", "2.5", "7")
+	c.expect([]any{"-1", "2.5", "3", "7", "10"}, "SORT", "list")
+	c.expect([]any{"10", "7", "3", "2.5", "-1"}, "SORT", "list", "DESC")
+	c.expect([]any{"3", "7"}, "SORT", "list", "LIMIT", "2", "2")
+	c.expect(int64(6), "RPUSH", "list", "x")
+	c.expect(respError("ERR One or more scores can't be converted into double"), "SORT", "list")
+}
+
+func TestSortAlpha(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(4), "SADD", "set", "pear", "apple", "fig", "banana")
+	c.expect([]any{"apple", "banana", "fig", "pear"}, "SORT", "set", "ALPHA")
+	c.expect([]any{"pear", "fig", "banana", "apple"}, "SORT_RO", "set", "ALPHA", "DESC")
+}
+
+func TestSortByGet(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "RPUSH", "ids", "1", "2", "3")
+	c.expect(respStatus("OK"), "MSET", "weight:1", "30", "weight:2", "10", "weight:3", "20",
+		"name:1", "one", "name:2", "two")
+	c.expect([]any{"2", "3", "1"}, "SORT", "ids", "BY", "weight:*")
+	c.expect([]any{"two", "2", nil, "3", "one", "1"}, "SORT", "ids", "BY", "weight:*", "GET", "name:*", "GET", "#")
+	c.expect([]any{"1", "2", "3"}, "SORT", "ids", "BY", "nosort")
+	c.expect(int64(3), "SORT", "ids", "DESC", "STORE", "sorted")
+	c.expect([]any{"3", "2", "1"}, "LRANGE", "sorted", "0", "-1")
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// Store is the keyspace. A value in Data is a string, a list ([]string), a
+// hash (map[string]string), a set (map[string]struct{}, or an intset while
+// it is small and made of integers), a *sortedSet or a *stream.
+type Store struct {
+	Data     map[string]any
+	Expiries map[string]time.Time
+	// Encodings records the encodings collections reached as they grew,
+	// which they keep when they shrink again, and the strings that were
+	// changed in place, which are raw.
+	Encodings map[string]string
+	// Sizes are the estimated sizes of the keys, which add up to Used,
+	// and Access records their accesses for eviction. Evicted counts the
+	// keys evicted.
+	Sizes   map[string]int64
+	Used    int64
+	Access  map[string]*keyAccess
+	Evicted int64
+	Mutex   sync.RWMutex
+	// Rand is the source of randomness for commands such as HRANDFIELD. It
+	// is guarded by Mutex and can be replaced to make them deterministic.
+	Rand *rand.Rand
+	// Clock tells which keys have expired, and expired, if set, is called
+	// with the write lock held for each key deleted because it expired.
+	Clock   clock
+	expired func(key string)
+	// Hits and Misses count the lookups of read commands that found their
+	// key and those that did not. They are guarded by Mutex.
+	Hits   int64
+	Misses int64
+	// saving holds the values a background snapshot has yet to write,
+	// while one is being written, and detached the keys whose values the
+	// keyspace has its own copy of since.
+	saving   map[string]any
+	detached map[string]struct{}
+	// ready is closed, and forgotten, when a key is written or the keyspace
+	// is loaded, so that the clients blocked until there is something to
+	// pop look again. It is guarded by Mutex.
+	ready chan struct{}
+	// scanKeys and scanCollections are the keys, and the elements of the
+	// collections, that SCAN and HSCAN, SSCAN and ZSCAN go through, taken
+	// when an iteration starts at cursor 0 and dropped once one ends. An
+	// element added since may be left out, which the guarantees of SCAN
+	// allow, and one removed since is skipped. They are guarded by Mutex.
+	scanKeys        scanIndex
+	scanCollections map[string]scanIndex
+}
+
+func NewStore(clk clock) *Store {
+	return &Store{
+		Data:     make(map[string]any),
+		Expiries: make(map[string]time.Time),
+
+		Encodings: make(map[string]string),
+		Sizes:     make(map[string]int64),
+		Access:    make(map[string]*keyAccess),
+		Rand:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		Clock:     clk,
+	}
+}
+
+// readyChan returns a channel closed the next time a key of the database is
+// written, or the keyspace is loaded anew, for the clients blocked until a
+// key they wait for has something to pop.
+func (s *Store) readyChan() <-chan struct{} {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	if s.ready == nil {
+		s.ready = make(chan struct{})
+	}
+	return s.ready
+}
+
+// signalReady wakes the clients waiting on readyChan. The caller must hold the
+// write lock.
+func (s *Store) signalReady() {
+	if s.ready != nil {
+		close(s.ready)
+		s.ready = nil
+	}
+}
+
+// WakeWaiters wakes the clients waiting on readyChan after a write.
+func (s *Store) WakeWaiters() {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.signalReady()
+}
+
+// lookup returns the live value stored at key, deleting it first if it has
+// expired. The caller must hold the write lock.
+func (s *Store) lookup(key string) (any, bool) {
+	if expiry, exists := s.Expiries[key]; exists && s.Clock.Now().After(expiry) {
+		s.removeExpired(key)
+		return nil, false
+	}
+	val, ok := s.Data[key]
+	if ok && s.saving != nil {
+		val = s.detach(key, val)
+	}
+	return val, ok
+}
+
+// countLookup records a lookup of a read command in Hits or Misses. The
+// caller must hold the write lock.
+func (s *Store) countLookup(found bool) {
+	if found {
+		s.Hits++
+	} else {
+		s.Misses++
+	}
+}
+
+// KeyspaceStats returns Hits and Misses.
+func (s *Store) KeyspaceStats() (hits, misses int64) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	return s.Hits, s.Misses
+}
+
+// ResetStats zeroes Hits, Misses and Evicted.
+func (s *Store) ResetStats() {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.Hits, s.Misses, s.Evicted = 0, 0, 0
+}
+
+// remove deletes key with everything recorded about it. The caller must hold
+// the write lock.
+func (s *Store) remove(key string) {
+	delete(s.Data, key)
+	delete(s.Expiries, key)
+	delete(s.Encodings, key)
+	s.Used -= s.Sizes[key]
+	delete(s.Sizes, key)
+	delete(s.Access, key)
+	delete(s.scanCollections, key)
+}
+
+// removeExpired deletes key, which expired. The caller must hold the write
+// lock.
+func (s *Store) removeExpired(key string) {
+	s.remove(key)
+	if s.expired != nil {
+		s.expired(key)
+	}
+}
+
+// ExpireSample deletes the expired keys among up to n of the keys with an
+// expiry, and returns how many it looked at and how many it deleted.
+func (s *Store) ExpireSample(n int) (sampled, expired int) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	now := s.Clock.Now()
+	for key, expiry := range s.Expiries {
+		if sampled == n {
+			break
+		}
+		sampled++
+		if now.After(expiry) {
+			s.removeExpired(key)
+			expired++
+		}
+	}
+	return sampled, expired
+}
+
+// Set stores value at key, expiring at expiry unless it is zero.
+func (s *Store) Set(key, value string, expiry time.Time) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	s.Data[key] = value
+	delete(s.Encodings, key)
+	if !expiry.IsZero() {
+		s.Expiries[key] = expiry
+	} else {
+		delete(s.Expiries, key)
+	}
+}
+
+// setCondition is the condition of SET NX and SET XX on whether the key
+// exists.
+type setCondition int
+
+const (
+	setAlways setCondition = iota
+	setIfMissing
+	setIfExists
+)
+
+// SetIf sets key to value like Set if the key exists or not as cond
+// requires, keeping the expiry the key has if keepTTL is set. It reports
+// whether it set the key and, if get is set, returns the string the key
+// held before, and errWrongType without setting anything if the key holds
+// another type.
+func (s *Store) SetIf(key, value string, expiry time.Time, keepTTL bool, cond setCondition, get bool) (string, bool, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	val, exists := s.lookup(key)
+	var old string
+	if get {
+		s.countLookup(exists)
+		if exists {
+			str, ok := val.(string)
+			if !ok {
+				return "", false, false, errWrongType
+			}
+			old = str
+		}
+	}
+	if cond == setIfMissing && exists || cond == setIfExists && !exists {
+		return old, exists, false, nil
+	}
+	s.Data[key] = value
+	delete(s.Encodings, key)
+	switch {
+	case !expiry.IsZero():
+		s.Expiries[key] = expiry
+	case !keepTTL:
+		delete(s.Expiries, key)
+	}
+	return old, exists, true, nil
+}
+
+func (s *Store) Get(key string) (string, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	val, ok := s.lookup(key)
+	s.countLookup(ok)
+	if !ok {
+		return "", false, nil
+	}
+	str, ok := val.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	return str, true, nil
+}
+
+// GetDel returns the string at key and deletes it.
+func (s *Store) GetDel(key string) (string, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	val, ok := s.lookup(key)
+	s.countLookup(ok)
+	if !ok {
+		return "", false, nil
+	}
+	str, ok := val.(string)
+	if !ok {
+		return "", false, errWrongType
+	}
+	s.remove(key)
+	return str, true, nil
+}
+
+// GetEx returns the string at key, and then sets it to expire at expiry
+// unless it is zero, or drops its expiry with persist. It reports whether
+// the expiry changed.
+func (s *Store) GetEx(key string, expiry time.Time, persist bool) (string, bool, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	val, ok := s.lookup(key)
+	s.countLookup(ok)
+	if !ok {
+		return "", false, false, nil
+	}
+	str, ok := val.(string)
+	if !ok {
+		return "", false, false, errWrongType
+	}
+	_, volatile := s.Expiries[key]
+	switch {
+	case persist && volatile:
+		delete(s.Expiries, key)
+		return str, true, true, nil
+	case !expiry.IsZero():
+		s.Expiries[key] = expiry
+		return str, true, true, nil
+	}
+	return str, true, false, nil
+}
+
+// parseInteger parses str as a 64-bit integer written the way redis writes
+// them, without a plus sign, leading zeros or spaces.
+func parseInteger(str string) (int64, bool) {
+	n, err := strconv.ParseInt(str, 10, 64)
+	return n, err == nil && strconv.FormatInt(n, 10) == str
+}
+
+// IncrBy adds delta to the integer held as a string at key, starting from 0
+// if the key is missing, and returns the result. The expiry of the key is
+// kept. On overflow the value is left as it was.
+func (s *Store) IncrBy(key string, delta int64) (int64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var n int64
+	if val, ok := s.lookup(key); ok {
+		str, ok := val.(string)
+		if !ok {
+			return 0, errWrongType
+		}
+		if n, ok = parseInteger(str); !ok {
+			return 0, errNotInteger
+		}
+	}
+	if (delta > 0 && n > math.MaxInt64-delta) || (delta < 0 && n < math.MinInt64-delta) {
+		return 0, errOverflow
+	}
+	n += delta
+	s.Data[key] = strconv.FormatInt(n, 10)
+	delete(s.Encodings, key)
+	return n, nil
+}
+
+// SetRange overwrites the string at key from offset with value, padding it
+// with zero bytes first if it is shorter, and returns its new length. The
+// string may not grow past maxLen bytes. An empty value changes nothing, not
+// even a missing key.
+func (s *Store) SetRange(key string, offset int, value string, maxLen int) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	str, _, err := s.str(key)
+	if err != nil {
+		return 0, err
+	}
+	if value == "" {
+		return len(str), nil
+	}
+	if offset > maxLen-len(value) {
+		return 0, errTooLong
+	}
+	buf := []byte(str)
+	if end := offset + len(value); end > len(buf) {
+		buf = append(buf, make([]byte, end-len(buf))...)
+	}
+	copy(buf[offset:], value)
+	s.setRaw(key, string(buf))
+	return len(buf), nil
+}
+
+// Append appends value to the string at key, created if missing, and returns
+// its new length, which may not exceed maxLen.
+func (s *Store) Append(key, value string, maxLen int) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	str, _, err := s.str(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(str) > maxLen-len(value) {
+		return 0, errTooLong
+	}
+	s.setRaw(key, str+value)
+	return len(str) + len(value), nil
+}
+
+// MSet sets the keys and values alternating in pairs, dropping their expiries.
+func (s *Store) MSet(pairs []string) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for i := 0; i < len(pairs); i += 2 {
+		s.Data[pairs[i]] = pairs[i+1]
+		delete(s.Encodings, pairs[i])
+		delete(s.Expiries, pairs[i])
+	}
+}
+
+// MGet returns the strings stored at keys, and whether each was found.
+func (s *Store) MGet(keys []string) ([]string, []bool) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	values := make([]string, len(keys))
+	found := make([]bool, len(keys))
+	for i, key := range keys {
+		val, ok := s.lookup(key)
+		s.countLookup(ok)
+		values[i], found[i] = val.(string)
+	}
+	return values, found
+}
+
+// randomCount parses the count of HRANDFIELD and ZRANDMEMBER, which redis
+// keeps within half the range of a long either way, so that it can be negated
+// and doubled for the values.
+func randomCount(arg string) (int, error) {
+	count, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errNotInteger
+	}
+	if count < -math.MaxInt64/2 || count > math.MaxInt64/2 {
+		return 0, errOutOfRange
+	}
+	return int(count), nil
+}
+
+// randomKeys picks count of keys using rng, following the HRANDFIELD and
+// ZRANDMEMBER rules: a positive count returns distinct keys (at most all of
+// them), a negative count returns exactly -count keys that may repeat. keys
+// are sorted first so that a seeded rng gives a stable result.
+func randomKeys(rng *rand.Rand, keys []string, count int) []string {
+	sort.Strings(keys)
+	if count < 0 {
+		var picked []string
+		for ; count < 0; count++ {
+			picked = append(picked, keys[rng.Intn(len(keys))])
+		}
+		return picked
+	}
+	if count >= len(keys) {
+		return keys
+	}
+	rng.Shuffle(len(keys), func(i, j int) { keys[i], keys[j] = keys[j], keys[i] })
+	return keys[:count]
+}
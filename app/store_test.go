@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+func TestIncrOverflow(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	overflow := respError("ERR increment or decrement would overflow")
+	maxInt, minInt := strconv.FormatInt(math.MaxInt64, 10), strconv.FormatInt(math.MinInt64, 10)
+
+	c.expect(respStatus("OK"), "SET", "n", maxInt)
+	c.expect(overflow, "INCR", "n")
+	c.expect(overflow, "INCRBY", "n", "10")
+	c.expect(maxInt, "GET", "n")
+	c.expect(int64(math.MaxInt64-1), "DECR", "n")
+
+	c.expect(respStatus("OK"), "SET", "n", minInt)
+	c.expect(overflow, "DECR", "n")
+	c.expect(overflow, "INCRBY", "n", "-1")
+	c.expect(minInt, "GET", "n")
+	// Negating the decrement overflows too.
+	c.expect(respStatus("OK"), "SET", "n", "0")
+	c.expect(respError("ERR decrement would overflow"), "DECRBY", "n", minInt)
+	c.expect("0", "GET", "n")
+	c.expect(int64(math.MinInt64), "INCRBY", "n", minInt)
+}
+
+func TestIncrCanonicalIntegers(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	notInteger := respError("ERR value is not an integer or out of range")
+	for _, value := range []string{"+5", "007", " 5", "5 ", "-0", ""} {
+		c.expect(respStatus("OK"), "SET", "n", value)
+		c.expect(notInteger, "INCR", "n")
+		c.expect(value, "GET", "n")
+		c.expect(notInteger, "INCRBY", "other", value)
+	}
+	c.expect(int64(0), "EXISTS", "other")
+	c.expect(respStatus("OK"), "SET", "n", "-5")
+	c.expect(int64(5), "INCRBY", "n", "10")
+}
+
+func TestStringSizeLimit(t *testing.T) {
+	_, addr := startServer(t, "proto-max-bulk-len", "1mb")
+	c := dial(t, addr)
+	tooLong := respError("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	limit := 1 << 20
+	c.expect(int64(limit), "SETRANGE", "key", strconv.Itoa(limit-1), "x")
+	c.expect(tooLong, "SETRANGE", "key", strconv.Itoa(limit-1), "xy")
+	c.expect(tooLong, "SETRANGE", "other", strconv.Itoa(limit), "x")
+	c.expect(int64(0), "EXISTS", "other")
+	c.expect(tooLong, "APPEND", "key", "x")
+	c.expect(int64(limit), "APPEND", "key", "")
+	c.expect(respError("ERR bit offset is not an integer or out of range"), "SETBIT", "bits", strconv.Itoa(limit*8), "1")
+	c.expect(int64(0), "SETBIT", "bits", strconv.Itoa(limit*8-1), "1")
+
+	// A longer argument is refused from its length, before it is read.
+	if _, err := fmt.Fprintf(c.conn, "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$%d\r\n", limit+1); err != nil {
+		t.Fatal(err)
+	}
+	if reply := c.read(); reply != respError("ERR Protocol error: invalid bulk length") {
+		t.Fatalf("an argument past the limit: got %#v", reply)
+	}
+	if !c.closed() {
+		t.Fatal("the connection was not closed after the protocol error")
+	}
+}
+
+func TestSetOptions(t *testing.T) {
+	clk := newFakeClock()
+	_, addr := startServerWithClock(t, clk)
+	c := dial(t, addr)
+	c.expect(nil, "SET", "key", "v1", "XX")
+	c.expect(int64(0), "EXISTS", "key")
+	c.expect(respStatus("OK"), "SET", "key", "v1", "NX")
+	c.expect(nil, "SET", "key", "v2", "NX")
+	c.expect("v1", "GET", "key")
+	c.expect(respStatus("OK"), "SET", "key", "v2", "XX")
+
+	c.expect("v2", "SET", "key", "v3", "GET")
+	c.expect(nil, "SET", "missing", "v1", "GET")
+	c.expect("v1", "GET", "missing")
+	// GET replies with the old value even when NX keeps it.
+	c.expect("v3", "SET", "key", "v4", "NX", "GET")
+	c.expect("v3", "GET", "key")
+	c.expect(int64(1), "RPUSH", "list", "a")
+	c.expect(respError("WRONGTYPE Operation against a key holding the wrong kind of value"), "SET", "list", "v1", "GET")
+	c.expect(respStatus("OK"), "SET", "list", "v1")
+
+	c.expect(respStatus("OK"), "SET", "key", "v1", "EX", "100")
+	c.expect(respStatus("OK"), "SET", "key", "v2", "KEEPTTL")
+	c.expect(int64(100), "TTL", "key")
+	c.expect(respStatus("OK"), "SET", "key", "v3")
+	c.expect(int64(-1), "TTL", "key")
+
+	syntax := respError("ERR syntax error")
+	for _, options := range [][]string{
+		{"NX", "XX"},
+		{"KEEPTTL", "EX", "10"},
+		{"EX", "10", "PX", "10"},
+		{"EX"},
+		{"UNKNOWN"},
+	} {
+		c.expect(syntax, append([]string{"SET", "key", "v4"}, options...)...)
+	}
+	c.expect("v3", "GET", "key")
+}
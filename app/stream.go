@@ -0,0 +1,713 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errStreamID      = errors.New("ERR Invalid stream ID specified as stream command argument")
+	errStreamIDZero  = errors.New("ERR The ID specified in XADD must be greater than 0-0")
+	errStreamIDSmall = errors.New("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+)
+
+type streamID struct {
+	ms, seq uint64
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) less(other streamID) bool {
+	return id.ms < other.ms || (id.ms == other.ms && id.seq < other.seq)
+}
+
+// next is the smallest id greater than id.
+func (id streamID) next() streamID {
+	if id.seq == math.MaxUint64 {
+		return streamID{id.ms + 1, 0}
+	}
+	return streamID{id.ms, id.seq + 1}
+}
+
+// parseStreamID parses ms-seq, or a bare ms whose sequence is then defaultSeq.
+func parseStreamID(arg string, defaultSeq uint64) (streamID, error) {
+	msPart, seqPart, hasSeq := strings.Cut(arg, "-")
+	ms, err := strconv.ParseUint(msPart, 10, 64)
+	if err != nil {
+		return streamID{}, errStreamID
+	}
+	if !hasSeq {
+		return streamID{ms, defaultSeq}, nil
+	}
+	seq, err := strconv.ParseUint(seqPart, 10, 64)
+	if err != nil {
+		return streamID{}, errStreamID
+	}
+	return streamID{ms, seq}, nil
+}
+
+// parseRangeID parses an XRANGE bound: - and + for the smallest and largest
+// ids, an optional ( for an exclusive bound, and an incomplete id whose
+// sequence defaults to the start or the end of the millisecond.
+func parseRangeID(arg string, isStart bool) (streamID, error) {
+	switch arg {
+	case "-":
+		return streamID{}, nil
+	case "+":
+		return streamID{math.MaxUint64, math.MaxUint64}, nil
+	}
+	exclusive := strings.HasPrefix(arg, "(")
+	arg = strings.TrimPrefix(arg, "(")
+	defaultSeq := uint64(0)
+	if !isStart {
+		defaultSeq = math.MaxUint64
+	}
+	id, err := parseStreamID(arg, defaultSeq)
+	if err != nil || !exclusive {
+		return id, err
+	}
+	if isStart {
+		if id == (streamID{math.MaxUint64, math.MaxUint64}) {
+			return id, errors.New("ERR invalid start ID for the interval")
+		}
+		return id.next(), nil
+	}
+	if id == (streamID{}) {
+		return id, errors.New("ERR invalid end ID for the interval")
+	}
+	if id.seq == 0 {
+		return streamID{id.ms - 1, math.MaxUint64}, nil
+	}
+	return streamID{id.ms, id.seq - 1}, nil
+}
+
+type streamEntry struct {
+	id     streamID
+	fields []string
+}
+
+// stream is the value of a stream key. Entries are kept sorted by id.
+type stream struct {
+	entries      []streamEntry
+	lastID       streamID
+	maxDeletedID streamID
+	entriesAdded uint64
+	groups       map[string]*consumerGroup
+}
+
+// search returns the index of the first entry whose id is at least id.
+func (st *stream) search(id streamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].id.less(id)
+	})
+}
+
+func (st *stream) rangeEntries(start, end streamID, count int) []streamEntry {
+	var entries []streamEntry
+	for i := st.search(start); i < len(st.entries) && !end.less(st.entries[i].id); i++ {
+		if count > 0 && len(entries) == count {
+			break
+		}
+		entries = append(entries, st.entries[i])
+	}
+	return entries
+}
+
+// trimOptions are the MAXLEN and MINID arguments shared by XADD and XTRIM.
+type trimOptions struct {
+	strategy string // "", "maxlen" or "minid"
+	maxLen   int
+	minID    streamID
+}
+
+// trim removes entries according to opts and returns how many were removed.
+// Approximate trimming (~) is treated as exact.
+func (st *stream) trim(opts trimOptions) int {
+	var cut int
+	switch opts.strategy {
+	case "maxlen":
+		cut = max(len(st.entries)-opts.maxLen, 0)
+	case "minid":
+		cut = st.search(opts.minID)
+	default:
+		return 0
+	}
+	st.entries = st.entries[cut:]
+	return cut
+}
+
+// parseTrimOptions parses MAXLEN|MINID [=|~] threshold [LIMIT count] at the
+// start of args, returning how many arguments it consumed.
+func parseTrimOptions(args []string) (trimOptions, int, error) {
+	var opts trimOptions
+	if len(args) == 0 {
+		return opts, 0, nil
+	}
+	strategy := strings.ToLower(args[0])
+	if strategy != "maxlen" && strategy != "minid" {
+		return opts, 0, nil
+	}
+	opts.strategy = strategy
+	i := 1
+	approximate := false
+	if i < len(args) && (args[i] == "=" || args[i] == "~") {
+		approximate = args[i] == "~"
+		i++
+	}
+	if i >= len(args) {
+		return opts, 0, errSyntax
+	}
+	if strategy == "maxlen" {
+		maxLen, err := strconv.Atoi(args[i])
+		if err != nil {
+			return opts, 0, errNotInteger
+		}
+		if maxLen < 0 {
+			return opts, 0, errors.New("ERR The MAXLEN argument must be >= 0.")
+		}
+		opts.maxLen = maxLen
+	} else {
+		minID, err := parseStreamID(args[i], 0)
+		if err != nil {
+			return opts, 0, err
+		}
+		opts.minID = minID
+	}
+	i++
+	if i+1 < len(args) && strings.EqualFold(args[i], "limit") {
+		if !approximate {
+			return opts, 0, errors.New("ERR syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		if _, err := strconv.Atoi(args[i+1]); err != nil {
+			return opts, 0, errNotInteger
+		}
+		i += 2
+	}
+	return opts, i, nil
+}
+
+// stream returns the stream stored at key, creating an empty one if create is
+// set and the key does not exist. The caller must hold the write lock.
+func (s *Store) stream(key string, create bool) (*stream, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		st := &stream{}
+		s.Data[key] = st
+		delete(s.Encodings, key)
+		return st, nil
+	}
+	st, ok := val.(*stream)
+	if !ok {
+		return nil, errWrongType
+	}
+	return st, nil
+}
+
+// XAdd appends an entry to the stream at key and returns its id. idArg is *,
+// ms-* or an explicit id; generated ids use the current time. Unless
+// noMkStream is set the stream is created if needed; otherwise ok is false
+// when it does not exist.
+func (s *Store) XAdd(key, idArg string, fields []string, noMkStream bool, trim trimOptions) (id streamID, ok bool, err error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil {
+		return id, false, err
+	}
+	if st == nil && noMkStream {
+		return id, false, nil
+	}
+	last := streamID{}
+	if st != nil {
+		last = st.lastID
+	}
+
+	switch {
+	case idArg == "*":
+		id = streamID{uint64(s.Clock.Now().UnixMilli()), 0}
+		if !last.less(id) {
+			id = last.next()
+		}
+	case strings.HasSuffix(idArg, "-*"):
+		ms, err := strconv.ParseUint(strings.TrimSuffix(idArg, "-*"), 10, 64)
+		if err != nil {
+			return id, false, errStreamID
+		}
+		id = streamID{ms, 0}
+		if ms == last.ms {
+			id = last.next()
+		} else if ms == 0 {
+			id.seq = 1
+		}
+	default:
+		if id, err = parseStreamID(idArg, 0); err != nil {
+			return id, false, err
+		}
+	}
+	if id == (streamID{}) {
+		return id, false, errStreamIDZero
+	}
+	if !last.less(id) {
+		return id, false, errStreamIDSmall
+	}
+
+	if st == nil {
+		st, _ = s.stream(key, true)
+	}
+	st.entries = append(st.entries, streamEntry{id, append([]string(nil), fields...)})
+	st.lastID = id
+	st.entriesAdded++
+	st.trim(trim)
+	return id, true, nil
+}
+
+// XRange returns up to count entries (all when count is 0) with ids between
+// start and end inclusive.
+func (s *Store) XRange(key string, start, end streamID, count int) ([]streamEntry, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil {
+		return nil, err
+	}
+	s.countLookup(st != nil)
+	if st == nil {
+		return nil, nil
+	}
+	return st.rangeEntries(start, end, count), nil
+}
+
+// XRead returns up to count entries (all when count is 0) of each stream at
+// keys with ids greater than the matching one of after, leaving out the
+// streams that have none.
+func (s *Store) XRead(keys []string, after []streamID, count int) ([]streamRead, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	var reads []streamRead
+	for i, key := range keys {
+		st, err := s.stream(key, false)
+		if err != nil {
+			return nil, err
+		}
+		s.countLookup(st != nil)
+		if st == nil || !after[i].less(st.lastID) {
+			continue
+		}
+		if entries := st.rangeEntries(after[i].next(), st.lastID, count); len(entries) > 0 {
+			reads = append(reads, streamRead{key, entries})
+		}
+	}
+	return reads, nil
+}
+
+// XLastID returns the id of the last entry added to the stream at key, 0-0
+// if there is none.
+func (s *Store) XLastID(key string) (streamID, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil || st == nil {
+		return streamID{}, err
+	}
+	return st.lastID, nil
+}
+
+func (s *Store) XLen(key string) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil {
+		return 0, err
+	}
+	s.countLookup(st != nil)
+	if st == nil {
+		return 0, nil
+	}
+	return len(st.entries), nil
+}
+
+// XDel deletes the entries with the given ids and returns how many existed.
+func (s *Store) XDel(key string, ids []streamID) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	deleted := 0
+	for _, id := range ids {
+		i := st.search(id)
+		if i == len(st.entries) || st.entries[i].id != id {
+			continue
+		}
+		st.entries = append(st.entries[:i], st.entries[i+1:]...)
+		if st.maxDeletedID.less(id) {
+			st.maxDeletedID = id
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+// XTrim trims the stream at key and returns how many entries were removed.
+func (s *Store) XTrim(key string, opts trimOptions) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	return st.trim(opts), nil
+}
+
+// streamInfo is what XINFO STREAM reports.
+type streamInfo struct {
+	length       int
+	lastID       streamID
+	maxDeletedID streamID
+	entriesAdded uint64
+	groups       int
+	first, last  *streamEntry
+}
+
+func (s *Store) XInfoStream(key string) (*streamInfo, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	st, err := s.stream(key, false)
+	if err != nil || st == nil {
+		return nil, err
+	}
+	info := &streamInfo{
+		length:       len(st.entries),
+		lastID:       st.lastID,
+		maxDeletedID: st.maxDeletedID,
+		entriesAdded: st.entriesAdded,
+		groups:       len(st.groups),
+	}
+	if len(st.entries) > 0 {
+		first, last := st.entries[0], st.entries[len(st.entries)-1]
+		info.first, info.last = &first, &last
+	}
+	return info, nil
+}
+
+// entryReply is the reply for an entry: its id and fields. An entry without
+// fields is one that was deleted while pending, and gets a null field list.
+func entryReply(entry streamEntry) any {
+	if entry.fields == nil {
+		return []any{entry.id.String(), nullArray}
+	}
+	return []any{entry.id.String(), entry.fields}
+}
+
+func entriesReply(entries []streamEntry) any {
+	reply := make([]any, len(entries))
+	for i, entry := range entries {
+		reply[i] = entryReply(entry)
+	}
+	return reply
+}
+
+// xaddCommand implements
+// XADD key [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]] *|id field value [field value ...].
+func (s *server) xaddCommand(c *clientConn, commands []string) {
+	if len(commands) < 5 {
+		c.replyWrongArgs("xadd")
+		return
+	}
+	i := 2
+	noMkStream := false
+	if strings.EqualFold(commands[i], "nomkstream") {
+		noMkStream = true
+		i++
+	}
+	trim, n, err := parseTrimOptions(commands[i:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	i += n
+	if i >= len(commands) || (len(commands)-i-1) < 2 || (len(commands)-i-1)%2 != 0 {
+		c.replyWrongArgs("xadd")
+		return
+	}
+	id, ok, err := s.db(c).XAdd(commands[1], commands[i], commands[i+1:], noMkStream, trim)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !ok {
+		c.replyNull()
+		return
+	}
+	// Replicas and the AOF must store the same id, so a generated one is
+	// propagated explicitly.
+	propagated := append([]string(nil), commands...)
+	propagated[i] = id.String()
+	s.propagate(c.db, propagated)
+	c.replyBulk(id.String())
+}
+
+// xrangeCommand implements XRANGE key start end [COUNT count].
+func (s *server) xrangeCommand(c *clientConn, commands []string) {
+	if len(commands) != 4 && len(commands) != 6 {
+		c.replyWrongArgs("xrange")
+		return
+	}
+	start, err := parseRangeID(commands[2], true)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	end, err := parseRangeID(commands[3], false)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	count := 0
+	if len(commands) == 6 {
+		if !strings.EqualFold(commands[4], "count") {
+			c.replyError(errSyntax)
+			return
+		}
+		if count, err = strconv.Atoi(commands[5]); err != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+		if count <= 0 {
+			c.replyStrings(nil)
+			return
+		}
+	}
+	entries, err := s.db(c).XRange(commands[1], start, end, count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyValue(entriesReply(entries))
+}
+
+// xreadOptions are the arguments of XREAD and XREADGROUP after the group and
+// the consumer: COUNT, BLOCK, whose timeout is -1 without it, and NOACK for
+// XREADGROUP, then the keys and the ids after STREAMS. blockAt is the index
+// of BLOCK in the command, 0 without it.
+type xreadOptions struct {
+	count     int
+	timeout   time.Duration
+	blockAt   int
+	noAck     bool
+	keys, ids []string
+}
+
+// parseXRead parses the options of XREAD, or of XREADGROUP if group is set,
+// from commands[start:].
+func parseXRead(commands []string, start int, group bool) (xreadOptions, error) {
+	opts := xreadOptions{timeout: -1}
+	i := start
+	for ; i < len(commands) && !strings.EqualFold(commands[i], "streams"); i++ {
+		switch option := strings.ToLower(commands[i]); {
+		case option == "count" && i+1 < len(commands):
+			n, err := strconv.Atoi(commands[i+1])
+			if err != nil {
+				return opts, errNotInteger
+			}
+			opts.count = max(n, 0)
+			i++
+		case option == "block" && i+1 < len(commands):
+			ms, err := strconv.ParseInt(commands[i+1], 10, 64)
+			if err != nil {
+				return opts, errors.New("ERR timeout is not an integer or out of range")
+			}
+			if ms < 0 {
+				return opts, errTimeoutNegative
+			}
+			opts.timeout, opts.blockAt = time.Duration(ms)*time.Millisecond, i
+			i++
+		case option == "noack" && group:
+			opts.noAck = true
+		default:
+			return opts, errSyntax
+		}
+	}
+	args := commands[min(i+1, len(commands)):]
+	if i == len(commands) || len(args) == 0 || len(args)%2 != 0 {
+		if group {
+			return opts, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '>' must be specified.", strings.ToLower(commands[0]))
+		}
+		return opts, fmt.Errorf("ERR Unbalanced '%s' list of streams: for each stream key an ID or '$' must be specified.", strings.ToLower(commands[0]))
+	}
+	opts.keys, opts.ids = args[:len(args)/2], args[len(args)/2:]
+	return opts, nil
+}
+
+// readsReply is the reply of XREAD and XREADGROUP with the entries read
+// from each stream.
+func readsReply(reads []streamRead) any {
+	reply := make([]any, len(reads))
+	for i, read := range reads {
+		reply[i] = []any{read.key, entriesReply(read.entries)}
+	}
+	return reply
+}
+
+// xreadCommand implements XREAD [COUNT count] [BLOCK milliseconds] STREAMS
+// key [key ...] id [id ...], which replies with the entries of the streams
+// after the ids. An id of $ stands for the last entry of the stream when
+// XREAD is called, so that only the entries added since are read. With
+// BLOCK, a read that finds no entries waits for some to be added, for up to
+// the timeout unless it is 0.
+func (s *server) xreadCommand(c *clientConn, commands []string) {
+	opts, err := parseXRead(commands, 1, false)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	after := make([]streamID, len(opts.ids))
+	for i, id := range opts.ids {
+		if id == "$" {
+			continue
+		}
+		if after[i], err = parseStreamID(id, 0); err != nil {
+			c.replyError(err)
+			return
+		}
+	}
+	resolved := false
+	s.block(c, opts.timeout, func(db *Store) (any, []string, error) {
+		// $ is resolved on the first try, before waiting.
+		for i, id := range opts.ids {
+			if id == "$" && !resolved {
+				if after[i], err = db.XLastID(opts.keys[i]); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+		resolved = true
+		reads, err := db.XRead(opts.keys, after, opts.count)
+		if err != nil || len(reads) == 0 {
+			return nil, nil, err
+		}
+		return readsReply(reads), nil, nil
+	})
+}
+
+func (s *server) xlenCommand(c *clientConn, commands []string) {
+	if len(commands) != 2 {
+		c.replyWrongArgs("xlen")
+		return
+	}
+	length, err := s.db(c).XLen(commands[1])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(length)
+}
+
+// xdelCommand implements XDEL key id [id ...].
+func (s *server) xdelCommand(c *clientConn, commands []string) {
+	if len(commands) < 3 {
+		c.replyWrongArgs("xdel")
+		return
+	}
+	ids := make([]streamID, len(commands)-2)
+	for i, arg := range commands[2:] {
+		id, err := parseStreamID(arg, 0)
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		ids[i] = id
+	}
+	deleted, err := s.db(c).XDel(commands[1], ids)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if deleted > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(deleted)
+}
+
+// xtrimCommand implements XTRIM key MAXLEN|MINID [=|~] threshold [LIMIT count].
+func (s *server) xtrimCommand(c *clientConn, commands []string) {
+	if len(commands) < 4 {
+		c.replyWrongArgs("xtrim")
+		return
+	}
+	opts, n, err := parseTrimOptions(commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if opts.strategy == "" || 2+n != len(commands) {
+		c.replyError(errSyntax)
+		return
+	}
+	removed, err := s.db(c).XTrim(commands[1], opts)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if removed > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(removed)
+}
+
+// xinfoCommand implements XINFO STREAM key.
+func (s *server) xinfoCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 {
+		c.replyWrongArgs("xinfo")
+		return
+	}
+	switch strings.ToLower(commands[1]) {
+	case "stream":
+		if len(commands) != 3 {
+			c.replyWrongArgs("xinfo|stream")
+			return
+		}
+		info, err := s.db(c).XInfoStream(commands[2])
+		if err != nil {
+			c.replyError(err)
+			return
+		}
+		if info == nil {
+			c.replyErrorMsg("no such key")
+			return
+		}
+		entryValue := func(entry *streamEntry) any {
+			if entry == nil {
+				return nil
+			}
+			return []any{entry.id.String(), entry.fields}
+		}
+		recordedFirst := streamID{}
+		if info.first != nil {
+			recordedFirst = info.first.id
+		}
+		c.replyValue(replyMap{
+			{"length", info.length},
+			{"last-generated-id", info.lastID.String()},
+			{"max-deleted-entry-id", info.maxDeletedID.String()},
+			{"entries-added", int64(info.entriesAdded)},
+			{"recorded-first-entry-id", recordedFirst.String()},
+			{"groups", info.groups},
+			{"first-entry", entryValue(info.first)},
+			{"last-entry", entryValue(info.last)},
+		})
+	default:
+		c.replyErrorMsg(fmt.Sprintf("unknown subcommand '%s'. Try XINFO HELP.", commands[1]))
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestXTrimMaxLen(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	for _, id := range []string{"1-1", "2-1", "3-1", "4-1", "5-1"} {
+		c.expect(id, "XADD", "stream", id, "field", "value")
+	}
+	c.expect(int64(3), "XTRIM", "stream", "MAXLEN", "2")
+	c.expect(int64(2), "XLEN", "stream")
+	c.expect(int64(0), "XTRIM", "stream", "MAXLEN", "~", "2")
+	entries, _ := c.do("XRANGE", "stream", "-", "+").([]any)
+	if len(entries) != 2 || entries[0].([]any)[0] != "4-1" {
+		t.Fatalf("XRANGE after XTRIM: got %#v, want 4-1 and 5-1", entries)
+	}
+	c.expect(int64(1), "XTRIM", "stream", "MINID", "5-0")
+	c.expect(int64(1), "XLEN", "stream")
+}
+
+func TestXDel(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	for _, id := range []string{"1-1", "2-1", "3-1"} {
+		c.expect(id, "XADD", "stream", id, "field", "value")
+	}
+	c.expect(int64(1), "XDEL", "stream", "2-1", "9-9")
+	c.expect(int64(2), "XLEN", "stream")
+	c.expect([]any{
+		[]any{"1-1", []any{"field", "value"}},
+		[]any{"3-1", []any{"field", "value"}},
+	}, "XRANGE", "stream", "-", "+")
+	c.expect(int64(0), "XDEL", "stream", "2-1")
+}
+
+func TestXInfoStream(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect("1-1", "XADD", "stream", "1-1", "a", "1")
+	c.expect("2-1", "XADD", "stream", "2-1", "b", "2")
+	c.expect(int64(1), "XDEL", "stream", "2-1")
+	reply, _ := c.do("XINFO", "STREAM", "stream").([]any)
+	info := map[string]any{}
+	for i := 0; i+1 < len(reply); i += 2 {
+		info[reply[i].(string)] = reply[i+1]
+	}
+	if info["length"] != int64(1) || info["last-generated-id"] != "2-1" {
+		t.Fatalf("XINFO STREAM: got %#v", reply)
+	}
+	first, _ := info["first-entry"].([]any)
+	last, _ := info["last-entry"].([]any)
+	if len(first) == 0 || first[0] != "1-1" || len(last) == 0 || last[0] != "1-1" {
+		t.Fatalf("XINFO STREAM entries: got %#v and %#v", info["first-entry"], info["last-entry"])
+	}
+}
+
+func TestConsumerGroup(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "XGROUP", "CREATE", "stream", "group", "$", "MKSTREAM")
+	c.expect(respError("BUSYGROUP Consumer Group name already exists"), "XGROUP", "CREATE", "stream", "group", "$")
+	c.expect("1-1", "XADD", "stream", "1-1", "field", "a")
+	c.expect("2-1", "XADD", "stream", "2-1", "field", "b")
+
+	c.expect([]any{[]any{"stream", []any{
+		[]any{"1-1", []any{"field", "a"}},
+		[]any{"2-1", []any{"field", "b"}},
+	}}}, "XREADGROUP", "GROUP", "group", "alice", "STREAMS", "stream", ">")
+	// The entries are delivered once, and then pending.
+	c.expect(nil, "XREADGROUP", "GROUP", "group", "bob", "STREAMS", "stream", ">")
+	c.expect([]any{int64(2), "1-1", "2-1", []any{[]any{"alice", "2"}}}, "XPENDING", "stream", "group")
+
+	c.expect(int64(1), "XACK", "stream", "group", "1-1", "1-1")
+	c.expect([]any{int64(1), "2-1", "2-1", []any{[]any{"alice", "1"}}}, "XPENDING", "stream", "group")
+	// Reading from 0 returns the entries still pending for the consumer.
+	c.expect([]any{[]any{"stream", []any{
+		[]any{"2-1", []any{"field", "b"}},
+	}}}, "XREADGROUP", "GROUP", "group", "alice", "STREAMS", "stream", "0")
+	c.expect(int64(1), "XACK", "stream", "group", "2-1")
+	c.expect([]any{int64(0), nil, nil, nil}, "XPENDING", "stream", "group")
+}
+
+func TestXClaim(t *testing.T) {
+	clk := newFakeClock()
+	_, addr := startServerWithClock(t, clk)
+	c := dial(t, addr)
+	c.expect(respStatus("OK"), "XGROUP", "CREATE", "stream", "group", "$", "MKSTREAM")
+	c.expect("1-1", "XADD", "stream", "1-1", "field", "a")
+	c.expect("2-1", "XADD", "stream", "2-1", "field", "b")
+	c.do("XREADGROUP", "GROUP", "group", "alice", "STREAMS", "stream", ">")
+
+	clk.Advance(3 * time.Second)
+	c.expect([]any{}, "XCLAIM", "stream", "group", "bob", "5000", "1-1")
+	clk.Advance(3 * time.Second)
+	c.expect([]any{[]any{"1-1", []any{"field", "a"}}}, "XCLAIM", "stream", "group", "bob", "5000", "1-1")
+	c.expect([]any{int64(2), "1-1", "2-1", []any{[]any{"alice", "1"}, []any{"bob", "1"}}}, "XPENDING", "stream", "group")
+
+	// Claiming resets the idle time, so only 2-1 is stale for XAUTOCLAIM.
+	c.expect([]any{"0-0", []any{[]any{"2-1", []any{"field", "b"}}}, []any{}},
+		"XAUTOCLAIM", "stream", "group", "carol", "5000", "0", "COUNT", "10")
+	c.expect([]any{int64(2), "1-1", "2-1", []any{[]any{"bob", "1"}, []any{"carol", "1"}}}, "XPENDING", "stream", "group")
+}
+
+func TestXRead(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect("1-1", "XADD", "stream", "1-1", "field", "a")
+	c.expect("2-1", "XADD", "stream", "2-1", "field", "b")
+	c.expect("1-1", "XADD", "other", "1-1", "field", "c")
+
+	c.expect([]any{
+		[]any{"stream", []any{[]any{"2-1", []any{"field", "b"}}}},
+		[]any{"other", []any{[]any{"1-1", []any{"field", "c"}}}},
+	}, "XREAD", "STREAMS", "stream", "other", "1-1", "0")
+	c.expect([]any{[]any{"stream", []any{[]any{"1-1", []any{"field", "a"}}}}},
+		"XREAD", "COUNT", "1", "STREAMS", "stream", "missing", "0", "0")
+	// $ reads only the entries added after XREAD.
+	c.expect(nil, "XREAD", "STREAMS", "stream", "$")
+	c.expect(nil, "XREAD", "STREAMS", "missing", "0")
+
+	c.expect(respStatus("OK"), "SET", "string", "value")
+	c.expect(respError("WRONGTYPE Operation against a key holding the wrong kind of value"), "XREAD", "STREAMS", "string", "0")
+	c.expect(respError("ERR Unbalanced 'xread' list of streams: for each stream key an ID or '$' must be specified."), "XREAD", "STREAMS", "stream", "other", "0")
+	c.expect(respError("ERR timeout is negative"), "XREAD", "BLOCK", "-1", "STREAMS", "stream", "0")
+	c.expect(respError("ERR syntax error"), "XREAD", "NOACK", "STREAMS", "stream", "0")
+}
+
+func TestXReadBlock(t *testing.T) {
+	s, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	other.expect("1-1", "XADD", "stream", "1-1", "field", "a")
+	c.send("XREAD", "BLOCK", "0", "STREAMS", "stream", "$")
+	blockedOn(t, s, 1)
+	other.expect("2-1", "XADD", "stream", "2-1", "field", "b")
+	if reply := c.read(); !reflect.DeepEqual(reply, []any{[]any{"stream", []any{[]any{"2-1", []any{"field", "b"}}}}}) {
+		t.Fatalf("XREAD BLOCK: got %#v", reply)
+	}
+
+	start := time.Now()
+	c.expect(nil, "XREAD", "BLOCK", "100", "STREAMS", "stream", "$")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("XREAD BLOCK 100 replied after %v, before its timeout", elapsed)
+	}
+	// Inside a transaction, XREAD does not wait.
+	c.expect(respStatus("OK"), "MULTI")
+	c.expect(respStatus("QUEUED"), "XREAD", "BLOCK", "0", "STREAMS", "stream", "$")
+	c.expect([]any{nil}, "EXEC")
+}
+
+func TestXReadGroupBlock(t *testing.T) {
+	s, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	r := attachReplica(t, addr)
+	other.expect(respStatus("OK"), "XGROUP", "CREATE", "stream", "group", "$", "MKSTREAM")
+	r.expectNext("XGROUP", "CREATE", "stream", "group", "$", "MKSTREAM")
+	c.send("XREADGROUP", "GROUP", "group", "alice", "BLOCK", "0", "STREAMS", "stream", ">")
+	blockedOn(t, s, 1)
+	other.expect("1-1", "XADD", "stream", "1-1", "field", "a")
+	if reply := c.read(); !reflect.DeepEqual(reply, []any{[]any{"stream", []any{[]any{"1-1", []any{"field", "a"}}}}}) {
+		t.Fatalf("XREADGROUP BLOCK: got %#v", reply)
+	}
+	r.expectNext("XADD", "stream", "1-1", "field", "a")
+	// The replicas get the read without BLOCK.
+	r.expectNext("XREADGROUP", "GROUP", "group", "alice", "STREAMS", "stream", ">")
+
+	// The history of the consumer is read without waiting.
+	c.expect([]any{[]any{"stream", []any{[]any{"1-1", []any{"field", "a"}}}}},
+		"XREADGROUP", "GROUP", "group", "alice", "BLOCK", "0", "STREAMS", "stream", "0")
+	c.expect(respError("ERR timeout is not an integer or out of range"),
+		"XREADGROUP", "GROUP", "group", "alice", "BLOCK", "soon", "STREAMS", "stream", ">")
+}
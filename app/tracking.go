@@ -0,0 +1,115 @@
+package main
+
+import (
+	"strings"
+)
+
+// trackingCommand implements CLIENT TRACKING ON|OFF [BCAST] [PREFIX prefix
+// ...]. Invalidations are pushes, so they reach RESP3 clients only.
+func (s *server) trackingCommand(c *clientConn, args []string) {
+	if len(args) == 0 {
+		c.replyWrongArgs("client|tracking")
+		return
+	}
+	var on, bcast bool
+	switch strings.ToLower(args[0]) {
+	case "on":
+		on = true
+	case "off":
+	default:
+		c.replyError(errSyntax)
+		return
+	}
+	var prefixes []string
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "bcast":
+			bcast = true
+		case "prefix":
+			if i+1 == len(args) {
+				c.replyError(errSyntax)
+				return
+			}
+			i++
+			prefixes = append(prefixes, args[i])
+		default:
+			c.replyError(errSyntax)
+			return
+		}
+	}
+	if len(prefixes) > 0 && !bcast {
+		c.replyErrorMsg("PREFIX option requires BCAST mode to be enabled")
+		return
+	}
+	if bcast && len(prefixes) == 0 {
+		prefixes = []string{""}
+	}
+
+	s.trackingMu.Lock()
+	s.untrack(c)
+	if on {
+		c.tracking = true
+		if bcast {
+			c.prefixes = prefixes
+			s.broadcasts[c] = struct{}{}
+		}
+	}
+	s.trackingMu.Unlock()
+	c.replyOK()
+}
+
+// untrack forgets the keys c read and turns its tracking off. The caller
+// must hold trackingMu.
+func (s *server) untrack(c *clientConn) {
+	for key := range c.trackedKeys {
+		s.trackedKeys.remove(key, c)
+	}
+	delete(s.broadcasts, c)
+	c.tracking, c.prefixes, c.trackedKeys = false, nil, nil
+}
+
+// trackRead remembers the keys of a read command for c, unless it tracks
+// prefixes instead.
+func (s *server) trackRead(c *clientConn, commands []string) {
+	s.trackingMu.Lock()
+	defer s.trackingMu.Unlock()
+	if !c.tracking || c.prefixes != nil {
+		return
+	}
+	for _, key := range commandKeys(commands) {
+		if c.trackedKeys == nil {
+			c.trackedKeys = map[string]struct{}{}
+		}
+		c.trackedKeys[key] = struct{}{}
+		s.trackedKeys.add(key, c)
+	}
+}
+
+// invalidate pushes an invalidation of the keys a write command changed to
+// the clients that read them since their last invalidation, and to those
+// tracking a matching prefix.
+func (s *server) invalidate(commands []string) {
+	s.trackingMu.Lock()
+	defer s.trackingMu.Unlock()
+	for _, key := range commandKeys(commands) {
+		for reader := range s.trackedKeys[key] {
+			delete(reader.trackedKeys, key)
+			reader.replyInvalidate(key)
+		}
+		delete(s.trackedKeys, key)
+		for watcher := range s.broadcasts {
+			for _, prefix := range watcher.prefixes {
+				if strings.HasPrefix(key, prefix) {
+					watcher.replyInvalidate(key)
+					break
+				}
+			}
+		}
+	}
+}
+
+func (c *clientConn) replyInvalidate(key string) {
+	if c.resp3() {
+		c.replyPush("invalidate", []string{key})
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTrackingInvalidation(t *testing.T) {
+	_, addr := startServer(t)
+	a, b := dial(t, addr), dial(t, addr)
+	a.hello3()
+	a.send("CLIENT", "TRACKING", "ON")
+	if reply := a.readRaw(); reply != "+OK\r\n" {
+		t.Fatalf("CLIENT TRACKING ON: got %q", reply)
+	}
+	a.send("GET", "k")
+	a.readRaw()
+	b.expect(respStatus("OK"), "SET", "k", "v")
+	want := ">2\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n"
+	if reply := a.readRaw(); reply != want {
+		t.Fatalf("after a write of k: got %q, want %q", reply, want)
+	}
+	// The key is tracked again only once read again.
+	b.expect(respStatus("OK"), "SET", "k", "w")
+	if reply := a.readRaw(); reply != "" {
+		t.Fatalf("after a second write of k: got %q, want nothing", reply)
+	}
+}
+
+func TestTrackingBroadcast(t *testing.T) {
+	_, addr := startServer(t)
+	a, b := dial(t, addr), dial(t, addr)
+	a.hello3()
+	a.send("CLIENT", "TRACKING", "ON", "BCAST", "PREFIX", "user:")
+	a.readRaw()
+	b.expect(respStatus("OK"), "SET", "other", "v")
+	b.expect(respStatus("OK"), "SET", "user:1", "v")
+	want := ">2\r\n$10\r\ninvalidate\r\n*1\r\n$6\r\nuser:1\r\n"
+	if reply := a.readRaw(); reply != want {
+		t.Fatalf("after writes under BCAST: got %q, want %q", reply, want)
+	}
+}
@@ -0,0 +1,912 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// sortedSet is the value of a sorted set key: members and their scores, and
+// the members ordered by score in a skiplist, which the ranges, ranks and
+// pops go through.
+type sortedSet struct {
+	scores map[string]float64
+	zsl    *skiplist
+}
+
+func newSortedSet() *sortedSet {
+	return &sortedSet{scores: make(map[string]float64), zsl: newSkiplist()}
+}
+
+// sortedSetFrom returns the sorted set of the members and scores given,
+// which it keeps.
+func sortedSetFrom(scores map[string]float64) *sortedSet {
+	z := &sortedSet{scores: scores, zsl: newSkiplist()}
+	for member, score := range scores {
+		z.zsl.insert(member, score)
+	}
+	return z
+}
+
+type zmember struct {
+	member string
+	score  float64
+}
+
+// set gives member score, adding it if it is new.
+func (z *sortedSet) set(member string, score float64) {
+	if current, exists := z.scores[member]; exists {
+		if current == score {
+			return
+		}
+		z.zsl.remove(member, current)
+	}
+	z.scores[member] = score
+	z.zsl.insert(member, score)
+}
+
+// remove deletes member and reports whether it was there.
+func (z *sortedSet) remove(member string) bool {
+	score, exists := z.scores[member]
+	if !exists {
+		return false
+	}
+	delete(z.scores, member)
+	z.zsl.remove(member, score)
+	return true
+}
+
+// sorted returns the members ordered by score, and by member for equal scores.
+func (z *sortedSet) sorted() []zmember {
+	members := make([]zmember, 0, z.zsl.length)
+	for n := z.zsl.first(); n != nil; n = n.levels[0].forward {
+		members = append(members, n.zmember)
+	}
+	return members
+}
+
+// zset returns the sorted set stored at key, creating an empty one if create
+// is set and the key does not exist. The caller must hold the write lock.
+func (s *Store) zset(key string, create bool) (*sortedSet, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		z := newSortedSet()
+		s.Data[key] = z
+		delete(s.Encodings, key)
+		return z, nil
+	}
+	z, ok := val.(*sortedSet)
+	if !ok {
+		return nil, errWrongType
+	}
+	return z, nil
+}
+
+var errScoreNaN = errors.New("ERR resulting score is not a number (NaN)")
+
+// zaddOptions are the NX, XX, GT, LT and CH options of ZADD.
+type zaddOptions struct {
+	nx, xx, gt, lt, ch bool
+}
+
+// allows reports whether the options let a member get score, given its
+// current score if it exists.
+func (o zaddOptions) allows(score, current float64, exists bool) bool {
+	if !exists {
+		return !o.xx
+	}
+	return !o.nx && !(o.gt && score <= current) && !(o.lt && score >= current)
+}
+
+// ZAdd sets the scores of the given members as far as opts allow, and returns
+// how many are new and how many already existed but got another score.
+func (s *Store) ZAdd(key string, scores []float64, members []string, opts zaddOptions) (added, changed int, err error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, !opts.xx)
+	if err != nil || z == nil {
+		return 0, 0, err
+	}
+	for i, member := range members {
+		current, exists := z.scores[member]
+		if !opts.allows(scores[i], current, exists) {
+			continue
+		}
+		if !exists {
+			added++
+		} else if scores[i] != current {
+			changed++
+		}
+		z.set(member, scores[i])
+	}
+	return added, changed, nil
+}
+
+// ZIncr adds delta to the score of member, a new member starting from 0, as
+// far as opts allow. It returns the new score, and whether opts allowed it.
+func (s *Store) ZIncr(key, member string, delta float64, opts zaddOptions) (float64, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, !opts.xx)
+	if err != nil || z == nil {
+		return 0, false, err
+	}
+	current, exists := z.scores[member]
+	if exists && opts.nx {
+		return 0, false, nil
+	}
+	score := current + delta
+	if math.IsNaN(score) {
+		return 0, false, errScoreNaN
+	}
+	if !opts.allows(score, current, exists) {
+		return 0, false, nil
+	}
+	z.set(member, score)
+	return score, true, nil
+}
+
+// ZRem removes members from the sorted set at key and returns how many were
+// there. A sorted set left empty is deleted.
+func (s *Store) ZRem(key string, members []string) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, false)
+	if err != nil || z == nil {
+		return 0, err
+	}
+	removed := 0
+	for _, member := range members {
+		if z.remove(member) {
+			removed++
+		}
+	}
+	if len(z.scores) == 0 {
+		s.remove(key)
+	}
+	return removed, nil
+}
+
+func (s *Store) ZScore(key, member string) (float64, bool, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, false)
+	if err != nil {
+		return 0, false, err
+	}
+	s.countLookup(z != nil)
+	if z == nil {
+		return 0, false, nil
+	}
+	score, ok := z.scores[member]
+	return score, ok, nil
+}
+
+// ZRandMember picks random members of the sorted set at key as described by
+// randomKeys, returning them together with their scores.
+func (s *Store) ZRandMember(key string, count int) ([]string, []float64, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, false)
+	if err != nil || z == nil || len(z.scores) == 0 || count == 0 {
+		return nil, nil, err
+	}
+	members := make([]string, 0, len(z.scores))
+	for member := range z.scores {
+		members = append(members, member)
+	}
+	members = randomKeys(s.Rand, members, count)
+	scores := make([]float64, len(members))
+	for i, member := range members {
+		scores[i] = z.scores[member]
+	}
+	return members, scores, nil
+}
+
+var errLexRange = errors.New("ERR min or max not valid string range item")
+
+// lexBound is an end of a lexicographic range: - or +, which inf sets to -1
+// or 1, for the lowest and the highest of strings, or a member, included
+// after [ and excluded after (.
+type lexBound struct {
+	value     string
+	inf       int
+	exclusive bool
+}
+
+func parseLexBound(arg string) (lexBound, error) {
+	switch {
+	case arg == "-":
+		return lexBound{inf: -1}, nil
+	case arg == "+":
+		return lexBound{inf: 1}, nil
+	case strings.HasPrefix(arg, "["):
+		return lexBound{value: arg[1:]}, nil
+	case strings.HasPrefix(arg, "("):
+		return lexBound{value: arg[1:], exclusive: true}, nil
+	}
+	return lexBound{}, errLexRange
+}
+
+// lexRange is the range of members from min to max of ZRANGEBYLEX and
+// ZLEXCOUNT, which are meant for sorted sets whose members all have the same
+// score, so that they are ordered by member.
+type lexRange struct {
+	min, max lexBound
+}
+
+func parseLexRange(min, max string) (lexRange, error) {
+	var r lexRange
+	var err error
+	if r.min, err = parseLexBound(min); err != nil {
+		return r, err
+	}
+	r.max, err = parseLexBound(max)
+	return r, err
+}
+
+// belowMin and aboveMax report whether member is before or after r.
+func (r lexRange) belowMin(member string) bool {
+	return r.min.inf > 0 || r.min.inf == 0 && (member < r.min.value || r.min.exclusive && member == r.min.value)
+}
+
+func (r lexRange) aboveMax(member string) bool {
+	return r.max.inf < 0 || r.max.inf == 0 && (member > r.max.value || r.max.exclusive && member == r.max.value)
+}
+
+func (r lexRange) contains(member string) bool {
+	return !r.belowMin(member) && !r.aboveMax(member)
+}
+
+// lexRank returns the rank of the first member of z within r, and the rank
+// after its last one. As in redis, the members are found by name alone, on
+// the assumption that they all have the same score.
+func (z *sortedSet) lexRank(r lexRange) (int, int) {
+	_, first := z.zsl.seek(func(n *skiplistNode) bool { return r.belowMin(n.member) })
+	_, end := z.zsl.seek(func(n *skiplistNode) bool { return !r.aboveMax(n.member) })
+	return first, max(first, end)
+}
+
+// ZRangeByLex returns the members of the sorted set at key within r, in
+// order, skipping the first offset of them and returning up to count unless
+// it is negative.
+func (s *Store) ZRangeByLex(key string, r lexRange, offset, count int) ([]string, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, false)
+	if err != nil {
+		return nil, err
+	}
+	s.countLookup(z != nil)
+	if z == nil || offset < 0 {
+		return nil, nil
+	}
+	first, end := z.lexRank(r)
+	var members []string
+	for n := z.zsl.byRank(first + offset); n != nil && first+offset+len(members) < end && len(members) != count; n = n.levels[0].forward {
+		members = append(members, n.member)
+	}
+	return members, nil
+}
+
+// ZLexCount returns the number of members of the sorted set at key within r.
+func (s *Store) ZLexCount(key string, r lexRange) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	z, err := s.zset(key, false)
+	if err != nil {
+		return 0, err
+	}
+	s.countLookup(z != nil)
+	if z == nil {
+		return 0, nil
+	}
+	first, end := z.lexRank(r)
+	return end - first, nil
+}
+
+// zsetOp is a ZUNION, ZINTER or ZDIFF, whose kind is union, inter or diff,
+// of the sorted sets at keys, or the STORE variant of one. The scores of each
+// are multiplied by its weight, and the scores a member gets from several
+// are combined by aggregate, sum, min or max. ZDIFF has neither option, and
+// keeps the scores of the first sorted set. withScores is the WITHSCORES
+// option of the variants that reply with the result.
+type zsetOp struct {
+	kind       string
+	keys       []string
+	weights    []float64
+	aggregate  string
+	withScores bool
+}
+
+func newZsetOp(kind string, keys []string) zsetOp {
+	op := zsetOp{kind: kind, keys: keys, aggregate: "sum"}
+	op.weights = make([]float64, len(keys))
+	for i := range op.weights {
+		op.weights[i] = 1
+	}
+	return op
+}
+
+// parseZsetOp parses the [destination] numkeys key [key ...] [WEIGHTS weight
+// [weight ...]] [AGGREGATE SUM|MIN|MAX] arguments of kind, with a destination
+// for store, or the WITHSCORES option otherwise.
+func parseZsetOp(commands []string, kind string, store bool) (zsetOp, error) {
+	at := 1
+	if store {
+		at = 2
+	}
+	numKeys, err := parseNumKeys(commands, at)
+	if err != nil {
+		return zsetOp{}, err
+	}
+	op := newZsetOp(kind, commands[at+1:at+1+numKeys])
+	for i := at + 1 + numKeys; i < len(commands); i++ {
+		left := len(commands) - i - 1
+		switch option := strings.ToLower(commands[i]); {
+		case option == "weights" && kind != "diff" && left >= numKeys:
+			for j := range op.weights {
+				weight, err := strconv.ParseFloat(commands[i+1+j], 64)
+				if err != nil || math.IsNaN(weight) {
+					return zsetOp{}, errors.New("ERR weight value is not a float")
+				}
+				op.weights[j] = weight
+			}
+			i += numKeys
+		case option == "aggregate" && kind != "diff" && left >= 1:
+			op.aggregate = strings.ToLower(commands[i+1])
+			if op.aggregate != "sum" && op.aggregate != "min" && op.aggregate != "max" {
+				return zsetOp{}, errSyntax
+			}
+			i++
+		case option == "withscores" && !store:
+			op.withScores = true
+		default:
+			return zsetOp{}, errSyntax
+		}
+	}
+	return op, nil
+}
+
+// combine returns score combined with the score a member already has by
+// the aggregate of op.
+func (op zsetOp) combine(current, score float64) float64 {
+	switch op.aggregate {
+	case "min":
+		return min(current, score)
+	case "max":
+		return max(current, score)
+	}
+	// Infinities of opposite signs add up to 0 rather than NaN.
+	if sum := current + score; !math.IsNaN(sum) {
+		return sum
+	}
+	return 0
+}
+
+// zsetOperand returns the scores of the members of the sorted set at key, or
+// a score of 1 for each member of a set. The caller must hold the write
+// lock.
+func (s *Store) zsetOperand(key string) (map[string]float64, error) {
+	val, ok := s.lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	switch v := val.(type) {
+	case *sortedSet:
+		return v.scores, nil
+	case map[string]struct{}:
+		scores := make(map[string]float64, len(v))
+		for member := range v {
+			scores[member] = 1
+		}
+		return scores, nil
+	case intset:
+		scores := make(map[string]float64, len(v))
+		for _, member := range v.members() {
+			scores[member] = 1
+		}
+		return scores, nil
+	}
+	return nil, errWrongType
+}
+
+// zsetOpResult returns the members op results in, with their scores. The
+// caller must hold the write lock.
+func (s *Store) zsetOpResult(op zsetOp) (map[string]float64, error) {
+	operands := make([]map[string]float64, len(op.keys))
+	for i, key := range op.keys {
+		scores, err := s.zsetOperand(key)
+		if err != nil {
+			return nil, err
+		}
+		operands[i] = scores
+	}
+	result := make(map[string]float64)
+	weighted := func(i int, member string) float64 {
+		// An infinite score weighted by 0 counts as 0 rather than NaN.
+		if score := operands[i][member] * op.weights[i]; !math.IsNaN(score) {
+			return score
+		}
+		return 0
+	}
+	switch op.kind {
+	case "union":
+		for i, scores := range operands {
+			for member := range scores {
+				if current, ok := result[member]; ok {
+					result[member] = op.combine(current, weighted(i, member))
+				} else {
+					result[member] = weighted(i, member)
+				}
+			}
+		}
+	case "inter":
+	members:
+		for member := range operands[0] {
+			score := weighted(0, member)
+			for i := 1; i < len(operands); i++ {
+				if _, ok := operands[i][member]; !ok {
+					continue members
+				}
+				score = op.combine(score, weighted(i, member))
+			}
+			result[member] = score
+		}
+	case "diff":
+	diff:
+		for member, score := range operands[0] {
+			for _, scores := range operands[1:] {
+				if _, ok := scores[member]; ok {
+					continue diff
+				}
+			}
+			result[member] = score
+		}
+	}
+	return result, nil
+}
+
+// ZSetOp returns the members op results in, ordered like a sorted set.
+func (s *Store) ZSetOp(op zsetOp) ([]zmember, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	result, err := s.zsetOpResult(op)
+	if err != nil {
+		return nil, err
+	}
+	return sortedSetFrom(result).sorted(), nil
+}
+
+// ZInterCard returns the number of members in all the sorted sets at keys,
+// counting up to limit unless it is 0.
+func (s *Store) ZInterCard(keys []string, limit int) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	result, err := s.zsetOpResult(newZsetOp("inter", keys))
+	if err != nil {
+		return 0, err
+	}
+	if limit > 0 {
+		return min(len(result), limit), nil
+	}
+	return len(result), nil
+}
+
+// ZStore stores the result of op at dest, deleting dest if it is empty, and
+// returns its number of members.
+func (s *Store) ZStore(dest string, op zsetOp) (int, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	result, err := s.zsetOpResult(op)
+	if err != nil {
+		return 0, err
+	}
+	s.remove(dest)
+	if len(result) > 0 {
+		s.Data[dest] = sortedSetFrom(result)
+	}
+	return len(result), nil
+}
+
+// ZMPop pops up to count members with the lowest scores, or the highest when
+// min is not set, from the first non-empty sorted set among keys. A sorted set
+// left empty is deleted.
+func (s *Store) ZMPop(keys []string, min bool, count int) (string, []zmember, error) {
+	s.Mutex.Lock()
+	defer s.Mutex.Unlock()
+	for _, key := range keys {
+		z, err := s.zset(key, false)
+		if err != nil {
+			return "", nil, err
+		}
+		if z == nil || len(z.scores) == 0 {
+			continue
+		}
+		var members []zmember
+		for len(members) < count && len(z.scores) > 0 {
+			n := z.zsl.first()
+			if !min {
+				n = z.zsl.tail
+			}
+			members = append(members, n.zmember)
+			z.remove(n.member)
+		}
+		if len(z.scores) == 0 {
+			s.remove(key)
+		}
+		return key, members, nil
+	}
+	return "", nil, nil
+}
+
+// zaddCommand implements ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member
+// [score member ...]. With INCR, it adds to the score of a single member like
+// ZINCRBY, and replies with the new score, or nil if the options prevented
+// the change.
+func (s *server) zaddCommand(c *clientConn, commands []string) {
+	var opts zaddOptions
+	incr := false
+	first := 2
+options:
+	for ; first < len(commands); first++ {
+		switch strings.ToLower(commands[first]) {
+		case "nx":
+			opts.nx = true
+		case "xx":
+			opts.xx = true
+		case "gt":
+			opts.gt = true
+		case "lt":
+			opts.lt = true
+		case "ch":
+			opts.ch = true
+		case "incr":
+			incr = true
+		default:
+			break options
+		}
+	}
+	pairs := len(commands) - first
+	if pairs == 0 || pairs%2 != 0 {
+		c.replyError(errSyntax)
+		return
+	}
+	if opts.nx && opts.xx {
+		c.replyErrorMsg("XX and NX options at the same time are not compatible")
+		return
+	}
+	if (opts.gt && opts.nx) || (opts.lt && opts.nx) || (opts.gt && opts.lt) {
+		c.replyErrorMsg("GT, LT, and/or NX options at the same time are not compatible")
+		return
+	}
+	if incr && pairs > 2 {
+		c.replyErrorMsg("INCR option supports a single increment-element pair")
+		return
+	}
+	var scores []float64
+	var members []string
+	for i := first; i < len(commands); i += 2 {
+		score, err := strconv.ParseFloat(commands[i], 64)
+		if err != nil || math.IsNaN(score) {
+			c.replyError(errNotFloat)
+			return
+		}
+		scores = append(scores, score)
+		members = append(members, commands[i+1])
+	}
+	if incr {
+		score, ok, err := s.db(c).ZIncr(commands[1], members[0], scores[0], opts)
+		if err != nil {
+			c.replyError(err)
+		} else if !ok {
+			c.replyNull()
+		} else {
+			s.propagate(c.db, commands)
+			c.replyBulk(formatFloat(score))
+		}
+		return
+	}
+	added, changed, err := s.db(c).ZAdd(commands[1], scores, members, opts)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	// Nothing is propagated when every member was skipped or kept its
+	// score.
+	if added+changed > 0 {
+		s.propagate(c.db, commands)
+	}
+	if opts.ch {
+		added += changed
+	}
+	c.replyInteger(added)
+}
+
+func (s *server) zremCommand(c *clientConn, commands []string) {
+	removed, err := s.db(c).ZRem(commands[1], commands[2:])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if removed > 0 {
+		s.propagate(c.db, commands)
+	}
+	c.replyInteger(removed)
+}
+
+func (s *server) zscoreCommand(c *clientConn, commands []string) {
+	if len(commands) != 3 {
+		c.replyWrongArgs("zscore")
+		return
+	}
+	score, ok, err := s.db(c).ZScore(commands[1], commands[2])
+	if err != nil {
+		c.replyError(err)
+	} else if !ok {
+		c.replyNull()
+	} else {
+		c.replyBulk(formatFloat(score))
+	}
+}
+
+// zrandmemberCommand implements ZRANDMEMBER key [count [WITHSCORES]].
+func (s *server) zrandmemberCommand(c *clientConn, commands []string) {
+	if len(commands) < 2 || len(commands) > 4 {
+		c.replyWrongArgs("zrandmember")
+		return
+	}
+	if len(commands) == 2 {
+		members, _, err := s.db(c).ZRandMember(commands[1], 1)
+		if err != nil {
+			c.replyError(err)
+		} else if len(members) == 0 {
+			c.replyNull()
+		} else {
+			c.replyBulk(members[0])
+		}
+		return
+	}
+	count, err := randomCount(commands[2])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	withScores := false
+	if len(commands) == 4 {
+		if !strings.EqualFold(commands[3], "withscores") {
+			c.replyError(errSyntax)
+			return
+		}
+		withScores = true
+	}
+	members, scores, err := s.db(c).ZRandMember(commands[1], count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if !withScores {
+		c.replyStrings(members)
+		return
+	}
+	pairs := make([]string, 0, 2*len(members))
+	for i := range members {
+		pairs = append(pairs, members[i], formatFloat(scores[i]))
+	}
+	c.replyStrings(pairs)
+}
+
+// zmpopCommand implements ZMPOP numkeys key [key ...] MIN|MAX [COUNT count].
+// It is propagated as the ZPOPMIN or ZPOPMAX of the sorted set it popped
+// from.
+func (s *server) zmpopCommand(c *clientConn, commands []string) {
+	if len(commands) < 4 {
+		c.replyWrongArgs("zmpop")
+		return
+	}
+	keys, min, count, err := parseMPop(commands, "min", "max")
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	key, popped, err := s.db(c).ZMPop(keys, min, count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if popped == nil {
+		c.replyNullArray()
+		return
+	}
+	pop := "ZPOPMAX"
+	if min {
+		pop = "ZPOPMIN"
+	}
+	s.propagate(c.db, []string{pop, key, strconv.Itoa(len(popped))})
+	members := make([]any, len(popped))
+	for i, m := range popped {
+		members[i] = []string{m.member, formatFloat(m.score)}
+	}
+	c.replyValue([]any{key, members})
+}
+
+// zpopCommand implements ZPOPMIN and ZPOPMAX key [count], which pop the count
+// members with the lowest or the highest scores, one by default, and reply
+// with them and their scores.
+func (s *server) zpopCommand(c *clientConn, commands []string) {
+	if len(commands) > 3 {
+		c.replyError(errSyntax)
+		return
+	}
+	count := 1
+	if len(commands) == 3 {
+		var err error
+		if count, err = strconv.Atoi(commands[2]); err != nil || count < 0 {
+			c.replyErrorMsg("value is out of range, must be positive")
+			return
+		}
+	}
+	_, popped, err := s.db(c).ZMPop(commands[1:2], commands[0] == "zpopmin", count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	if len(popped) > 0 {
+		s.propagate(c.db, commands)
+	}
+	reply := make([]string, 0, 2*len(popped))
+	for _, m := range popped {
+		reply = append(reply, m.member, formatFloat(m.score))
+	}
+	c.replyStrings(reply)
+}
+
+// bzpopCommand implements BZPOPMIN and BZPOPMAX key [key ...] timeout, which
+// pop a member like ZPOPMIN and ZPOPMAX from the first non-empty sorted set
+// among keys, blocking as described by block if they are all empty. It
+// replies with the key, the member and its score, and is propagated as the
+// ZPOPMIN or ZPOPMAX it turned into.
+func (s *server) bzpopCommand(c *clientConn, commands []string) {
+	timeout, err := parseTimeout(commands[len(commands)-1])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	keys, min := commands[1:len(commands)-1], commands[0] == "bzpopmin"
+	s.block(c, timeout, func(db *Store) (any, []string, error) {
+		key, popped, err := db.ZMPop(keys, min, 1)
+		if len(popped) == 0 {
+			return nil, nil, err
+		}
+		reply := []string{key, popped[0].member, formatFloat(popped[0].score)}
+		return reply, []string{strings.TrimPrefix(commands[0], "b"), key}, nil
+	})
+}
+
+// zstoreCommand implements ZUNIONSTORE, ZINTERSTORE and ZDIFFSTORE
+// destination numkeys key [key ...] with the options of parseZsetOp, which
+// reply with the number of members stored.
+func (s *server) zstoreCommand(c *clientConn, commands []string) {
+	op, err := parseZsetOp(commands, strings.TrimSuffix(strings.TrimPrefix(commands[0], "z"), "store"), true)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	stored, err := s.db(c).ZStore(commands[1], op)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	s.propagate(c.db, commands)
+	c.replyInteger(stored)
+}
+
+// zsetOpCommand implements ZUNION, ZINTER and ZDIFF numkeys key [key ...] with
+// the options of parseZsetOp, which reply with the resulting members, and
+// their scores with WITHSCORES.
+func (s *server) zsetOpCommand(c *clientConn, commands []string) {
+	op, err := parseZsetOp(commands, strings.TrimPrefix(commands[0], "z"), false)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	members, err := s.db(c).ZSetOp(op)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	reply := make([]string, 0, 2*len(members))
+	for _, m := range members {
+		reply = append(reply, m.member)
+		if op.withScores {
+			reply = append(reply, formatFloat(m.score))
+		}
+	}
+	c.replyStrings(reply)
+}
+
+// zintercardCommand implements ZINTERCARD numkeys key [key ...] [LIMIT
+// limit], the number of members of the intersection of the sorted sets,
+// counting up to limit unless it is 0.
+func (s *server) zintercardCommand(c *clientConn, commands []string) {
+	numKeys, err := parseNumKeys(commands, 1)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	limit := 0
+	rest := commands[2+numKeys:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || !strings.EqualFold(rest[0], "limit") {
+			c.replyError(errSyntax)
+			return
+		}
+		if limit, err = strconv.Atoi(rest[1]); err != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+		if limit < 0 {
+			c.replyErrorMsg("LIMIT can't be negative")
+			return
+		}
+	}
+	count, err := s.db(c).ZInterCard(commands[2:2+numKeys], limit)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(count)
+}
+
+// zrangebylexCommand implements ZRANGEBYLEX key min max [LIMIT offset count].
+// A negative count returns every member from offset on.
+func (s *server) zrangebylexCommand(c *clientConn, commands []string) {
+	if len(commands) != 4 && len(commands) != 7 {
+		c.replyError(errSyntax)
+		return
+	}
+	r, err := parseLexRange(commands[2], commands[3])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	offset, count := 0, -1
+	if len(commands) == 7 {
+		if !strings.EqualFold(commands[4], "limit") {
+			c.replyError(errSyntax)
+			return
+		}
+		var err1, err2 error
+		offset, err1 = strconv.Atoi(commands[5])
+		count, err2 = strconv.Atoi(commands[6])
+		if err1 != nil || err2 != nil {
+			c.replyError(errNotInteger)
+			return
+		}
+	}
+	members, err := s.db(c).ZRangeByLex(commands[1], r, offset, count)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyStrings(members)
+}
+
+// zlexcountCommand implements ZLEXCOUNT key min max.
+func (s *server) zlexcountCommand(c *clientConn, commands []string) {
+	r, err := parseLexRange(commands[2], commands[3])
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	count, err := s.db(c).ZLexCount(commands[1], r)
+	if err != nil {
+		c.replyError(err)
+		return
+	}
+	c.replyInteger(count)
+}
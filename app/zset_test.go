@@ -0,0 +1,207 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestZRandMember(t *testing.T) {
+	s, addr := startServer(t)
+	seedRand(s, 1)
+	c := dial(t, addr)
+	c.expect(int64(2), "ZADD", "zset", "1", "a", "2.5", "b")
+	scores := map[string]string{"a": "1", "b": "2.5"}
+
+	pairs, _ := c.do("ZRANDMEMBER", "zset", "2", "WITHSCORES").([]any)
+	if len(pairs) != 4 {
+		t.Fatalf("ZRANDMEMBER 2 WITHSCORES: got %#v, want two member and score pairs", pairs)
+	}
+	for i := 0; i < len(pairs); i += 2 {
+		if scores[pairs[i].(string)] != pairs[i+1].(string) {
+			t.Fatalf("ZRANDMEMBER 2 WITHSCORES: got %#v", pairs)
+		}
+	}
+
+	repeated, _ := c.do("ZRANDMEMBER", "zset", "-6", "WITHSCORES").([]any)
+	if len(repeated) != 12 {
+		t.Fatalf("ZRANDMEMBER -6 WITHSCORES: got %d elements, want 12", len(repeated))
+	}
+	if member, _ := c.do("ZRANDMEMBER", "zset").(string); scores[member] == "" {
+		t.Fatalf("ZRANDMEMBER: got %q, want a member", member)
+	}
+	c.expect(respError("ERR value is out of range"), "ZRANDMEMBER", "zset", "9223372036854775807")
+}
+
+func TestZMPop(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(2), "ZADD", "zset", "1", "a", "2", "b")
+	c.expect([]any{"zset", []any{[]any{"b", "2"}}}, "ZMPOP", "2", "empty", "zset", "MAX")
+	c.expect([]any{"zset", []any{[]any{"a", "1"}}}, "ZMPOP", "2", "empty", "zset", "MIN", "COUNT", "3")
+	c.expect(nil, "ZMPOP", "1", "zset", "MIN")
+}
+
+func TestZAddOptions(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(2), "ZADD", "zset", "5", "a", "5", "b")
+	// GT and LT only move a score one way, and still add new members.
+	c.expect(int64(0), "ZADD", "zset", "GT", "3", "a")
+	c.expect("5", "ZSCORE", "zset", "a")
+	c.expect(int64(1), "ZADD", "zset", "GT", "CH", "7", "a")
+	c.expect("7", "ZSCORE", "zset", "a")
+	c.expect(int64(0), "ZADD", "zset", "LT", "CH", "9", "b")
+	c.expect(int64(1), "ZADD", "zset", "LT", "1", "c")
+
+	c.expect(int64(0), "ZADD", "zset", "NX", "1", "a")
+	c.expect("7", "ZSCORE", "zset", "a")
+	c.expect(int64(0), "ZADD", "zset", "XX", "1", "d")
+	c.expect(nil, "ZSCORE", "zset", "d")
+	// CH counts the updated members along with the added ones.
+	c.expect(int64(2), "ZADD", "zset", "CH", "8", "a", "5", "b", "1", "d")
+
+	c.expect("10.5", "ZADD", "zset", "INCR", "2.5", "a")
+	c.expect("10.5", "ZSCORE", "zset", "a")
+	c.expect(nil, "ZADD", "zset", "NX", "INCR", "1", "a")
+	c.expect(nil, "ZADD", "zset", "GT", "INCR", "-1", "a")
+	c.expect("10.5", "ZSCORE", "zset", "a")
+
+	c.expect(respError("ERR XX and NX options at the same time are not compatible"), "ZADD", "zset", "NX", "XX", "1", "a")
+	c.expect(respError("ERR GT, LT, and/or NX options at the same time are not compatible"), "ZADD", "zset", "NX", "GT", "1", "a")
+	c.expect(respError("ERR INCR option supports a single increment-element pair"), "ZADD", "zset", "INCR", "1", "a", "2", "b")
+}
+
+func TestZRangeByLex(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(5), "ZADD", "zset", "0", "a", "0", "b", "0", "c", "0", "d", "0", "e")
+	c.expect([]any{"a", "b"}, "ZRANGEBYLEX", "zset", "[a", "(c")
+	c.expect([]any{"b", "c"}, "ZRANGEBYLEX", "zset", "(a", "[c")
+	c.expect([]any{"a", "b", "c", "d", "e"}, "ZRANGEBYLEX", "zset", "-", "+")
+	c.expect([]any{"d", "e"}, "ZRANGEBYLEX", "zset", "[d", "+")
+	c.expect([]any{}, "ZRANGEBYLEX", "zset", "+", "-")
+	c.expect([]any{"b", "c"}, "ZRANGEBYLEX", "zset", "-", "+", "LIMIT", "1", "2")
+	c.expect([]any{"b", "c", "d", "e"}, "ZRANGEBYLEX", "zset", "-", "+", "LIMIT", "1", "-1")
+	c.expect([]any{"d"}, "ZRANGEBYLEX", "zset", "(b", "[d", "LIMIT", "1", "5")
+	c.expect([]any{}, "ZRANGEBYLEX", "zset", "[b", "[c", "LIMIT", "2", "1")
+	c.expect([]any{}, "ZRANGEBYLEX", "zset", "-", "+", "LIMIT", "-1", "1")
+	c.expect([]any{}, "ZRANGEBYLEX", "zset", "-", "+", "LIMIT", "0", "0")
+	c.expect(int64(2), "ZLEXCOUNT", "zset", "[a", "(c")
+	c.expect(int64(5), "ZLEXCOUNT", "zset", "-", "+")
+	c.expect(int64(0), "ZLEXCOUNT", "missing", "-", "+")
+	c.expect(respError("ERR min or max not valid string range item"), "ZRANGEBYLEX", "zset", "a", "[c")
+	c.expect(respError("ERR min or max not valid string range item"), "ZLEXCOUNT", "zset", "[a", "c")
+}
+
+func TestLexRange(t *testing.T) {
+	for _, test := range []struct {
+		min, max string
+		in, out  []string
+	}{
+		{"[b", "[d", []string{"b", "c", "d"}, []string{"a", "e", ""}},
+		{"(b", "(d", []string{"c", "bb"}, []string{"b", "d"}},
+		{"-", "(b", []string{"", "a", "ab"}, []string{"b", "c"}},
+		{"[", "+", []string{"", "zzz"}, nil},
+		{"+", "+", nil, []string{"", "z"}},
+		{"[d", "[b", nil, []string{"b", "c", "d"}},
+	} {
+		r, err := parseLexRange(test.min, test.max)
+		if err != nil {
+			t.Fatalf("parsing %s %s: %v", test.min, test.max, err)
+		}
+		for _, member := range test.in {
+			if !r.contains(member) {
+				t.Errorf("%s %s does not contain %q", test.min, test.max, member)
+			}
+		}
+		for _, member := range test.out {
+			if r.contains(member) {
+				t.Errorf("%s %s contains %q", test.min, test.max, member)
+			}
+		}
+	}
+	for _, bound := range []string{"", "a", "]a", "*"} {
+		if _, err := parseLexBound(bound); err != errLexRange {
+			t.Errorf("parseLexBound(%q) = %v, want an error", bound, err)
+		}
+	}
+}
+
+func TestZPop(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(4), "ZADD", "zset", "3", "c", "1", "a", "2", "b", "4", "d")
+	c.expect([]any{"a", "1"}, "ZPOPMIN", "zset")
+	c.expect([]any{"d", "4", "c", "3"}, "ZPOPMAX", "zset", "2")
+	c.expect([]any{"b", "2"}, "ZPOPMIN", "zset", "5")
+	c.expect(int64(0), "EXISTS", "zset")
+	c.expect([]any{}, "ZPOPMIN", "zset")
+	c.expect(respError("ERR value is out of range, must be positive"), "ZPOPMIN", "zset", "-1")
+}
+
+func TestBZPopWokenByZAdd(t *testing.T) {
+	s, addr := startServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	c.send("BZPOPMIN", "empty", "zset", "0")
+	blockedOn(t, s, 1)
+	other.expect(int64(2), "ZADD", "zset", "2", "b", "1", "a")
+	if reply := c.read(); !reflect.DeepEqual(reply, []any{"zset", "a", "1"}) {
+		t.Fatalf("BZPOPMIN: got %#v", reply)
+	}
+	c.expect([]any{"zset", "b", "2"}, "BZPOPMAX", "zset", "0")
+
+	start := time.Now()
+	c.expect(nil, "BZPOPMAX", "zset", "0.1")
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("BZPOPMAX replied after %v, before its timeout", elapsed)
+	}
+}
+
+func TestZSetOperationsStore(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(2), "ZADD", "one", "1", "a", "2", "b")
+	c.expect(int64(2), "ZADD", "two", "10", "b", "20", "c")
+	c.expect(int64(2), "SADD", "set", "a", "c")
+
+	c.expect(int64(3), "ZUNIONSTORE", "dest", "2", "one", "two", "WEIGHTS", "2", "3")
+	for member, score := range map[string]string{"a": "2", "b": "34", "c": "60"} {
+		c.expect(score, "ZSCORE", "dest", member)
+	}
+	c.expect(int64(3), "ZUNIONSTORE", "dest", "2", "one", "two", "AGGREGATE", "MAX")
+	c.expect("10", "ZSCORE", "dest", "b")
+	// The members of a set have a score of 1.
+	c.expect(int64(1), "ZINTERSTORE", "dest", "2", "two", "set", "AGGREGATE", "MIN")
+	c.expect("1", "ZSCORE", "dest", "c")
+	c.expect(int64(1), "ZINTERSTORE", "dest", "2", "one", "two")
+	c.expect("12", "ZSCORE", "dest", "b")
+	c.expect(int64(1), "ZDIFFSTORE", "dest", "2", "one", "two")
+	c.expect("1", "ZSCORE", "dest", "a")
+	c.expect(nil, "ZSCORE", "dest", "b")
+	// An empty result deletes the destination.
+	c.expect(int64(0), "ZINTERSTORE", "dest", "2", "one", "missing")
+	c.expect(int64(0), "EXISTS", "dest")
+
+	c.expect(respError("ERR syntax error"), "ZUNIONSTORE", "dest", "2", "one", "two", "WEIGHTS", "1")
+	c.expect(respError("ERR weight value is not a float"), "ZUNIONSTORE", "dest", "2", "one", "two", "WEIGHTS", "1", "x")
+}
+
+func TestZSetOperations(t *testing.T) {
+	_, addr := startServer(t)
+	c := dial(t, addr)
+	c.expect(int64(3), "ZADD", "one", "1", "a", "2", "b", "3", "c")
+	c.expect(int64(3), "ZADD", "two", "10", "b", "20", "c", "30", "d")
+
+	c.expect([]any{"b", "12", "c", "23"}, "ZINTER", "2", "one", "two", "WITHSCORES")
+	c.expect([]any{"b", "c"}, "ZINTER", "2", "one", "two")
+	c.expect([]any{"a", "1", "b", "10", "c", "20", "d", "30"}, "ZUNION", "2", "one", "two", "AGGREGATE", "MAX", "WITHSCORES")
+	c.expect([]any{"a"}, "ZDIFF", "2", "one", "two")
+	c.expect(int64(2), "DBSIZE")
+
+	c.expect(int64(2), "ZINTERCARD", "2", "one", "two")
+	c.expect(int64(1), "ZINTERCARD", "2", "one", "two", "LIMIT", "1")
+	c.expect(int64(2), "ZINTERCARD", "2", "one", "two", "LIMIT", "0")
+	c.expect(int64(0), "ZINTERCARD", "2", "one", "missing")
+	c.expect(respError("ERR LIMIT can't be negative"), "ZINTERCARD", "2", "one", "two", "LIMIT", "-1")
+}